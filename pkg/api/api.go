@@ -102,6 +102,7 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.healthAPIEntries()...)
 	group.Entries = append(group.Entries, s.aboutAPIEntries()...)
 	group.Entries = append(group.Entries, s.customDataAPIEntries()...)
+	group.Entries = append(group.Entries, s.credentialAPIEntries()...)
 	group.Entries = append(group.Entries, s.profileAPIEntries()...)
 
 	for _, fn := range appendAddonAPIs {
@@ -129,6 +130,11 @@ func (s *Server) healthAPIEntries() []*Entry {
 			Method:  "GET",
 			Handler: func(w http.ResponseWriter, r *http.Request) { /* 200 by default */ },
 		},
+		{
+			Path:    StatusHealthPrefix,
+			Method:  "GET",
+			Handler: s.getHealthStatus,
+		},
 	}
 }
 
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filters/validator"
+)
+
+const (
+	// CredentialsPrefix is the URL prefix of APIs for the credentials used
+	// by the BasicAuth validator in ETCD mode.
+	CredentialsPrefix = "/credentials"
+
+	credsCustomDataPrefix = "/custom-data/"
+	credsDefaultPrefix    = "credentials/"
+)
+
+// credential is the format of a credential in etcd, it must be consistent
+// with the one of the BasicAuth validator.
+type credential struct {
+	Key  string `yaml:"key,omitempty"`
+	User string `yaml:"username,omitempty"`
+	Pass string `yaml:"password"`
+}
+
+func (s *Server) credentialAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    CredentialsPrefix + "/htpasswd",
+			Method:  http.MethodGet,
+			Handler: s.exportCredentials,
+		},
+		{
+			Path:    CredentialsPrefix + "/htpasswd",
+			Method:  http.MethodPost,
+			Handler: s.importCredentials,
+		},
+	}
+}
+
+// credsPrefix returns the etcd prefix of credentials, the etcdPrefix query
+// parameter has the same meaning as the one in BasicAuth validator spec.
+func credsPrefix(r *http.Request) string {
+	prefix := strings.TrimPrefix(r.URL.Query().Get("etcdPrefix"), "/")
+	if prefix == "" {
+		prefix = credsDefaultPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return credsCustomDataPrefix + prefix
+}
+
+// parseHtpasswd parses htpasswd content into credentials keyed by user name,
+// plain text passwords are hashed with bcrypt.
+func parseHtpasswd(r io.Reader) (map[string]string, error) {
+	creds := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("line %d: bad format", lineNo)
+		}
+		user, pass := parts[0], parts[1]
+		if strings.Contains(user, "/") {
+			return nil, fmt.Errorf("line %d: invalid user name %s", lineNo, user)
+		}
+
+		if !validator.IsPasswordHashed(pass) {
+			hashed, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: hash password failed: %v", lineNo, err)
+			}
+			pass = string(hashed)
+		}
+		creds[user] = pass
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// formatHtpasswd formats the credentials in etcd into htpasswd content,
+// sorted by user name.
+func formatHtpasswd(kvs map[string]string) string {
+	lines := make([]string, 0, len(kvs))
+	for _, v := range kvs {
+		cred := credential{}
+		if err := yaml.Unmarshal([]byte(v), &cred); err != nil {
+			continue
+		}
+		user := cred.User
+		if user == "" {
+			user = cred.Key
+		}
+		if user == "" || cred.Pass == "" {
+			continue
+		}
+		lines = append(lines, user+":"+cred.Pass+"\n")
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "")
+}
+
+func (s *Server) importCredentials(w http.ResponseWriter, r *http.Request) {
+	creds, err := parseHtpasswd(r.Body)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("parse htpasswd failed: %v", err))
+		return
+	}
+
+	prefix := credsPrefix(r)
+	kvs := map[string]*string{}
+
+	// rebuild removes the existing credentials which are not imported.
+	if r.URL.Query().Get("rebuild") == "true" {
+		old, err := s.cluster.GetPrefix(prefix)
+		if err != nil {
+			ClusterPanic(err)
+		}
+		for k := range old {
+			kvs[k] = nil
+		}
+	}
+
+	for user, pass := range creds {
+		buff, err := yaml.Marshal(&credential{Key: user, Pass: pass})
+		if err != nil {
+			panic(err)
+		}
+		value := string(buff)
+		kvs[prefix+user] = &value
+	}
+
+	err = s.cluster.PutAndDelete(kvs)
+	if err != nil {
+		ClusterPanic(err)
+	}
+}
+
+func (s *Server) exportCredentials(w http.ResponseWriter, r *http.Request) {
+	kvs, err := s.cluster.GetPrefix(credsPrefix(r))
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, formatHtpasswd(kvs))
+}
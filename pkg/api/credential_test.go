@@ -0,0 +1,120 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster/clustertest"
+)
+
+func newCredentialTestServer(store map[string]string) *Server {
+	mc := clustertest.NewMockedCluster()
+	mc.MockedGetPrefix = func(prefix string) (map[string]string, error) {
+		kvs := map[string]string{}
+		for k, v := range store {
+			if strings.HasPrefix(k, prefix) {
+				kvs[k] = v
+			}
+		}
+		return kvs, nil
+	}
+	mc.MockedPutAndDelete = func(kvs map[string]*string) error {
+		for k, v := range kvs {
+			if v == nil {
+				delete(store, k)
+			} else {
+				store[k] = *v
+			}
+		}
+		return nil
+	}
+	return &Server{cluster: mc}
+}
+
+func TestImportExportCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	store := map[string]string{
+		"/custom-data/credentials/old": "key: old\npassword: $2y$05$old\n",
+	}
+	s := newCredentialTestServer(store)
+
+	blob := `# users
+alice:$apr1$RMKAfAp4$gwHYgqq9BfY4s/Dbb1mBc/
+
+bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+carol:secret
+`
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, CredentialsPrefix+"/htpasswd", strings.NewReader(blob))
+	s.importCredentials(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Len(store, 4)
+
+	cred := credential{}
+	assert.Nil(yaml.Unmarshal([]byte(store["/custom-data/credentials/alice"]), &cred))
+	assert.Equal("alice", cred.Key)
+	assert.Equal("$apr1$RMKAfAp4$gwHYgqq9BfY4s/Dbb1mBc/", cred.Pass)
+
+	// Plain text passwords are hashed.
+	cred = credential{}
+	assert.Nil(yaml.Unmarshal([]byte(store["/custom-data/credentials/carol"]), &cred))
+	assert.NotEqual("secret", cred.Pass)
+	assert.Nil(bcrypt.CompareHashAndPassword([]byte(cred.Pass), []byte("secret")))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, CredentialsPrefix+"/htpasswd", nil)
+	s.exportCredentials(w, r)
+	exported := w.Body.String()
+	lines := strings.Split(strings.TrimSpace(exported), "\n")
+	assert.Len(lines, 4)
+	assert.Equal("alice:$apr1$RMKAfAp4$gwHYgqq9BfY4s/Dbb1mBc/", lines[0])
+	assert.Equal("bob:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", lines[1])
+	assert.True(strings.HasPrefix(lines[2], "carol:$2a$"))
+	assert.Equal("old:$2y$05$old", lines[3])
+
+	// Round trip into another prefix with rebuild, the hashed passwords are
+	// kept as they are.
+	store["/custom-data/users/stale"] = "key: stale\npassword: $2y$05$stale\n"
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, CredentialsPrefix+"/htpasswd?etcdPrefix=/users&rebuild=true",
+		strings.NewReader(exported))
+	s.importCredentials(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.NotContains(store, "/custom-data/users/stale")
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, CredentialsPrefix+"/htpasswd?etcdPrefix=users/", nil)
+	s.exportCredentials(w, r)
+	assert.Equal(exported, w.Body.String())
+
+	// Bad lines are rejected without touching etcd.
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, CredentialsPrefix+"/htpasswd", strings.NewReader("dave\n"))
+	s.importCredentials(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Len(store, 8)
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// StatusHealthPrefix is the path of the aggregated health of objects.
+	StatusHealthPrefix = "/status/health"
+
+	healthHealthy  = "healthy"
+	healthDegraded = "degraded"
+
+	// objectStateRunning and objectStateFailed are the same as the
+	// states reported by traffic gates, e.g. HTTPServer.
+	objectStateRunning = "running"
+	objectStateFailed  = "failed"
+)
+
+type (
+	// HealthStatus is the aggregated health of the objects running in
+	// the current member.
+	HealthStatus struct {
+		Health  string          `yaml:"health"`
+		Objects []*ObjectHealth `yaml:"objects"`
+	}
+
+	// ObjectHealth is the state of a single object.
+	ObjectHealth struct {
+		Name        string `yaml:"name"`
+		Kind        string `yaml:"kind"`
+		TrafficGate bool   `yaml:"trafficGate"`
+		State       string `yaml:"state"`
+		Error       string `yaml:"error,omitempty"`
+	}
+)
+
+// newObjectHealth extracts the state and error from the status of an
+// object. Objects that don't report a state, e.g. Pipeline, are running
+// as long as they exist.
+func newObjectHealth(name, kind string, trafficGate bool, status interface{}) *ObjectHealth {
+	oh := &ObjectHealth{
+		Name:        name,
+		Kind:        kind,
+		TrafficGate: trafficGate,
+		State:       objectStateRunning,
+	}
+
+	buff, err := yaml.Marshal(status)
+	if err != nil {
+		oh.Error = fmt.Sprintf("marshal status failed: %v", err)
+		return oh
+	}
+
+	state := struct {
+		State string `yaml:"state"`
+		Error string `yaml:"error"`
+	}{}
+	// NOTE: Statuses whose top-level is not a map carry no state.
+	yaml.Unmarshal(buff, &state)
+
+	if state.State != "" {
+		oh.State = state.State
+	}
+	oh.Error = state.Error
+
+	return oh
+}
+
+// aggregateHealth aggregates the health of objects, it is degraded if
+// any traffic gate is failed.
+func aggregateHealth(objects []*ObjectHealth) *HealthStatus {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Name < objects[j].Name
+	})
+
+	hs := &HealthStatus{Health: healthHealthy, Objects: objects}
+	for _, oh := range objects {
+		if oh.TrafficGate && oh.State == objectStateFailed {
+			hs.Health = healthDegraded
+			break
+		}
+	}
+
+	return hs
+}
+
+func (s *Server) getHealthStatus(w http.ResponseWriter, r *http.Request) {
+	entity, exists := s.super.GetSystemController(trafficcontroller.Kind)
+	if !exists {
+		HandleAPIError(w, r, http.StatusServiceUnavailable, fmt.Errorf("traffic controller not found"))
+		return
+	}
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+
+	objects := []*ObjectHealth{}
+	namespace := rawconfigtrafficcontroller.DefaultNamespace
+	tc.WalkTrafficGates(namespace, func(entity *supervisor.ObjectEntity) bool {
+		objects = append(objects, newObjectHealth(entity.Spec().Name(),
+			entity.Spec().Kind(), true, entity.Instance().Status().ObjectStatus))
+		return true
+	})
+	tc.WalkPipelines(namespace, func(entity *supervisor.ObjectEntity) bool {
+		objects = append(objects, newObjectHealth(entity.Spec().Name(),
+			entity.Spec().Kind(), false, entity.Instance().Status().ObjectStatus))
+		return true
+	})
+
+	hs := aggregateHealth(objects)
+
+	buff, err := yaml.Marshal(hs)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", hs, err))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	// Load balancers only look at the status code.
+	if hs.Health != healthHealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	w.Write(buff)
+}
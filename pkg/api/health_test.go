@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/pipeline"
+)
+
+func TestAggregateHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	running := newObjectHealth("server-a", httpserver.Kind, true,
+		&httpserver.Status{Name: "server-a", State: "running"})
+	failed := newObjectHealth("server-b", httpserver.Kind, true,
+		&httpserver.Status{Name: "server-b", State: "failed", Error: "listen failed"})
+	pl := newObjectHealth("pipeline-a", pipeline.Kind, false, &pipeline.Status{})
+
+	assert.Equal(objectStateRunning, running.State)
+	assert.Equal("", running.Error)
+	assert.Equal(objectStateFailed, failed.State)
+	assert.Equal("listen failed", failed.Error)
+	assert.Equal(objectStateRunning, pl.State)
+
+	hs := aggregateHealth([]*ObjectHealth{running, pl})
+	assert.Equal(healthHealthy, hs.Health)
+	assert.Equal("pipeline-a", hs.Objects[0].Name)
+
+	hs = aggregateHealth([]*ObjectHealth{running, failed, pl})
+	assert.Equal(healthDegraded, hs.Health)
+	assert.Len(hs.Objects, 3)
+
+	// Only failed traffic gates degrade the member.
+	other := newObjectHealth("other", "Other", false, map[string]string{"state": "failed"})
+	hs = aggregateHealth([]*ObjectHealth{running, other})
+	assert.Equal(healthHealthy, hs.Health)
+
+	// Statuses without a map at the top-level are running.
+	hs = aggregateHealth([]*ObjectHealth{newObjectHealth("nil", "Nil", true, nil)})
+	assert.Equal(healthHealthy, hs.Health)
+}
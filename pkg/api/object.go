@@ -26,6 +26,8 @@ import (
 	"github.com/go-chi/chi/v5"
 	yaml "gopkg.in/yaml.v2"
 
+	"github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
 	"github.com/megaease/easegress/pkg/supervisor"
 )
 
@@ -72,6 +74,31 @@ func (s *Server) objectAPIEntries() []*Entry {
 			Method:  "DELETE",
 			Handler: s.deleteObject,
 		},
+		{
+			Path:    ObjectPrefix + "/{name}/drain",
+			Method:  "POST",
+			Handler: s.drainObject,
+		},
+		{
+			Path:    ObjectPrefix + "/{name}/drain",
+			Method:  "DELETE",
+			Handler: s.undrainObject,
+		},
+		{
+			Path:    ObjectPrefix + "/{name}/restart",
+			Method:  "POST",
+			Handler: s.restartObject,
+		},
+		{
+			Path:    ObjectPrefix + "/{name}/maintenance",
+			Method:  "POST",
+			Handler: s.startObjectMaintenance,
+		},
+		{
+			Path:    ObjectPrefix + "/{name}/maintenance",
+			Method:  "DELETE",
+			Handler: s.stopObjectMaintenance,
+		},
 		{
 			Path:    StatusObjectPrefix,
 			Method:  "GET",
@@ -213,6 +240,117 @@ func (s *Server) listObjects(w http.ResponseWriter, r *http.Request) {
 	w.Write(buff)
 }
 
+// trafficNamespace returns the namespace in the query of the request, it
+// is the namespace of the traffic objects created by the admin API if not
+// specified.
+func trafficNamespace(r *http.Request) string {
+	if namespace := r.URL.Query().Get("namespace"); namespace != "" {
+		return namespace
+	}
+	return rawconfigtrafficcontroller.DefaultNamespace
+}
+
+// getTrafficGate returns the traffic gate running in the current member,
+// it handles the error and returns nil if not found.
+func (s *Server) getTrafficGate(w http.ResponseWriter, r *http.Request) *supervisor.ObjectEntity {
+	name := chi.URLParam(r, "name")
+
+	entity, exists := s.super.GetSystemController(trafficcontroller.Kind)
+	if !exists {
+		HandleAPIError(w, r, http.StatusServiceUnavailable, fmt.Errorf("traffic controller not found"))
+		return nil
+	}
+
+	tc := entity.Instance().(*trafficcontroller.TrafficController)
+	gate, exists := tc.GetTrafficGate(trafficNamespace(r), name)
+	if !exists {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("not found"))
+		return nil
+	}
+
+	return gate
+}
+
+// drainObject drains the traffic gate running in the current member, it
+// is used to stop accepting new traffic before the member is shutdown.
+func (s *Server) drainObject(w http.ResponseWriter, r *http.Request) {
+	gate := s.getTrafficGate(w, r)
+	if gate == nil {
+		return
+	}
+
+	drainer, ok := gate.Instance().(interface{ Drain() })
+	if !ok {
+		HandleAPIError(w, r, http.StatusBadRequest,
+			fmt.Errorf("%s does not support draining", gate.Spec().Kind()))
+		return
+	}
+
+	drainer.Drain()
+}
+
+// undrainObject makes the drained traffic gate running in the current
+// member accept new traffic again.
+func (s *Server) undrainObject(w http.ResponseWriter, r *http.Request) {
+	gate := s.getTrafficGate(w, r)
+	if gate == nil {
+		return
+	}
+
+	undrainer, ok := gate.Instance().(interface{ Undrain() })
+	if !ok {
+		HandleAPIError(w, r, http.StatusBadRequest,
+			fmt.Errorf("%s does not support draining", gate.Spec().Kind()))
+		return
+	}
+
+	undrainer.Undrain()
+}
+
+// restartObject restarts the traffic gate running in the current member
+// gracefully without changing its spec, e.g. to reset the connections.
+func (s *Server) restartObject(w http.ResponseWriter, r *http.Request) {
+	gate := s.getTrafficGate(w, r)
+	if gate == nil {
+		return
+	}
+
+	restarter, ok := gate.Instance().(interface{ Restart() })
+	if !ok {
+		HandleAPIError(w, r, http.StatusBadRequest,
+			fmt.Errorf("%s does not support restarting", gate.Spec().Kind()))
+		return
+	}
+
+	restarter.Restart()
+}
+
+func (s *Server) startObjectMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.setObjectMaintenance(w, r, true)
+}
+
+func (s *Server) stopObjectMaintenance(w http.ResponseWriter, r *http.Request) {
+	s.setObjectMaintenance(w, r, false)
+}
+
+// setObjectMaintenance turns on or off the maintenance mode of the traffic
+// gate running in the current member, the pipelines are not affected.
+func (s *Server) setObjectMaintenance(w http.ResponseWriter, r *http.Request, on bool) {
+	gate := s.getTrafficGate(w, r)
+	if gate == nil {
+		return
+	}
+
+	m, ok := gate.Instance().(interface{ SetMaintenance(bool) })
+	if !ok {
+		HandleAPIError(w, r, http.StatusBadRequest,
+			fmt.Errorf("%s does not support maintenance", gate.Spec().Kind()))
+		return
+	}
+
+	m.SetMaintenance(on)
+}
+
 func (s *Server) getStatusObject(w http.ResponseWriter, r *http.Request) {
 	name := chi.URLParam(r, "name")
 
@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
 	"go.etcd.io/etcd/server/v3/embed"
@@ -330,7 +331,24 @@ func (c *cluster) getClient() (*clientv3.Client, error) {
 		}
 	}
 	logger.Infof("client connect with endpoints: %v", endpoints)
-	client, err := clientv3.New(clientv3.Config{
+	config, err := c.clientConfig(endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("build client config failed: %v", err)
+	}
+	client, err := clientv3.New(*config)
+	if err != nil {
+		return nil, fmt.Errorf("create client failed: %v", err)
+	}
+
+	logger.Infof("client is ready")
+
+	c.client = client
+
+	return client, nil
+}
+
+func (c *cluster) clientConfig(endpoints []string) (*clientv3.Config, error) {
+	config := &clientv3.Config{
 		Endpoints:            endpoints,
 		AutoSyncInterval:     autoSyncInterval,
 		DialTimeout:          dialTimeout,
@@ -338,16 +356,27 @@ func (c *cluster) getClient() (*clientv3.Client, error) {
 		DialKeepAliveTimeout: dialKeepAliveTimeout,
 		LogConfig:            logger.EtcdClientLoggerConfig(c.opt, logger.EtcdClientFilename),
 		MaxCallSendMsgSize:   c.opt.Cluster.MaxCallSendMsgSize,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("create client failed: %v", err)
+		Username:             c.opt.Cluster.Username,
+		Password:             c.opt.Cluster.Password,
 	}
 
-	logger.Infof("client is ready")
+	clientTLS := c.opt.Cluster.ClientTLS
+	if !clientTLS.Enabled() {
+		return config, nil
+	}
 
-	c.client = client
+	tlsInfo := transport.TLSInfo{
+		TrustedCAFile: clientTLS.CAFile,
+		CertFile:      clientTLS.CertFile,
+		KeyFile:       clientTLS.KeyFile,
+	}
+	tlsConfig, err := tlsInfo.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("build client tls config failed: %v", err)
+	}
+	config.TLS = tlsConfig
 
-	return client, nil
+	return config, nil
 }
 
 func (c *cluster) closeClient() {
@@ -18,6 +18,11 @@
 package cluster
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,6 +31,7 @@ import (
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	yaml "gopkg.in/yaml.v2"
 )
 
 type (
@@ -41,10 +47,19 @@ type (
 		GetRawPrefix(prefix string) (map[string]*mvccpb.KeyValue, error)
 		GetWithOp(key string, ops ...ClientOp) (map[string]string, error)
 
+		// GetInt, GetJSON and GetYAML get the value of the key and decode
+		// it, the returned bool is false if the key does not exist.
+		GetInt(key string) (int, bool, error)
+		GetJSON(key string, out interface{}) (bool, error)
+		GetYAML(key string, out interface{}) (bool, error)
+
 		Put(key, value string) error
 		PutUnderLease(key, value string) error
 		PutAndDelete(map[string]*string) error
 		PutAndDeleteUnderLease(map[string]*string) error
+		// PutIfAbsent puts the key-value only if the key does not exist,
+		// it returns whether the write happened.
+		PutIfAbsent(key, value string) (bool, error)
 
 		Delete(key string) error
 		DeletePrefix(prefix string) error
@@ -53,6 +68,10 @@ type (
 		// increase/decrease an integer by one, which is very useful to create
 		// a cluster-level counter.
 		STM(apply func(concurrency.STM) error) error
+		// STMWithContext is like STM, but the transaction is aborted
+		// once ctx is done, it is used by callers who can't wait for
+		// the cluster to be ready.
+		STMWithContext(ctx context.Context, apply func(concurrency.STM) error) error
 
 		Watcher() (Watcher, error)
 		Syncer(pullInterval time.Duration) (Syncer, error)
@@ -109,6 +128,44 @@ const (
 	OpKeysOnly ClientOp = "keysOnly"
 )
 
+// GetInt gets the value of key by get and parses it as an integer.
+func GetInt(get func(key string) (*string, error), key string) (int, bool, error) {
+	var v int
+	ok, err := getAndDecode(get, key, func(data string) (err error) {
+		v, err = strconv.Atoi(strings.TrimSpace(data))
+		return
+	})
+	return v, ok, err
+}
+
+// GetJSON gets the value of key by get and unmarshals it as JSON to out.
+func GetJSON(get func(key string) (*string, error), key string, out interface{}) (bool, error) {
+	return getAndDecode(get, key, func(data string) error {
+		return json.Unmarshal([]byte(data), out)
+	})
+}
+
+// GetYAML gets the value of key by get and unmarshals it as YAML to out.
+func GetYAML(get func(key string) (*string, error), key string, out interface{}) (bool, error) {
+	return getAndDecode(get, key, func(data string) error {
+		return yaml.Unmarshal([]byte(data), out)
+	})
+}
+
+func getAndDecode(get func(key string) (*string, error), key string, decode func(data string) error) (bool, error) {
+	value, err := get(key)
+	if err != nil {
+		return false, err
+	}
+	if value == nil {
+		return false, nil
+	}
+	if err = decode(*value); err != nil {
+		return true, fmt.Errorf("decode value of %s failed: %v", key, err)
+	}
+	return true, nil
+}
+
 func getOpOption(op ClientOp) clientv3.OpOption {
 	switch op {
 	case OpPrefix:
@@ -18,6 +18,7 @@
 package cluster
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -42,3 +43,76 @@ func TestGetOpOption(t *testing.T) {
 		assert.Equal(t, op1, op2)
 	}
 }
+
+func TestGetAndDecode(t *testing.T) {
+	assert := assert.New(t)
+
+	kvs := map[string]string{
+		"int":        " 10 ",
+		"badInt":     "ten",
+		"json":       `{"name": "alice", "age": 30}`,
+		"badJSON":    `{"name": `,
+		"yaml":       "name: bob\nage: 20\n",
+		"badYAML":    "name: [bob",
+		"errorValue": "",
+	}
+	get := func(key string) (*string, error) {
+		if key == "errorValue" {
+			return nil, fmt.Errorf("mocked error")
+		}
+		if v, ok := kvs[key]; ok {
+			return &v, nil
+		}
+		return nil, nil
+	}
+
+	type person struct {
+		Name string `json:"name" yaml:"name"`
+		Age  int    `json:"age" yaml:"age"`
+	}
+
+	// present
+	i, ok, err := GetInt(get, "int")
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(10, i)
+
+	p := &person{}
+	ok, err = GetJSON(get, "json", p)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(person{Name: "alice", Age: 30}, *p)
+
+	p = &person{}
+	ok, err = GetYAML(get, "yaml", p)
+	assert.NoError(err)
+	assert.True(ok)
+	assert.Equal(person{Name: "bob", Age: 20}, *p)
+
+	// missing
+	_, ok, err = GetInt(get, "missing")
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = GetJSON(get, "missing", p)
+	assert.NoError(err)
+	assert.False(ok)
+	ok, err = GetYAML(get, "missing", p)
+	assert.NoError(err)
+	assert.False(ok)
+
+	// malformed
+	_, ok, err = GetInt(get, "badInt")
+	assert.Error(err)
+	assert.True(ok)
+	ok, err = GetJSON(get, "badJSON", p)
+	assert.Error(err)
+	assert.True(ok)
+	ok, err = GetYAML(get, "badYAML", p)
+	assert.Error(err)
+	assert.True(ok)
+
+	// get error
+	_, ok, err = GetInt(get, "errorValue")
+	assert.Error(err)
+	assert.False(ok)
+}
@@ -18,16 +18,21 @@
 package cluster
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/phayes/freeport"
 	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
 
 	"github.com/megaease/easegress/pkg/env"
 	"github.com/megaease/easegress/pkg/option"
@@ -80,12 +85,13 @@ func closeClusters(clusters []*cluster) {
 	}
 }
 
-func createSecondaryNode(clusterName string, primaryListenPeerURLs []string) *cluster {
+func createSecondaryNode(homeDir, clusterName string, primaryListenPeerURLs []string) *cluster {
 	ports, err := freeport.GetFreePorts(1)
 	check(err)
 	name := "secondary-member-x"
 	opt := option.New()
 	opt.Name = name
+	opt.HomeDir = homeDir
 	opt.ClusterName = clusterName
 	opt.ClusterRole = "secondary"
 	opt.ClusterRequestTimeout = "10s"
@@ -106,7 +112,10 @@ func TestCluster(t *testing.T) {
 	clusterNodes := mockStaticCluster(3)
 	primaryName := clusterNodes[0].opt.ClusterName
 	primaryAddress := clusterNodes[0].opt.Cluster.InitialAdvertisePeerURLs
-	secondaryNode := createSecondaryNode(primaryName, primaryAddress)
+	homeDir, err := ioutil.TempDir("", "cluster-secondary-test")
+	check(err)
+	defer os.RemoveAll(homeDir)
+	secondaryNode := createSecondaryNode(homeDir, primaryName, primaryAddress)
 	defer closeClusters(clusterNodes)
 	defer closeClusters([]*cluster{secondaryNode})
 }
@@ -435,6 +444,15 @@ func TestMutexAndOP(t *testing.T) {
 	if err != nil {
 		t.Errorf("STM failed: %v", err)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = c.STMWithContext(ctx, func(s concurrency.STM) error {
+		return nil
+	})
+	if err != nil {
+		t.Errorf("STMWithContext failed: %v", err)
+	}
 }
 
 func TestUtilEqual(t *testing.T) {
@@ -537,3 +555,86 @@ func TestRunDefrag(t *testing.T) {
 
 	assert.NotNil(cluster.checkClusterName())
 }
+
+func TestClientConfig(t *testing.T) {
+	assert := assert.New(t)
+	etcdDirName, err := ioutil.TempDir("", "cluster-test")
+	check(err)
+	defer os.RemoveAll(etcdDirName)
+
+	opt := CreateOptionsForTest(etcdDirName)
+	c := &cluster{opt: opt}
+	endpoints := []string{"https://127.0.0.1:2379"}
+
+	config, err := c.clientConfig(endpoints)
+	assert.NoError(err)
+	assert.Equal(endpoints, config.Endpoints)
+	assert.Nil(config.TLS)
+	assert.Empty(config.Username)
+
+	opt.Cluster.Username = "root"
+	opt.Cluster.Password = "secret"
+	tlsInfo, err := transport.SelfCert(zap.NewNop(), etcdDirName, []string{"127.0.0.1"}, 1)
+	assert.NoError(err)
+	opt.Cluster.ClientTLS = option.ClientTLSOptions{
+		CAFile:   tlsInfo.CertFile,
+		CertFile: tlsInfo.CertFile,
+		KeyFile:  tlsInfo.KeyFile,
+	}
+
+	config, err = c.clientConfig(endpoints)
+	assert.NoError(err)
+	assert.Equal("root", config.Username)
+	assert.Equal("secret", config.Password)
+	assert.NotNil(config.TLS)
+	assert.NotNil(config.TLS.GetClientCertificate)
+	assert.NotNil(config.TLS.RootCAs)
+
+	opt.Cluster.ClientTLS.KeyFile = filepath.Join(etcdDirName, "not-exist.key")
+	_, err = c.clientConfig(endpoints)
+	assert.Error(err)
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	assert := assert.New(t)
+
+	opts, _, _ := mockMembers(1)
+	cls, err := New(opts[0])
+	assert.NoError(err)
+	c := cls.(*cluster)
+	defer closeClusters([]*cluster{c})
+
+	_, err = c.getClient()
+	assert.NoError(err)
+
+	// absent, writes.
+	ok, err := c.PutIfAbsent("/test/putifabsent", "v1")
+	assert.NoError(err)
+	assert.True(ok)
+
+	// present, no-op.
+	ok, err = c.PutIfAbsent("/test/putifabsent", "v2")
+	assert.NoError(err)
+	assert.False(ok)
+
+	value, err := c.Get("/test/putifabsent")
+	assert.NoError(err)
+	assert.Equal("v1", *value)
+
+	// concurrent writers, only one wins.
+	var wins int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := c.PutIfAbsent("/test/putifabsent/race", fmt.Sprintf("v%d", i))
+			assert.NoError(err)
+			if ok {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(int32(1), wins)
+}
@@ -18,6 +18,7 @@
 package clustertest
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -40,9 +41,11 @@ type MockedCluster struct {
 	MockedPutUnderLease          func(key, value string) error
 	MockedPutAndDelete           func(map[string]*string) error
 	MockedPutAndDeleteUnderLease func(map[string]*string) error
+	MockedPutIfAbsent            func(key, value string) (bool, error)
 	MockedDelete                 func(key string) error
 	MockedDeletePrefix           func(prefix string) error
 	MockedSTM                    func(apply func(concurrency.STM) error) error
+	MockedSTMWithContext         func(ctx context.Context, apply func(concurrency.STM) error) error
 	MockedWatcher                func() (cluster.Watcher, error)
 	MockedSyncer                 func(pullInterval time.Duration) (cluster.Syncer, error)
 	MockedMutex                  func(name string) (cluster.Mutex, error)
@@ -83,6 +86,21 @@ func (mc *MockedCluster) Get(key string) (*string, error) {
 	return nil, nil
 }
 
+// GetInt implements interface function GetInt, it uses Get to get the value
+func (mc *MockedCluster) GetInt(key string) (int, bool, error) {
+	return cluster.GetInt(mc.Get, key)
+}
+
+// GetJSON implements interface function GetJSON, it uses Get to get the value
+func (mc *MockedCluster) GetJSON(key string, out interface{}) (bool, error) {
+	return cluster.GetJSON(mc.Get, key, out)
+}
+
+// GetYAML implements interface function GetYAML, it uses Get to get the value
+func (mc *MockedCluster) GetYAML(key string, out interface{}) (bool, error) {
+	return cluster.GetYAML(mc.Get, key, out)
+}
+
 // GetPrefix implements interface function GetPrefix
 func (mc *MockedCluster) GetPrefix(prefix string) (map[string]string, error) {
 	if mc.MockedGetPrefix != nil {
@@ -147,6 +165,19 @@ func (mc *MockedCluster) PutAndDeleteUnderLease(m map[string]*string) error {
 	return nil
 }
 
+// PutIfAbsent implements interface function PutIfAbsent, it uses Get
+// and Put if MockedPutIfAbsent is nil
+func (mc *MockedCluster) PutIfAbsent(key, value string) (bool, error) {
+	if mc.MockedPutIfAbsent != nil {
+		return mc.MockedPutIfAbsent(key, value)
+	}
+	v, err := mc.Get(key)
+	if err != nil || v != nil {
+		return false, err
+	}
+	return true, mc.Put(key, value)
+}
+
 // Delete implements interface function Delete
 func (mc *MockedCluster) Delete(key string) error {
 	if mc.MockedDelete != nil {
@@ -171,6 +202,15 @@ func (mc *MockedCluster) STM(apply func(concurrency.STM) error) error {
 	return nil
 }
 
+// STMWithContext implements interface function STMWithContext, it falls
+// back to MockedSTM if MockedSTMWithContext is not set.
+func (mc *MockedCluster) STMWithContext(ctx context.Context, apply func(concurrency.STM) error) error {
+	if mc.MockedSTMWithContext != nil {
+		return mc.MockedSTMWithContext(ctx, apply)
+	}
+	return mc.STM(apply)
+}
+
 // Watcher implements interface function Watcher
 func (mc *MockedCluster) Watcher() (cluster.Watcher, error) {
 	if mc.MockedWatcher != nil {
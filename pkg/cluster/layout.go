@@ -34,7 +34,8 @@ const (
 	configObjectFormat       = "/config/objects/%s" // +objectName
 	configVersion            = "/config/version"
 	wasmCodeEvent            = "/wasm/code"
-	wasmDataPrefixFormat     = "/wasm/data/%s/%s/" // + pipelineName + filterName
+	wasmDataPrefixFormat     = "/wasm/data/%s/%s/"  // + pipelineName + filterName
+	rateLimitPrefixFormat    = "/rate-limit/%s/%s/" // + pipelineName + filterName
 	customDataKindPrefix     = "/custom-data-kinds/"
 	customDataPrefix         = "/custom-data/"
 	fullObjectNameFormat     = "%s/%s" // +namespace + name
@@ -142,6 +143,11 @@ func (l *Layout) WasmDataPrefix(pipeline string, name string) string {
 	return fmt.Sprintf(wasmDataPrefixFormat, pipeline, name)
 }
 
+// RateLimitPrefix returns the prefix of rate limit counters
+func (l *Layout) RateLimitPrefix(pipeline string, name string) string {
+	return fmt.Sprintf(rateLimitPrefixFormat, pipeline, name)
+}
+
 // CustomDataPrefix returns the prefix of all custom data
 func (l *Layout) CustomDataPrefix() string {
 	return customDataPrefix
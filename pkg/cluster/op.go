@@ -18,6 +18,8 @@
 package cluster
 
 import (
+	"context"
+
 	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
@@ -55,6 +57,24 @@ func (c *cluster) Put(key, value string) error {
 	return err
 }
 
+func (c *cluster) PutIfAbsent(key, value string) (bool, error) {
+	client, err := c.getClient()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := c.requestContext()
+	defer cancel()
+	resp, err := client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
 func (c *cluster) PutAndDeleteUnderLease(kvs map[string]*string) error {
 	return c.putAndDelete(kvs, true)
 }
@@ -128,6 +148,18 @@ func (c *cluster) Get(key string) (*string, error) {
 	return &value, nil
 }
 
+func (c *cluster) GetInt(key string) (int, bool, error) {
+	return GetInt(c.Get, key)
+}
+
+func (c *cluster) GetJSON(key string, out interface{}) (bool, error) {
+	return GetJSON(c.Get, key, out)
+}
+
+func (c *cluster) GetYAML(key string, out interface{}) (bool, error) {
+	return GetYAML(c.Get, key, out)
+}
+
 func (c *cluster) GetRaw(key string) (*mvccpb.KeyValue, error) {
 	client, err := c.getClient()
 	if err != nil {
@@ -223,3 +255,12 @@ func (c *cluster) STM(apply func(concurrency.STM) error) error {
 	_, err = concurrency.NewSTM(client, apply)
 	return err
 }
+
+func (c *cluster) STMWithContext(ctx context.Context, apply func(concurrency.STM) error) error {
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+	_, err = concurrency.NewSTM(client, apply, concurrency.WithAbortContext(ctx))
+	return err
+}
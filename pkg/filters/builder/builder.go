@@ -20,6 +20,9 @@ package builder
 import (
 	"bytes"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"text/template"
 
 	sprig "github.com/go-task/slim-sprig"
@@ -56,17 +59,60 @@ func (spec *Spec) Validate() error {
 		return fmt.Errorf("sourceNamespace and template cannot be specified at the same time")
 	}
 
+	if spec.Template != "" {
+		if _, err := spec.parseTemplate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+var (
+	reTemplateErrLine = regexp.MustCompile(`^template: [^:]*:(\d+):`)
+	reTemplateField   = regexp.MustCompile(`^([A-Za-z]\w*)\s*:`)
+)
+
+// parseTemplate parses the template, if it fails, the error reports the
+// field of the result where the error is, e.g. url or body.
+func (spec *Spec) parseTemplate() (*template.Template, error) {
+	t, err := NewTemplate(spec.LeftDelim, spec.RightDelim, spec.Template)
+	if err == nil {
+		return t, nil
+	}
+
+	m := reTemplateErrLine.FindStringSubmatch(err.Error())
+	if m == nil {
+		return nil, fmt.Errorf("invalid template: %v", err)
+	}
+	line, _ := strconv.Atoi(m[1])
+	lines := strings.Split(spec.Template, "\n")
+	for i := line - 1; i >= 0 && i < len(lines); i-- {
+		if f := reTemplateField.FindStringSubmatch(lines[i]); f != nil {
+			return nil, fmt.Errorf("invalid template of field %s: %v", f[1], err)
+		}
+	}
+	return nil, fmt.Errorf("invalid template: %v", err)
+}
+
+// NewTemplate parses text as a template with the functions available to
+// builders, empty delimiters mean the default ones.
+func NewTemplate(leftDelim, rightDelim, text string) (*template.Template, error) {
+	t := template.New("").Delims(leftDelim, rightDelim)
+	t.Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
+	return t.Parse(text)
+}
+
 func (b *Builder) reload(spec *Spec) {
 	if spec.SourceNamespace != "" {
 		return
 	}
 
-	t := template.New("").Delims(spec.LeftDelim, spec.RightDelim)
-	t.Funcs(sprig.TxtFuncMap()).Funcs(extraFuncs)
-	b.template = template.Must(t.Parse(spec.Template))
+	t, err := spec.parseTemplate()
+	if err != nil {
+		panic(err)
+	}
+	b.template = t
 }
 
 func (b *Builder) build(data map[string]interface{}, v interface{}) error {
@@ -92,7 +138,9 @@ func (b *Builder) Status() interface{} {
 func (b *Builder) Close() {
 }
 
-func prepareBuilderData(ctx *context.Context) (map[string]interface{}, error) {
+// PrepareData prepares the data of ctx for executing templates created by
+// NewTemplate.
+func PrepareData(ctx *context.Context) (map[string]interface{}, error) {
 	requests := make(map[string]interface{})
 	responses := make(map[string]interface{})
 
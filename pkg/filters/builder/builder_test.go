@@ -45,3 +45,43 @@ func TestBuilderSpec(t *testing.T) {
 	err = invalidSpec2.Validate()
 	assert.NotNil(err)
 }
+
+func TestBuilderSpecTemplate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := Spec{Template: `
+method: {{ .requests.DEFAULT.Method }}
+url: /{{ .requests.DEFAULT.URL.Path }}
+`}
+	assert.Nil(spec.Validate())
+
+	for field, tmpl := range map[string]string{
+		"url": `
+method: GET
+url: /{{ index .requests.DEFAULT.URL.Path }
+body: ok
+`,
+		"body": `
+method: GET
+body: |
+  {{ if .requests.DEFAULT.Body }}
+  {{ .requests.DEFAULT.Body }}
+`,
+		"headers": `
+method: GET
+headers:
+  X-Test: [{{ undefinedFunc }}]
+body: ok
+`,
+	} {
+		spec := Spec{Template: tmpl}
+		err := spec.Validate()
+		assert.NotNil(err)
+		assert.Contains(err.Error(), "field "+field)
+
+		assert.Panics(func() {
+			b := &Builder{}
+			b.reload(&spec)
+		})
+	}
+}
@@ -18,6 +18,8 @@
 package builder
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -90,6 +92,50 @@ func mergeObject(objs ...map[string]interface{}) interface{} {
 	return out
 }
 
+// jsonPath returns the value at path of a JSON document, v could be a
+// JSON string, JSON bytes or a parsed JSON object (e.g. JSONBody of a
+// request). Keys of the path are separated by '.', and array elements
+// are accessed by index, e.g. "users.0.name". It returns nil if the value
+// doesn't exist.
+func jsonPath(path string, v interface{}) interface{} {
+	var data []byte
+	switch d := v.(type) {
+	case string:
+		data = []byte(d)
+	case []byte:
+		data = d
+	}
+	if data != nil {
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return nil
+		}
+	}
+
+	if path == "" {
+		return v
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch o := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = o[key]; !ok {
+				return nil
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(o) {
+				return nil
+			}
+			v = o[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
 var extraFuncs = template.FuncMap{
 	"addf": func(a, b interface{}) float64 {
 		x, y := toFloat64(a), toFloat64(b)
@@ -129,4 +175,18 @@ var extraFuncs = template.FuncMap{
 	},
 
 	"mergeObject": mergeObject,
+
+	"jsonPath": jsonPath,
+
+	// aliases of b64enc and b64dec of sprig.
+	"base64enc": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"base64dec": func(s string) string {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	},
 }
@@ -98,3 +98,27 @@ func TestMergeObject(t *testing.T) {
 	assert.Equal(4, m["e2"])
 	assert.Equal(5, m["e3"])
 }
+
+func TestJSONPath(t *testing.T) {
+	assert := assert.New(t)
+
+	doc := `{"users": [{"name": "alice", "age": 20}, {"name": "bob"}], "total": 2}`
+	assert.Equal("alice", jsonPath("users.0.name", doc))
+	assert.Equal("bob", jsonPath("users.1.name", []byte(doc)))
+	assert.Equal(json.Number("20"), jsonPath("users.0.age", doc))
+	assert.Equal(json.Number("2"), jsonPath("total", doc))
+	assert.Nil(jsonPath("users.2.name", doc))
+	assert.Nil(jsonPath("users.x", doc))
+	assert.Nil(jsonPath("total.x", doc))
+	assert.Nil(jsonPath("users", "not json"))
+
+	obj := map[string]interface{}{"a": map[string]interface{}{"b": "c"}}
+	assert.Equal("c", jsonPath("a.b", obj))
+	assert.Equal(obj, jsonPath("", obj))
+
+	enc := extraFuncs["base64enc"].(func(string) string)
+	dec := extraFuncs["base64dec"].(func(string) string)
+	assert.Equal("aGVsbG8=", enc("hello"))
+	assert.Equal("hello", dec("aGVsbG8="))
+	assert.NotEqual("hello", dec("!!"))
+}
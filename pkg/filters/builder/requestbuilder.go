@@ -19,6 +19,7 @@ package builder
 
 import (
 	"fmt"
+	"io"
 	"runtime/debug"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -30,6 +31,16 @@ import (
 const (
 	// RequestBuilderKind is the kind of RequestBuilder.
 	RequestBuilderKind = "RequestBuilder"
+
+	// StreamBodyIgnore means stream bodies are not read, and are not
+	// available in the template.
+	StreamBodyIgnore = "ignore"
+	// StreamBodyPreserve means stream bodies are read into memory for
+	// the template, and the source requests are still readable.
+	StreamBodyPreserve = "preserve"
+	// StreamBodyConsume means stream bodies are read into memory for
+	// the template, and the bodies of the source requests are released.
+	StreamBodyConsume = "consume"
 )
 
 var requestBuilderKind = &filters.Kind{
@@ -60,6 +71,7 @@ type (
 		filters.BaseSpec `yaml:",inline"`
 		Spec             `yaml:",inline"`
 		Protocol         string `yaml:"protocol" jsonschema:"omitempty"`
+		StreamBody       string `yaml:"streamBody,omitempty" jsonschema:"omitempty,enum=ignore,enum=preserve,enum=consume"`
 	}
 )
 
@@ -115,9 +127,18 @@ func (rb *RequestBuilder) Handle(ctx *context.Context) (result string) {
 		}
 	}()
 
-	data, err := prepareBuilderData(ctx)
+	var streams []protocols.Request
+	if rb.spec.StreamBody == StreamBodyPreserve || rb.spec.StreamBody == StreamBodyConsume {
+		var err error
+		if streams, err = readStreamBodies(ctx); err != nil {
+			logger.Warnf("RequestBuilder(%s): failed to read stream body: %v", rb.Name(), err)
+			return resultBuildErr
+		}
+	}
+
+	data, err := PrepareData(ctx)
 	if err != nil {
-		logger.Warnf("prepareBuilderData failed: %v", err)
+		logger.Warnf("PrepareData failed: %v", err)
 		return resultBuildErr
 	}
 
@@ -129,6 +150,12 @@ func (rb *RequestBuilder) Handle(ctx *context.Context) (result string) {
 		return resultBuildErr
 	}
 
+	if rb.spec.StreamBody == StreamBodyConsume {
+		for _, r := range streams {
+			r.SetPayload(nil)
+		}
+	}
+
 	req, err := p.BuildRequest(ri)
 	if err != nil {
 		logger.Warnf(err.Error())
@@ -138,3 +165,28 @@ func (rb *RequestBuilder) Handle(ctx *context.Context) (result string) {
 	ctx.SetOutputRequest(req)
 	return ""
 }
+
+// readStreamBodies reads the stream bodies of the requests in ctx into
+// memory, and sets them back to the requests, so the bodies are available
+// in the template and the requests are still readable. It returns the
+// requests whose body was a stream.
+func readStreamBodies(ctx *context.Context) ([]protocols.Request, error) {
+	var streams []protocols.Request
+	for _, r := range ctx.Requests() {
+		if !r.IsStream() {
+			continue
+		}
+
+		body := r.GetPayload()
+		data, err := io.ReadAll(body)
+		if c, ok := body.(io.Closer); ok {
+			c.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.SetPayload(data)
+		streams = append(streams, r)
+	}
+	return streams, nil
+}
@@ -267,6 +267,41 @@ template: |
 		testReq := ctx.GetRequest("test").(*httpprot.Request)
 		assert.Equal("Hello! World!", string(testReq.RawPayload()))
 	}
+
+	// test string, encoding and JSON functions
+	{
+		yml := `template: |
+  method: {{ .requests.src.Header.Get "X-Method" | default "get" | upper }}
+  url: /users/{{ .requests.src.JSONBody | jsonPath "user.name" | lower }}
+  headers:
+    X-Token: [{{ .requests.src.Header.Get "X-User" | trim | base64enc }}]
+    X-Role: [{{ jsonPath "user.roles.1" .requests.src.Body }}]
+  body: {{ "aGVsbG8=" | base64dec }}
+`
+		spec := &RequestBuilderSpec{}
+		yaml.Unmarshal([]byte(yml), spec)
+		rb := getRequestBuilder(spec)
+		defer rb.Close()
+
+		ctx := context.New(nil)
+		stdReq, err := http.NewRequest(http.MethodPost, "http://www.megaease.com/", strings.NewReader(`{"user": {"name": "Alice", "roles": ["user", "admin"]}}`))
+		assert.Nil(err)
+		stdReq.Header.Set("X-User", " alice ")
+		req, err := httpprot.NewRequest(stdReq)
+		assert.Nil(err)
+		req.FetchPayload(1024 * 1024)
+		ctx.SetRequest("src", req)
+		ctx.UseNamespace("test")
+
+		res := rb.Handle(ctx)
+		assert.Empty(res)
+		testReq := ctx.GetRequest("test").(*httpprot.Request)
+		assert.Equal(http.MethodGet, testReq.Method())
+		assert.Equal("/users/alice", testReq.Path())
+		assert.Equal("YWxpY2U=", testReq.HTTPHeader().Get("X-Token"))
+		assert.Equal("admin", testReq.HTTPHeader().Get("X-Role"))
+		assert.Equal("hello", string(testReq.RawPayload()))
+	}
 }
 
 func TestRequestBody(t *testing.T) {
@@ -440,6 +475,69 @@ field2: value2
 	}
 }
 
+func TestRequestBuilderSourceBody(t *testing.T) {
+	assert := assert.New(t)
+
+	build := func(streamBody string, stream bool) (*httpprot.Request, *httpprot.Request) {
+		yml := `template: |
+  method: POST
+  url: http://www.facebook.com
+  body: body {{ .requests.request1.Body }}
+`
+		spec := &RequestBuilderSpec{}
+		yaml.Unmarshal([]byte(yml), spec)
+		spec.StreamBody = streamBody
+		rb := getRequestBuilder(spec)
+		defer rb.Close()
+
+		ctx := context.New(nil)
+		stdReq, err := http.NewRequest(http.MethodPost, "http://www.google.com", strings.NewReader("123"))
+		assert.Nil(err)
+		req1, err := httpprot.NewRequest(stdReq)
+		assert.Nil(err)
+		if stream {
+			req1.FetchPayload(-1)
+		} else {
+			req1.FetchPayload(1024 * 1024)
+		}
+		ctx.SetRequest("request1", req1)
+		ctx.UseNamespace("test")
+
+		assert.Empty(rb.Handle(ctx))
+		return req1, ctx.GetRequest("test").(*httpprot.Request)
+	}
+
+	// the body of request1 is still readable after building.
+	req1, testReq := build("", false)
+	assert.Equal("body 123", string(testReq.RawPayload()))
+	data, err := io.ReadAll(req1.GetPayload())
+	assert.Nil(err)
+	assert.Equal("123", string(data))
+
+	// stream bodies are not read by default.
+	req1, testReq = build("", true)
+	assert.Contains(string(testReq.RawPayload()), "is a stream")
+	assert.True(req1.IsStream())
+	data, err = io.ReadAll(req1.GetPayload())
+	assert.Nil(err)
+	assert.Equal("123", string(data))
+
+	// stream bodies are read and preserved.
+	req1, testReq = build(StreamBodyPreserve, true)
+	assert.Equal("body 123", string(testReq.RawPayload()))
+	assert.False(req1.IsStream())
+	data, err = io.ReadAll(req1.GetPayload())
+	assert.Nil(err)
+	assert.Equal("123", string(data))
+
+	// stream bodies are read and consumed.
+	req1, testReq = build(StreamBodyConsume, true)
+	assert.Equal("body 123", string(testReq.RawPayload()))
+	data, err = io.ReadAll(req1.GetPayload())
+	assert.Nil(err)
+	assert.Empty(data)
+}
+
 func TestRequestBuilder(t *testing.T) {
 	assert := assert.New(t)
 
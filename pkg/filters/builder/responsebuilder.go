@@ -115,9 +115,9 @@ func (rb *ResponseBuilder) Handle(ctx *context.Context) (result string) {
 		}
 	}()
 
-	data, err := prepareBuilderData(ctx)
+	data, err := PrepareData(ctx)
 	if err != nil {
-		logger.Warnf("prepareBuilderData failed: %v", err)
+		logger.Warnf("PrepareData failed: %v", err)
 		return resultBuildErr
 	}
 
@@ -18,6 +18,7 @@
 package corsadaptor
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 
@@ -74,6 +75,17 @@ type (
 	}
 )
 
+// Validate validates the spec of CORSAdaptor.
+func (spec *Spec) Validate() error {
+	// Browsers reject "Access-Control-Allow-Origin: *" for credentialed
+	// requests, and allowing credentials for all origins would let any
+	// site read the credentialed responses.
+	if spec.AllowCredentials && allowAllOrigins(spec.AllowedOrigins) {
+		return fmt.Errorf("allowCredentials requires an explicit list of allowedOrigins")
+	}
+	return nil
+}
+
 // Name returns the name of the CORSAdaptor filter instance.
 func (a *CORSAdaptor) Name() string {
 	return a.spec.Name()
@@ -100,14 +112,28 @@ func (a *CORSAdaptor) Inherit(previousGeneration filters.Filter) {
 }
 
 func (a *CORSAdaptor) reload() {
-	a.cors = cors.New(cors.Options{
+	opts := cors.Options{
 		AllowedOrigins:   a.spec.AllowedOrigins,
 		AllowedMethods:   a.spec.AllowedMethods,
 		AllowedHeaders:   a.spec.AllowedHeaders,
 		AllowCredentials: a.spec.AllowCredentials,
 		ExposedHeaders:   a.spec.ExposedHeaders,
 		MaxAge:           a.spec.MaxAge,
-	})
+	}
+
+	a.cors = cors.New(opts)
+}
+
+func allowAllOrigins(origins []string) bool {
+	if len(origins) == 0 {
+		return true
+	}
+	for _, o := range origins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
 }
 
 // Handle handles simple cross-origin requests or directs.
@@ -140,6 +166,14 @@ func (a *CORSAdaptor) handleCORS(ctx *context.Context) string {
 	// set CORS headers to response
 	rw := httptest.NewRecorder()
 	a.cors.HandlerFunc(rw, r.Std())
+
+	// If there's already a response, e.g. the filter is placed after a
+	// Proxy, inject the CORS headers into it instead of replacing it.
+	if resp, ok := ctx.GetOutputResponse().(*httpprot.Response); ok && !isPreflight {
+		injectHeaders(resp.HTTPHeader(), rw.Header())
+		return ""
+	}
+
 	resp, _ := httpprot.NewResponse(rw.Result())
 	ctx.SetOutputResponse(resp)
 	if !isCorsRequest {
@@ -151,6 +185,18 @@ func (a *CORSAdaptor) handleCORS(ctx *context.Context) string {
 	return "" // next filter
 }
 
+// injectHeaders sets the CORS headers to dst, the "Vary" header is
+// appended as it could be set by the backend.
+func injectHeaders(dst, cors http.Header) {
+	for k, v := range cors {
+		if k == "Vary" {
+			dst[k] = append(dst[k], v...)
+		} else {
+			dst[k] = v
+		}
+	}
+}
+
 // Status return status.
 func (a *CORSAdaptor) Status() interface{} {
 	return nil
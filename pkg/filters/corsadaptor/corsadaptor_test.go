@@ -123,4 +123,146 @@ allowedOrigins:
 			t.Error("request should not be preflighted")
 		}
 	})
+
+	newCORS := func(yamlSpec string) filters.Filter {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+		spec, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Nil(err)
+		cors := kind.CreateInstance(spec)
+		cors.Init()
+		return cors
+	}
+
+	const yamlSpec = `
+kind: CORSAdaptor
+name: cors
+supportCORSRequest: true
+allowedOrigins:
+  - http://*.megaease.com
+allowedMethods: [GET, PUT]
+allowedHeaders: [X-Token]
+maxAge: 600
+`
+
+	t.Run("preflight headers", func(t *testing.T) {
+		cors := newCORS(yamlSpec)
+
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodOptions, "http://example.com", nil)
+		req.Header.Set("Origin", "http://www.megaease.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		req.Header.Set("Access-Control-Request-Headers", "X-Token")
+		setRequest(t, ctx, req)
+
+		assert.Equal(resultPreflighted, cors.Handle(ctx))
+		h := ctx.GetOutputResponse().(*httpprot.Response).HTTPHeader()
+		assert.Equal("http://www.megaease.com", h.Get("Access-Control-Allow-Origin"))
+		assert.Equal(http.MethodPut, h.Get("Access-Control-Allow-Methods"))
+		assert.Equal("X-Token", h.Get("Access-Control-Allow-Headers"))
+		assert.Equal("600", h.Get("Access-Control-Max-Age"))
+		assert.Equal("", h.Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		cors := newCORS(yamlSpec)
+
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodOptions, "http://example.com", nil)
+		req.Header.Set("Origin", "http://www.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		setRequest(t, ctx, req)
+
+		assert.Equal(resultPreflighted, cors.Handle(ctx))
+		h := ctx.GetOutputResponse().(*httpprot.Response).HTTPHeader()
+		assert.Equal("", h.Get("Access-Control-Allow-Origin"))
+		assert.Equal("", h.Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("inject headers into response", func(t *testing.T) {
+		cors := newCORS(yamlSpec)
+
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		req.Header.Set("Origin", "http://www.megaease.com")
+		setRequest(t, ctx, req)
+
+		// the response from the backend.
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusCreated)
+		resp.HTTPHeader().Set("Vary", "Accept-Encoding")
+		resp.SetPayload("backend")
+		ctx.SetOutputResponse(resp)
+
+		assert.Equal("", cors.Handle(ctx))
+		assert.Same(resp, ctx.GetOutputResponse())
+		assert.Equal(http.StatusCreated, resp.StatusCode())
+		assert.Equal("backend", string(resp.RawPayload()))
+		h := resp.HTTPHeader()
+		assert.Equal("http://www.megaease.com", h.Get("Access-Control-Allow-Origin"))
+		assert.Equal([]string{"Accept-Encoding", "Origin"}, h.Values("Vary"))
+
+		// disallowed origin.
+		ctx = context.New(nil)
+		req.Header.Set("Origin", "http://www.example.com")
+		setRequest(t, ctx, req)
+		resp, _ = httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+		assert.Equal("", cors.Handle(ctx))
+		assert.Equal("", resp.HTTPHeader().Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("credentialed request", func(t *testing.T) {
+		cors := newCORS(`
+kind: CORSAdaptor
+name: cors
+supportCORSRequest: true
+allowCredentials: true
+allowedOrigins: ["http://*.megaease.com"]
+`)
+
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodOptions, "http://example.com", nil)
+		req.Header.Set("Origin", "http://www.megaease.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		setRequest(t, ctx, req)
+
+		assert.Equal(resultPreflighted, cors.Handle(ctx))
+		h := ctx.GetOutputResponse().(*httpprot.Response).HTTPHeader()
+		// the origin is echoed instead of "*" for credentialed requests.
+		assert.Equal("http://www.megaease.com", h.Get("Access-Control-Allow-Origin"))
+		assert.Equal("true", h.Get("Access-Control-Allow-Credentials"))
+
+		ctx = context.New(nil)
+		req.Method = http.MethodGet
+		req.Header.Del("Access-Control-Request-Method")
+		req.Header.Set("Cookie", "session=1")
+		setRequest(t, ctx, req)
+		resp, _ := httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+		assert.Equal("", cors.Handle(ctx))
+		assert.Equal("http://www.megaease.com", resp.HTTPHeader().Get("Access-Control-Allow-Origin"))
+		assert.Equal("true", resp.HTTPHeader().Get("Access-Control-Allow-Credentials"))
+
+		// disallowed origin.
+		ctx = context.New(nil)
+		req.Header.Set("Origin", "http://www.example.com")
+		setRequest(t, ctx, req)
+		resp, _ = httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+		assert.Equal("", cors.Handle(ctx))
+		assert.Equal("", resp.HTTPHeader().Get("Access-Control-Allow-Origin"))
+		assert.Equal("", resp.HTTPHeader().Get("Access-Control-Allow-Credentials"))
+	})
+
+	t.Run("credentials with all origins", func(t *testing.T) {
+		spec := &Spec{AllowCredentials: true}
+		assert.Error(spec.Validate())
+		spec.AllowedOrigins = []string{"http://www.megaease.com", "*"}
+		assert.Error(spec.Validate())
+		spec.AllowedOrigins = []string{"http://www.megaease.com"}
+		assert.NoError(spec.Validate())
+		spec = &Spec{}
+		assert.NoError(spec.Validate())
+	})
 }
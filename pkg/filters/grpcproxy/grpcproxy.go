@@ -0,0 +1,321 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcproxy
+
+import (
+	stdcontext "context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// Kind is the kind of GRPCProxy.
+	Kind = "GRPCProxy"
+
+	resultInternalError = "internalError"
+	resultClientError   = "clientError"
+	resultServerError   = "serverError"
+	resultTimeout       = "timeout"
+)
+
+// gRPC status codes used by the proxy itself, see
+// https://github.com/grpc/grpc/blob/master/doc/statuscodes.md.
+const (
+	grpcCodeDeadlineExceeded = 4
+	grpcCodeInternal         = 13
+	grpcCodeUnavailable      = 14
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "GRPCProxy proxies gRPC requests to backend servers",
+	Results: []string{
+		resultInternalError,
+		resultClientError,
+		resultServerError,
+		resultTimeout,
+	},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &GRPCProxy{spec: spec.(*Spec)}
+	},
+}
+
+var _ filters.Filter = (*GRPCProxy)(nil)
+
+func init() {
+	filters.Register(kind)
+}
+
+// Hop-by-hop headers, they are rejected by HTTP/2.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type (
+	// GRPCProxy is the filter GRPCProxy.
+	GRPCProxy struct {
+		spec *Spec
+
+		lb      proxy.LoadBalancer
+		timeout time.Duration
+
+		// h2cTransport is used for servers of the http scheme, and
+		// tlsTransport is used for servers of the https scheme.
+		h2cTransport *http2.Transport
+		tlsTransport *http2.Transport
+	}
+
+	// Spec describes the GRPCProxy.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Servers     []*proxy.Server        `yaml:"servers" jsonschema:"required"`
+		LoadBalance *proxy.LoadBalanceSpec `yaml:"loadBalance,omitempty" jsonschema:"omitempty"`
+		Timeout     string                 `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates Spec.
+func (s *Spec) Validate() error {
+	if len(s.Servers) == 0 {
+		return fmt.Errorf("servers is empty")
+	}
+
+	serversGotWeight := 0
+	for _, server := range s.Servers {
+		u, err := url.Parse(server.URL)
+		if err != nil {
+			return fmt.Errorf("invalid server url %s: %v", server.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("scheme of server url %s is not http or https", server.URL)
+		}
+		if server.Weight > 0 {
+			serversGotWeight++
+		}
+	}
+	if serversGotWeight > 0 && serversGotWeight < len(s.Servers) {
+		msgFmt := "not all servers have weight(%d/%d)"
+		return fmt.Errorf(msgFmt, serversGotWeight, len(s.Servers))
+	}
+
+	if s.LoadBalance != nil && s.LoadBalance.Policy == proxy.LoadBalancePolicyHeaderHash && s.LoadBalance.HeaderHashKey == "" {
+		return fmt.Errorf("headerHash needs to specify headerHashKey")
+	}
+
+	return nil
+}
+
+// Name returns the name of the GRPCProxy filter instance.
+func (gp *GRPCProxy) Name() string {
+	return gp.spec.Name()
+}
+
+// Kind returns the kind of GRPCProxy.
+func (gp *GRPCProxy) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the GRPCProxy
+func (gp *GRPCProxy) Spec() filters.Spec {
+	return gp.spec
+}
+
+// Init initializes GRPCProxy.
+func (gp *GRPCProxy) Init() {
+	gp.reload()
+}
+
+// Inherit inherits previous generation of GRPCProxy.
+func (gp *GRPCProxy) Inherit(previousGeneration filters.Filter) {
+	gp.reload()
+}
+
+func (gp *GRPCProxy) reload() {
+	lbSpec := gp.spec.LoadBalance
+	if lbSpec == nil {
+		lbSpec = &proxy.LoadBalanceSpec{}
+	}
+	gp.lb = proxy.NewLoadBalancer(lbSpec, gp.spec.Servers)
+
+	if gp.spec.Timeout != "" {
+		timeout, err := time.ParseDuration(gp.spec.Timeout)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", gp.spec.Timeout, err)
+		}
+		gp.timeout = timeout
+	}
+
+	gp.h2cTransport = &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	gp.tlsTransport = &http2.Transport{}
+}
+
+// Handle handles the gRPC request in the context.
+func (gp *GRPCProxy) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	if !httpprot.IsGRPC(req.HTTPHeader()) {
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(http.StatusUnsupportedMediaType)
+		ctx.SetOutputResponse(resp)
+		return resultClientError
+	}
+
+	server := gp.lb.ChooseServer(req)
+	if server == nil {
+		setErrorResponse(ctx, grpcCodeUnavailable, "no available server")
+		return resultInternalError
+	}
+
+	stdctx := req.Context()
+	if gp.timeout > 0 {
+		var cancel stdcontext.CancelFunc
+		stdctx, cancel = stdcontext.WithTimeout(stdctx, gp.timeout)
+		// The response body is sent to the client after the filter
+		// returns, so cancel it when the context finishes.
+		ctx.OnFinish(cancel)
+	}
+
+	stdr, err := http.NewRequestWithContext(stdctx, http.MethodPost, server.URL+req.Path(), req.GetPayload())
+	if err != nil {
+		logger.Errorf("BUG: new request failed: %v", err)
+		setErrorResponse(ctx, grpcCodeInternal, "internal error")
+		return resultInternalError
+	}
+	stdr.Header = cloneHeader(req.HTTPHeader())
+	// Trailers of the request are filled after its body is fully read,
+	// sharing the map makes them be sent to the server.
+	stdr.Trailer = req.Std().Trailer
+	if server.KeepHost {
+		stdr.Host = req.Host()
+	}
+
+	transport := gp.h2cTransport
+	if stdr.URL.Scheme == "https" {
+		transport = gp.tlsTransport
+	}
+
+	resp, err := transport.RoundTrip(stdr)
+	if err != nil {
+		logger.Debugf("%s: failed to send request to %s: %v", gp.Name(), server.URL, err)
+		if errors.Is(stdctx.Err(), stdcontext.DeadlineExceeded) {
+			setErrorResponse(ctx, grpcCodeDeadlineExceeded, "deadline exceeded")
+			return resultTimeout
+		}
+		setErrorResponse(ctx, grpcCodeUnavailable, "upstream unavailable")
+		return resultServerError
+	}
+
+	// The body is always a stream, so that messages of streaming calls
+	// are passed through as soon as they arrive, and the trailers are
+	// available after the body is fully read.
+	httpResp, _ := httpprot.NewResponse(resp)
+	httpResp.FetchPayload(-1)
+	ctx.SetOutputResponse(httpResp)
+
+	return statusResult(resp)
+}
+
+// Status returns status.
+func (gp *GRPCProxy) Status() interface{} {
+	return nil
+}
+
+// Close closes GRPCProxy.
+func (gp *GRPCProxy) Close() {
+	gp.h2cTransport.CloseIdleConnections()
+	gp.tlsTransport.CloseIdleConnections()
+}
+
+func cloneHeader(in http.Header) http.Header {
+	out := in.Clone()
+	for _, h := range hopHeaders {
+		out.Del(h)
+	}
+	// gRPC servers require "TE: trailers" to detect incompatible proxies.
+	out.Set("Te", "trailers")
+	return out
+}
+
+// setErrorResponse sets a Trailers-Only gRPC response with the status code
+// and message to the context.
+func setErrorResponse(ctx *context.Context, code int, msg string) {
+	resp, _ := httpprot.NewResponse(nil)
+	h := resp.HTTPHeader()
+	h.Set("Content-Type", "application/grpc")
+	h.Set("Grpc-Status", strconv.Itoa(code))
+	h.Set("Grpc-Message", msg)
+	ctx.SetOutputResponse(resp)
+}
+
+// statusResult maps the response of the server to a result. As the body
+// is a stream, only the gRPC status of a Trailers-Only response, which is
+// sent in the header, is available, and the status in trailers is left to
+// the client.
+func statusResult(resp *http.Response) string {
+	if resp.StatusCode >= 500 {
+		return resultServerError
+	}
+	if resp.StatusCode >= 400 {
+		return resultClientError
+	}
+
+	code, err := strconv.Atoi(resp.Header.Get("Grpc-Status"))
+	if err != nil {
+		return ""
+	}
+
+	switch code {
+	case 0: // OK
+		return ""
+	case 1, 3, 5, 6, 7, 9, 11, 16:
+		// CANCELLED, INVALID_ARGUMENT, NOT_FOUND, ALREADY_EXISTS,
+		// PERMISSION_DENIED, FAILED_PRECONDITION, OUT_OF_RANGE,
+		// UNAUTHENTICATED
+		return resultClientError
+	case grpcCodeDeadlineExceeded:
+		return resultTimeout
+	default:
+		return resultServerError
+	}
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpcproxy
+
+import (
+	stdcontext "context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/proxy"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newTestGRPCProxy(yamlSpec string, assert *assert.Assertions) *GRPCProxy {
+	rawSpec := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(yamlSpec), &rawSpec)
+	assert.NoError(err)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+
+	gp := kind.CreateInstance(spec).(*GRPCProxy)
+	gp.Init()
+
+	assert.Equal(kind, gp.Kind())
+	assert.Equal(spec, gp.Spec())
+	return gp
+}
+
+// startBackend starts a gRPC health server as the backend.
+func startBackend(t *testing.T) (*health.Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := health.NewServer()
+	gs := grpc.NewServer()
+	healthpb.RegisterHealthServer(gs, hs)
+	go gs.Serve(l)
+	t.Cleanup(gs.Stop)
+
+	return hs, "http://" + l.Addr().String()
+}
+
+// startFrontend starts an h2c server which sends the requests to the
+// proxy and writes the responses the same way as the HTTPServer.
+func startFrontend(t *testing.T, gp *GRPCProxy, results chan<- string) string {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := httpprot.NewRequest(r)
+		req.FetchPayload(-1)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		defer ctx.Finish()
+
+		results <- gp.Handle(ctx)
+
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		for k, v := range resp.HTTPHeader() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode())
+
+		payload := resp.GetPayload()
+		buf := make([]byte, 1024)
+		for {
+			n, err := payload.Read(buf)
+			if n > 0 {
+				w.Write(buf[:n])
+				w.(http.Flusher).Flush()
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		for k, v := range resp.Std().Trailer {
+			w.Header()[http.TrailerPrefix+k] = v
+		}
+	})
+
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func dial(t *testing.T, addr string) healthpb.HealthClient {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{}
+	assert.Error(spec.Validate())
+
+	spec.Servers = []*proxy.Server{{URL: "tcp://127.0.0.1:9095"}}
+	assert.Error(spec.Validate())
+
+	spec.Servers = []*proxy.Server{{URL: "http://127.0.0.1:9095", Weight: 1}, {URL: "https://127.0.0.1:9096"}}
+	assert.Error(spec.Validate())
+
+	spec.Servers[1].Weight = 1
+	assert.NoError(spec.Validate())
+
+	spec.LoadBalance = &proxy.LoadBalanceSpec{Policy: "headerHash"}
+	assert.Error(spec.Validate())
+}
+
+func TestGRPCProxy(t *testing.T) {
+	assert := assert.New(t)
+
+	hs1, url1 := startBackend(t)
+	hs2, url2 := startBackend(t)
+	hs1.SetServingStatus("svc", healthpb.HealthCheckResponse_SERVING)
+	hs2.SetServingStatus("svc", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	gp := newTestGRPCProxy(`
+name: grpcproxy
+kind: GRPCProxy
+servers:
+- url: `+url1+`
+- url: `+url2+`
+loadBalance:
+  policy: roundRobin
+`, assert)
+	defer gp.Close()
+
+	results := make(chan string, 10)
+	client := dial(t, startFrontend(t, gp, results))
+	ctx := stdcontext.Background()
+
+	// unary calls are balanced over the servers.
+	statuses := map[healthpb.HealthCheckResponse_ServingStatus]bool{}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+		assert.NoError(err)
+		assert.Equal("", <-results)
+		statuses[resp.Status] = true
+	}
+	assert.True(statuses[healthpb.HealthCheckResponse_SERVING])
+	assert.True(statuses[healthpb.HealthCheckResponse_NOT_SERVING])
+
+	// the status of a failed call is passed to the client.
+	_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	assert.Equal(codes.NotFound, status.Code(err))
+	assert.Equal(resultClientError, <-results)
+
+	// server streaming: updates are received while the stream is open.
+	hs1.SetServingStatus("watch", healthpb.HealthCheckResponse_SERVING)
+	hs2.SetServingStatus("watch", healthpb.HealthCheckResponse_SERVING)
+	watchCtx, cancel := stdcontext.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Watch(watchCtx, &healthpb.HealthCheckRequest{Service: "watch"})
+	assert.NoError(err)
+
+	resp, err := stream.Recv()
+	assert.NoError(err)
+	assert.Equal(healthpb.HealthCheckResponse_SERVING, resp.Status)
+	assert.Equal("", <-results)
+
+	hs1.SetServingStatus("watch", healthpb.HealthCheckResponse_NOT_SERVING)
+	hs2.SetServingStatus("watch", healthpb.HealthCheckResponse_NOT_SERVING)
+	resp, err = stream.Recv()
+	assert.NoError(err)
+	assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+
+	cancel()
+}
+
+func TestGRPCProxyUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	addr := l.Addr().String()
+	l.Close()
+
+	gp := newTestGRPCProxy(`
+name: grpcproxy
+kind: GRPCProxy
+timeout: 1s
+servers:
+- url: http://`+addr+`
+`, assert)
+	defer gp.Close()
+
+	results := make(chan string, 10)
+	client := dial(t, startFrontend(t, gp, results))
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(codes.Unavailable, status.Code(err))
+	assert.Equal(resultServerError, <-results)
+
+	// non-gRPC requests are rejected.
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	fctx := context.New(tracing.NoopSpan)
+	fctx.SetRequest(context.DefaultNamespace, req)
+	assert.Equal(resultClientError, gp.Handle(fctx))
+	resp := fctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusUnsupportedMediaType, resp.StatusCode())
+}
@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package jqtransformer implements a filter to transform JSON bodies by
+// jq programs.
+package jqtransformer
+
+import (
+	"bytes"
+	stdcontext "context"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	json "github.com/goccy/go-json"
+	"github.com/itchyny/gojq"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of JQTransformer.
+	Kind = "JQTransformer"
+
+	directionRequest  = "request"
+	directionResponse = "response"
+
+	resultResponseNotFound = "responseNotFound"
+	resultInvalidBody      = "invalidBody"
+	resultTransformFailed  = "transformFailed"
+
+	keyContentLength = "Content-Length"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "JQTransformer transforms the JSON body of the request or response by a jq program",
+	Results:     []string{resultResponseNotFound, resultInvalidBody, resultTransformFailed},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{Direction: directionRequest}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &JQTransformer{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// JQTransformer is the filter to transform JSON bodies.
+	JQTransformer struct {
+		spec *Spec
+		code *gojq.Code
+	}
+
+	// message is the common part of requests and responses.
+	message interface {
+		Header() protocols.Header
+		IsStream() bool
+		RawPayload() []byte
+		SetPayload(payload interface{})
+	}
+
+	// Spec describes the JQTransformer.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Program   string `yaml:"program" jsonschema:"required"`
+		Direction string `yaml:"direction" jsonschema:"omitempty,enum=request,enum=response"`
+	}
+)
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if _, err := compile(spec.Program); err != nil {
+		return fmt.Errorf("invalid program: %v", err)
+	}
+	return nil
+}
+
+// compile compiles the jq program, the environment variables of the
+// process are never exposed to the program.
+func compile(program string) (*gojq.Code, error) {
+	q, err := gojq.Parse(program)
+	if err != nil {
+		return nil, err
+	}
+	return gojq.Compile(q, gojq.WithEnvironLoader(func() []string { return nil }))
+}
+
+// Name returns the name of the JQTransformer filter instance.
+func (jt *JQTransformer) Name() string {
+	return jt.spec.Name()
+}
+
+// Kind returns the kind of JQTransformer.
+func (jt *JQTransformer) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the JQTransformer.
+func (jt *JQTransformer) Spec() filters.Spec {
+	return jt.spec
+}
+
+// Init initializes JQTransformer.
+func (jt *JQTransformer) Init() {
+	jt.reload()
+}
+
+// Inherit inherits previous generation of JQTransformer.
+func (jt *JQTransformer) Inherit(previousGeneration filters.Filter) {
+	jt.reload()
+}
+
+func (jt *JQTransformer) reload() {
+	code, err := compile(jt.spec.Program)
+	if err != nil {
+		panic(err)
+	}
+	jt.code = code
+}
+
+// Handle transforms the body of the request or response.
+func (jt *JQTransformer) Handle(ctx *context.Context) string {
+	var msg message = ctx.GetInputRequest()
+	if jt.spec.Direction == directionResponse {
+		resp := ctx.GetInputResponse()
+		if resp == nil {
+			return resultResponseNotFound
+		}
+		msg = resp
+	}
+
+	if msg.IsStream() {
+		logger.Debugf("%s: can not transform a stream body", jt.Name())
+		return resultInvalidBody
+	}
+
+	input, err := decodeJSON(msg.RawPayload())
+	if err != nil {
+		logger.Debugf("%s: body is not JSON: %v", jt.Name(), err)
+		return resultInvalidBody
+	}
+
+	stdctx := stdcontext.Background()
+	if req, ok := ctx.GetInputRequest().(*httpprot.Request); ok {
+		stdctx = req.Context()
+	}
+
+	output, err := jt.transform(stdctx, input)
+	if err != nil {
+		logger.Debugf("%s: failed to transform body: %v", jt.Name(), err)
+		return resultTransformFailed
+	}
+
+	body, err := json.Marshal(output)
+	if err != nil {
+		logger.Debugf("%s: failed to marshal result: %v", jt.Name(), err)
+		return resultTransformFailed
+	}
+
+	msg.SetPayload(body)
+	msg.Header().Set(keyContentLength, strconv.Itoa(len(body)))
+	return ""
+}
+
+// decodeJSON decodes data and converts its numbers to the types gojq
+// works with, so that integers beyond 2^53 keep their precision instead
+// of being rounded by float64.
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid character after top-level value")
+	}
+	return normalizeNumbers(v), nil
+}
+
+// normalizeNumbers converts json.Number in v to int, *big.Int or float64.
+func normalizeNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		return normalizeNumber(v)
+	case map[string]interface{}:
+		for k, x := range v {
+			v[k] = normalizeNumbers(x)
+		}
+		return v
+	case []interface{}:
+		for i, x := range v {
+			v[i] = normalizeNumbers(x)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func normalizeNumber(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil && math.MinInt <= i && i <= math.MaxInt {
+		return int(i)
+	}
+	if !strings.ContainsAny(n.String(), ".eE") {
+		if bi, ok := new(big.Int).SetString(n.String(), 10); ok {
+			return bi
+		}
+	}
+	f, _ := n.Float64()
+	return f
+}
+
+// transform runs the program on input, only the first result of the
+// program is used.
+func (jt *JQTransformer) transform(ctx stdcontext.Context, input interface{}) (interface{}, error) {
+	iter := jt.code.RunWithContext(ctx, input)
+	v, ok := iter.Next()
+	if !ok {
+		return nil, fmt.Errorf("no result")
+	}
+	if err, ok := v.(error); ok {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Status returns the status of JQTransformer.
+func (jt *JQTransformer) Status() interface{} {
+	return nil
+}
+
+// Close closes JQTransformer.
+func (jt *JQTransformer) Close() {
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jqtransformer
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestJQTransformer(yamlSpec string, assert *assert.Assertions) *JQTransformer {
+	rawSpec := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(yamlSpec), &rawSpec)
+	assert.NoError(err)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+
+	jt := kind.CreateInstance(spec).(*JQTransformer)
+	jt.Init()
+	return jt
+}
+
+func newContext(body string) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://megaease.com/", strings.NewReader(body))
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestJQTransformerRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: JQTransformer
+name: jq
+program: '{name: .user.name, count: (.items | length)}'
+`
+	jt := newTestJQTransformer(yamlSpec, assert)
+	defer jt.Close()
+	assert.Equal(kind, jt.Kind())
+	assert.Equal("jq", jt.Name())
+	assert.Nil(jt.Status())
+
+	ctx := newContext(`{"user": {"name": "megaease", "age": 5}, "items": [1, 2, 3]}`)
+	assert.Equal("", jt.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.JSONEq(`{"name": "megaease", "count": 3}`, string(req.RawPayload()))
+	assert.Equal(strconv.Itoa(len(req.RawPayload())), req.HTTPHeader().Get("Content-Length"))
+
+	// malformed input is kept as it is.
+	ctx = newContext(`{"user": `)
+	assert.Equal(resultInvalidBody, jt.Handle(ctx))
+	assert.Equal(`{"user": `, string(ctx.GetInputRequest().RawPayload()))
+
+	// runtime errors of the program.
+	ctx = newContext(`{"items": 1, "user": []}`)
+	assert.Equal(resultTransformFailed, jt.Handle(ctx))
+
+	// the program produces no result.
+	yamlSpec = `
+kind: JQTransformer
+name: jq
+program: 'empty'
+`
+	jt = newTestJQTransformer(yamlSpec, assert)
+	assert.Equal(resultTransformFailed, jt.Handle(newContext(`{}`)))
+}
+
+func TestJQTransformerLargeNumber(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: JQTransformer
+name: jq
+program: '{id: .id, next: (.id + 1), ratio: .ratio, small: .small}'
+`
+	jt := newTestJQTransformer(yamlSpec, assert)
+
+	ctx := newContext(`{"id": 9007199254740993, "ratio": 0.5, "small": 7}`)
+	assert.Equal("", jt.Handle(ctx))
+	body := string(ctx.GetInputRequest().RawPayload())
+	assert.JSONEq(`{"id": 9007199254740993, "next": 9007199254740994, "ratio": 0.5, "small": 7}`, body)
+	// JSONEq compares numbers as float64, check the exact digits.
+	assert.Contains(body, `"id":9007199254740993`)
+	assert.Contains(body, `"next":9007199254740994`)
+
+	ctx = newContext(`{"id": 123456789012345678901234567890}`)
+	assert.Equal("", jt.Handle(ctx))
+	assert.Contains(string(ctx.GetInputRequest().RawPayload()), `"id":123456789012345678901234567890`)
+
+	ctx = newContext(`{"id": 1} {"id": 2}`)
+	assert.Equal(resultInvalidBody, jt.Handle(ctx))
+}
+
+func TestJQTransformerResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: JQTransformer
+name: jq
+direction: response
+program: '[.[] | select(.enabled) | .id]'
+`
+	jt := newTestJQTransformer(yamlSpec, assert)
+
+	ctx := newContext("")
+	assert.Equal(resultResponseNotFound, jt.Handle(ctx))
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload(`[{"id": 1, "enabled": true}, {"id": 2, "enabled": false}]`)
+	ctx.SetInputResponse(resp)
+	assert.Equal("", jt.Handle(ctx))
+	assert.Equal(`[1]`, string(resp.RawPayload()))
+
+	// the request is not touched.
+	assert.Equal("", string(ctx.GetInputRequest().RawPayload()))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Program: ".a"}
+	assert.NoError(spec.Validate())
+
+	spec = &Spec{Program: ".a |"}
+	assert.Error(spec.Validate())
+
+	// environment variables are not available.
+	code, err := compile("env | length")
+	assert.NoError(err)
+	v, _ := code.Run(nil).Next()
+	assert.Equal(0, v)
+}
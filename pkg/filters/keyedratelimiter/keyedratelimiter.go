@@ -0,0 +1,329 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keyedratelimiter
+
+import (
+	stdcontext "context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"golang.org/x/time/rate"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/builder"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+)
+
+var nowFunc = time.Now
+
+const (
+	// Kind is the kind of KeyedRateLimiter.
+	Kind = "KeyedRateLimiter"
+
+	resultRateLimited = "rateLimited"
+
+	defaultMaxKeys = 10000
+
+	// clusterTimeout bounds the time of limiting a request in the
+	// cluster, the local limiter is used if it is exceeded.
+	clusterTimeout = 100 * time.Millisecond
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "KeyedRateLimiter limits the rate of requests by a key rendered from the request.",
+	Results:     []string{resultRateLimited},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{MaxKeys: defaultMaxKeys}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &KeyedRateLimiter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// KeyedRateLimiter is filter KeyedRateLimiter.
+	KeyedRateLimiter struct {
+		spec     *Spec
+		template *template.Template
+		limiters *lru.Cache
+		cluster  clusterLimiter
+	}
+
+	// Spec describes the KeyedRateLimiter.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Key        string  `yaml:"key" jsonschema:"required"`
+		LeftDelim  string  `yaml:"leftDelim" jsonschema:"omitempty"`
+		RightDelim string  `yaml:"rightDelim" jsonschema:"omitempty"`
+		Rate       float64 `yaml:"rate" jsonschema:"required"`
+		Burst      int     `yaml:"burst,omitempty" jsonschema:"omitempty,minimum=1"`
+		MaxKeys    int     `yaml:"maxKeys,omitempty" jsonschema:"omitempty,minimum=1"`
+		Cluster    bool    `yaml:"cluster" jsonschema:"omitempty"`
+	}
+
+	// clusterLimiter limits the requests of a key in the cluster.
+	clusterLimiter interface {
+		allow(key string) (bool, error)
+	}
+
+	// slidingWindow is a clusterLimiter using the sliding window counter
+	// algorithm. The requests of a key are counted in etcd in one second
+	// windows, and the number of requests in the last second is estimated
+	// by the counts of the current and previous windows.
+	slidingWindow struct {
+		cls         cluster.Cluster
+		prefix      string
+		limit       int64
+		lastCleaned int64
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Rate <= 0 {
+		return fmt.Errorf("rate must be greater than 0")
+	}
+	if _, err := builder.NewTemplate(spec.LeftDelim, spec.RightDelim, spec.Key); err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	return nil
+}
+
+func (spec *Spec) burst() int {
+	if spec.Burst > 0 {
+		return spec.Burst
+	}
+	return int(math.Max(1, math.Ceil(spec.Rate)))
+}
+
+func (spec *Spec) maxKeys() int {
+	if spec.MaxKeys > 0 {
+		return spec.MaxKeys
+	}
+	return defaultMaxKeys
+}
+
+// clusterLimit returns the max number of requests of a key allowed in
+// the last second in the cluster.
+func (spec *Spec) clusterLimit() int64 {
+	limit := int64(math.Ceil(spec.Rate))
+	if burst := int64(spec.burst()); burst > limit {
+		limit = burst
+	}
+	return limit
+}
+
+func (sw *slidingWindow) counterKey(window int64, key string) string {
+	return sw.prefix + strconv.FormatInt(window, 10) + "/" + url.PathEscape(key)
+}
+
+// allow checks and counts a request of key atomically, rejected requests
+// are not counted.
+func (sw *slidingWindow) allow(key string) (bool, error) {
+	now := nowFunc()
+	window := now.Unix()
+	curKey, prevKey := sw.counterKey(window, key), sw.counterKey(window-1, key)
+
+	// the weight of the previous window is the part of it overlapping the
+	// last second.
+	weight := 1 - float64(now.Nanosecond())/float64(time.Second)
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), clusterTimeout)
+	defer cancel()
+
+	allowed := false
+	err := sw.cls.STMWithContext(ctx, func(s concurrency.STM) error {
+		cur, _ := strconv.ParseInt(s.Get(curKey), 10, 64)
+		prev, _ := strconv.ParseInt(s.Get(prevKey), 10, 64)
+
+		allowed = float64(prev)*weight+float64(cur) < float64(sw.limit)
+		if allowed {
+			s.Put(curKey, strconv.FormatInt(cur+1, 10))
+		}
+		return nil
+	})
+
+	sw.clean(window)
+	return allowed, err
+}
+
+// clean deletes the counters of the windows before the previous one, it
+// is done only once for every window by every instance.
+func (sw *slidingWindow) clean(window int64) {
+	last := atomic.LoadInt64(&sw.lastCleaned)
+	if window <= last || !atomic.CompareAndSwapInt64(&sw.lastCleaned, last, window) {
+		return
+	}
+
+	go func() {
+		for w := window - 2; w >= last-1 && w > window-10; w-- {
+			prefix := sw.prefix + strconv.FormatInt(w, 10) + "/"
+			if err := sw.cls.DeletePrefix(prefix); err != nil {
+				logger.Warnf("delete rate limit counters %s failed: %v", prefix, err)
+				return
+			}
+		}
+	}()
+}
+
+// Name returns the name of the KeyedRateLimiter filter instance.
+func (krl *KeyedRateLimiter) Name() string {
+	return krl.spec.Name()
+}
+
+// Kind returns the kind of KeyedRateLimiter.
+func (krl *KeyedRateLimiter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the KeyedRateLimiter
+func (krl *KeyedRateLimiter) Spec() filters.Spec {
+	return krl.spec
+}
+
+// Init initializes KeyedRateLimiter.
+func (krl *KeyedRateLimiter) Init() {
+	krl.reload(nil)
+}
+
+// Inherit inherits previous generation of KeyedRateLimiter.
+func (krl *KeyedRateLimiter) Inherit(previousGeneration filters.Filter) {
+	krl.reload(previousGeneration.(*KeyedRateLimiter))
+}
+
+func (krl *KeyedRateLimiter) reload(previous *KeyedRateLimiter) {
+	spec := krl.spec
+
+	t, err := builder.NewTemplate(spec.LeftDelim, spec.RightDelim, spec.Key)
+	if err != nil {
+		panic(err)
+	}
+	krl.template = t
+
+	// keep the token buckets if they are still valid for the new spec.
+	if previous != nil {
+		ps := previous.spec
+		if ps.Key == spec.Key && ps.Rate == spec.Rate && ps.burst() == spec.burst() {
+			krl.limiters = previous.limiters
+			krl.limiters.Resize(spec.maxKeys())
+		}
+	}
+	if krl.limiters == nil {
+		krl.limiters, _ = lru.New(spec.maxKeys())
+	}
+
+	if spec.Cluster && spec.Super() != nil && spec.Super().Cluster() != nil {
+		cls := spec.Super().Cluster()
+		krl.cluster = &slidingWindow{
+			cls:    cls,
+			prefix: cls.Layout().RateLimitPrefix(spec.Pipeline(), spec.Name()),
+			limit:  spec.clusterLimit(),
+		}
+	}
+}
+
+func (krl *KeyedRateLimiter) limiter(key string) *rate.Limiter {
+	if v, ok := krl.limiters.Get(key); ok {
+		return v.(*rate.Limiter)
+	}
+
+	l := rate.NewLimiter(rate.Limit(krl.spec.Rate), krl.spec.burst())
+	if prev, ok, _ := krl.limiters.PeekOrAdd(key, l); ok {
+		return prev.(*rate.Limiter)
+	}
+	return l
+}
+
+func (krl *KeyedRateLimiter) renderKey(ctx *context.Context) (string, error) {
+	data, err := builder.PrepareData(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err = krl.template.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Handle limits the rate of requests by their keys, every distinct key
+// has its own token bucket. If cluster is enabled, the requests of a key
+// are limited in the whole cluster instead, and the local token bucket is
+// used only if the cluster is not available.
+func (krl *KeyedRateLimiter) Handle(ctx *context.Context) string {
+	key, err := krl.renderKey(ctx)
+	if err != nil {
+		// requests whose key can not be rendered share the same bucket.
+		logger.Warnf("%s: render key failed: %v", krl.Name(), err)
+		key = ""
+	}
+
+	if krl.cluster != nil {
+		allowed, err := krl.cluster.allow(key)
+		if err == nil {
+			if !allowed {
+				return krl.reject(ctx, key)
+			}
+			return ""
+		}
+		logger.Warnf("%s: limit request in cluster failed, fall back to local limiting: %v", krl.Name(), err)
+	}
+
+	if !krl.limiter(key).Allow() {
+		return krl.reject(ctx, key)
+	}
+	return ""
+}
+
+func (krl *KeyedRateLimiter) reject(ctx *context.Context, key string) string {
+	resp, _ := httpprot.NewResponse(nil)
+	ctx.SetOutputResponse(resp)
+	ctx.AddTag(fmt.Sprintf("keyedRateLimiter: too many requests of key %q", key))
+
+	resp.SetStatusCode(http.StatusTooManyRequests)
+	resp.Std().Header.Set("X-EG-Rate-Limiter", "too-many-requests")
+	return resultRateLimited
+}
+
+// Status returns Status.
+func (krl *KeyedRateLimiter) Status() interface{} {
+	return nil
+}
+
+// Close closes KeyedRateLimiter.
+func (krl *KeyedRateLimiter) Close() {
+}
@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package keyedratelimiter
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/cluster/clustertest"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+	"github.com/stretchr/testify/assert"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newKeyedRateLimiter(t *testing.T, yamlSpec string) *KeyedRateLimiter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	krl := kind.CreateInstance(spec).(*KeyedRateLimiter)
+	krl.Init()
+	return krl
+}
+
+func newContext(user string) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	if user != "" {
+		stdr.Header.Set("X-User", user)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	return ctx
+}
+
+const yamlSpec = `
+kind: KeyedRateLimiter
+name: limiter
+key: '{{index .requests.DEFAULT.Header "X-User" | first}}'
+rate: 0.001
+burst: 2
+`
+
+func TestSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range []string{
+		"kind: KeyedRateLimiter\nname: limiter\nkey: abc\nrate: 0",
+		"kind: KeyedRateLimiter\nname: limiter\nkey: '{{.abc'\nrate: 1",
+	} {
+		rawSpec := make(map[string]interface{})
+		yamltool.Unmarshal([]byte(s), &rawSpec)
+		_, err := filters.NewSpec(nil, "", rawSpec)
+		assert.Error(err)
+	}
+
+	krl := newKeyedRateLimiter(t, yamlSpec)
+	assert.Equal("limiter", krl.Name())
+	assert.Equal(kind, krl.Kind())
+	assert.Nil(krl.Status())
+
+	spec := krl.Spec().(*Spec)
+	assert.Equal(2, spec.burst())
+	assert.Equal(defaultMaxKeys, spec.maxKeys())
+	assert.Equal(int64(2), spec.clusterLimit())
+
+	spec = &Spec{Rate: 2.5}
+	assert.Equal(3, spec.burst())
+
+	// token buckets are kept if they are still valid.
+	newKrl := kind.CreateInstance(krl.Spec()).(*KeyedRateLimiter)
+	newKrl.Inherit(krl)
+	assert.Same(krl.limiters, newKrl.limiters)
+	krl.Close()
+	newKrl.Close()
+}
+
+func TestKeyIsolationAndBurst(t *testing.T) {
+	assert := assert.New(t)
+	krl := newKeyedRateLimiter(t, yamlSpec)
+
+	for _, user := range []string{"alice", "bob"} {
+		for i := 0; i < 2; i++ {
+			assert.Equal("", krl.Handle(newContext(user)), "user %s, request %d", user, i)
+		}
+	}
+
+	ctx := newContext("alice")
+	assert.Equal(resultRateLimited, krl.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusTooManyRequests, resp.StatusCode())
+	assert.Equal("too-many-requests", resp.HTTPHeader().Get("X-EG-Rate-Limiter"))
+
+	assert.Equal(resultRateLimited, krl.Handle(newContext("bob")))
+	assert.Equal("", krl.Handle(newContext("carol")))
+}
+
+func TestMaxKeys(t *testing.T) {
+	assert := assert.New(t)
+	krl := newKeyedRateLimiter(t, yamlSpec+"maxKeys: 2\n")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal("", krl.Handle(newContext("alice")))
+	}
+	assert.Equal(resultRateLimited, krl.Handle(newContext("alice")))
+
+	// the bucket of alice is evicted by bob and carol.
+	assert.Equal("", krl.Handle(newContext("bob")))
+	assert.Equal("", krl.Handle(newContext("carol")))
+	assert.Equal("", krl.Handle(newContext("alice")))
+}
+
+// newMockedCluster creates a cluster whose STM reads and writes a map
+// shared by all the limiters using it.
+func newMockedCluster() (*clustertest.MockedCluster, map[string]string) {
+	var mu sync.Mutex
+	kvs := map[string]string{}
+
+	cls := clustertest.NewMockedCluster()
+	cls.MockedSTM = func(apply func(concurrency.STM) error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return apply(&clustertest.MockedSTM{
+			MockedGet: func(key ...string) string { return kvs[key[0]] },
+			MockedPut: func(key, val string, opts ...clientv3.OpOption) { kvs[key] = val },
+		})
+	}
+	cls.MockedDeletePrefix = func(prefix string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for k := range kvs {
+			if strings.HasPrefix(k, prefix) {
+				delete(kvs, k)
+			}
+		}
+		return nil
+	}
+	return cls, kvs
+}
+
+func newClusterLimiter(t *testing.T, cls cluster.Cluster) *KeyedRateLimiter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec+"cluster: true\n"), &rawSpec)
+
+	super := supervisor.NewMock(nil, cls, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+	spec, err := filters.NewSpec(super, "pipeline", rawSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	krl := kind.CreateInstance(spec).(*KeyedRateLimiter)
+	krl.Init()
+	return krl
+}
+
+func TestClusterLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	cls, _ := newMockedCluster()
+	krl1, krl2 := newClusterLimiter(t, cls), newClusterLimiter(t, cls)
+
+	// the limit, max(rate, burst) = 2, is shared by the two instances.
+	assert.Equal("", krl1.Handle(newContext("alice")))
+	assert.Equal("", krl2.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl1.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl2.Handle(newContext("alice")))
+	assert.Equal("", krl1.Handle(newContext("bob")))
+
+	// the requests of the previous window are weighted by its overlap
+	// with the last second: 2 * 0.75 + 0 < 2, 2 * 0.75 + 1 >= 2.
+	now = time.Unix(1001, int64(250*time.Millisecond))
+	assert.Equal("", krl2.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl1.Handle(newContext("alice")))
+
+	// 2 * 0.25 + 1 < 2
+	now = time.Unix(1001, int64(750*time.Millisecond))
+	assert.Equal("", krl1.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl2.Handle(newContext("alice")))
+}
+
+func TestClusterLimiterClean(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	deleted := make(chan string, 10)
+	cls, _ := newMockedCluster()
+	cls.MockedDeletePrefix = func(prefix string) error {
+		deleted <- prefix
+		return nil
+	}
+	krl := newClusterLimiter(t, cls)
+	sw := krl.cluster.(*slidingWindow)
+	sw.lastCleaned = 999
+
+	now = time.Unix(1002, 0)
+	assert.Equal("", krl.Handle(newContext("alice")))
+	assert.Equal(sw.prefix+"1000/", <-deleted)
+	assert.Equal(sw.prefix+"999/", <-deleted)
+	assert.Equal(sw.prefix+"998/", <-deleted)
+}
+
+type mockClusterLimiter struct {
+	err error
+}
+
+func (mcl *mockClusterLimiter) allow(key string) (bool, error) {
+	return false, mcl.err
+}
+
+func TestClusterFallback(t *testing.T) {
+	assert := assert.New(t)
+	krl := newKeyedRateLimiter(t, yamlSpec)
+
+	mcl := &mockClusterLimiter{}
+	krl.cluster = mcl
+	assert.Equal(resultRateLimited, krl.Handle(newContext("alice")))
+
+	// the local bucket is used if the cluster is not available.
+	mcl.err = fmt.Errorf("mock error")
+	assert.Equal("", krl.Handle(newContext("alice")))
+	assert.Equal("", krl.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl.Handle(newContext("alice")))
+}
+
+func TestClusterFallbackOnStall(t *testing.T) {
+	assert := assert.New(t)
+
+	// the STM stalls until it is aborted, like when etcd is unreachable.
+	cls := clustertest.NewMockedCluster()
+	cls.MockedSTMWithContext = func(ctx stdcontext.Context, apply func(concurrency.STM) error) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	krl := newClusterLimiter(t, cls)
+
+	start := time.Now()
+	assert.Equal("", krl.Handle(newContext("alice")))
+	assert.Equal("", krl.Handle(newContext("alice")))
+	assert.Equal(resultRateLimited, krl.Handle(newContext("alice")))
+	assert.Less(time.Since(start), time.Second)
+}
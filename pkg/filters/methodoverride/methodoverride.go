@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package methodoverride implements a filter to override the method of
+// requests by a header or a form field.
+package methodoverride
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of MethodOverride.
+	Kind = "MethodOverride"
+
+	defaultHeader    = "X-HTTP-Method-Override"
+	defaultFormField = "_method"
+
+	mimeFormURLEncoded = "application/x-www-form-urlencoded"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "MethodOverride overrides the method of the request by a header or a form field",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Header:        defaultHeader,
+			FormField:     defaultFormField,
+			Methods:       []string{http.MethodPut, http.MethodPatch, http.MethodDelete},
+			SourceMethods: []string{http.MethodPost},
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &MethodOverride{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// MethodOverride is the filter to override the method of requests.
+	MethodOverride struct {
+		spec          *Spec
+		methods       map[string]struct{}
+		sourceMethods map[string]struct{}
+	}
+
+	// Spec describes the MethodOverride.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Header        string   `yaml:"header" jsonschema:"omitempty"`
+		FormField     string   `yaml:"formField" jsonschema:"omitempty"`
+		Methods       []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		SourceMethods []string `yaml:"sourceMethods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	}
+)
+
+// Name returns the name of the MethodOverride filter instance.
+func (mo *MethodOverride) Name() string {
+	return mo.spec.Name()
+}
+
+// Kind returns the kind of MethodOverride.
+func (mo *MethodOverride) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the MethodOverride.
+func (mo *MethodOverride) Spec() filters.Spec {
+	return mo.spec
+}
+
+// Init initializes MethodOverride.
+func (mo *MethodOverride) Init() {
+	mo.reload()
+}
+
+// Inherit inherits previous generation of MethodOverride.
+func (mo *MethodOverride) Inherit(previousGeneration filters.Filter) {
+	mo.reload()
+}
+
+func (mo *MethodOverride) reload() {
+	toSet := func(methods []string) map[string]struct{} {
+		m := map[string]struct{}{}
+		for _, method := range methods {
+			m[method] = struct{}{}
+		}
+		return m
+	}
+	mo.methods = toSet(mo.spec.Methods)
+	mo.sourceMethods = toSet(mo.spec.SourceMethods)
+}
+
+// Handle overrides the method of the request, the request is not changed
+// if the method is not in the allowlist.
+func (mo *MethodOverride) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+
+	if _, ok := mo.sourceMethods[req.Method()]; !ok {
+		return ""
+	}
+
+	method := mo.overriddenMethod(req)
+	if method == "" || method == req.Method() {
+		return ""
+	}
+	if _, ok := mo.methods[method]; !ok {
+		return ""
+	}
+
+	ctx.AddTag(stringtool.Cat("methodOverride: method ", req.Method(), " overridden to ", method))
+	req.SetMethod(method)
+	return ""
+}
+
+// overriddenMethod returns the method in the header, or in the form field
+// if the header is not present.
+func (mo *MethodOverride) overriddenMethod(req *httpprot.Request) string {
+	if mo.spec.Header != "" {
+		if method := req.HTTPHeader().Get(mo.spec.Header); method != "" {
+			return strings.ToUpper(strings.TrimSpace(method))
+		}
+	}
+
+	if mo.spec.FormField == "" || req.IsStream() {
+		return ""
+	}
+
+	// the form is parsed from the payload, so that the body is still
+	// available to the following filters.
+	mediaType, _, _ := mime.ParseMediaType(req.HTTPHeader().Get("Content-Type"))
+	if mediaType != mimeFormURLEncoded {
+		return ""
+	}
+	form, err := url.ParseQuery(string(req.RawPayload()))
+	if err != nil {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(form.Get(mo.spec.FormField)))
+}
+
+// Status returns the status of MethodOverride.
+func (mo *MethodOverride) Status() interface{} {
+	return nil
+}
+
+// Close closes MethodOverride.
+func (mo *MethodOverride) Close() {
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package methodoverride
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestMethodOverride(yamlSpec string, assert *assert.Assertions) *MethodOverride {
+	rawSpec := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(yamlSpec), &rawSpec)
+	assert.NoError(err)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+
+	mo := kind.CreateInstance(spec).(*MethodOverride)
+	mo.Init()
+	return mo
+}
+
+func newContext(method string, header http.Header, body string) *context.Context {
+	stdr, _ := http.NewRequest(method, "http://megaease.com/", strings.NewReader(body))
+	for k, v := range header {
+		stdr.Header[k] = v
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestMethodOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: MethodOverride
+name: override
+`
+	mo := newTestMethodOverride(yamlSpec, assert)
+	defer mo.Close()
+	assert.Equal(kind, mo.Kind())
+	assert.Equal("override", mo.Name())
+	assert.Nil(mo.Status())
+
+	handle := func(ctx *context.Context) string {
+		assert.Equal("", mo.Handle(ctx))
+		return ctx.GetInputRequest().(*httpprot.Request).Std().Method
+	}
+
+	// from the header.
+	ctx := newContext(http.MethodPost, http.Header{"X-Http-Method-Override": {"delete"}}, "")
+	assert.Equal(http.MethodDelete, handle(ctx))
+
+	// from the form field, the body is kept.
+	formHeader := http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}}
+	ctx = newContext(http.MethodPost, formHeader, "name=abc&_method=PUT")
+	assert.Equal(http.MethodPut, handle(ctx))
+	assert.Equal("name=abc&_method=PUT", string(ctx.GetInputRequest().RawPayload()))
+
+	// the header takes precedence over the form field.
+	formHeader.Set("X-HTTP-Method-Override", "PATCH")
+	ctx = newContext(http.MethodPost, formHeader, "_method=PUT")
+	assert.Equal(http.MethodPatch, handle(ctx))
+
+	// methods not in the allowlist are ignored.
+	ctx = newContext(http.MethodPost, http.Header{"X-Http-Method-Override": {"CONNECT"}}, "")
+	assert.Equal(http.MethodPost, handle(ctx))
+
+	// the form field of other content types is ignored.
+	ctx = newContext(http.MethodPost, http.Header{"Content-Type": {"text/plain"}}, "_method=PUT")
+	assert.Equal(http.MethodPost, handle(ctx))
+
+	// only POST requests are overridden by default.
+	ctx = newContext(http.MethodGet, http.Header{"X-Http-Method-Override": {"DELETE"}}, "")
+	assert.Equal(http.MethodGet, handle(ctx))
+}
+
+func TestMethodOverrideSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: MethodOverride
+name: override
+header: X-Method
+formField: ""
+methods: [DELETE]
+sourceMethods: [GET, POST]
+`
+	mo := newTestMethodOverride(yamlSpec, assert)
+
+	ctx := newContext(http.MethodGet, http.Header{"X-Method": {"DELETE"}}, "")
+	assert.Equal("", mo.Handle(ctx))
+	assert.Equal(http.MethodDelete, ctx.GetInputRequest().(*httpprot.Request).Method())
+
+	ctx = newContext(http.MethodPost, http.Header{"X-Method": {"PUT"}}, "")
+	mo.Handle(ctx)
+	assert.Equal(http.MethodPost, ctx.GetInputRequest().(*httpprot.Request).Method())
+
+	// the form field is disabled.
+	formHeader := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	ctx = newContext(http.MethodPost, formHeader, "_method=DELETE")
+	mo.Handle(ctx)
+	assert.Equal(http.MethodPost, ctx.GetInputRequest().(*httpprot.Request).Method())
+}
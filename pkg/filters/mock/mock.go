@@ -18,9 +18,15 @@
 package mock
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
 	"strings"
+	"text/template"
 	"time"
 
+	sprig "github.com/go-task/slim-sprig"
+
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
 	"github.com/megaease/easegress/pkg/logger"
@@ -33,6 +39,12 @@ const (
 	Kind = "Mock"
 
 	resultMocked = "mocked"
+
+	// maxRandomDelay caps the sampled delays to avoid runaway sleeps.
+	maxRandomDelay = time.Minute
+
+	distributionUniform = "uniform"
+	distributionNormal  = "normal"
 )
 
 var kind = &filters.Kind{
@@ -66,13 +78,43 @@ type (
 
 	// Rule is the mock rule.
 	Rule struct {
-		Match   MatchRule         `yaml:"match" jsonschema:"required"`
-		Code    int               `yaml:"code" jsonschema:"required,format=httpcode"`
-		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
-		Body    string            `yaml:"body" jsonschema:"omitempty"`
-		Delay   string            `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		Match        MatchRule         `yaml:"match" jsonschema:"required"`
+		Code         int               `yaml:"code" jsonschema:"omitempty,format=httpcode"`
+		Headers      map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body         string            `yaml:"body" jsonschema:"omitempty"`
+		BodyTemplate string            `yaml:"bodyTemplate" jsonschema:"omitempty"`
+		Delay        string            `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		RandomDelay  *RandomDelay      `yaml:"randomDelay" jsonschema:"omitempty"`
+		Responses    []*Response       `yaml:"responses" jsonschema:"omitempty"`
+
+		delay       time.Duration
+		responses   []*Response
+		totalWeight int
+	}
+
+	// RandomDelay describes the distribution of the delay.
+	RandomDelay struct {
+		Distribution string `yaml:"distribution" jsonschema:"omitempty,enum=,enum=uniform,enum=normal"`
+		Min          string `yaml:"min" jsonschema:"omitempty,format=duration"`
+		Max          string `yaml:"max" jsonschema:"omitempty,format=duration"`
+		Mean         string `yaml:"mean" jsonschema:"omitempty,format=duration"`
+		StdDev       string `yaml:"stdDev" jsonschema:"omitempty,format=duration"`
+
+		min    time.Duration
+		max    time.Duration
+		mean   time.Duration
+		stdDev time.Duration
+	}
+
+	// Response is a weighted response variant of a rule.
+	Response struct {
+		Code         int               `yaml:"code" jsonschema:"required,format=httpcode"`
+		Headers      map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body         string            `yaml:"body" jsonschema:"omitempty"`
+		BodyTemplate string            `yaml:"bodyTemplate" jsonschema:"omitempty"`
+		Weight       int               `yaml:"weight" jsonschema:"required,minimum=1"`
 
-		delay time.Duration
+		template *template.Template
 	}
 
 	// MatchRule is the rule to match a request
@@ -84,6 +126,79 @@ type (
 	}
 )
 
+// Validate validates the Rule.
+func (r *Rule) Validate() error {
+	if len(r.Responses) == 0 && r.Code == 0 {
+		return fmt.Errorf("code is required if responses is empty")
+	}
+	_, err := parseBodyTemplate(r.BodyTemplate)
+	return err
+}
+
+// Validate validates the RandomDelay.
+func (rd *RandomDelay) Validate() error {
+	rd.parse()
+
+	if rd.Distribution == distributionNormal {
+		if rd.Mean == "" {
+			return fmt.Errorf("mean is required for normal distribution")
+		}
+	} else if rd.Max == "" {
+		return fmt.Errorf("max is required for uniform distribution")
+	}
+
+	if rd.Max != "" && rd.max < rd.min {
+		return fmt.Errorf("max must not be less than min")
+	}
+
+	return nil
+}
+
+func (rd *RandomDelay) parse() {
+	rd.min, _ = time.ParseDuration(rd.Min)
+	rd.max, _ = time.ParseDuration(rd.Max)
+	rd.mean, _ = time.ParseDuration(rd.Mean)
+	rd.stdDev, _ = time.ParseDuration(rd.StdDev)
+}
+
+// sample samples a delay from the distribution, the result is always
+// between min and max (if specified) and never exceeds maxRandomDelay.
+func (rd *RandomDelay) sample() time.Duration {
+	var d time.Duration
+	if rd.Distribution == distributionNormal {
+		d = rd.mean + time.Duration(rand.NormFloat64()*float64(rd.stdDev))
+	} else {
+		d = rd.min + time.Duration(rand.Int63n(int64(rd.max-rd.min)+1))
+	}
+
+	if d < rd.min {
+		d = rd.min
+	}
+	if rd.max > 0 && d > rd.max {
+		d = rd.max
+	}
+	if d > maxRandomDelay {
+		d = maxRandomDelay
+	}
+
+	return d
+}
+
+// Validate validates the Response.
+func (r *Response) Validate() error {
+	_, err := parseBodyTemplate(r.BodyTemplate)
+	return err
+}
+
+// parseBodyTemplate parses the body template, it returns nil if there
+// are no template markers in the body template.
+func parseBodyTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	return template.New("").Funcs(sprig.TxtFuncMap()).Parse(text)
+}
+
 // Name returns the name of the Mock filter instance.
 func (m *Mock) Name() string {
 	return m.spec.Name()
@@ -111,10 +226,29 @@ func (m *Mock) Inherit(previousGeneration filters.Filter) {
 
 func (m *Mock) reload() {
 	for _, r := range m.spec.Rules {
-		if r.Delay == "" {
-			continue
+		if r.Delay != "" {
+			r.delay, _ = time.ParseDuration(r.Delay)
+		}
+		if r.RandomDelay != nil {
+			r.RandomDelay.parse()
+		}
+
+		r.responses = r.Responses
+		if len(r.responses) == 0 {
+			r.responses = []*Response{{
+				Code:         r.Code,
+				Headers:      r.Headers,
+				Body:         r.Body,
+				BodyTemplate: r.BodyTemplate,
+				Weight:       1,
+			}}
+		}
+
+		r.totalWeight = 0
+		for _, resp := range r.responses {
+			r.totalWeight += resp.Weight
+			resp.template, _ = parseBodyTemplate(resp.BodyTemplate)
 		}
-		r.delay, _ = time.ParseDuration(r.Delay)
 	}
 }
 
@@ -196,26 +330,70 @@ func (m *Mock) match(ctx *context.Context) *Rule {
 	return nil
 }
 
+// chooseResponse chooses a response of the rule randomly by weight.
+func (r *Rule) chooseResponse() *Response {
+	if len(r.responses) == 1 {
+		return r.responses[0]
+	}
+
+	weight := rand.Intn(r.totalWeight)
+	for _, resp := range r.responses {
+		weight -= resp.Weight
+		if weight < 0 {
+			return resp
+		}
+	}
+
+	panic(fmt.Errorf("BUG: should not run to here, total weight=%d", r.totalWeight))
+}
+
+// body returns the body of the response. The body template is rendered
+// with the request if it contains template markers, otherwise it is used
+// as a literal body; the body option is used if rendering fails or the
+// body template is empty.
+func (r *Response) body(req *httpprot.Request) []byte {
+	if r.template != nil {
+		data := map[string]interface{}{
+			"Request": req.ToBuilderRequest(""),
+		}
+		var buf bytes.Buffer
+		err := r.template.Execute(&buf, data)
+		if err == nil {
+			return buf.Bytes()
+		}
+		logger.Warnf("failed to render mock body template: %v", err)
+	} else if r.BodyTemplate != "" {
+		return []byte(r.BodyTemplate)
+	}
+
+	return []byte(r.Body)
+}
+
 func (m *Mock) mock(ctx *context.Context, rule *Rule) {
 	resp, _ := httpprot.NewResponse(nil)
 
-	resp.SetStatusCode(rule.Code)
-	for key, value := range rule.Headers {
+	mr := rule.chooseResponse()
+	resp.SetStatusCode(mr.Code)
+	for key, value := range mr.Headers {
 		resp.Std().Header.Set(key, value)
 	}
-	resp.SetPayload([]byte(rule.Body))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	resp.SetPayload(mr.body(req))
 	ctx.SetOutputResponse(resp)
 
-	if rule.delay <= 0 {
+	delay := rule.delay
+	if rule.RandomDelay != nil {
+		delay = rule.RandomDelay.sample()
+	}
+	if delay <= 0 {
 		return
 	}
 
-	req := ctx.GetInputRequest().(*httpprot.Request)
-	logger.Debugf("delay for %v ...", rule.delay)
+	logger.Debugf("delay for %v ...", delay)
 	select {
 	case <-req.Context().Done():
 		logger.Debugf("request cancelled in the middle of delay mocking")
-	case <-time.After(rule.delay):
+	case <-time.After(delay):
 	}
 }
 
@@ -19,9 +19,11 @@ package mock
 
 import (
 	"io"
+	"math/rand"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
@@ -213,3 +215,142 @@ rules:
 		assert.Equal(204, resp.StatusCode())
 	}
 }
+
+func TestWeightedResponses(t *testing.T) {
+	assert := assert.New(t)
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- match:
+    path: /chaos
+  responses:
+  - code: 200
+    body: ok
+    weight: 90
+  - code: 500
+    body: error
+    weight: 10
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(e)
+
+	m := kind.CreateInstance(spec)
+	m.Init()
+
+	rand.Seed(0)
+	const total = 10000
+	counts := map[int]int{}
+	for i := 0; i < total; i++ {
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com/chaos", nil)
+		setRequest(t, ctx, context.DefaultNamespace, req)
+		assert.Equal(resultMocked, m.Handle(ctx))
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		counts[resp.StatusCode()]++
+	}
+
+	assert.Equal(total, counts[200]+counts[500])
+	assert.InDelta(0.9, float64(counts[200])/total, 0.02)
+	assert.InDelta(0.1, float64(counts[500])/total, 0.02)
+}
+
+func TestRuleValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	r := &Rule{}
+	assert.Error(r.Validate())
+
+	r.Code = 200
+	assert.NoError(r.Validate())
+
+	r = &Rule{Responses: []*Response{{Code: 200, Weight: 1}}}
+	assert.NoError(r.Validate())
+}
+
+func TestBodyTemplate(t *testing.T) {
+	assert := assert.New(t)
+	const yamlSpec = `
+kind: Mock
+name: mock
+rules:
+- match:
+    pathPrefix: /echo/
+  code: 200
+  bodyTemplate: '{"echo":"{{.Request.Header.Get "X-Id"}}","path":"{{.Request.URL.Path}}"}'
+- match:
+    path: /literal
+  code: 200
+  bodyTemplate: 'no markers'
+- match:
+    path: /bad
+  code: 200
+  body: fallback
+  bodyTemplate: '{{.Request.NoSuchField}}'
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := filters.NewSpec(nil, "", rawSpec)
+	assert.Nil(e)
+
+	m := kind.CreateInstance(spec)
+	m.Init()
+
+	handle := func(path string) string {
+		ctx := context.New(nil)
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.Header.Set("X-Id", "abc")
+		setRequest(t, ctx, context.DefaultNamespace, req)
+		m.Handle(ctx)
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		body, _ := io.ReadAll(resp.GetPayload())
+		return string(body)
+	}
+
+	assert.Equal(`{"echo":"abc","path":"/echo/1"}`, handle("/echo/1"))
+	assert.Equal("no markers", handle("/literal"))
+	assert.Equal("fallback", handle("/bad"))
+
+	r := &Rule{Code: 200, BodyTemplate: "{{.Request"}
+	assert.Error(r.Validate())
+}
+
+func TestRandomDelay(t *testing.T) {
+	assert := assert.New(t)
+
+	rd := &RandomDelay{Min: "10ms", Max: "20ms"}
+	assert.NoError(rd.Validate())
+	for i := 0; i < 1000; i++ {
+		d := rd.sample()
+		assert.GreaterOrEqual(d, 10*time.Millisecond)
+		assert.LessOrEqual(d, 20*time.Millisecond)
+	}
+
+	rd = &RandomDelay{Distribution: "normal", Mean: "10ms", StdDev: "5ms", Min: "5ms", Max: "15ms"}
+	assert.NoError(rd.Validate())
+	var sum time.Duration
+	for i := 0; i < 1000; i++ {
+		d := rd.sample()
+		assert.GreaterOrEqual(d, 5*time.Millisecond)
+		assert.LessOrEqual(d, 15*time.Millisecond)
+		sum += d
+	}
+	assert.InDelta(float64(10*time.Millisecond), float64(sum/1000), float64(time.Millisecond))
+
+	// never negative and capped.
+	rd = &RandomDelay{Distribution: "normal", Mean: "1h", StdDev: "1h"}
+	assert.NoError(rd.Validate())
+	for i := 0; i < 1000; i++ {
+		d := rd.sample()
+		assert.GreaterOrEqual(d, time.Duration(0))
+		assert.LessOrEqual(d, maxRandomDelay)
+	}
+
+	assert.Error((&RandomDelay{}).Validate())
+	assert.Error((&RandomDelay{Distribution: "normal"}).Validate())
+	assert.Error((&RandomDelay{Min: "20ms", Max: "10ms"}).Validate())
+}
@@ -18,10 +18,17 @@
 package mqttclientauth
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
 	"github.com/megaease/easegress/pkg/logger"
@@ -33,6 +40,10 @@ const (
 	Kind = "MQTTClientAuth"
 
 	resultAuthFail = "AuthFail"
+
+	defaultHookTimeout  = 2 * time.Second
+	defaultHookCacheTTL = 30 * time.Second
+	hookCacheSize       = 10000
 )
 
 var kind = &filters.Kind{
@@ -57,6 +68,7 @@ type (
 		spec    *Spec
 		authMap map[string]string
 		salt    string
+		hook    *authHook
 	}
 
 	// Spec is spec for MQTTClientAuth.
@@ -65,8 +77,18 @@ type (
 	Spec struct {
 		filters.BaseSpec `yaml:",inline"`
 
-		Salt string  `yaml:"salt" jsonschema:"omitempty"`
-		Auth []*Auth `yaml:"auth" jsonschema:"required"`
+		Salt     string        `yaml:"salt" jsonschema:"omitempty"`
+		Auth     []*Auth       `yaml:"auth" jsonschema:"omitempty"`
+		AuthHook *AuthHookSpec `yaml:"authHook,omitempty" jsonschema:"omitempty"`
+	}
+
+	// AuthHookSpec describes an HTTP endpoint to check the client ID,
+	// username and password of clients. The endpoint allows a client by
+	// responding a 2xx status code, and denies it otherwise.
+	AuthHookSpec struct {
+		URL      string `yaml:"url" jsonschema:"required,format=url"`
+		Timeout  string `yaml:"timeout,omitempty" jsonschema:"omitempty,format=duration"`
+		CacheTTL string `yaml:"cacheTTL,omitempty" jsonschema:"omitempty,format=duration"`
 	}
 
 	// Auth describes username and password for MQTTProxy
@@ -74,10 +96,106 @@ type (
 		Username         string `yaml:"username" jsonschema:"required"`
 		SaltedSha256Pass string `yaml:"saltedSha256Pass" jsonschema:"required"`
 	}
+
+	// authHookRequest is the body of the requests sent to the auth hook.
+	authHookRequest struct {
+		ClientID string `json:"clientID"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	authHook struct {
+		url    string
+		client *http.Client
+		ttl    time.Duration
+		cache  *lru.Cache
+	}
+
+	authHookResult struct {
+		allowed  bool
+		expireAt time.Time
+	}
 )
 
 var _ filters.Filter = (*MQTTClientAuth)(nil)
 
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if len(spec.Auth) > 0 && spec.AuthHook != nil {
+		return fmt.Errorf("auth and authHook cannot be specified at the same time")
+	}
+	return nil
+}
+
+func newAuthHook(spec *AuthHookSpec) *authHook {
+	timeout := defaultHookTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	ttl := defaultHookCacheTTL
+	if spec.CacheTTL != "" {
+		ttl, _ = time.ParseDuration(spec.CacheTTL)
+	}
+
+	cache, _ := lru.New(hookCacheSize)
+	return &authHook{
+		url:    spec.URL,
+		client: &http.Client{Timeout: timeout},
+		ttl:    ttl,
+		cache:  cache,
+	}
+}
+
+// check checks the client by the auth hook, results are cached for the
+// TTL, while errors are not cached and the client is denied.
+func (h *authHook) check(connect *packets.ConnectPacket) bool {
+	hash := sha256.New()
+	for _, v := range [][]byte{[]byte(connect.ClientIdentifier), []byte(connect.Username), connect.Password} {
+		hash.Write(v)
+		hash.Write([]byte{0})
+	}
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	if v, ok := h.cache.Get(key); ok {
+		r := v.(*authHookResult)
+		if time.Now().Before(r.expireAt) {
+			return r.allowed
+		}
+		h.cache.Remove(key)
+	}
+
+	allowed, err := h.call(connect)
+	if err != nil {
+		logger.Errorf("call auth hook %s for client %s failed: %v", h.url, connect.ClientIdentifier, err)
+		return false
+	}
+
+	if h.ttl > 0 {
+		h.cache.Add(key, &authHookResult{allowed: allowed, expireAt: time.Now().Add(h.ttl)})
+	}
+	return allowed
+}
+
+func (h *authHook) call(connect *packets.ConnectPacket) (bool, error) {
+	body, err := json.Marshal(&authHookRequest{
+		ClientID: connect.ClientIdentifier,
+		Username: connect.Username,
+		Password: string(connect.Password),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode >= 200 && resp.StatusCode < 300, nil
+}
+
 // Name returns the name of the MQTTClientAuth filter instance.
 func (a *MQTTClientAuth) Name() string {
 	return a.spec.Name()
@@ -102,7 +220,9 @@ func (a *MQTTClientAuth) Init() {
 		a.authMap[auth.Username] = auth.SaltedSha256Pass
 	}
 
-	if len(a.authMap) == 0 {
+	if a.spec.AuthHook != nil {
+		a.hook = newAuthHook(a.spec.AuthHook)
+	} else if len(a.authMap) == 0 {
 		logger.Errorf("empty valid authentication for MQTT filter %v", a.spec.Name())
 	}
 }
@@ -130,6 +250,12 @@ func (a *MQTTClientAuth) checkAuth(connect *packets.ConnectPacket) string {
 	if connect.ClientIdentifier == "" {
 		return resultAuthFail
 	}
+	if a.hook != nil {
+		if !a.hook.check(connect) {
+			return resultAuthFail
+		}
+		return ""
+	}
 	saltedSha256Pass, ok := a.authMap[connect.Username]
 	if !ok {
 		return resultAuthFail
@@ -18,9 +18,14 @@
 package mqttclientauth
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/context"
@@ -109,3 +114,77 @@ func TestAuthFile(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestAuthHook(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		req := &authHookRequest{}
+		err := json.NewDecoder(r.Body).Decode(req)
+		assert.NoError(err)
+
+		switch {
+		case req.Username == "slow":
+			time.Sleep(200 * time.Millisecond)
+		case req.Username == "alice" && req.Password == "alice":
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	spec := &Spec{
+		AuthHook: &AuthHookSpec{URL: server.URL, Timeout: "100ms", CacheTTL: "1m"},
+	}
+	auth := kind.CreateInstance(spec)
+	auth.Init()
+
+	handle := func(cid, username, password string) (string, bool) {
+		ctx := newContext(cid, username, password)
+		result := auth.Handle(ctx)
+		return result, ctx.GetOutputResponse().(*mqttprot.Response).Disconnect()
+	}
+
+	// allow
+	result, disconnect := handle("cid1", "alice", "alice")
+	assert.Equal("", result)
+	assert.False(disconnect)
+
+	// deny
+	result, disconnect = handle("cid1", "alice", "bob")
+	assert.Equal(resultAuthFail, result)
+	assert.True(disconnect)
+	result, _ = handle("cid2", "bob", "bob")
+	assert.Equal(resultAuthFail, result)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+
+	// results are cached.
+	result, _ = handle("cid1", "alice", "alice")
+	assert.Equal("", result)
+	result, _ = handle("cid2", "bob", "bob")
+	assert.Equal(resultAuthFail, result)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+
+	// timeout fails the client, and is not cached.
+	for i := 0; i < 2; i++ {
+		result, _ = handle("cid3", "slow", "slow")
+		assert.Equal(resultAuthFail, result)
+	}
+	assert.Equal(int32(5), atomic.LoadInt32(&calls))
+
+	// expired results are checked again.
+	auth.(*MQTTClientAuth).hook.ttl = time.Millisecond
+	auth.(*MQTTClientAuth).hook.cache.Purge()
+	handle("cid1", "alice", "alice")
+	time.Sleep(10 * time.Millisecond)
+	handle("cid1", "alice", "alice")
+	assert.Equal(int32(7), atomic.LoadInt32(&calls))
+
+	// auth and authHook are exclusive.
+	spec.Auth = []*Auth{{Username: "test", SaltedSha256Pass: "test"}}
+	assert.Error(spec.Validate())
+}
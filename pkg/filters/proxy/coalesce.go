@@ -0,0 +1,260 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const defaultCoalesceMaxWait = 5 * time.Second
+
+type (
+	// CoalesceSpec describes the coalescing of requests. Concurrent
+	// identical requests share the response of a single upstream call.
+	CoalesceSpec struct {
+		MaxWait string   `yaml:"maxWait" jsonschema:"omitempty,format=duration"`
+		Methods []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Headers []string `yaml:"headers" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// CoalesceStatus is the status of request coalescing.
+	CoalesceStatus struct {
+		Leaders   uint64 `yaml:"leaders"`
+		Followers uint64 `yaml:"followers"`
+		Timeouts  uint64 `yaml:"timeouts"`
+	}
+
+	// coalescer coalesces concurrent identical requests, the first one
+	// (the leader) is sent to the backend, and the others (the
+	// followers) wait for its response.
+	coalescer struct {
+		maxWait time.Duration
+		methods map[string]struct{}
+		headers []string
+		cache   *ResponseCache
+
+		mutex sync.Mutex
+		calls map[string]*coalescedCall
+
+		leaders   uint64
+		followers uint64
+		timeouts  uint64
+	}
+
+	coalescedCall struct {
+		done chan struct{}
+		// entry is nil if the response of the leader can't be shared.
+		entry *CacheEntry
+		// varyNames and varyValues are the request headers in the Vary
+		// header of the response and their values in the request of the
+		// leader, followers with different values can't share it.
+		varyNames  []string
+		varyValues string
+	}
+)
+
+// Validate validates CoalesceSpec.
+func (spec *CoalesceSpec) Validate() error {
+	if spec.MaxWait != "" {
+		if _, err := time.ParseDuration(spec.MaxWait); err != nil {
+			return fmt.Errorf("invalid maxWait: %v", err)
+		}
+	}
+	return nil
+}
+
+func newCoalescer(spec *CoalesceSpec, cache *ResponseCache) *coalescer {
+	c := &coalescer{
+		maxWait: defaultCoalesceMaxWait,
+		methods: map[string]struct{}{},
+		cache:   cache,
+		calls:   map[string]*coalescedCall{},
+	}
+
+	if spec.MaxWait != "" {
+		c.maxWait, _ = time.ParseDuration(spec.MaxWait)
+	}
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	for _, m := range methods {
+		c.methods[m] = struct{}{}
+	}
+
+	// requests of different users never share responses.
+	c.headers = []string{"Authorization", "Cookie"}
+	for _, h := range spec.Headers {
+		c.headers = append(c.headers, http.CanonicalHeaderKey(h))
+	}
+
+	return c
+}
+
+// key returns the key of the request, ok is false if the request must
+// not be coalesced.
+func (c *coalescer) key(req *httpprot.Request) (key string, ok bool) {
+	if _, ok := c.methods[req.Method()]; !ok {
+		return "", false
+	}
+	if req.IsStream() {
+		return "", false
+	}
+
+	cc := cacheControl(req.HTTPHeader())
+	if _, ok := cc["no-cache"]; ok {
+		return "", false
+	}
+	if _, ok := cc["no-store"]; ok {
+		return "", false
+	}
+
+	primaryKey := stringtool.Cat(req.Method(), " ", req.Scheme(), "://", req.Host(), req.Std().URL.RequestURI())
+
+	// the request headers the responses vary on, known by the cache.
+	names := c.headers
+	if c.cache != nil {
+		c.cache.mutex.Lock()
+		if v := c.cache.varies[primaryKey]; v != nil {
+			names = append(append([]string{}, names...), v.names...)
+		}
+		c.cache.mutex.Unlock()
+	}
+
+	names = append([]string{}, names...)
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(primaryKey)
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		sb.WriteString("\n")
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(strings.Join(req.HTTPHeader().Values(name), ","))
+	}
+	return sb.String(), true
+}
+
+// headerValues returns the values of the headers of the request.
+func headerValues(req *httpprot.Request, names []string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		sb.WriteString(strings.Join(req.HTTPHeader().Values(name), ","))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// join joins the call of key, leader is true if the caller is the first
+// one and must send the request to the backend.
+func (c *coalescer) join(key string) (call *coalescedCall, leader bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if call = c.calls[key]; call != nil {
+		atomic.AddUint64(&c.followers, 1)
+		return call, false
+	}
+
+	call = &coalescedCall{done: make(chan struct{})}
+	c.calls[key] = call
+	atomic.AddUint64(&c.leaders, 1)
+	return call, true
+}
+
+// finish finishes the call of key and wakes up the followers, resp is
+// shared with them if it is not nil.
+func (c *coalescer) finish(key string, call *coalescedCall, req *httpprot.Request, resp *httpprot.Response) {
+	if resp != nil && shareable(resp) {
+		call.varyNames, _ = varyNames(resp.HTTPHeader())
+		call.varyValues = headerValues(req, call.varyNames)
+		call.entry = &CacheEntry{
+			StatusCode: resp.StatusCode(),
+			Header:     resp.HTTPHeader().Clone(),
+			Body:       resp.RawPayload(),
+		}
+	}
+
+	c.mutex.Lock()
+	delete(c.calls, key)
+	c.mutex.Unlock()
+
+	close(call.done)
+}
+
+// wait waits for the leader of the call, it returns nil if the leader
+// doesn't respond within the max wait or its response can't be shared.
+func (c *coalescer) wait(req *httpprot.Request, call *coalescedCall) *CacheEntry {
+	timer := time.NewTimer(c.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		if call.entry == nil || headerValues(req, call.varyNames) != call.varyValues {
+			return nil
+		}
+		return call.entry
+	case <-timer.C:
+		atomic.AddUint64(&c.timeouts, 1)
+		return nil
+	case <-req.Context().Done():
+		return nil
+	}
+}
+
+// shareable returns whether the response could be shared with other
+// clients.
+func shareable(resp *httpprot.Response) bool {
+	if resp.IsStream() {
+		return false
+	}
+	if len(resp.HTTPHeader().Values("Set-Cookie")) > 0 {
+		return false
+	}
+	if _, ok := varyNames(resp.HTTPHeader()); !ok {
+		return false
+	}
+	cc := cacheControl(resp.HTTPHeader())
+	for _, d := range []string{"no-store", "private"} {
+		if _, ok := cc[d]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *coalescer) status() *CoalesceStatus {
+	return &CoalesceStatus{
+		Leaders:   atomic.LoadUint64(&c.leaders),
+		Followers: atomic.LoadUint64(&c.followers),
+		Timeouts:  atomic.LoadUint64(&c.timeouts),
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalesceKey(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newCoalescer(&CoalesceSpec{Headers: []string{"accept-language"}}, nil)
+
+	newReq := func(method, url string) *httpprot.Request {
+		stdr, _ := http.NewRequest(method, url, nil)
+		req, _ := httpprot.NewRequest(stdr)
+		return req
+	}
+
+	req := newReq(http.MethodGet, "http://megaease.com/abc?x=1")
+	k1, ok := c.key(req)
+	assert.True(ok)
+
+	req.HTTPHeader().Set("Accept-Language", "en")
+	k2, ok := c.key(req)
+	assert.True(ok)
+	assert.NotEqual(k1, k2)
+
+	req.HTTPHeader().Set("Authorization", "Basic YWJjOmRlZg==")
+	k3, ok := c.key(req)
+	assert.True(ok)
+	assert.NotEqual(k2, k3)
+
+	_, ok = c.key(newReq(http.MethodPost, "http://megaease.com/abc"))
+	assert.False(ok)
+
+	req = newReq(http.MethodGet, "http://megaease.com/abc")
+	req.HTTPHeader().Set(keyCacheControl, "no-cache")
+	_, ok = c.key(req)
+	assert.False(ok)
+
+	assert.NoError((&CoalesceSpec{MaxWait: "1s"}).Validate())
+	assert.Error((&CoalesceSpec{MaxWait: "1"}).Validate())
+}
+
+func TestCoalesce(t *testing.T) {
+	assert := assert.New(t)
+
+	const concurrency = 20
+
+	var proxy *Proxy
+	var count int32
+	var setCookie int32
+	var followers uint64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+
+		// wait for the followers to join.
+		c := proxy.mainPool.coalescer
+		for i := 0; i < 100; i++ {
+			if atomic.LoadUint64(&c.followers) >= atomic.LoadUint64(&followers) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if atomic.LoadInt32(&setCookie) == 1 {
+			w.Header().Set("Set-Cookie", "a=b")
+		}
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + server.URL + `
+  coalesce:
+    maxWait: 5s
+`
+	proxy = newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	fire := func() {
+		atomic.AddUint64(&followers, concurrency-1)
+		var wg sync.WaitGroup
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+				ctx := getCtx(stdr)
+				assert.Equal("", proxy.Handle(ctx))
+				resp := ctx.GetOutputResponse().(*httpprot.Response)
+				assert.Equal(http.StatusOK, resp.StatusCode())
+				assert.Equal("ok", string(resp.RawPayload()))
+			}()
+		}
+		wg.Wait()
+	}
+
+	// the backend is called once.
+	fire()
+	assert.Equal(int32(1), atomic.LoadInt32(&count))
+
+	status := proxy.mainPool.status().Coalesce
+	assert.Equal(uint64(1), status.Leaders)
+	assert.Equal(uint64(concurrency-1), status.Followers)
+
+	// responses with cookies are not shared.
+	atomic.StoreInt32(&count, 0)
+	atomic.StoreInt32(&setCookie, 1)
+	fire()
+	assert.Equal(int32(concurrency), atomic.LoadInt32(&count))
+}
@@ -18,9 +18,16 @@
 package proxy
 
 import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/andybalholm/brotli"
+
 	"github.com/megaease/easegress/pkg/util/readers"
 )
 
@@ -29,12 +36,25 @@ import (
 type (
 	// compression is filter compression.
 	compression struct {
-		spec *CompressionSpec
+		spec      *CompressionSpec
+		encodings []string
 	}
 
 	// CompressionSpec describes the compression.
 	CompressionSpec struct {
-		MinLength uint32 `yaml:"minLength"`
+		MinLength  uint32   `yaml:"minLength"`
+		Encodings  []string `yaml:"encodings" jsonschema:"omitempty,uniqueItems=true"`
+		Decompress bool     `yaml:"decompress" jsonschema:"omitempty"`
+	}
+
+	// decompressReader decompresses the data of the underlying reader,
+	// the decompressor is created on the first read, so the underlying
+	// reader is untouched before that.
+	decompressReader struct {
+		r             io.ReadCloser
+		zr            io.Reader
+		err           error
+		newDecompress func(io.Reader) (io.Reader, error)
 	}
 )
 
@@ -45,57 +65,174 @@ const (
 	keyVary            = "Vary"
 )
 
+var compressors = map[string]func(w io.Writer) io.WriteCloser{
+	"gzip": func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	},
+	"deflate": func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	},
+	"br": func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriter(w)
+	},
+}
+
+var decompressors = map[string]func(r io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": func(r io.Reader) (io.Reader, error) {
+		return zlib.NewReader(r)
+	},
+	"br": func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+}
+
+// Validate validates CompressionSpec.
+func (spec *CompressionSpec) Validate() error {
+	for _, e := range spec.Encodings {
+		if _, ok := compressors[e]; !ok {
+			return fmt.Errorf("unsupported encoding %q", e)
+		}
+	}
+	return nil
+}
+
 func newCompression(spec *CompressionSpec) *compression {
+	encodings := spec.Encodings
+	if len(encodings) == 0 {
+		encodings = []string{"gzip"}
+	}
+
 	return &compression{
-		spec: spec,
+		spec:      spec,
+		encodings: encodings,
 	}
 }
 
-func (c *compression) compress(req *http.Request, resp *http.Response) bool {
-	if !c.acceptGzip(req) {
-		return false
+// compress compresses the body of the response with the encoding
+// preferred by the client, and returns the encoding, it returns an
+// empty string if the body is not compressed.
+func (c *compression) compress(req *http.Request, resp *http.Response) string {
+	encoding := c.chooseEncoding(req)
+	if encoding == "" {
+		return ""
 	}
 
-	if c.alreadyGziped(resp) {
-		return false
+	if resp.ContentLength != -1 && resp.ContentLength < int64(c.spec.MinLength) {
+		return ""
 	}
 
-	if resp.ContentLength != -1 && resp.ContentLength < int64(c.spec.MinLength) {
-		return false
+	if current := c.contentEncoding(resp); current != "" {
+		if current == encoding || !c.spec.Decompress {
+			return ""
+		}
+		fn := decompressors[current]
+		if fn == nil {
+			return ""
+		}
+		resp.Body = &decompressReader{r: resp.Body, newDecompress: fn}
 	}
 
+	resp.ContentLength = -1
 	resp.Header.Del(keyContentLength)
-	resp.Header.Set(keyContentEncoding, "gzip")
-	resp.Header.Add(keyVary, keyContentEncoding)
+	resp.Header.Set(keyContentEncoding, encoding)
+	resp.Header.Add(keyVary, keyAcceptEncoding)
 
-	resp.Body = readers.NewGZipCompressReader(resp.Body)
-	return true
+	resp.Body = readers.NewCompressReader(resp.Body, compressors[encoding])
+	return encoding
 }
 
-func (c *compression) alreadyGziped(resp *http.Response) bool {
-	for _, ce := range resp.Header.Values(keyContentEncoding) {
-		if strings.Contains(ce, "gzip") {
-			return true
-		}
+// contentEncoding returns the content encoding of the response, the
+// result is an empty string if the response is not encoded.
+func (c *compression) contentEncoding(resp *http.Response) string {
+	values := resp.Header.Values(keyContentEncoding)
+	encoding := strings.ToLower(strings.TrimSpace(strings.Join(values, ",")))
+	if encoding == "identity" {
+		return ""
 	}
-
-	return false
+	return encoding
 }
 
-func (c *compression) acceptGzip(req *http.Request) bool {
+// chooseEncoding chooses the encoding according to the q-values in the
+// Accept-Encoding header of the request, the order of the encodings in
+// the spec is used as the preference if q-values are equal. It returns
+// an empty string if no encoding is acceptable.
+//
+// Reference: https://www.rfc-editor.org/rfc/rfc7231#section-5.3.4
+func (c *compression) chooseEncoding(req *http.Request) string {
 	acceptEncodings := req.Header.Values(keyAcceptEncoding)
+	if len(acceptEncodings) == 0 {
+		return c.encodings[0]
+	}
 
-	// NOTE: Easegress does not support parsing qvalue for performance.
-	// Reference: https://tools.ietf.org/html/rfc2616#section-14.3
-	if len(acceptEncodings) > 0 {
-		for _, ae := range acceptEncodings {
-			if strings.Contains(ae, "*/*") ||
-				strings.Contains(ae, "gzip") {
-				return true
+	qvalues := map[string]float64{}
+	wildcard := -1.0
+	for _, ae := range acceptEncodings {
+		for _, item := range strings.Split(ae, ",") {
+			name, q := parseQValue(item)
+			// NOTE: "*/*" is not valid for Accept-Encoding, but it is
+			// accepted for backward compatibility.
+			if name == "*" || name == "*/*" {
+				wildcard = q
+			} else if name != "" {
+				qvalues[name] = q
 			}
 		}
-		return false
 	}
 
-	return true
+	best, bestQ := "", 0.0
+	for _, e := range c.encodings {
+		q, ok := qvalues[e]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best
+}
+
+// parseQValue parses an item of the Accept-Encoding header, and returns
+// the lower-cased coding name and its q-value.
+func parseQValue(item string) (string, float64) {
+	parts := strings.Split(item, ";")
+	name := strings.ToLower(strings.TrimSpace(parts[0]))
+
+	q := 1.0
+	for _, p := range parts[1:] {
+		p = strings.TrimSpace(p)
+		if !strings.HasPrefix(p, "q=") {
+			continue
+		}
+		v, err := strconv.ParseFloat(p[2:], 64)
+		if err != nil {
+			v = 0
+		}
+		q = v
+	}
+
+	return name, q
+}
+
+// Read implements io.Reader.
+func (r *decompressReader) Read(p []byte) (int, error) {
+	if r.zr == nil && r.err == nil {
+		r.zr, r.err = r.newDecompress(r.r)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return r.zr.Read(p)
+}
+
+// Close implements io.Closer.
+func (r *decompressReader) Close() error {
+	if c, ok := r.zr.(io.Closer); ok {
+		c.Close()
+	}
+	return r.r.Close()
 }
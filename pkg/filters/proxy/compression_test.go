@@ -18,54 +18,90 @@
 package proxy
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io"
 	"net/http"
 	"strings"
 	"testing"
+
+	"github.com/andybalholm/brotli"
 )
 
-func TestAcceptGzip(t *testing.T) {
+func TestChooseEncoding(t *testing.T) {
 	c := newCompression(&CompressionSpec{MinLength: 100})
 
 	req, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
-	if !c.acceptGzip(req) {
+	if c.chooseEncoding(req) != "gzip" {
 		t.Error("accept gzip should be true")
 	}
 
 	req.Header.Add(keyAcceptEncoding, "text/text")
-	if c.acceptGzip(req) {
+	if c.chooseEncoding(req) != "" {
 		t.Error("accept gzip should be false")
 	}
 
 	req.Header.Add(keyAcceptEncoding, "*/*")
-	if !c.acceptGzip(req) {
+	if c.chooseEncoding(req) != "gzip" {
 		t.Error("accept gzip should be true")
 	}
 
 	req.Header.Del(keyAcceptEncoding)
 	req.Header.Add(keyAcceptEncoding, "gzip")
-	if !c.acceptGzip(req) {
+	if c.chooseEncoding(req) != "gzip" {
 		t.Error("accept gzip should be true")
 	}
+
+	c = newCompression(&CompressionSpec{Encodings: []string{"br", "gzip", "deflate"}})
+	cases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"deflate;q=0.8, gzip;q=0.3", "deflate"},
+		{"br;q=0, *;q=0.5", "gzip"},
+		{"*", "br"},
+		{"identity", ""},
+		{"gzip;q=0, deflate;q=0, br;q=0", ""},
+	}
+	for _, tc := range cases {
+		req.Header.Set(keyAcceptEncoding, tc.acceptEncoding)
+		if got := c.chooseEncoding(req); got != tc.expected {
+			t.Errorf("%q: expected %q, got %q", tc.acceptEncoding, tc.expected, got)
+		}
+	}
 }
 
-func TestAlreadyGziped(t *testing.T) {
+func TestContentEncoding(t *testing.T) {
 	c := newCompression(&CompressionSpec{MinLength: 100})
 
 	resp := &http.Response{Header: http.Header{}}
 
-	if c.alreadyGziped(resp) {
-		t.Error("already gziped should be false")
+	if c.contentEncoding(resp) != "" {
+		t.Error("content encoding should be empty")
+	}
+
+	resp.Header.Add(keyContentEncoding, "identity")
+	if c.contentEncoding(resp) != "" {
+		t.Error("content encoding should be empty")
 	}
 
-	resp.Header.Add(keyContentEncoding, "text")
-	if c.alreadyGziped(resp) {
-		t.Error("already gziped should be false")
+	resp.Header.Set(keyContentEncoding, "GZip")
+	if c.contentEncoding(resp) != "gzip" {
+		t.Error("content encoding should be gzip")
 	}
+}
 
-	resp.Header.Add(keyContentEncoding, "gzip")
-	if !c.alreadyGziped(resp) {
-		t.Error("already gziped should be true")
+func TestCompressionSpecValidate(t *testing.T) {
+	spec := &CompressionSpec{Encodings: []string{"gzip", "br"}}
+	if spec.Validate() != nil {
+		t.Error("spec should be valid")
+	}
+
+	spec.Encodings = append(spec.Encodings, "zstd")
+	if spec.Validate() == nil {
+		t.Error("spec should be invalid")
 	}
 }
 
@@ -97,3 +133,85 @@ func TestCompress(t *testing.T) {
 		t.Error("data length should not be zero")
 	}
 }
+
+func TestCompressBrotli(t *testing.T) {
+	c := newCompression(&CompressionSpec{Encodings: []string{"gzip", "br"}})
+
+	req, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
+	req.Header.Set(keyAcceptEncoding, "gzip;q=0.5, br")
+
+	rawBody := strings.Repeat("this is the raw body. ", 100)
+	resp := &http.Response{
+		Header:        http.Header{},
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader(rawBody)),
+	}
+
+	if c.compress(req, resp) != "br" {
+		t.Fatal("body should be compressed by brotli")
+	}
+	if resp.Header.Get(keyContentEncoding) != "br" {
+		t.Error("content encoding should be br")
+	}
+	if resp.Header.Get(keyVary) != keyAcceptEncoding {
+		t.Error("response should vary on accept encoding")
+	}
+
+	data, _ := io.ReadAll(brotli.NewReader(resp.Body))
+	if string(data) != rawBody {
+		t.Error("decompressed body should be the raw body")
+	}
+}
+
+func TestCompressEncodedBody(t *testing.T) {
+	rawBody := strings.Repeat("this is the raw body. ", 100)
+	buf := bytes.NewBuffer(nil)
+	zw := gzip.NewWriter(buf)
+	zw.Write([]byte(rawBody))
+	zw.Close()
+	gzipBody := buf.Bytes()
+
+	newResp := func() *http.Response {
+		resp := &http.Response{
+			Header:        http.Header{},
+			ContentLength: int64(len(gzipBody)),
+			Body:          io.NopCloser(bytes.NewReader(gzipBody)),
+		}
+		resp.Header.Set(keyContentEncoding, "gzip")
+		return resp
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "https://megaease.com", nil)
+	req.Header.Set(keyAcceptEncoding, "br")
+
+	// pass through if decompress is not enabled.
+	c := newCompression(&CompressionSpec{Encodings: []string{"gzip", "br"}})
+	resp := newResp()
+	if c.compress(req, resp) != "" {
+		t.Error("body should not be compressed")
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(data, gzipBody) || resp.Header.Get(keyContentEncoding) != "gzip" {
+		t.Error("body should be passed through")
+	}
+
+	// pass through if the client accepts the current encoding.
+	c = newCompression(&CompressionSpec{Encodings: []string{"gzip", "br"}, Decompress: true})
+	req.Header.Set(keyAcceptEncoding, "gzip")
+	resp = newResp()
+	if c.compress(req, resp) != "" {
+		t.Error("body should not be compressed")
+	}
+
+	// decompress and compress again with brotli.
+	req.Header.Set(keyAcceptEncoding, "br")
+	resp = newResp()
+	if c.compress(req, resp) != "br" {
+		t.Fatal("body should be compressed by brotli")
+	}
+	data, _ = io.ReadAll(brotli.NewReader(resp.Body))
+	if string(data) != rawBody {
+		t.Error("decompressed body should be the raw body")
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ipPreferenceIPv4 = "ipv4"
+	ipPreferenceIPv6 = "ipv6"
+
+	// defaultDialFallbackDelay is the same as the one of net.Dialer.
+	defaultDialFallbackDelay = 300 * time.Millisecond
+)
+
+// fnLookupIPAddr and fnDialAddr are replaced in tests.
+var fnLookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+var fnDialAddr = func(ctx stdcontext.Context, d *net.Dialer, network, address string) (net.Conn, error) {
+	return d.DialContext(ctx, network, address)
+}
+
+type (
+	// DialSpec is the spec to dial the backend servers.
+	DialSpec struct {
+		// IPPreference is the address family to try first for servers
+		// with both IPv4 and IPv6 addresses, empty follows the order of
+		// the resolver.
+		IPPreference string `yaml:"ipPreference" jsonschema:"omitempty,enum=,enum=ipv4,enum=ipv6"`
+		// FallbackDelay is how long to wait for the preferred address
+		// family before trying the other one in parallel, a negative
+		// value disables the parallel attempt.
+		FallbackDelay string `yaml:"fallbackDelay" jsonschema:"omitempty,format=duration"`
+		// DSCP is the Differentiated Services Code Point to mark the
+		// connections with, zero leaves the marking to the system.
+		DSCP int `yaml:"dscp" jsonschema:"omitempty,minimum=0,maximum=63"`
+	}
+
+	// dialer dials the addresses of the preferred family first, and
+	// falls back to the other family in the happy eyeballs style.
+	dialer struct {
+		dialer        *net.Dialer
+		preference    string
+		fallbackDelay time.Duration
+	}
+
+	dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+)
+
+// Validate validates DialSpec.
+func (spec *DialSpec) Validate() error {
+	switch spec.IPPreference {
+	case "", ipPreferenceIPv4, ipPreferenceIPv6:
+	default:
+		return fmt.Errorf("invalid ipPreference %s", spec.IPPreference)
+	}
+	if spec.FallbackDelay != "" {
+		if _, err := time.ParseDuration(spec.FallbackDelay); err != nil {
+			return fmt.Errorf("invalid fallbackDelay: %v", err)
+		}
+	}
+	if spec.DSCP < 0 || spec.DSCP > 63 {
+		return fmt.Errorf("invalid dscp %d, must be in [0, 63]", spec.DSCP)
+	}
+	if spec.DSCP != 0 && !dscpSupported {
+		return fmt.Errorf("dscp is only supported on Linux")
+	}
+	return nil
+}
+
+func newDialer(spec *DialSpec) *dialer {
+	d := &dialer{
+		dialer: &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 60 * time.Second,
+		},
+		fallbackDelay: defaultDialFallbackDelay,
+	}
+
+	if spec == nil {
+		return d
+	}
+
+	d.preference = spec.IPPreference
+	if spec.FallbackDelay != "" {
+		d.fallbackDelay, _ = time.ParseDuration(spec.FallbackDelay)
+	}
+	if d.fallbackDelay == 0 {
+		d.fallbackDelay = defaultDialFallbackDelay
+	}
+	d.dialer.FallbackDelay = d.fallbackDelay
+	if spec.DSCP != 0 {
+		d.dialer.Control = dscpControl(spec.DSCP)
+	}
+	return d
+}
+
+func (d *dialer) isPreferred(ip net.IP) bool {
+	if d.preference == ipPreferenceIPv4 {
+		return ip.To4() != nil
+	}
+	return ip.To4() == nil
+}
+
+// DialContext dials address on network.
+func (d *dialer) DialContext(ctx stdcontext.Context, network, address string) (net.Conn, error) {
+	if d.preference == "" {
+		return fnDialAddr(ctx, d.dialer, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || net.ParseIP(host) != nil {
+		return fnDialAddr(ctx, d.dialer, network, address)
+	}
+
+	addrs, err := fnLookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var primaries, fallbacks []string
+	for _, addr := range addrs {
+		hostport := net.JoinHostPort(addr.IP.String(), port)
+		if d.isPreferred(addr.IP) {
+			primaries = append(primaries, hostport)
+		} else {
+			fallbacks = append(fallbacks, hostport)
+		}
+	}
+
+	if len(primaries) == 0 {
+		primaries, fallbacks = fallbacks, nil
+	}
+	if len(fallbacks) == 0 {
+		return d.dialSerial(ctx, network, primaries)
+	}
+	if d.fallbackDelay < 0 {
+		return d.dialSerial(ctx, network, append(primaries, fallbacks...))
+	}
+	return d.dialParallel(ctx, network, primaries, fallbacks)
+}
+
+// dialSerial dials the addresses one by one until one succeeds.
+func (d *dialer) dialSerial(ctx stdcontext.Context, network string, addrs []string) (net.Conn, error) {
+	var err error
+	for _, addr := range addrs {
+		var conn net.Conn
+		if conn, err = fnDialAddr(ctx, d.dialer, network, addr); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("no address to dial")
+	}
+	return nil, err
+}
+
+// dialParallel dials the primaries, and the fallbacks after the fallback
+// delay or once the primaries failed, the first established connection
+// wins.
+func (d *dialer) dialParallel(ctx stdcontext.Context, network string, primaries, fallbacks []string) (net.Conn, error) {
+	ctx, cancel := stdcontext.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	dial := func(addrs []string, primary bool) {
+		conn, err := d.dialSerial(ctx, network, addrs)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-ctx.Done():
+			if conn != nil {
+				conn.Close()
+			}
+		}
+	}
+
+	go dial(primaries, true)
+
+	timer := time.NewTimer(d.fallbackDelay)
+	defer timer.Stop()
+
+	var primaryErr, fallbackErr error
+	fallbackStarted := false
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				go dial(fallbacks, false)
+			}
+		case r := <-results:
+			if r.err == nil {
+				return r.conn, nil
+			}
+			if r.primary {
+				primaryErr = r.err
+			} else {
+				fallbackErr = r.err
+			}
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				go dial(fallbacks, false)
+			}
+		}
+	}
+}
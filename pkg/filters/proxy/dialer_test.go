@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDialSpecValidate(t *testing.T) {
+	assertion := assert.New(t)
+
+	spec := &DialSpec{}
+	assertion.NoError(spec.Validate())
+
+	spec = &DialSpec{IPPreference: "ipv6", FallbackDelay: "50ms"}
+	assertion.NoError(spec.Validate())
+
+	spec = &DialSpec{IPPreference: "ipv5"}
+	assertion.Error(spec.Validate())
+
+	spec = &DialSpec{IPPreference: "ipv4", FallbackDelay: "abc"}
+	assertion.Error(spec.Validate())
+
+	spec = &DialSpec{DSCP: 64}
+	assertion.Error(spec.Validate())
+
+	spec = &DialSpec{DSCP: -1}
+	assertion.Error(spec.Validate())
+}
+
+func TestDialerFallback(t *testing.T) {
+	assertion := assert.New(t)
+
+	oldLookup, oldDial := fnLookupIPAddr, fnDialAddr
+	defer func() {
+		fnLookupIPAddr, fnDialAddr = oldLookup, oldDial
+	}()
+
+	fnLookupIPAddr = func(ctx stdcontext.Context, host string) ([]net.IPAddr, error) {
+		return []net.IPAddr{
+			{IP: net.ParseIP("2001:db8::1")},
+			{IP: net.ParseIP("192.0.2.1")},
+		}, nil
+	}
+
+	var lock sync.Mutex
+	var dialed []string
+	fnDialAddr = func(ctx stdcontext.Context, d *net.Dialer, network, address string) (net.Conn, error) {
+		lock.Lock()
+		dialed = append(dialed, address)
+		lock.Unlock()
+
+		// the IPv6 address is a black hole.
+		if address == "[2001:db8::1]:80" {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+
+	// the IPv4 address is dialed after the fallback delay.
+	d := newDialer(&DialSpec{IPPreference: "ipv6", FallbackDelay: "50ms"})
+	start := time.Now()
+	conn, err := d.DialContext(stdcontext.Background(), "tcp", "example.com:80")
+	assertion.NoError(err)
+	assertion.NotNil(conn)
+	conn.Close()
+	assertion.Less(time.Since(start), 5*time.Second)
+	lock.Lock()
+	assertion.Equal([]string{"[2001:db8::1]:80", "192.0.2.1:80"}, dialed)
+	dialed = nil
+	lock.Unlock()
+
+	// the IPv4 address is dialed directly.
+	d = newDialer(&DialSpec{IPPreference: "ipv4"})
+	conn, err = d.DialContext(stdcontext.Background(), "tcp", "example.com:80")
+	assertion.NoError(err)
+	conn.Close()
+	assertion.Equal([]string{"192.0.2.1:80"}, dialed)
+	dialed = nil
+
+	// IP addresses are dialed without lookup.
+	d = newDialer(&DialSpec{IPPreference: "ipv4"})
+	conn, err = d.DialContext(stdcontext.Background(), "tcp", "127.0.0.1:80")
+	assertion.NoError(err)
+	conn.Close()
+	assertion.Equal([]string{"127.0.0.1:80"}, dialed)
+	dialed = nil
+
+	// both families fail.
+	fnDialAddr = func(ctx stdcontext.Context, d *net.Dialer, network, address string) (net.Conn, error) {
+		return nil, fmt.Errorf("connection refused")
+	}
+	d = newDialer(&DialSpec{IPPreference: "ipv6", FallbackDelay: "10s"})
+	_, err = d.DialContext(stdcontext.Background(), "tcp", "example.com:80")
+	assertion.Error(err)
+}
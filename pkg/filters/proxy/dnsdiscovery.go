@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	// DNSRecordTypeA resolves the servers from A/AAAA records.
+	DNSRecordTypeA = "A"
+	// DNSRecordTypeSRV resolves the servers from SRV records.
+	DNSRecordTypeSRV = "SRV"
+
+	defaultDNSRefreshInterval = 30 * time.Second
+	dnsLookupTimeout          = 5 * time.Second
+)
+
+type (
+	// DNSDiscoverySpec describes how to discover the servers of a pool
+	// from DNS records.
+	DNSDiscoverySpec struct {
+		Host            string `yaml:"host" jsonschema:"required"`
+		Type            string `yaml:"type,omitempty" jsonschema:"omitempty,enum=A,enum=SRV"`
+		Port            int    `yaml:"port,omitempty" jsonschema:"omitempty,minimum=1,maximum=65535"`
+		Scheme          string `yaml:"scheme,omitempty" jsonschema:"omitempty,enum=http,enum=https"`
+		RefreshInterval string `yaml:"refreshInterval,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	// dnsResolver is the subset of net.Resolver used by DNS discovery.
+	dnsResolver interface {
+		LookupHost(ctx stdcontext.Context, host string) ([]string, error)
+		LookupSRV(ctx stdcontext.Context, service, proto, name string) (string, []*net.SRV, error)
+	}
+)
+
+// defaultDNSResolver is the resolver used by DNS discovery, it is a
+// variable for testing.
+var defaultDNSResolver dnsResolver = net.DefaultResolver
+
+// Validate validates DNSDiscoverySpec.
+func (spec *DNSDiscoverySpec) Validate() error {
+	if spec.Host == "" {
+		return fmt.Errorf("host of DNS discovery is empty")
+	}
+	return nil
+}
+
+func (spec *DNSDiscoverySpec) recordType() string {
+	if spec.Type == "" {
+		return DNSRecordTypeA
+	}
+	return spec.Type
+}
+
+func (spec *DNSDiscoverySpec) scheme() string {
+	if spec.Scheme == "" {
+		return "http"
+	}
+	return spec.Scheme
+}
+
+func (spec *DNSDiscoverySpec) port() int {
+	if spec.Port > 0 {
+		return spec.Port
+	}
+	if spec.scheme() == "https" {
+		return 443
+	}
+	return 80
+}
+
+func (spec *DNSDiscoverySpec) refreshInterval() time.Duration {
+	if spec.RefreshInterval == "" {
+		return defaultDNSRefreshInterval
+	}
+	d, err := time.ParseDuration(spec.RefreshInterval)
+	if err != nil || d <= 0 {
+		logger.Errorf("BUG: invalid refresh interval %s of DNS discovery", spec.RefreshInterval)
+		return defaultDNSRefreshInterval
+	}
+	return d
+}
+
+// resolve looks up the DNS records and converts them to servers, the
+// servers are sorted by URL.
+func (spec *DNSDiscoverySpec) resolve(resolver dnsResolver) ([]*Server, error) {
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), dnsLookupTimeout)
+	defer cancel()
+
+	var servers []*Server
+	scheme := spec.scheme()
+
+	switch spec.recordType() {
+	case DNSRecordTypeSRV:
+		_, records, err := resolver.LookupSRV(ctx, "", "", spec.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			host := strings.TrimSuffix(r.Target, ".")
+			port := strconv.Itoa(int(r.Port))
+			servers = append(servers, &Server{
+				URL:    scheme + "://" + net.JoinHostPort(host, port),
+				Weight: int(r.Weight),
+			})
+		}
+	default:
+		addrs, err := resolver.LookupHost(ctx, spec.Host)
+		if err != nil {
+			return nil, err
+		}
+		port := strconv.Itoa(spec.port())
+		for _, addr := range addrs {
+			servers = append(servers, &Server{
+				URL: scheme + "://" + net.JoinHostPort(addr, port),
+			})
+		}
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].URL < servers[j].URL
+	})
+
+	// weights of all servers must be set or unset, see ServerPoolSpec.Validate.
+	weighted := 0
+	for _, s := range servers {
+		if s.Weight > 0 {
+			weighted++
+		}
+	}
+	if weighted > 0 && weighted < len(servers) {
+		for _, s := range servers {
+			if s.Weight == 0 {
+				s.Weight = 1
+			}
+		}
+	}
+
+	return servers, nil
+}
+
+// sameServers reports whether the two sorted server lists are the same.
+func sameServers(a, b []*Server) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].URL != b[i].URL || a[i].Weight != b[i].Weight {
+			return false
+		}
+	}
+	return true
+}
+
+// discoverDNS resolves the servers of the pool from DNS and refreshes
+// them periodically. The load balancer is only replaced when the
+// servers change, and the current servers are kept if the lookup fails
+// or returns nothing.
+func (sp *ServerPool) discoverDNS() {
+	spec := sp.spec.DNSDiscovery
+	resolver := defaultDNSResolver
+
+	var current []*Server
+	refresh := func() {
+		servers, err := spec.resolve(resolver)
+		if err != nil {
+			logger.Warnf("%s: resolve %s record of %s failed: %v", sp.name, spec.recordType(), spec.Host, err)
+			return
+		}
+		if len(servers) == 0 {
+			logger.Warnf("%s: no %s record found for %s", sp.name, spec.recordType(), spec.Host)
+			return
+		}
+		if sameServers(current, servers) {
+			return
+		}
+		logger.Infof("%s: servers of %s changed to %v", sp.name, spec.Host, servers)
+		current = servers
+		sp.createLoadBalancer(servers)
+	}
+
+	sp.createLoadBalancer(sp.spec.Servers)
+	refresh()
+
+	ticker := time.NewTicker(spec.refreshInterval())
+	sp.wg.Add(1)
+	go func() {
+		defer sp.wg.Done()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sp.done:
+				return
+			case <-ticker.C:
+				refresh()
+			}
+		}
+	}()
+}
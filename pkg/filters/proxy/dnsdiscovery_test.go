@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubResolver struct {
+	sync.Mutex
+	hosts []string
+	srvs  []*net.SRV
+	err   error
+}
+
+func (r *stubResolver) LookupHost(ctx stdcontext.Context, host string) ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.hosts...), r.err
+}
+
+func (r *stubResolver) LookupSRV(ctx stdcontext.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.Lock()
+	defer r.Unlock()
+	return "", append([]*net.SRV(nil), r.srvs...), r.err
+}
+
+func (r *stubResolver) set(hosts []string, srvs []*net.SRV, err error) {
+	r.Lock()
+	r.hosts, r.srvs, r.err = hosts, srvs, err
+	r.Unlock()
+}
+
+func poolServerURLs(sp *ServerPool) []string {
+	var urls []string
+	switch lb := sp.LoadBalancer().(type) {
+	case *roundRobinLoadBalancer:
+		for _, s := range lb.Servers {
+			urls = append(urls, s.URL)
+		}
+	case *WeightedRandomLoadBalancer:
+		for _, s := range lb.Servers {
+			urls = append(urls, s.URL)
+		}
+	}
+	return urls
+}
+
+func TestDNSDiscoverySpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &DNSDiscoverySpec{}
+	assert.Error(spec.Validate())
+	spec.Host = "backend.default.svc"
+	assert.NoError(spec.Validate())
+	assert.Equal(DNSRecordTypeA, spec.recordType())
+	assert.Equal(80, spec.port())
+	assert.Equal(defaultDNSRefreshInterval, spec.refreshInterval())
+
+	spec.Scheme = "https"
+	assert.Equal(443, spec.port())
+
+	resolver := &stubResolver{}
+	resolver.set([]string{"10.0.0.2", "10.0.0.1", "fd00::1"}, nil, nil)
+	servers, err := spec.resolve(resolver)
+	assert.NoError(err)
+	assert.Equal("https://10.0.0.1:443", servers[0].URL)
+	assert.Equal("https://10.0.0.2:443", servers[1].URL)
+	assert.Equal("https://[fd00::1]:443", servers[2].URL)
+
+	spec.Type = DNSRecordTypeSRV
+	spec.Scheme = ""
+	resolver.set(nil, []*net.SRV{
+		{Target: "b.backend.default.svc.", Port: 8080, Weight: 10},
+		{Target: "a.backend.default.svc.", Port: 8080},
+	}, nil)
+	servers, err = spec.resolve(resolver)
+	assert.NoError(err)
+	assert.Equal("http://a.backend.default.svc:8080", servers[0].URL)
+	assert.Equal(1, servers[0].Weight)
+	assert.Equal("http://b.backend.default.svc:8080", servers[1].URL)
+	assert.Equal(10, servers[1].Weight)
+
+	resolver.set(nil, nil, fmt.Errorf("no such host"))
+	_, err = spec.resolve(resolver)
+	assert.Error(err)
+
+	sps := &ServerPoolSpec{DNSDiscovery: &DNSDiscoverySpec{}}
+	assert.Error(sps.Validate())
+	sps.DNSDiscovery.Host = "backend.default.svc"
+	assert.NoError(sps.Validate())
+	sps.ServiceName = "backend"
+	assert.Error(sps.Validate())
+}
+
+func TestServerPoolDNSDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	srv1 := &net.SRV{Target: "backend1.default.svc.", Port: 8080}
+	srv2 := &net.SRV{Target: "backend2.default.svc.", Port: 8080}
+
+	resolver := &stubResolver{}
+	resolver.set(nil, []*net.SRV{srv1}, nil)
+	defaultDNSResolver = resolver
+	defer func() {
+		defaultDNSResolver = net.DefaultResolver
+	}()
+
+	// respond with the host of the server, so the test doesn't depend
+	// on real backends.
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"X-Server": []string{r.URL.Host}},
+			Body:       http.NoBody,
+		}, nil
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- dnsDiscovery:
+    host: backend.default.svc
+    type: SRV
+    refreshInterval: 10ms
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+	sp := proxy.mainPool
+
+	assert.Equal([]string{"http://backend1.default.svc:8080"}, poolServerURLs(sp))
+
+	// send requests concurrently while the records are changing.
+	done := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", nil)
+				ctx := getCtx(stdr)
+				assert.Equal("", proxy.Handle(ctx))
+			}
+		}()
+	}
+
+	resolver.set(nil, []*net.SRV{srv1, srv2}, nil)
+	assert.Eventually(func() bool {
+		return len(poolServerURLs(sp)) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// lookup failures and empty results keep the current servers.
+	resolver.set(nil, nil, fmt.Errorf("no such host"))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(poolServerURLs(sp), 2)
+	resolver.set(nil, []*net.SRV{}, nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(poolServerURLs(sp), 2)
+
+	resolver.set(nil, []*net.SRV{srv2}, nil)
+	assert.Eventually(func() bool {
+		urls := poolServerURLs(sp)
+		return len(urls) == 1 && urls[0] == "http://backend2.default.svc:8080"
+	}, time.Second, 10*time.Millisecond)
+
+	close(done)
+	wg.Wait()
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", nil)
+	ctx := getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal("backend2.default.svc:8080", resp.Std().Header.Get("X-Server"))
+}
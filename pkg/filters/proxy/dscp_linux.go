@@ -0,0 +1,52 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dscpSupported reports whether setting DSCP is supported.
+const dscpSupported = true
+
+// dscpControl returns the control function of net.Dialer to set the DSCP
+// of connections, which is the upper six bits of the IPv4 TOS or the IPv6
+// traffic class.
+func dscpControl(dscp int) func(network, address string, c syscall.RawConn) error {
+	tos := dscp << 2
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			switch network {
+			case "tcp6", "udp6":
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+			default:
+				err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+			}
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
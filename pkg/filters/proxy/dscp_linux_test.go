@@ -0,0 +1,65 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestDialDSCP(t *testing.T) {
+	assert := assert.New(t)
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(err)
+	defer l.Close()
+
+	getTOS := func(conn net.Conn) int {
+		rc, err := conn.(syscall.Conn).SyscallConn()
+		assert.NoError(err)
+		var tos int
+		rc.Control(func(fd uintptr) {
+			tos, err = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+		})
+		assert.NoError(err)
+		return tos
+	}
+
+	spec := &DialSpec{DSCP: 46}
+	assert.NoError(spec.Validate())
+
+	d := newDialer(spec)
+	conn, err := d.DialContext(stdcontext.Background(), "tcp", l.Addr().String())
+	assert.NoError(err)
+	defer conn.Close()
+	assert.Equal(46<<2, getTOS(conn))
+
+	d = newDialer(&DialSpec{})
+	conn2, err := d.DialContext(stdcontext.Background(), "tcp", l.Addr().String())
+	assert.NoError(err)
+	defer conn2.Close()
+	assert.Equal(0, getTOS(conn2))
+}
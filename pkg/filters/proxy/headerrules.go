@@ -0,0 +1,128 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters/builder"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+)
+
+type (
+	// HeaderRulesSpec describes the rules to adapt the headers of the
+	// requests sent to, or the responses received from the backend. The
+	// values of set and add are templates, executed with the same data as
+	// the builder filters.
+	HeaderRulesSpec struct {
+		httpheader.AdaptSpec `yaml:",inline"`
+		LeftDelim            string `yaml:"leftDelim" jsonschema:"omitempty"`
+		RightDelim           string `yaml:"rightDelim" jsonschema:"omitempty"`
+	}
+
+	headerRules struct {
+		del []string
+		set map[string]*template.Template
+		add map[string]*template.Template
+		// dynamic is true if any of the values is not a plain string.
+		dynamic bool
+	}
+)
+
+// Validate validates HeaderRulesSpec.
+func (spec *HeaderRulesSpec) Validate() error {
+	_, err := newHeaderRules(spec)
+	return err
+}
+
+func newHeaderRules(spec *HeaderRulesSpec) (*headerRules, error) {
+	hr := &headerRules{
+		del: spec.Del,
+		set: map[string]*template.Template{},
+		add: map[string]*template.Template{},
+	}
+
+	leftDelim := spec.LeftDelim
+	if leftDelim == "" {
+		leftDelim = "{{"
+	}
+
+	parse := func(rules map[string]string, templates map[string]*template.Template) error {
+		for key, value := range rules {
+			t, err := builder.NewTemplate(spec.LeftDelim, spec.RightDelim, value)
+			if err != nil {
+				return fmt.Errorf("invalid value of header %s: %v", key, err)
+			}
+			templates[key] = t
+			if strings.Contains(value, leftDelim) {
+				hr.dynamic = true
+			}
+		}
+		return nil
+	}
+
+	if err := parse(spec.Set, hr.set); err != nil {
+		return nil, err
+	}
+	if err := parse(spec.Add, hr.add); err != nil {
+		return nil, err
+	}
+	return hr, nil
+}
+
+// apply applies the rules to h, ctx provides the data of the templates.
+func (hr *headerRules) apply(ctx *context.Context, h http.Header) error {
+	var data map[string]interface{}
+	if hr.dynamic {
+		var err error
+		if data, err = builder.PrepareData(ctx); err != nil {
+			return err
+		}
+	}
+
+	render := func(t *template.Template) (string, error) {
+		var sb strings.Builder
+		if err := t.Execute(&sb, data); err != nil {
+			return "", err
+		}
+		return sb.String(), nil
+	}
+
+	for _, key := range hr.del {
+		h.Del(key)
+	}
+	for key, t := range hr.set {
+		value, err := render(t)
+		if err != nil {
+			return fmt.Errorf("render header %s: %v", key, err)
+		}
+		h.Set(key, value)
+	}
+	for key, t := range hr.add {
+		value, err := render(t)
+		if err != nil {
+			return fmt.Errorf("render header %s: %v", key, err)
+		}
+		h.Add(key, value)
+	}
+	return nil
+}
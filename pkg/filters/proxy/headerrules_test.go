@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderRulesSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HeaderRulesSpec{}
+	spec.Set = map[string]string{"X-User": "{{ .requests.DEFAULT.Header.Get \"X-Id\" }}"}
+	assert.NoError(spec.Validate())
+
+	spec.Add = map[string]string{"X-Bad": "{{ .requests.DEFAULT"}
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolHeaderRules(t *testing.T) {
+	assert := assert.New(t)
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Server", "backend")
+		w.Header().Set("X-Version", "1")
+		w.Header().Add("X-Tag", "a")
+	}))
+	defer server.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + server.URL + `
+  requestHeaders:
+    del: ["Cookie"]
+    set:
+      X-Internal-Auth: secret
+      X-User: '{{ .requests.DEFAULT.Header.Get "X-Id" }}'
+    add:
+      X-Forwarded-Proto: http
+  responseHeaders:
+    del: ["Server"]
+    set:
+      X-Version: "2"
+      X-Status: "{{ .responses.DEFAULT.StatusCode }}"
+    add:
+      X-Tag: b
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+	stdr.Header.Set("Cookie", "a=b")
+	stdr.Header.Set("X-Id", "megaease")
+	stdr.Header.Set("X-Forwarded-Proto", "https")
+	ctx := getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+
+	// request headers
+	assert.Empty(received.Get("Cookie"))
+	assert.Equal("secret", received.Get("X-Internal-Auth"))
+	assert.Equal("megaease", received.Get("X-User"))
+	assert.Equal([]string{"https", "http"}, received.Values("X-Forwarded-Proto"))
+
+	// the inbound request is not changed.
+	assert.Equal("a=b", stdr.Header.Get("Cookie"))
+
+	// response headers
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	h := resp.HTTPHeader()
+	assert.Empty(h.Get("Server"))
+	assert.Equal("2", h.Get("X-Version"))
+	assert.Equal("200", h.Get("X-Status"))
+	assert.Equal([]string{"a", "b"}, h.Values("X-Tag"))
+}
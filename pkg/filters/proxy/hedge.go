@@ -0,0 +1,322 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+)
+
+const (
+	// hedgeSampleSize is the number of latest latencies used to
+	// calculate the percentile delay.
+	hedgeSampleSize = 1000
+	// hedgeMinSamples is the minimum number of latencies required
+	// before the percentile delay is used.
+	hedgeMinSamples = 20
+	// hedgeRefreshInterval is the interval to recalculate the
+	// percentile delay.
+	hedgeRefreshInterval = time.Second
+)
+
+type (
+	// HedgeSpec describes the hedging of requests. If the first attempt
+	// doesn't respond within the delay, another attempt is sent to a
+	// different server, the first response wins and the others are
+	// cancelled.
+	HedgeSpec struct {
+		Delay            string   `yaml:"delay" jsonschema:"omitempty,format=duration"`
+		Percentile       float64  `yaml:"percentile" jsonschema:"omitempty,minimum=0,maximum=100"`
+		MaxExtraAttempts int      `yaml:"maxExtraAttempts,omitempty" jsonschema:"omitempty,minimum=1"`
+		Methods          []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+	}
+
+	hedger struct {
+		spec             *HedgeSpec
+		delay            time.Duration
+		maxExtraAttempts int
+		methods          map[string]struct{}
+
+		mutex           sync.Mutex
+		samples         []time.Duration
+		next            int
+		lastRefresh     time.Time
+		percentileDelay time.Duration
+	}
+
+	// cancelOnCloseBody cancels the context of the request when the
+	// response body is closed.
+	cancelOnCloseBody struct {
+		io.ReadCloser
+		cancel stdcontext.CancelFunc
+	}
+
+	hedgeResult struct {
+		index  int
+		stdReq *http.Request
+		resp   *http.Response
+		err    error
+	}
+)
+
+// Validate validates HedgeSpec.
+func (spec *HedgeSpec) Validate() error {
+	if spec.Delay == "" && spec.Percentile == 0 {
+		return fmt.Errorf("one of delay and percentile must be specified")
+	}
+	return nil
+}
+
+func newHedger(spec *HedgeSpec) *hedger {
+	h := &hedger{
+		spec:             spec,
+		maxExtraAttempts: spec.MaxExtraAttempts,
+		methods:          map[string]struct{}{},
+	}
+
+	if spec.Delay != "" {
+		h.delay, _ = time.ParseDuration(spec.Delay)
+	}
+	if h.maxExtraAttempts <= 0 {
+		h.maxExtraAttempts = 1
+	}
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	for _, m := range methods {
+		h.methods[m] = struct{}{}
+	}
+
+	return h
+}
+
+// match returns whether the request could be hedged, stream requests
+// are never hedged as their body can only be read once.
+func (h *hedger) match(req *httpprot.Request) bool {
+	if req.IsStream() {
+		return false
+	}
+	_, ok := h.methods[req.Method()]
+	return ok
+}
+
+// observe records the latency of a successful attempt.
+func (h *hedger) observe(d time.Duration) {
+	if h.spec.Percentile == 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	if len(h.samples) < hedgeSampleSize {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+		h.next = (h.next + 1) % hedgeSampleSize
+	}
+	h.mutex.Unlock()
+}
+
+// hedgeDelay returns the delay before sending the next attempt, zero
+// means no more attempts should be sent. When percentile is specified,
+// the delay is the percentile of the latest latencies, and the
+// configured delay is used until there are enough samples.
+func (h *hedger) hedgeDelay() time.Duration {
+	if h.spec.Percentile == 0 {
+		return h.delay
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := fasttime.Now()
+	if now.Sub(h.lastRefresh) < hedgeRefreshInterval {
+		return h.percentileDelay
+	}
+	h.lastRefresh = now
+
+	if len(h.samples) < hedgeMinSamples {
+		h.percentileDelay = h.delay
+		return h.percentileDelay
+	}
+
+	samples := make([]time.Duration, len(h.samples))
+	copy(samples, h.samples)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	idx := int(float64(len(samples)-1) * h.spec.Percentile / 100)
+	h.percentileDelay = samples[idx]
+	return h.percentileDelay
+}
+
+// chooseServer chooses a server for the next attempt, it tries to avoid
+// the servers already used by previous attempts.
+func (h *hedger) chooseServer(lb LoadBalancer, req *httpprot.Request, used []*Server) *Server {
+	var svr *Server
+	for i := 0; i <= len(used); i++ {
+		svr = lb.ChooseServer(req)
+		if svr == nil {
+			return nil
+		}
+		inUse := false
+		for _, u := range used {
+			if u == svr {
+				inUse = true
+				break
+			}
+		}
+		if !inUse {
+			return svr
+		}
+	}
+	return svr
+}
+
+// Close implements io.Closer.
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// sendHedgedRequest sends the request to the backend, and sends extra
+// attempts to other servers if there's no response within the hedge
+// delay. The first successful response is returned, and the other
+// attempts are cancelled.
+func (sp *ServerPool) sendHedgedRequest(stdctx stdcontext.Context, spCtx *serverPoolContext) (*http.Response, error) {
+	h := sp.hedger
+	lb := sp.LoadBalancer()
+	maxAttempts := 1 + h.maxExtraAttempts
+
+	// the channel is large enough for all attempts, so that the senders
+	// never block.
+	results := make(chan *hedgeResult, maxAttempts)
+	cancels := make([]stdcontext.CancelFunc, 0, maxAttempts)
+	used := make([]*Server, 0, maxAttempts)
+	inflight := 0
+
+	send := func() error {
+		svr := h.chooseServer(lb, spCtx.req, used)
+		if svr == nil {
+			logger.Debugf("%s: no available server", sp.name)
+			return serverPoolError{http.StatusServiceUnavailable, resultInternalError}
+		}
+
+		ss := sp.getServerStat(svr.URL)
+		tracker := &connTracker{stat: ss}
+		ctx, cancel := stdcontext.WithCancel(tracker.withTrace(stdctx))
+		if err := spCtx.prepareRequest(svr, ctx, false); err != nil {
+			cancel()
+			logger.Debugf("%s: failed to prepare request: %v", sp.name, err)
+			return serverPoolError{http.StatusInternalServerError, resultInternalError}
+		}
+
+		index, stdReq := len(cancels), spCtx.stdReq
+		cancels = append(cancels, cancel)
+		used = append(used, svr)
+		inflight++
+
+		if index > 0 {
+			spCtx.LazyAddTag(func() string {
+				return fmt.Sprintf("hedged attempt %d to %s", index, svr.URL)
+			})
+		}
+
+		go func() {
+			start := fasttime.Now()
+			resp, err := sp.sendToServer(ss, tracker, stdReq)
+			if err == nil {
+				h.observe(fasttime.Since(start))
+			}
+			results <- &hedgeResult{index: index, stdReq: stdReq, resp: resp, err: err}
+		}()
+		return nil
+	}
+
+	if err := send(); err != nil {
+		return nil, err
+	}
+
+	var timer <-chan time.Time
+	if delay := h.hedgeDelay(); delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case <-timer:
+			timer = nil
+			if len(cancels) >= maxAttempts {
+				break
+			}
+			// failing to send an extra attempt is not fatal, the
+			// in-flight attempts are still there.
+			if send() == nil && len(cancels) < maxAttempts {
+				if delay := h.hedgeDelay(); delay > 0 {
+					t := time.NewTimer(delay)
+					defer t.Stop()
+					timer = t.C
+				}
+			}
+
+		case r := <-results:
+			inflight--
+			if r.err != nil {
+				cancels[r.index]()
+				lastErr = r.err
+				continue
+			}
+
+			// cancel the losers and release their responses.
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			go func(n int) {
+				for i := 0; i < n; i++ {
+					if loser := <-results; loser.resp != nil {
+						loser.resp.Body.Close()
+					}
+				}
+			}(inflight)
+
+			// the context of the winner is cancelled once its body
+			// is closed.
+			r.resp.Body = &cancelOnCloseBody{ReadCloser: r.resp.Body, cancel: cancels[r.index]}
+			spCtx.stdReq = r.stdReq
+			spCtx.tagServer(used[r.index])
+			return r.resp, nil
+		}
+	}
+
+	logger.Debugf("%s: failed to send request: %v", sp.name, lastErr)
+	return nil, requestError(stdctx)
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedgeSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &HedgeSpec{}
+	assert.Error(spec.Validate())
+
+	spec.Delay = "10ms"
+	assert.NoError(spec.Validate())
+
+	h := newHedger(spec)
+	assert.Equal(1, h.maxExtraAttempts)
+	assert.Equal(10*time.Millisecond, h.hedgeDelay())
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	assert.True(h.match(req))
+	stdr.Method = http.MethodPost
+	assert.False(h.match(req))
+}
+
+func TestHedgePercentile(t *testing.T) {
+	assert := assert.New(t)
+
+	h := newHedger(&HedgeSpec{Delay: "50ms", Percentile: 90})
+
+	// the delay is used before there are enough samples.
+	assert.Equal(50*time.Millisecond, h.hedgeDelay())
+
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	h.lastRefresh = time.Time{}
+	assert.Equal(90*time.Millisecond, h.hedgeDelay())
+}
+
+func TestHedgeRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	var slowCancelled, fastCount int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			atomic.AddInt32(&slowCancelled, 1)
+			return
+		case <-time.After(2 * time.Second):
+		}
+		io.WriteString(w, "slow")
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fastCount, 1)
+		io.WriteString(w, "fast")
+	}))
+	defer fast.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + slow.URL + `
+  - url: ` + fast.URL + `
+  loadBalance:
+    policy: roundRobin
+  hedge:
+    delay: 50ms
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	// the first attempt goes to the slow server, the hedged one goes
+	// to the fast server and wins.
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+	ctx := getCtx(stdr)
+	start := time.Now()
+	assert.Equal("", proxy.Handle(ctx))
+	assert.Less(time.Since(start), time.Second)
+
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusOK, resp.StatusCode())
+	assert.Equal("fast", string(resp.RawPayload()))
+	assert.Equal(int32(1), atomic.LoadInt32(&fastCount))
+
+	// the loser is cancelled.
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(int32(1), atomic.LoadInt32(&slowCancelled))
+
+	// requests with methods not in the allowlist are never hedged.
+	stdr, _ = http.NewRequest(http.MethodPost, "http://megaease.com/", nil)
+	ctx = getCtx(stdr)
+	assert.Equal("", proxy.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal("slow", string(resp.RawPayload()))
+	assert.Equal(int32(1), atomic.LoadInt32(&fastCount))
+}
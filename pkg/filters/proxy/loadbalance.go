@@ -21,6 +21,8 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math/rand"
+	"sort"
+	"strconv"
 	"sync/atomic"
 
 	"github.com/megaease/easegress/pkg/logger"
@@ -38,6 +40,10 @@ const (
 	LoadBalancePolicyIPHash = "ipHash"
 	// LoadBalancePolicyHeaderHash is the load balance policy of HTTP header hash.
 	LoadBalancePolicyHeaderHash = "headerHash"
+
+	// hashRingReplicas is the number of virtual nodes of a server in
+	// the consistent hash ring.
+	hashRingReplicas = 160
 )
 
 // LoadBalancer is the interface of an HTTP load balancer.
@@ -49,6 +55,11 @@ type LoadBalancer interface {
 type LoadBalanceSpec struct {
 	Policy        string `yaml:"policy" jsonschema:"omitempty,enum=,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash"`
 	HeaderHashKey string `yaml:"headerHashKey" jsonschema:"omitempty"`
+	// HeaderHashFallback is the policy used when the header of
+	// headerHashKey is absent, default is roundRobin.
+	HeaderHashFallback string `yaml:"headerHashFallback,omitempty" jsonschema:"omitempty,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash"`
+	// SlowStart ramps the traffic to servers newly added to the pool.
+	SlowStart *SlowStartSpec `yaml:"slowStart,omitempty" jsonschema:"omitempty"`
 }
 
 // NewLoadBalancer creates a load balancer for servers according to spec.
@@ -63,7 +74,8 @@ func NewLoadBalancer(spec *LoadBalanceSpec, servers []*Server) LoadBalancer {
 	case LoadBalancePolicyIPHash:
 		return newIPHashLoadBalancer(servers)
 	case LoadBalancePolicyHeaderHash:
-		return newHeaderHashLoadBalancer(servers, spec.HeaderHashKey)
+		fallback := NewLoadBalancer(&LoadBalanceSpec{Policy: spec.HeaderHashFallback}, servers)
+		return newHeaderHashLoadBalancer(servers, spec.HeaderHashKey, fallback)
 	default:
 		logger.Errorf("unsupported load balancing policy: %s", spec.Policy)
 		return newRoundRobinLoadBalancer(servers)
@@ -178,18 +190,58 @@ func (lb *ipHashLoadBalancer) ChooseServer(req *httpprot.Request) *Server {
 	return lb.Servers[hash.Sum32()%uint32(len(lb.Servers))]
 }
 
-// headerHashLoadBalancer does load balancing based on header hash.
+// headerHashLoadBalancer does load balancing based on header hash, the
+// header value is hashed into a consistent hash ring, so that adding or
+// removing a server only remaps the keys of that server. Requests without
+// the header are handled by the fallback load balancer.
 type headerHashLoadBalancer struct {
 	BaseLoadBalancer
-	key string
+	key      string
+	fallback LoadBalancer
+	ring     []hashRingNode
+}
+
+type hashRingNode struct {
+	hash   uint64
+	server *Server
 }
 
-func newHeaderHashLoadBalancer(servers []*Server, key string) *headerHashLoadBalancer {
+// hash64 hashes s for the consistent hash ring, FNV-1a doesn't spread
+// similar strings well in the high bits, so the result is mixed with
+// the finalizer of MurmurHash3.
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func newHeaderHashLoadBalancer(servers []*Server, key string, fallback LoadBalancer) *headerHashLoadBalancer {
+	ring := make([]hashRingNode, 0, len(servers)*hashRingReplicas)
+	for _, svr := range servers {
+		for i := 0; i < hashRingReplicas; i++ {
+			ring = append(ring, hashRingNode{
+				hash:   hash64(svr.URL + "#" + strconv.Itoa(i)),
+				server: svr,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+
 	return &headerHashLoadBalancer{
 		BaseLoadBalancer: BaseLoadBalancer{
 			Servers: servers,
 		},
-		key: key,
+		key:      key,
+		fallback: fallback,
+		ring:     ring,
 	}
 }
 
@@ -199,7 +251,16 @@ func (lb *headerHashLoadBalancer) ChooseServer(req *httpprot.Request) *Server {
 		return nil
 	}
 	v := req.HTTPHeader().Get(lb.key)
-	hash := fnv.New32()
-	hash.Write([]byte(v))
-	return lb.Servers[hash.Sum32()%uint32(len(lb.Servers))]
+	if v == "" {
+		return lb.fallback.ChooseServer(req)
+	}
+
+	h := hash64(v)
+	i := sort.Search(len(lb.ring), func(i int) bool {
+		return lb.ring[i].hash >= h
+	})
+	if i == len(lb.ring) {
+		i = 0
+	}
+	return lb.ring[i].server
 }
@@ -30,7 +30,10 @@ import (
 func prepareServers(count int) []*Server {
 	svrs := make([]*Server, 0, count)
 	for i := 0; i < count; i++ {
-		svrs = append(svrs, &Server{Weight: i + 1})
+		svrs = append(svrs, &Server{
+			URL:    fmt.Sprintf("http://192.168.0.%d", i+1),
+			Weight: i + 1,
+		})
 	}
 	return svrs
 }
@@ -161,3 +164,84 @@ func TestHeaderHashLoadBalancer(t *testing.T) {
 		assert.GreaterOrEqual(counter[i], 1)
 	}
 }
+
+func TestHeaderHashLoadBalancerConsistency(t *testing.T) {
+	assert := assert.New(t)
+
+	newRequest := func(v string) *httpprot.Request {
+		req := &http.Request{Header: http.Header{}}
+		if v != "" {
+			req.Header.Add("X-User-Id", v)
+		}
+		r, _ := httpprot.NewRequest(req)
+		return r
+	}
+	spec := &LoadBalanceSpec{
+		Policy:        "headerHash",
+		HeaderHashKey: "X-User-Id",
+	}
+	chooseAll := func(lb LoadBalancer) map[string]*Server {
+		result := map[string]*Server{}
+		for i := 0; i < 1000; i++ {
+			v := fmt.Sprintf("user-%d", i)
+			result[v] = lb.ChooseServer(newRequest(v))
+		}
+		return result
+	}
+
+	svrs := prepareServers(10)
+	lb := NewLoadBalancer(spec, svrs)
+
+	// same header, same server.
+	before := chooseAll(lb)
+	for v, svr := range chooseAll(lb) {
+		assert.Same(before[v], svr)
+	}
+
+	// removing a server only remaps the keys of the removed server.
+	removed := svrs[3]
+	lb = NewLoadBalancer(spec, append(append([]*Server{}, svrs[:3]...), svrs[4:]...))
+	moved := 0
+	for v, svr := range chooseAll(lb) {
+		if before[v] == removed {
+			assert.NotSame(removed, svr)
+			moved++
+		} else {
+			assert.Same(before[v], svr)
+		}
+	}
+	assert.Greater(moved, 0)
+	assert.Less(moved, 300)
+
+	// adding a server only moves keys to the new server.
+	added := &Server{URL: "http://192.168.0.100", Weight: 11}
+	lb = NewLoadBalancer(spec, append(append([]*Server{}, svrs...), added))
+	moved = 0
+	for v, svr := range chooseAll(lb) {
+		if svr != before[v] {
+			assert.Same(added, svr)
+			moved++
+		}
+	}
+	assert.Greater(moved, 0)
+	assert.Less(moved, 300)
+
+	// requests without the header fall back to round robin by default.
+	counter := [10]int{}
+	for i := 0; i < 100; i++ {
+		svr := lb.ChooseServer(newRequest(""))
+		if svr != added {
+			counter[svr.Weight-1]++
+		}
+	}
+	for i := 0; i < 10; i++ {
+		assert.GreaterOrEqual(counter[i], 1)
+	}
+
+	spec.HeaderHashFallback = LoadBalancePolicyIPHash
+	lb = NewLoadBalancer(spec, svrs)
+	svr := lb.ChooseServer(newRequest(""))
+	for i := 0; i < 10; i++ {
+		assert.Same(svr, lb.ChooseServer(newRequest("")))
+	}
+}
@@ -18,11 +18,15 @@
 package proxy
 
 import (
+	"bytes"
 	stdcontext "context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/textproto"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -75,6 +79,55 @@ type serverPoolContext struct {
 	stdReq  *http.Request
 	resp    *httpprot.Response
 	stdResp *http.Response
+
+	// preserveHost and hostRewrite are the Host options of the pool.
+	preserveHost bool
+	hostRewrite  string
+
+	requestHeaders *headerRules
+
+	// connectFailed is true if the last attempt failed to connect to
+	// the backend server.
+	connectFailed bool
+
+	// backendStart and backendDuration are the start time and duration
+	// of the last attempt to the backend, cacheHit is true if the
+	// response is built from the cache. They are for the Server-Timing
+	// header.
+	backendStart    time.Time
+	backendDuration time.Duration
+	cacheHit        bool
+}
+
+// tagServer records the server the request is sent to in the span.
+func (spCtx *serverPoolContext) tagServer(svr *Server) {
+	if spCtx.span != nil {
+		spCtx.span.Tag("proxy.server", svr.URL)
+	}
+}
+
+// captureHTTP records the request sent to the backend and its response
+// in the span, the bodies are not consumed.
+func (spCtx *serverPoolContext) captureHTTP() {
+	if spCtx.stdReq != nil {
+		body := []byte{}
+		if spCtx.req.IsStream() {
+			body = nil
+		} else if p := spCtx.req.RawPayload(); p != nil {
+			body = p
+		}
+		spCtx.span.CaptureHTTPRequest(spCtx.stdReq.Header, body)
+	}
+
+	if spCtx.resp != nil {
+		body := []byte{}
+		if spCtx.resp.IsStream() {
+			body = nil
+		} else if p := spCtx.resp.RawPayload(); p != nil {
+			body = p
+		}
+		spCtx.span.CaptureHTTPResponse(spCtx.resp.HTTPHeader(), body)
+	}
 }
 
 // Hop-by-hop headers. These are removed when sent to the backend.
@@ -147,12 +200,21 @@ func (spCtx *serverPoolContext) prepareRequest(svr *Server, ctx stdcontext.Conte
 
 	stdr.Header = cloneHeader(req.HTTPHeader())
 
-	// only set host when server address is not host name OR
-	// server is explicitly told to keep the host of the request.
-	if !svr.addrIsHostName || svr.KeepHost {
+	// the options of the pool take precedence, otherwise, only set host
+	// when server address is not host name OR server is explicitly told
+	// to keep the host of the request.
+	if spCtx.hostRewrite != "" {
+		stdr.Host = spCtx.hostRewrite
+	} else if spCtx.preserveHost || !svr.addrIsHostName || svr.KeepHost {
 		stdr.Host = req.Host()
 	}
 
+	if spCtx.requestHeaders != nil {
+		if err = spCtx.requestHeaders.apply(spCtx.Context, stdr.Header); err != nil {
+			return err
+		}
+	}
+
 	if spCtx.span != nil {
 		spCtx.span.InjectHTTP(stdr)
 	}
@@ -161,6 +223,10 @@ func (spCtx *serverPoolContext) prepareRequest(svr *Server, ctx stdcontext.Conte
 	return nil
 }
 
+// defaultMirrorMaxDrainSize is the default max size of the response body
+// drained in the mirror pool.
+const defaultMirrorMaxDrainSize = 1024 * 1024
+
 // ServerPool defines a server pool.
 type ServerPool struct {
 	proxy        *Proxy
@@ -169,43 +235,127 @@ type ServerPool struct {
 	wg           sync.WaitGroup
 	name         string
 	failureCodes map[int]struct{}
+	client       *http.Client
+
+	filter                  RequestMatcher
+	loadBalancer            atomic.Value
+	timeout                 time.Duration
+	retryWrapper            resilience.Wrapper
+	circuitBreakerWrapper   resilience.Wrapper
+	concurrencyLimitWrapper resilience.Wrapper
 
-	filter                RequestMatcher
-	loadBalancer          atomic.Value
-	timeout               time.Duration
-	retryWrapper          resilience.Wrapper
-	circuitBreakerWrapper resilience.Wrapper
+	httpStat         *httpstat.HTTPStat
+	serverStatsMutex sync.RWMutex
+	serverStats      map[string]*serverStat
+	serverJoined     map[string]time.Time
 
-	httpStat    *httpstat.HTTPStat
 	memoryCache *MemoryCache
+	cache       *ResponseCache
+	hedger      *hedger
+	coalescer   *coalescer
+
+	requestHeaders  *headerRules
+	responseHeaders *headerRules
 }
 
 // ServerPoolSpec is the spec for a server pool.
 type ServerPoolSpec struct {
-	SpanName             string              `yaml:"spanName" jsonschema:"omitempty"`
-	Filter               *RequestMatcherSpec `yaml:"filter" jsonschema:"omitempty"`
-	ServerMaxBodySize    int64               `yaml:"serverMaxBodySize" jsonschema:"omitempty"`
-	ServerTags           []string            `yaml:"serverTags" jsonschema:"omitempty,uniqueItems=true"`
-	Servers              []*Server           `yaml:"servers" jsonschema:"omitempty"`
-	ServiceRegistry      string              `yaml:"serviceRegistry" jsonschema:"omitempty"`
-	ServiceName          string              `yaml:"serviceName" jsonschema:"omitempty"`
-	LoadBalance          *LoadBalanceSpec    `yaml:"loadBalance" jsonschema:"omitempty"`
-	Timeout              string              `yaml:"timeout" jsonschema:"omitempty,format=duration"`
-	RetryPolicy          string              `yaml:"retryPolicy" jsonschema:"omitempty"`
-	CircuitBreakerPolicy string              `yaml:"circuitBreakerPolicy" jsonschema:"omitempty"`
-	FailureCodes         []int               `yaml:"failureCodes" jsonschema:"omitempty"`
-	MemoryCache          *MemoryCacheSpec    `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+	SpanName               string              `yaml:"spanName" jsonschema:"omitempty"`
+	Filter                 *RequestMatcherSpec `yaml:"filter" jsonschema:"omitempty"`
+	ServerMaxBodySize      int64               `yaml:"serverMaxBodySize" jsonschema:"omitempty"`
+	ServerTags             []string            `yaml:"serverTags" jsonschema:"omitempty,uniqueItems=true"`
+	Servers                []*Server           `yaml:"servers" jsonschema:"omitempty"`
+	ServiceRegistry        string              `yaml:"serviceRegistry" jsonschema:"omitempty"`
+	ServiceName            string              `yaml:"serviceName" jsonschema:"omitempty"`
+	DNSDiscovery           *DNSDiscoverySpec   `yaml:"dnsDiscovery,omitempty" jsonschema:"omitempty"`
+	LoadBalance            *LoadBalanceSpec    `yaml:"loadBalance" jsonschema:"omitempty"`
+	Timeout                string              `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	RetryPolicy            string              `yaml:"retryPolicy" jsonschema:"omitempty"`
+	CircuitBreakerPolicy   string              `yaml:"circuitBreakerPolicy" jsonschema:"omitempty"`
+	ConcurrencyLimitPolicy string              `yaml:"concurrencyLimitPolicy" jsonschema:"omitempty"`
+	FailureCodes           []int               `yaml:"failureCodes" jsonschema:"omitempty"`
+	GRPCStatus             bool                `yaml:"grpcStatus" jsonschema:"omitempty"`
+	MemoryCache            *MemoryCacheSpec    `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+	Cache                  *ResponseCacheSpec  `yaml:"cache,omitempty" jsonschema:"omitempty"`
+	Hedge                  *HedgeSpec          `yaml:"hedge,omitempty" jsonschema:"omitempty"`
+	Coalesce               *CoalesceSpec       `yaml:"coalesce,omitempty" jsonschema:"omitempty"`
+	MTLS                   *MTLS               `yaml:"mtls,omitempty" jsonschema:"omitempty"`
+	MaxIdleConns           int                 `yaml:"maxIdleConns" jsonschema:"omitempty"`
+	MaxIdleConnsPerHost    int                 `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty"`
+	PreserveHost           bool                `yaml:"preserveHost" jsonschema:"omitempty"`
+	HostRewrite            string              `yaml:"hostRewrite" jsonschema:"omitempty"`
+	RequestHeaders         *HeaderRulesSpec    `yaml:"requestHeaders,omitempty" jsonschema:"omitempty"`
+	ResponseHeaders        *HeaderRulesSpec    `yaml:"responseHeaders,omitempty" jsonschema:"omitempty"`
+	IdempotencyHeader      string              `yaml:"idempotencyHeader" jsonschema:"omitempty"`
+
+	// MirrorMaxDrainSize and MirrorSkipResponseBody are only for the
+	// mirror pool. The response bodies of the mirror pool are discarded,
+	// at most MirrorMaxDrainSize bytes are drained for the connection to
+	// be reused, or none if MirrorSkipResponseBody is true.
+	MirrorMaxDrainSize     int64 `yaml:"mirrorMaxDrainSize" jsonschema:"omitempty,minimum=0"`
+	MirrorSkipResponseBody bool  `yaml:"mirrorSkipResponseBody" jsonschema:"omitempty"`
 }
 
 // ServerPoolStatus is the status of Pool.
 type ServerPoolStatus struct {
-	Stat *httpstat.Status `yaml:"stat"`
+	Stat     *httpstat.Status     `yaml:"stat"`
+	Cache    *ResponseCacheStatus `yaml:"cache,omitempty"`
+	Coalesce *CoalesceStatus      `yaml:"coalesce,omitempty"`
+	Servers  []*ServerStatus      `yaml:"servers,omitempty"`
+
+	// Resilience is the status of the resilience policies, nil if there's
+	// no resilience policy.
+	Resilience *ResilienceStatus `yaml:"resilience,omitempty"`
+}
+
+// ResilienceStatus is the status of the resilience policies of a pool,
+// the status of a policy is nil if the pool doesn't use it.
+type ResilienceStatus struct {
+	CircuitBreaker   *resilience.CircuitBreakerStatus   `yaml:"circuitBreaker,omitempty" json:"circuitBreaker,omitempty"`
+	Retry            *resilience.RetryStatus            `yaml:"retry,omitempty" json:"retry,omitempty"`
+	ConcurrencyLimit *resilience.ConcurrencyLimitStatus `yaml:"concurrencyLimit,omitempty" json:"concurrencyLimit,omitempty"`
 }
 
 // Validate validates ServerPoolSpec.
 func (sps *ServerPoolSpec) Validate() error {
-	if sps.ServiceName == "" && len(sps.Servers) == 0 {
-		return fmt.Errorf("both serviceName and servers are empty")
+	if sps.ServiceName == "" && sps.DNSDiscovery == nil && len(sps.Servers) == 0 {
+		return fmt.Errorf("serviceName, dnsDiscovery and servers are all empty")
+	}
+
+	if sps.DNSDiscovery != nil {
+		if sps.ServiceName != "" {
+			return fmt.Errorf("serviceName and dnsDiscovery can't be used together")
+		}
+		if err := sps.DNSDiscovery.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if sps.PreserveHost && sps.HostRewrite != "" {
+		return fmt.Errorf("preserveHost and hostRewrite can't be used together")
+	}
+
+	if sps.RequestHeaders != nil {
+		if err := sps.RequestHeaders.Validate(); err != nil {
+			return fmt.Errorf("requestHeaders: %v", err)
+		}
+	}
+	if sps.ResponseHeaders != nil {
+		if err := sps.ResponseHeaders.Validate(); err != nil {
+			return fmt.Errorf("responseHeaders: %v", err)
+		}
+	}
+
+	if sps.Coalesce != nil {
+		if err := sps.Coalesce.Validate(); err != nil {
+			return fmt.Errorf("coalesce: %v", err)
+		}
+	}
+
+	if sps.LoadBalance != nil && sps.LoadBalance.SlowStart != nil {
+		if err := sps.LoadBalance.SlowStart.Validate(); err != nil {
+			return fmt.Errorf("slowStart: %v", err)
+		}
 	}
 
 	serversGotWeight := 0
@@ -225,11 +375,12 @@ func (sps *ServerPoolSpec) Validate() error {
 // NewServerPool creates a new server pool according to spec.
 func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool {
 	sp := &ServerPool{
-		proxy:    proxy,
-		spec:     spec,
-		done:     make(chan struct{}),
-		name:     name,
-		httpStat: httpstat.New(),
+		proxy:       proxy,
+		spec:        spec,
+		done:        make(chan struct{}),
+		name:        name,
+		httpStat:    httpstat.New(),
+		serverStats: map[string]*serverStat{},
 	}
 
 	if spec.Filter != nil {
@@ -240,7 +391,32 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 		sp.memoryCache = NewMemoryCache(spec.MemoryCache)
 	}
 
-	if spec.ServiceRegistry == "" || spec.ServiceName == "" {
+	if spec.Cache != nil {
+		sp.cache = NewResponseCache(spec.Cache)
+	}
+
+	if spec.Hedge != nil {
+		sp.hedger = newHedger(spec.Hedge)
+	}
+
+	if spec.Coalesce != nil {
+		sp.coalescer = newCoalescer(spec.Coalesce, sp.cache)
+	}
+
+	if spec.RequestHeaders != nil {
+		sp.requestHeaders, _ = newHeaderRules(spec.RequestHeaders)
+	}
+	if spec.ResponseHeaders != nil {
+		sp.responseHeaders, _ = newHeaderRules(spec.ResponseHeaders)
+	}
+
+	if spec.MTLS != nil || spec.MaxIdleConns > 0 || spec.MaxIdleConnsPerHost > 0 {
+		sp.client = sp.createClient()
+	}
+
+	if spec.DNSDiscovery != nil {
+		sp.discoverDNS()
+	} else if spec.ServiceRegistry == "" || spec.ServiceName == "" {
 		sp.createLoadBalancer(sp.spec.Servers)
 	} else {
 		sp.watchServers()
@@ -258,6 +434,42 @@ func NewServerPool(proxy *Proxy, spec *ServerPoolSpec, name string) *ServerPool
 	return sp
 }
 
+// createClient creates a dedicated HTTP client for the server pool,
+// settings not specified by the pool are inherited from the proxy.
+func (sp *ServerPool) createClient() *http.Client {
+	mtls := sp.spec.MTLS
+	maxIdleConns := sp.spec.MaxIdleConns
+	maxIdleConnsPerHost := sp.spec.MaxIdleConnsPerHost
+
+	if sp.proxy != nil {
+		if mtls == nil {
+			mtls = sp.proxy.spec.MTLS
+		}
+		if maxIdleConns == 0 {
+			maxIdleConns = sp.proxy.spec.MaxIdleConns
+		}
+		if maxIdleConnsPerHost == 0 {
+			maxIdleConnsPerHost = sp.proxy.spec.MaxIdleConnsPerHost
+		}
+	}
+
+	tlsCfg, _ := newTLSConfig(mtls)
+	var dial *DialSpec
+	if sp.proxy != nil {
+		dial = sp.proxy.spec.Dial
+	}
+	return newHTTPClient(tlsCfg, maxIdleConns, maxIdleConnsPerHost, dial)
+}
+
+// httpClient returns the HTTP client of the server pool, it is the
+// shared client of the proxy if the pool doesn't have a dedicated one.
+func (sp *ServerPool) httpClient() *http.Client {
+	if sp.client != nil {
+		return sp.client
+	}
+	return sp.proxy.client
+}
+
 // LoadBalancer returns the load balancer of the server pool.
 func (sp *ServerPool) LoadBalancer() LoadBalancer {
 	return sp.loadBalancer.Load().(LoadBalancer)
@@ -274,7 +486,12 @@ func (sp *ServerPool) createLoadBalancer(servers []*Server) {
 	}
 
 	lb := NewLoadBalancer(spec, servers)
+	joined := sp.trackServers(servers)
+	if spec.SlowStart != nil {
+		lb = newSlowStartLoadBalancer(spec.SlowStart, lb, joined)
+	}
 	sp.loadBalancer.Store(lb)
+	sp.pruneServerStats(servers)
 }
 
 func (sp *ServerPool) watchServers() {
@@ -332,7 +549,39 @@ func (sp *ServerPool) useService(instances map[string]*serviceregistry.ServiceIn
 }
 
 func (sp *ServerPool) status() *ServerPoolStatus {
-	s := &ServerPoolStatus{Stat: sp.httpStat.Status()}
+	s := &ServerPoolStatus{
+		Stat:    sp.httpStat.Status(),
+		Servers: sp.serverStatuses(),
+	}
+	if sp.cache != nil {
+		s.Cache = sp.cache.Status()
+	}
+	if sp.coalescer != nil {
+		s.Coalesce = sp.coalescer.status()
+	}
+	s.Resilience = sp.resilienceStatus()
+	return s
+}
+
+func (sp *ServerPool) resilienceStatus() *ResilienceStatus {
+	s := &ResilienceStatus{}
+	if cb, ok := sp.circuitBreakerWrapper.(interface {
+		Status() *resilience.CircuitBreakerStatus
+	}); ok {
+		s.CircuitBreaker = cb.Status()
+	}
+	if r, ok := sp.retryWrapper.(interface {
+		Status() *resilience.RetryStatus
+	}); ok {
+		s.Retry = r.Status()
+	}
+	if cl, ok := sp.concurrencyLimitWrapper.(*resilience.ConcurrencyLimiter); ok {
+		s.ConcurrencyLimit = cl.Status()
+	}
+
+	if s.CircuitBreaker == nil && s.Retry == nil && s.ConcurrencyLimit == nil {
+		return nil
+	}
 	return s
 }
 
@@ -363,6 +612,19 @@ func (sp *ServerPool) InjectResiliencePolicy(policies map[string]resilience.Poli
 		}
 		sp.circuitBreakerWrapper = policy.CreateWrapper()
 	}
+
+	name = sp.spec.ConcurrencyLimitPolicy
+	if name != "" {
+		p := policies[name]
+		if p == nil {
+			panic(fmt.Errorf("concurrency limit policy %s not found", name))
+		}
+		policy, ok := p.(*resilience.ConcurrencyLimitPolicy)
+		if !ok {
+			panic(fmt.Errorf("policy %s is not a concurrency limit policy", name))
+		}
+		sp.concurrencyLimitWrapper = policy.CreateWrapper()
+	}
 }
 
 func (sp *ServerPool) collectMetrics(spCtx *serverPoolContext) {
@@ -418,19 +680,33 @@ func (sp *ServerPool) handleMirror(spCtx *serverPoolContext) {
 		return
 	}
 
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
+	resp, err := fnSendRequest(spCtx.stdReq, sp.httpClient())
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
-	io.Copy(io.Discard, resp.Body)
-	resp.Body.Close()
+	if sp.spec.MirrorSkipResponseBody {
+		return
+	}
+
+	// The body is never buffered, and it is drained only up to a limit,
+	// a larger body costs a connection instead of the bandwidth.
+	limit := sp.spec.MirrorMaxDrainSize
+	if limit <= 0 {
+		limit = defaultMirrorMaxDrainSize
+	}
+	io.CopyN(io.Discard, resp.Body, limit)
 }
 
 func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	spCtx := &serverPoolContext{
-		Context: ctx,
-		req:     ctx.GetInputRequest().(*httpprot.Request),
+		Context:      ctx,
+		req:          ctx.GetInputRequest().(*httpprot.Request),
+		preserveHost: sp.spec.PreserveHost,
+		hostRewrite:  sp.spec.HostRewrite,
+
+		requestHeaders: sp.requestHeaders,
 	}
 
 	if mirror {
@@ -440,13 +716,66 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 
 	spCtx.startTime = fasttime.Now()
 	defer sp.collectMetrics(spCtx)
+	if sp.proxy.spec.ServerTiming {
+		defer sp.addServerTiming(spCtx)
+	}
 
 	if sp.buildResponseFromCache(spCtx) {
 		return ""
 	}
 
+	if sp.coalescer != nil {
+		return sp.handleCoalesced(spCtx)
+	}
+	return sp.handleRequest(spCtx)
+}
+
+// handleCoalesced handles the request with request coalescing, only the
+// leader of identical requests is sent to the backend, followers share
+// its response, or send their own if they can't.
+func (sp *ServerPool) handleCoalesced(spCtx *serverPoolContext) string {
+	key, ok := sp.coalescer.key(spCtx.req)
+	if !ok {
+		return sp.handleRequest(spCtx)
+	}
+
+	call, leader := sp.coalescer.join(key)
+	if leader {
+		result := sp.handleRequest(spCtx)
+		if result == "" {
+			sp.coalescer.finish(key, call, spCtx.req, spCtx.resp)
+		} else {
+			sp.coalescer.finish(key, call, spCtx.req, nil)
+		}
+		return result
+	}
+
+	ce := sp.coalescer.wait(spCtx.req, call)
+	if ce == nil {
+		return sp.handleRequest(spCtx)
+	}
+
+	spCtx.AddTag("coalesced")
+	sp.buildResponseFromCacheEntry(spCtx, ce)
+	return ""
+}
+
+// handleRequest sends the request to the backend with the resilience
+// policies.
+func (sp *ServerPool) handleRequest(spCtx *serverPoolContext) string {
+	ctx := spCtx.Context
+
+	// it is impossible to retry a stream request as its body can only
+	// be read once, unless it is buffered by retriable.
+	retry, retriable := sp.retryWrapper != nil, true
+	if retry {
+		retriable = sp.retriable(spCtx.req)
+		retry = !spCtx.req.IsStream()
+	}
+
 	// wrap the handler function to meet the requirement of resilience
 	// wrappers.
+	attempt := 0
 	handler := func(stdctx stdcontext.Context) error {
 		if sp.timeout > 0 {
 			var cancel stdcontext.CancelFunc
@@ -459,36 +788,55 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 		spCtx.stdReq = nil
 		spCtx.resp = nil
 		spCtx.stdResp = nil
+		spCtx.connectFailed = false
 
 		spanName := sp.spec.SpanName
 		if spanName == "" {
 			spanName = sp.name
 		}
+		attempt++
 		spCtx.span = ctx.Span().NewChild(spanName)
 		defer spCtx.span.Finish()
+		spCtx.span.Tag("proxy.attempt", strconv.Itoa(attempt))
 
-		return sp.doHandle(stdctx, spCtx)
+		err := sp.doHandle(stdctx, spCtx)
+		spCtx.captureHTTP()
+		if spCtx.stdResp != nil {
+			spCtx.span.Tag("http.status_code", strconv.Itoa(spCtx.stdResp.StatusCode))
+		}
+		if spe, ok := err.(serverPoolError); ok {
+			spCtx.span.Tag("error", spe.Result())
+		}
+		if err != nil && retry && !retriable && !spCtx.connectFailed {
+			return &resilience.NonRetriableError{Err: err}
+		}
+		return err
 	}
 
-	// resilience wrappers, note that it is impossible to retry a stream
-	// request as its body can only be read once.
-	if sp.retryWrapper != nil && !spCtx.req.IsStream() {
+	// resilience wrappers.
+	if retry {
 		handler = sp.retryWrapper.Wrap(handler)
 	}
 	if sp.circuitBreakerWrapper != nil {
 		handler = sp.circuitBreakerWrapper.Wrap(handler)
 	}
+	if sp.concurrencyLimitWrapper != nil {
+		handler = sp.concurrencyLimitWrapper.Wrap(handler)
+	}
 
-	// call the handler.
+	// call the handler, the context of the request sent to the backend
+	// is derived from the context of the inbound request, so the backend
+	// request is aborted once the client disconnects.
 	err := handler(spCtx.req.Context())
 	if err == nil {
 		return ""
 	}
 
-	// CircuitBreaker is the most outside resiliencer, if the error
-	// is ErrShortCircuited, we are sure the response is nil.
+	// CircuitBreaker and ConcurrencyLimit are the most outside
+	// resiliencers, if the error is ErrShortCircuited, we are sure the
+	// response is nil.
 	if err == resilience.ErrShortCircuited {
-		logger.Debugf("%s: short circuited by circuit break policy", sp.name)
+		logger.Debugf("%s: short circuited by circuit break or concurrency limit policy", sp.name)
 		spCtx.AddTag("short circuited")
 		sp.buildFailureResponse(spCtx, http.StatusServiceUnavailable)
 		return resultShortCircuited
@@ -507,45 +855,143 @@ func (sp *ServerPool) handle(ctx *context.Context, mirror bool) string {
 	panic(fmt.Errorf("should not reach here"))
 }
 
-func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolContext) error {
+// idempotentMethods are the methods safe to retry, see RFC 7231,
+// section 4.2.2.
+var idempotentMethods = map[string]struct{}{
+	http.MethodGet:     {},
+	http.MethodHead:    {},
+	http.MethodOptions: {},
+	http.MethodTrace:   {},
+	http.MethodPut:     {},
+	http.MethodDelete:  {},
+}
+
+// retriable returns whether the request could be retried on any failure,
+// requests not retriable are only retried if they failed to connect to
+// the backend. If the idempotency header is not configured, all requests
+// are retriable, otherwise, requests with non-idempotent methods must
+// carry the header, and their stream body is buffered for replay.
+func (sp *ServerPool) retriable(req *httpprot.Request) bool {
+	if _, ok := idempotentMethods[req.Method()]; ok {
+		return true
+	}
+
+	header := sp.spec.IdempotencyHeader
+	if header == "" {
+		return true
+	}
+	if req.HTTPHeader().Get(header) == "" {
+		return false
+	}
+
+	if req.IsStream() {
+		sp.bufferPayload(req)
+	}
+	return true
+}
+
+// bufferPayload reads the stream body of the request into memory, the
+// body is kept as a stream if it is larger than the max payload size.
+func (sp *ServerPool) bufferPayload(req *httpprot.Request) {
+	stream := req.GetPayload()
+	payload, err := io.ReadAll(io.LimitReader(stream, httpprot.DefaultMaxPayloadSize+1))
+	if err != nil || int64(len(payload)) > httpprot.DefaultMaxPayloadSize {
+		req.SetPayload(io.MultiReader(bytes.NewReader(payload), stream))
+		return
+	}
+	req.SetPayload(payload)
+}
+
+// isConnectError returns whether err is a failure to connect to the
+// backend server.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// requestError returns the error of a failed request according to the
+// state of its context.
+func requestError(ctx stdcontext.Context) error {
+	if err := ctx.Err(); err == nil {
+		return serverPoolError{http.StatusServiceUnavailable, resultServerError}
+	} else if err == stdcontext.DeadlineExceeded {
+		return serverPoolError{http.StatusRequestTimeout, resultTimeout}
+	}
+
+	// NOTE: return 499 if client is Disconnected.
+	// TODO: define a constant for 499
+	return serverPoolError{499, resultClientError}
+}
+
+func (sp *ServerPool) sendRequest(stdctx stdcontext.Context, spCtx *serverPoolContext) (*http.Response, error) {
 	svr := sp.LoadBalancer().ChooseServer(spCtx.req)
 
 	// if there's no available server.
 	if svr == nil {
 		logger.Debugf("%s: no available server", sp.name)
-		return serverPoolError{http.StatusServiceUnavailable, resultInternalError}
+		return nil, serverPoolError{http.StatusServiceUnavailable, resultInternalError}
 	}
 
+	spCtx.tagServer(svr)
+
 	// prepare the request to send.
 	statResult := &gohttpstat.Result{}
 	stdctx = gohttpstat.WithHTTPStat(stdctx, statResult)
+	ss := sp.getServerStat(svr.URL)
+	tracker := &connTracker{stat: ss}
+	stdctx = tracker.withTrace(stdctx)
 	if err := spCtx.prepareRequest(svr, stdctx, false); err != nil {
 		logger.Debugf("%s: failed to prepare request: %v", sp.name, err)
-		return serverPoolError{http.StatusInternalServerError, resultInternalError}
+		return nil, serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
-	resp, err := fnSendRequest(spCtx.stdReq, sp.proxy.client)
+	resp, err := sp.sendToServer(ss, tracker, spCtx.stdReq)
 	if err != nil {
 		logger.Debugf("%s: failed to send request: %v", sp.name, err)
+		spCtx.connectFailed = isConnectError(err)
 
 		statResult.End(fasttime.Now())
 		spCtx.LazyAddTag(func() string {
 			return fmt.Sprintf("trace %v", statResult)
 		})
 
-		if err := spCtx.stdReq.Context().Err(); err == nil {
-			return serverPoolError{http.StatusServiceUnavailable, resultServerError}
-		} else if err == stdcontext.DeadlineExceeded {
-			return serverPoolError{http.StatusRequestTimeout, resultTimeout}
+		// the body of the request could be limited by other filters,
+		// e.g. RequestBodyLimiter.
+		if errors.Is(err, httpprot.ErrRequestEntityTooLarge) {
+			return nil, serverPoolError{http.StatusRequestEntityTooLarge, resultClientError}
 		}
 
-		// NOTE: return 499 if client is Disconnected.
-		// TODO: define a constant for 499
-		return serverPoolError{499, resultClientError}
+		return nil, requestError(spCtx.stdReq.Context())
+	}
+
+	return resp, nil
+}
+
+func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolContext) error {
+	var (
+		resp *http.Response
+		err  error
+	)
+
+	spCtx.backendStart = fasttime.Now()
+	defer func() {
+		spCtx.backendDuration = fasttime.Since(spCtx.backendStart)
+	}()
+
+	if sp.hedger != nil && sp.hedger.match(spCtx.req) {
+		resp, err = sp.sendHedgedRequest(stdctx, spCtx)
+	} else {
+		resp, err = sp.sendRequest(stdctx, spCtx)
+	}
+	if err != nil {
+		return err
 	}
 
 	spCtx.stdResp = resp
 	if err = sp.buildResponse(spCtx); err != nil {
+		if err == httpprot.ErrResponseEntityTooLarge {
+			return serverPoolError{http.StatusBadGateway, resultServerError}
+		}
 		return serverPoolError{http.StatusInternalServerError, resultInternalError}
 	}
 
@@ -562,9 +1008,23 @@ func (sp *ServerPool) doHandle(stdctx stdcontext.Context, spCtx *serverPoolConte
 		return serverPoolError{resp.StatusCode, resultFailureCode}
 	}
 
+	// gRPC reports failures in the grpc-status trailer (or header for
+	// trailers-only responses), the HTTP status code is always 200.
+	if sp.spec.GRPCStatus {
+		if result := grpcStatusResult(spCtx.resp); result != "" {
+			spCtx.LazyAddTag(func() string {
+				return "grpc status: " + grpcStatus(spCtx.resp)
+			})
+			return serverPoolError{resp.StatusCode, result}
+		}
+	}
+
 	if sp.memoryCache != nil {
 		sp.memoryCache.Store(spCtx.req, spCtx.resp)
 	}
+	if sp.cache != nil {
+		sp.cache.Store(spCtx.req, spCtx.resp)
+	}
 
 	return nil
 }
@@ -574,8 +1034,8 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 	spCtx.stdResp.Body = body
 
 	if sp.proxy.compression != nil {
-		if sp.proxy.compression.compress(spCtx.stdReq, spCtx.stdResp) {
-			spCtx.AddTag("gzip")
+		if encoding := sp.proxy.compression.compress(spCtx.stdReq, spCtx.stdResp); encoding != "" {
+			spCtx.AddTag(encoding)
 		}
 	}
 
@@ -586,11 +1046,7 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 		return err
 	}
 
-	maxBodySize := sp.spec.ServerMaxBodySize
-	if maxBodySize == 0 {
-		maxBodySize = sp.proxy.spec.ServerMaxBodySize
-	}
-	if err = resp.FetchPayload(maxBodySize); err != nil {
+	if err = sp.fetchPayload(resp); err != nil {
 		logger.Debugf("%s: failed to fetch response payload: %v", sp.name, err)
 		body.Close()
 		return err
@@ -602,19 +1058,125 @@ func (sp *ServerPool) buildResponse(spCtx *serverPoolContext) (err error) {
 
 	spCtx.resp = resp
 	spCtx.SetOutputResponse(resp)
+
+	// the rules are applied after the response is set, so that their
+	// templates could refer to it.
+	if sp.responseHeaders != nil {
+		if err = sp.responseHeaders.apply(spCtx.Context, resp.Std().Header); err != nil {
+			logger.Debugf("%s: failed to adapt response headers: %v", sp.name, err)
+			body.Close()
+			spCtx.resp = nil
+			return err
+		}
+	}
 	return nil
 }
 
-func (sp *ServerPool) buildResponseFromCache(spCtx *serverPoolContext) bool {
-	if sp.memoryCache == nil {
-		return false
+// grpcStatus returns the gRPC status code of the response, it is empty
+// if the response is a stream whose trailers are not available yet.
+func grpcStatus(resp *httpprot.Response) string {
+	if code := resp.Std().Header.Get("Grpc-Status"); code != "" {
+		return code
+	}
+	if resp.IsStream() || resp.Std().Trailer == nil {
+		return ""
+	}
+	return resp.Std().Trailer.Get("Grpc-Status")
+}
+
+// grpcStatusResult maps the gRPC status code of the response to a result,
+// it returns an empty string if the call succeeded or there is no status.
+// See https://github.com/grpc/grpc/blob/master/doc/statuscodes.md.
+func grpcStatusResult(resp *httpprot.Response) string {
+	code, err := strconv.Atoi(grpcStatus(resp))
+	if err != nil {
+		return ""
+	}
+
+	switch code {
+	case 0: // OK
+		return ""
+	case 1, 3, 5, 6, 7, 9, 11, 16:
+		// CANCELLED, INVALID_ARGUMENT, NOT_FOUND, ALREADY_EXISTS,
+		// PERMISSION_DENIED, FAILED_PRECONDITION, OUT_OF_RANGE,
+		// UNAUTHENTICATED
+		return resultClientError
+	default:
+		return resultServerError
+	}
+}
+
+// fetchPayload fetches the payload of the response, it buffers the body
+// of the response if it is not larger than StreamBodyThreshold, and
+// streams the body otherwise.
+func (sp *ServerPool) fetchPayload(resp *httpprot.Response) error {
+	maxBodySize := sp.spec.ServerMaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = sp.proxy.spec.ServerMaxBodySize
+	}
+
+	// the body is always a stream.
+	if maxBodySize < 0 {
+		return resp.FetchPayload(maxBodySize)
+	}
+
+	if size := sp.proxy.spec.ClientMaxBodySize; size > 0 {
+		maxBodySize = size
+	} else if maxBodySize == 0 {
+		maxBodySize = httpprot.DefaultMaxPayloadSize
 	}
 
-	ce := sp.memoryCache.Load(spCtx.req)
+	threshold := sp.proxy.spec.StreamBodyThreshold
+	if threshold <= 0 {
+		return resp.FetchPayload(maxBodySize)
+	}
+
+	stdr := resp.Std()
+	if stdr.ContentLength > threshold {
+		return resp.FetchPayload(-1)
+	}
+	if stdr.ContentLength >= 0 {
+		return resp.FetchPayload(maxBodySize)
+	}
+
+	// the length of the body is unknown, read at most threshold+1 bytes
+	// to decide whether to buffer or stream it.
+	payload, err := io.ReadAll(io.LimitReader(stdr.Body, threshold+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(payload)) > threshold {
+		resp.SetPayload(io.MultiReader(bytes.NewReader(payload), stdr.Body))
+		return nil
+	}
+
+	if int64(len(payload)) > maxBodySize {
+		return httpprot.ErrResponseEntityTooLarge
+	}
+
+	resp.SetPayload(payload)
+	return nil
+}
+
+func (sp *ServerPool) buildResponseFromCache(spCtx *serverPoolContext) bool {
+	var ce *CacheEntry
+	if sp.memoryCache != nil {
+		ce = sp.memoryCache.Load(spCtx.req)
+	}
+	if ce == nil && sp.cache != nil {
+		ce = sp.cache.Load(spCtx.req)
+	}
 	if ce == nil {
 		return false
 	}
 
+	spCtx.cacheHit = true
+	sp.buildResponseFromCacheEntry(spCtx, ce)
+	return true
+}
+
+func (sp *ServerPool) buildResponseFromCacheEntry(spCtx *serverPoolContext, ce *CacheEntry) {
 	resp, _ := httpprot.NewResponse(nil)
 	resp.SetStatusCode(ce.StatusCode)
 	resp.Std().Header = ce.Header.Clone()
@@ -622,7 +1184,6 @@ func (sp *ServerPool) buildResponseFromCache(spCtx *serverPoolContext) bool {
 
 	spCtx.resp = resp
 	spCtx.SetOutputResponse(resp)
-	return true
 }
 
 func (sp *ServerPool) buildFailureResponse(spCtx *serverPoolContext, statusCode int) {
@@ -635,4 +1196,7 @@ func (sp *ServerPool) buildFailureResponse(spCtx *serverPoolContext, statusCode
 func (sp *ServerPool) close() {
 	close(sp.done)
 	sp.wg.Wait()
+	if sp.client != nil {
+		sp.client.CloseIdleConnections()
+	}
 }
@@ -18,13 +18,34 @@
 package proxy
 
 import (
+	stdcontext "context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/pkg/resilience"
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/openzipkin/zipkin-go/model"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/yaml.v2"
 )
@@ -146,3 +167,1054 @@ servers:
 	sp.memoryCache.Store(req, resp)
 	assert.True(sp.buildResponseFromCache(spCtx))
 }
+
+func TestBuildResponseFromResponseCache(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `spanName: test
+cache:
+  defaultTTL: 1m
+servers:
+- url: http://192.168.1.1
+`
+
+	spec := &ServerPoolSpec{}
+	err := yaml.Unmarshal([]byte(yamlSpec), spec)
+	assert.NoError(err)
+	assert.NoError(spec.Validate())
+
+	sp := NewServerPool(nil, spec, "test")
+	spCtx := &serverPoolContext{
+		Context: context.New(tracing.NoopSpan),
+	}
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	spCtx.req = req
+
+	spCtx.SetRequest(context.DefaultNamespace, req)
+
+	assert.False(sp.buildResponseFromCache(spCtx))
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte("0123456789A"))
+
+	sp.cache.Store(req, resp)
+	assert.True(sp.buildResponseFromCache(spCtx))
+
+	status := sp.status()
+	assert.Equal(uint64(1), status.Cache.Hits)
+	assert.Equal(uint64(1), status.Cache.Misses)
+}
+
+func TestGRPCStatusResult(t *testing.T) {
+	assert := assert.New(t)
+
+	// fake gRPC backend, the status is reported by trailers.
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "grpc message")
+		w.Header().Set("Grpc-Status", r.URL.Query().Get("code"))
+	}))
+	defer svr.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + svr.URL + `
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	handle := func(code int) string {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://megaease.com/svc?code="+strconv.Itoa(code), nil)
+		return proxy.Handle(getCtx(stdr))
+	}
+
+	// grpcStatus is not enabled.
+	assert.Equal("", handle(13))
+
+	proxy.mainPool.spec.GRPCStatus = true
+	assert.Equal("", handle(0))
+	assert.Equal(resultClientError, handle(5))
+	assert.Equal(resultClientError, handle(16))
+	assert.Equal(resultServerError, handle(13))
+	assert.Equal(resultServerError, handle(14))
+
+	// trailers-only response.
+	resp, _ := httpprot.NewResponse(&http.Response{Header: http.Header{}})
+	assert.Equal("", grpcStatusResult(resp))
+	resp.Std().Header.Set("Grpc-Status", "4")
+	assert.Equal(resultServerError, grpcStatusResult(resp))
+}
+
+// tooLargeReader fails like a request body exceeding its size limit.
+type tooLargeReader struct{}
+
+func (r tooLargeReader) Read(p []byte) (int, error) {
+	return 0, httpprot.ErrRequestEntityTooLarge
+}
+
+func TestRequestEntityTooLarge(t *testing.T) {
+	assert := assert.New(t)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer svr.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + svr.URL + `
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	stdr, _ := http.NewRequest(http.MethodPost, "http://megaease.com/", nil)
+	ctx := getCtx(stdr)
+	ctx.GetInputRequest().(*httpprot.Request).SetPayload(tooLargeReader{})
+
+	assert.Equal(resultClientError, proxy.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+}
+
+func TestFetchPayload(t *testing.T) {
+	assert := assert.New(t)
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		cl := int64(size)
+		if r.URL.Query().Get("chunked") != "" {
+			cl = -1
+		}
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			ContentLength: cl,
+			Body:          io.NopCloser(strings.NewReader(strings.Repeat("a", size))),
+		}, nil
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+clientMaxBodySize: 100
+streamBodyThreshold: 200
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	handle := func(query string) (string, *httpprot.Response) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc?"+query, nil)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		resp, _ := ctx.GetOutputResponse().(*httpprot.Response)
+		return result, resp
+	}
+
+	// under the cap, buffered.
+	for _, q := range []string{"size=50", "size=50&chunked=1"} {
+		result, resp := handle(q)
+		assert.Equal("", result)
+		assert.False(resp.IsStream())
+		assert.Equal(50, len(resp.RawPayload()))
+	}
+
+	// over the cap, rejected.
+	for _, q := range []string{"size=150", "size=150&chunked=1"} {
+		result, resp := handle(q)
+		assert.Equal(resultServerError, result)
+		assert.Equal(http.StatusBadGateway, resp.StatusCode())
+	}
+
+	// above the threshold, streamed.
+	for _, q := range []string{"size=1000", "size=1000&chunked=1"} {
+		result, resp := handle(q)
+		assert.Equal("", result)
+		assert.True(resp.IsStream())
+		data, err := io.ReadAll(resp.GetPayload())
+		assert.NoError(err)
+		assert.Equal(1000, len(data))
+		resp.Close()
+	}
+}
+
+func TestClientDisconnectCancelsBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	backendCanceled := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(backendCanceled)
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + backend.URL + `
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	result := make(chan string, 1)
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result <- proxy.Handle(getCtx(r))
+	}))
+	defer front.Close()
+
+	ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), 100*time.Millisecond)
+	defer cancel()
+	stdr, _ := http.NewRequestWithContext(ctx, http.MethodGet, front.URL, nil)
+	_, err := http.DefaultClient.Do(stdr)
+	assert.Error(err)
+
+	select {
+	case <-backendCanceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("backend request is not canceled")
+	}
+
+	select {
+	case r := <-result:
+		assert.Equal(resultClientError, r)
+	case <-time.After(3 * time.Second):
+		t.Fatal("proxy does not return")
+	}
+}
+
+// newTestCert creates a self-signed certificate for 127.0.0.1, and
+// returns the certificate and its PEM encoded certificate and key.
+func newTestCert(assert *assert.Assertions) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"MegaEase"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(err)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	assert.NoError(err)
+	return cert, certPem, keyPem
+}
+
+func TestServerPoolClient(t *testing.T) {
+	assert := assert.New(t)
+
+	cert, certPem, keyPem := newTestCert(assert)
+	_, otherCertPem, otherKeyPem := newTestCert(assert)
+
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	svr.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	svr.StartTLS()
+	defer svr.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	mtls := func(certPem, keyPem []byte) string {
+		return `
+    certBase64: ` + base64.StdEncoding.EncodeToString(certPem) + `
+    keyBase64: ` + base64.StdEncoding.EncodeToString(keyPem) + `
+    rootCertBase64: ` + base64.StdEncoding.EncodeToString(certPem)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+maxIdleConns: 100
+maxIdleConnsPerHost: 10
+pools:
+- servers:
+  - url: ` + svr.URL + `
+  maxIdleConnsPerHost: 5
+  mtls:` + mtls(certPem, keyPem) + `
+- filter:
+    headers:
+      X-Canary:
+        exact: other-ca
+  servers:
+  - url: ` + svr.URL + `
+  mtls:` + mtls(otherCertPem, otherKeyPem) + `
+- filter:
+    headers:
+      X-Canary:
+        exact: shared
+  servers:
+  - url: ` + svr.URL + `
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	// the main pool has its own transport, settings not specified by
+	// the pool are inherited from the proxy.
+	transport := proxy.mainPool.httpClient().Transport.(*http.Transport)
+	assert.Equal(100, transport.MaxIdleConns)
+	assert.Equal(5, transport.MaxIdleConnsPerHost)
+	assert.False(transport.TLSClientConfig.InsecureSkipVerify)
+
+	transport = proxy.candidatePools[0].httpClient().Transport.(*http.Transport)
+	assert.Equal(10, transport.MaxIdleConnsPerHost)
+	assert.NotSame(proxy.mainPool.httpClient(), proxy.candidatePools[0].httpClient())
+
+	// the pool without its own settings uses the shared client.
+	assert.Same(proxy.client, proxy.candidatePools[1].httpClient())
+
+	handle := func(canary string) int {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		if canary != "" {
+			stdr.Header.Set("X-Canary", canary)
+		}
+		ctx := getCtx(stdr)
+		proxy.Handle(ctx)
+		return ctx.GetOutputResponse().(*httpprot.Response).StatusCode()
+	}
+
+	// the main pool trusts the CA of the server.
+	assert.Equal(http.StatusOK, handle(""))
+	// the candidate pool trusts another CA, so the request fails.
+	assert.Equal(http.StatusServiceUnavailable, handle("other-ca"))
+	// the shared client skips verification.
+	assert.Equal(http.StatusOK, handle("shared"))
+}
+
+func TestServerStats(t *testing.T) {
+	assert := assert.New(t)
+
+	okSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer okSvr.Close()
+
+	failSvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failSvr.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + okSvr.URL + `
+  - url: ` + failSvr.URL + `
+  loadBalance:
+    policy: roundRobin
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	for i := 0; i < 5; i++ {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		proxy.Handle(getCtx(stdr))
+	}
+
+	status := proxy.Status().(*Status)
+	servers := status.MainPool.Servers
+	assert.Len(servers, 2)
+
+	stats := map[string]*ServerStatus{}
+	for _, s := range servers {
+		stats[s.URL] = s
+	}
+
+	ok, fail := stats[okSvr.URL], stats[failSvr.URL]
+	assert.Equal(uint64(3), ok.Requests)
+	assert.Equal(uint64(0), ok.Errors)
+	assert.Equal(uint64(2), fail.Requests)
+	assert.Equal(uint64(2), fail.Errors)
+
+	// connections are reused, and released after the responses are read.
+	assert.Equal(uint64(1), ok.NewConns)
+	assert.Equal(uint64(2), ok.ReusedConns)
+	assert.Equal(uint64(1), fail.NewConns)
+	assert.Equal(uint64(1), fail.ReusedConns)
+	assert.Equal(int64(0), ok.ActiveConns)
+	assert.Equal(int64(0), fail.ActiveConns)
+
+	// the connections are idle, and the percentiles are not reset.
+	assert.Eventually(func() bool {
+		for _, s := range proxy.mainPool.serverStatuses() {
+			if s.IdleConns != 1 || s.P50 != ok.P50 && s.URL == okSvr.URL {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+
+	metrics := status.ToMetrics("test")
+	urls := map[string]bool{}
+	for _, m := range metrics {
+		if m.Type == "eg-http-server" {
+			urls[m.URL] = true
+		}
+	}
+	assert.True(urls[okSvr.URL])
+	assert.True(urls[failSvr.URL])
+}
+
+// newTestTracer creates a tracer reporting to a test zipkin collector,
+// the returned function closes the tracer and returns the reported spans.
+func newTestTracer(assert *assert.Assertions, spec *tracing.Spec) (*tracing.Tracer, func() []model.SpanModel) {
+	spansCh := make(chan []model.SpanModel, 10)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var spans []model.SpanModel
+		json.NewDecoder(r.Body).Decode(&spans)
+		spansCh <- spans
+	}))
+
+	spec.ServiceName = "test"
+	spec.Zipkin = &tracing.ZipkinSpec{
+		ServerURL:  collector.URL,
+		SampleRate: 1,
+	}
+	tracer, err := tracing.New(spec)
+	assert.NoError(err)
+
+	collect := func() []model.SpanModel {
+		defer collector.Close()
+
+		// flush the spans to the collector.
+		tracer.Close()
+		var result []model.SpanModel
+		for {
+			select {
+			case spans := <-spansCh:
+				result = append(result, spans...)
+			case <-time.After(500 * time.Millisecond):
+				return result
+			}
+		}
+	}
+	return tracer, collect
+}
+
+func TestServerPoolTracing(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer, collect := newTestTracer(assert, &tracing.Spec{})
+
+	var traceParents []string
+	count := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParents = append(traceParents, r.Header.Get("traceparent"))
+		count++
+		if count == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + backend.URL + `
+  spanName: backend
+  retryPolicy: retry
+  failureCodes: [500]
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"retry": &resilience.RetryPolicy{MaxAttempts: 2, WaitDuration: "1ms"},
+	})
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	rootSpan := tracer.NewSpan("root")
+	ctx := context.New(rootSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	assert.Equal("", proxy.Handle(ctx))
+	rootSpan.Finish()
+
+	spans := map[string]model.SpanModel{}
+	for _, s := range collect() {
+		if s.Name == "backend" {
+			spans[s.Tags["proxy.attempt"]] = s
+		}
+	}
+
+	assert.Len(spans, 2)
+	first, second := spans["1"], spans["2"]
+	assert.Equal(backend.URL, first.Tags["proxy.server"])
+	assert.Equal("500", first.Tags["http.status_code"])
+	assert.Equal(resultFailureCode, first.Tags["error"])
+	assert.Equal(backend.URL, second.Tags["proxy.server"])
+	assert.Equal("200", second.Tags["http.status_code"])
+	assert.NotContains(second.Tags, "error")
+	assert.Equal(rootSpan.Context().TraceID, second.TraceID)
+	assert.Equal(rootSpan.Context().ID, *second.ParentID)
+	assert.True(second.Duration > 0)
+
+	// the span context is propagated to the backend.
+	assert.Len(traceParents, 2)
+	assert.Contains(traceParents[0], first.ID.String())
+	assert.Contains(traceParents[1], second.ID.String())
+}
+
+func TestServerPoolBodyCapture(t *testing.T) {
+	assert := assert.New(t)
+
+	tracer, collect := newTestTracer(assert, &tracing.Spec{
+		BodyCapture: &tracing.BodyCaptureSpec{
+			MaxBytes:      40,
+			RedactHeaders: []string{"X-Api-Key"},
+			RedactFields:  []string{"password"},
+		},
+	})
+
+	var received []byte
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"data":"`+strings.Repeat("x", 100)+`"}`)
+	}))
+	defer backend.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + backend.URL + `
+  spanName: backend
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	reqBody := `{"user":"megaease","password":"secret"}`
+	stdr, _ := http.NewRequest(http.MethodPost, "http://megaease.com/", strings.NewReader(reqBody))
+	stdr.Header.Set("Content-Type", "application/json")
+	stdr.Header.Set("X-Api-Key", "secret")
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	rootSpan := tracer.NewSpan("root")
+	ctx := context.New(rootSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	assert.Equal("", proxy.Handle(ctx))
+	rootSpan.Finish()
+
+	// the bodies are not consumed.
+	assert.Equal(reqBody, string(received))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Len(resp.RawPayload(), 111)
+
+	var span *model.SpanModel
+	for _, s := range collect() {
+		if s.Name == "backend" {
+			s := s
+			span = &s
+		}
+	}
+	assert.NotNil(span)
+
+	tags := span.Tags
+	assert.Contains(tags["http.request.headers"], "X-Api-Key: [REDACTED]\n")
+	assert.NotContains(tags["http.request.headers"], "secret")
+	assert.Equal(`{"password":"[REDACTED]","user":"megaease"}`[:40], tags["http.request.body"])
+	assert.Equal("true", tags["http.request.body.truncated"])
+	assert.Contains(tags["http.response.headers"], "Content-Type: application/json\n")
+	assert.Equal(`{"data":"`+strings.Repeat("x", 31), tags["http.response.body"])
+	assert.Equal("true", tags["http.response.body.truncated"])
+}
+
+func TestServerPoolCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	var healthy int32
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer backend.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- filter:
+    headers:
+      "X-Pool":
+        exact: candidate
+  servers:
+  - url: ` + backend.URL + `
+  circuitBreakerPolicy: circuitBreaker
+  failureCodes: [500]
+- servers:
+  - url: ` + backend.URL + `
+  circuitBreakerPolicy: circuitBreaker
+  failureCodes: [500]
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"circuitBreaker": &resilience.CircuitBreakerPolicy{
+			FailureRateThreshold:             50,
+			SlowCallRateThreshold:            100,
+			SlidingWindowSize:                4,
+			MinimumNumberOfCalls:             4,
+			PermittedNumberOfCallsInHalfOpen: 2,
+			WaitDurationInOpen:               "100ms",
+		},
+	})
+
+	handle := func() (string, int) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		return result, ctx.GetOutputResponse().(*httpprot.Response).StatusCode()
+	}
+	state := func() (string, string) {
+		status := proxy.Status().(*Status)
+		return status.MainPool.Resilience.CircuitBreaker.State,
+			status.CandidatePools[0].Resilience.CircuitBreaker.State
+	}
+
+	main, candidate := state()
+	assert.Equal("Closed", main)
+	assert.Equal("Closed", candidate)
+
+	// drive the breaker of the main pool open.
+	for i := 0; i < 4; i++ {
+		result, code := handle()
+		assert.Equal(resultFailureCode, result)
+		assert.Equal(http.StatusInternalServerError, code)
+	}
+	main, candidate = state()
+	assert.Equal("Open", main)
+	assert.Equal("Closed", candidate)
+
+	// requests are short circuited without reaching the backend.
+	atomic.StoreInt32(&calls, 0)
+	for i := 0; i < 3; i++ {
+		result, code := handle()
+		assert.Equal(resultShortCircuited, result)
+		assert.Equal(http.StatusServiceUnavailable, code)
+	}
+	assert.Equal(int32(0), atomic.LoadInt32(&calls))
+
+	// the breaker turns half open after the wait duration, and the
+	// probes close it once they succeed.
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(150 * time.Millisecond)
+
+	result, code := handle()
+	assert.Equal("", result)
+	assert.Equal(http.StatusOK, code)
+	main, _ = state()
+	assert.Equal("HalfOpen", main)
+
+	result, _ = handle()
+	assert.Equal("", result)
+	main, _ = state()
+	assert.Equal("Closed", main)
+	assert.Equal(int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestServerPoolResilienceStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  retryPolicy: retry
+  circuitBreakerPolicy: circuitBreaker
+  failureCodes: [500]
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	status := proxy.Status().(*Status)
+	assert.Nil(status.MainPool.Resilience)
+
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"retry": &resilience.RetryPolicy{MaxAttempts: 2, WaitDuration: "1ms"},
+		"circuitBreaker": &resilience.CircuitBreakerPolicy{
+			FailureRateThreshold:  50,
+			SlowCallRateThreshold: 100,
+			SlidingWindowSize:     2,
+			MinimumNumberOfCalls:  2,
+			WaitDurationInOpen:    "1m",
+		},
+	})
+
+	status = proxy.Status().(*Status)
+	assert.Equal(&ResilienceStatus{
+		CircuitBreaker: &resilience.CircuitBreakerStatus{State: "Closed"},
+		Retry:          &resilience.RetryStatus{},
+	}, status.MainPool.Resilience)
+
+	// every call is attempted twice before the breaker opens.
+	for i := 0; i < 2; i++ {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		assert.Equal(resultFailureCode, proxy.Handle(getCtx(stdr)))
+	}
+	assert.Equal(int32(4), atomic.LoadInt32(&calls))
+
+	for i := 0; i < 3; i++ {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		assert.Equal(resultShortCircuited, proxy.Handle(getCtx(stdr)))
+	}
+	assert.Equal(int32(4), atomic.LoadInt32(&calls))
+
+	status = proxy.Status().(*Status)
+	assert.Equal(&ResilienceStatus{
+		CircuitBreaker: &resilience.CircuitBreakerStatus{State: "Open", ShortCircuits: 3, Failures: 2},
+		Retry:          &resilience.RetryStatus{Retries: 2, Exhausted: 2},
+	}, status.MainPool.Resilience)
+
+	var found bool
+	for _, m := range status.ToMetrics("proxy") {
+		if m.Type != "eg-http-resilience" {
+			continue
+		}
+		found = true
+		data, err := json.Marshal(m)
+		assert.NoError(err)
+		assert.Contains(string(data), `"circuitBreaker":{"state":"Open","shortCircuits":3,"failures":2}`)
+		assert.Contains(string(data), `"retry":{"retries":2,"exhausted":2}`)
+	}
+	assert.True(found)
+}
+
+func TestServerPoolConcurrencyLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}))
+	defer backend.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + backend.URL + `
+  concurrencyLimitPolicy: limit
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"limit": &resilience.ConcurrencyLimitPolicy{InitialLimit: 2, MinLimit: 1, MaxLimit: 2},
+	})
+
+	handle := func() (string, int) {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		ctx := getCtx(stdr)
+		result := proxy.Handle(ctx)
+		return result, ctx.GetOutputResponse().(*httpprot.Response).StatusCode()
+	}
+
+	done := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, _ := handle()
+			done <- result
+		}()
+	}
+	<-started
+	<-started
+
+	status := proxy.Status().(*Status)
+	assert.Equal(&resilience.ConcurrencyLimitStatus{Limit: 2, Inflight: 2}, status.MainPool.Resilience.ConcurrencyLimit)
+
+	result, code := handle()
+	assert.Equal(resultShortCircuited, result)
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	close(block)
+	assert.Equal("", <-done)
+	assert.Equal("", <-done)
+
+	result, _ = handle()
+	assert.Equal("", result)
+}
+
+// endlessBody is a response body of unlimited size which counts the bytes
+// read from it.
+type endlessBody struct {
+	read   int64
+	closed int32
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	atomic.AddInt64(&b.read, int64(len(p)))
+	return len(p), nil
+}
+
+func (b *endlessBody) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return nil
+}
+
+func TestMirrorResponseBody(t *testing.T) {
+	assert := assert.New(t)
+
+	var body *endlessBody
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		body = &endlessBody{}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}, nil
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+mirrorPool:
+  filter:
+    headers:
+      "X-Mirror":
+        exact: mirror
+  servers:
+  - url: http://127.0.0.2:9095
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	mirror := func() {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/", nil)
+		assert.Equal("", proxy.mirrorPool.handle(getCtx(stdr), true))
+	}
+
+	// The body is drained up to the default limit, and closed.
+	mirror()
+	assert.LessOrEqual(body.read, int64(defaultMirrorMaxDrainSize))
+	assert.Greater(body.read, int64(0))
+	assert.Equal(int32(1), body.closed)
+
+	proxy.mirrorPool.spec.MirrorMaxDrainSize = 1000
+	mirror()
+	assert.LessOrEqual(body.read, int64(1000))
+	assert.Equal(int32(1), body.closed)
+
+	proxy.mirrorPool.spec.MirrorSkipResponseBody = true
+	mirror()
+	assert.Equal(int64(0), body.read)
+	assert.Equal(int32(1), body.closed)
+
+	// Mirror options are rejected in other pools.
+	spec := &Spec{Pools: []*ServerPoolSpec{{
+		Servers:            []*Server{{URL: "http://127.0.0.1:9095"}},
+		MirrorMaxDrainSize: 1000,
+	}}}
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolHost(t *testing.T) {
+	assert := assert.New(t)
+
+	var host atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host.Store(r.Host)
+	}))
+	defer server.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		return client.Do(r)
+	}
+
+	// use a host name, or the Host of the request is always kept.
+	backend := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+
+	cases := []struct {
+		options string
+		host    string
+	}{
+		{"", strings.TrimPrefix(backend, "http://")},
+		{"preserveHost: true", "megaease.com"},
+		{"hostRewrite: example.com", "example.com"},
+	}
+
+	for _, c := range cases {
+		yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: ` + backend + `
+  ` + c.options + `
+`
+		proxy := newTestProxy(yamlSpec, assert)
+
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+		assert.Equal("", proxy.Handle(getCtx(stdr)))
+		assert.Equal(c.host, host.Load(), c.options)
+
+		proxy.Close()
+	}
+
+	spec := &ServerPoolSpec{
+		Servers:      []*Server{{URL: backend}},
+		PreserveHost: true,
+		HostRewrite:  "example.com",
+	}
+	assert.Error(spec.Validate())
+}
+
+func TestServerPoolIdempotencyHeader(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls int32
+	var connectErr bool
+	var bodies []string
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if connectErr {
+			return nil, &url.Error{Op: r.Method, URL: r.URL.String(), Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+		}
+		return &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  retryPolicy: retry
+  failureCodes: [500]
+  idempotencyHeader: Idempotency-Key
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+
+	proxy.InjectResiliencePolicy(map[string]resilience.Policy{
+		"retry": &resilience.RetryPolicy{MaxAttempts: 3, WaitDuration: "1ms"},
+	})
+
+	send := func(method, key string, stream bool) int32 {
+		atomic.StoreInt32(&calls, 0)
+		bodies = nil
+		stdr, _ := http.NewRequest(method, "http://megaease.com/", nil)
+		if key != "" {
+			stdr.Header.Set("Idempotency-Key", key)
+		}
+		ctx := getCtx(stdr)
+		if stream {
+			ctx.GetInputRequest().(*httpprot.Request).SetPayload(strings.NewReader("abc"))
+		} else {
+			ctx.GetInputRequest().(*httpprot.Request).SetPayload("abc")
+		}
+		proxy.Handle(ctx)
+		return atomic.LoadInt32(&calls)
+	}
+
+	// idempotent methods and keyed POSTs are retried.
+	assert.Equal(int32(3), send(http.MethodGet, "", false))
+	assert.Equal(int32(3), send(http.MethodPost, "key", false))
+
+	// the stream body of a keyed POST is buffered for replay.
+	assert.Equal(int32(3), send(http.MethodPost, "key", true))
+	assert.Equal([]string{"abc", "abc", "abc"}, bodies)
+
+	// unkeyed POSTs are not retried, except on connect failures.
+	assert.Equal(int32(1), send(http.MethodPost, "", false))
+
+	// unkeyed stream POSTs are sent once without the retry wrapper.
+	assert.Equal(int32(1), send(http.MethodPost, "", true))
+	assert.Equal([]string{"abc"}, bodies)
+
+	connectErr = true
+	assert.Equal(int32(3), send(http.MethodPost, "", false))
+	assert.Equal(int32(3), send(http.MethodPost, "key", false))
+	connectErr = false
+
+	// retry status
+	status := proxy.Status().(*Status)
+	assert.Equal(uint64(10), status.MainPool.Resilience.Retry.Retries)
+}
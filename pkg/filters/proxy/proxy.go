@@ -22,7 +22,6 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"fmt"
-	"net"
 	"net/http"
 	"time"
 
@@ -47,6 +46,10 @@ const (
 	// result for resilience
 	resultTimeout        = "timeout"
 	resultShortCircuited = "shortCircuited"
+
+	// defaultIdleConnTimeout is the idle timeout of the connections to
+	// the backend servers.
+	defaultIdleConnTimeout = 90 * time.Second
 )
 
 var kind = &filters.Kind{
@@ -110,7 +113,15 @@ type (
 		MTLS                *MTLS             `yaml:"mtls,omitempty" jsonschema:"omitempty"`
 		MaxIdleConns        int               `yaml:"maxIdleConns" jsonschema:"omitempty"`
 		MaxIdleConnsPerHost int               `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty"`
+		Dial                *DialSpec         `yaml:"dial,omitempty" jsonschema:"omitempty"`
 		ServerMaxBodySize   int64             `yaml:"serverMaxBodySize" jsonschema:"omitempty"`
+		ClientMaxBodySize   int64             `yaml:"clientMaxBodySize" jsonschema:"omitempty"`
+		StreamBodyThreshold int64             `yaml:"streamBodyThreshold" jsonschema:"omitempty"`
+
+		// ServerTiming adds the Server-Timing header to the responses,
+		// which describes the cache status and the time spent on the
+		// backend.
+		ServerTiming bool `yaml:"serverTiming" jsonschema:"omitempty"`
 	}
 
 	// Status is the status of Proxy.
@@ -138,12 +149,27 @@ func (s *Spec) Validate() error {
 		if err := pool.Validate(); err != nil {
 			return fmt.Errorf("pool %d: %v", i, err)
 		}
+		if pool.MirrorMaxDrainSize != 0 || pool.MirrorSkipResponseBody {
+			return fmt.Errorf("pool %d: mirror options are only for mirrorPool", i)
+		}
 	}
 
 	if numMainPool != 1 {
 		return fmt.Errorf("one and only one mainPool is required")
 	}
 
+	if s.Compression != nil {
+		if err := s.Compression.Validate(); err != nil {
+			return fmt.Errorf("compression: %v", err)
+		}
+	}
+
+	if s.Dial != nil {
+		if err := s.Dial.Validate(); err != nil {
+			return fmt.Errorf("dial: %v", err)
+		}
+	}
+
 	if s.MirrorPool != nil {
 		if s.MirrorPool.Filter == nil {
 			return fmt.Errorf("filter of mirrorPool is required")
@@ -151,6 +177,15 @@ func (s *Spec) Validate() error {
 		if s.MirrorPool.MemoryCache != nil {
 			return fmt.Errorf("memoryCache must be empty in mirrorPool")
 		}
+		if s.MirrorPool.Cache != nil {
+			return fmt.Errorf("cache must be empty in mirrorPool")
+		}
+		if s.MirrorPool.Hedge != nil {
+			return fmt.Errorf("hedge must be empty in mirrorPool")
+		}
+		if s.MirrorPool.Coalesce != nil {
+			return fmt.Errorf("coalesce must be empty in mirrorPool")
+		}
 	}
 
 	return nil
@@ -182,8 +217,10 @@ func (p *Proxy) Inherit(previousGeneration filters.Filter) {
 }
 
 func (p *Proxy) tlsConfig() (*tls.Config, error) {
-	mtls := p.spec.MTLS
+	return newTLSConfig(p.spec.MTLS)
+}
 
+func newTLSConfig(mtls *MTLS) (*tls.Config, error) {
 	if mtls == nil {
 		return &tls.Config{InsecureSkipVerify: true}, nil
 	}
@@ -235,23 +272,24 @@ func (p *Proxy) reload() {
 	}
 
 	tlsCfg, _ := p.tlsConfig()
-	p.client = &http.Client{
+	p.client = newHTTPClient(tlsCfg, p.spec.MaxIdleConns, p.spec.MaxIdleConnsPerHost, p.spec.Dial)
+}
+
+// newHTTPClient creates the HTTP client to send requests to backend servers.
+func newHTTPClient(tlsCfg *tls.Config, maxIdleConns, maxIdleConnsPerHost int, dial *DialSpec) *http.Client {
+	return &http.Client{
 		// NOTE: Timeout could be no limit, real client or server could cancel it.
 		Timeout: 0,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 60 * time.Second,
-				DualStack: true,
-			}).DialContext,
+			Proxy:              http.ProxyFromEnvironment,
+			DialContext:        newDialer(dial).DialContext,
 			TLSClientConfig:    tlsCfg,
 			DisableCompression: false,
 			// NOTE: The large number of Idle Connections can
 			// reduce overhead of building connections.
-			MaxIdleConns:          p.spec.MaxIdleConns,
-			MaxIdleConnsPerHost:   p.spec.MaxIdleConnsPerHost,
-			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          maxIdleConns,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			IdleConnTimeout:       defaultIdleConnTimeout,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
 		},
@@ -325,18 +363,18 @@ func (s *Status) ToMetrics(service string) []*easemonitor.Metrics {
 
 	if s.MainPool != nil {
 		svc := service + "/mainPool"
-		results = append(results, s.MainPool.Stat.ToMetrics(svc)...)
+		results = append(results, s.MainPool.toMetrics(svc)...)
 	}
 
 	for i := range s.CandidatePools {
 		svc := fmt.Sprintf("%s/candidatePool/%d", service, i)
 		p := s.CandidatePools[i]
-		results = append(results, p.Stat.ToMetrics(svc)...)
+		results = append(results, p.toMetrics(svc)...)
 	}
 
 	if s.MirrorPool != nil {
 		svc := service + "/mirrorPool"
-		results = append(results, s.MirrorPool.Stat.ToMetrics(svc)...)
+		results = append(results, s.MirrorPool.toMetrics(svc)...)
 	}
 
 	for _, m := range results {
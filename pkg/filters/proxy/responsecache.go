@@ -0,0 +1,362 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"container/list"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const defaultResponseCacheMaxEntries = 1024
+
+type (
+	// ResponseCache is a LRU cache of responses, it honors the
+	// Cache-Control and Vary headers.
+	ResponseCache struct {
+		spec       *ResponseCacheSpec
+		defaultTTL time.Duration
+		maxEntries int
+		methods    map[string]struct{}
+		codes      map[int]struct{}
+
+		mutex   sync.Mutex
+		lru     *list.List
+		entries map[string]*list.Element
+		varies  map[string]*responseCacheVary
+
+		hits   uint64
+		misses uint64
+	}
+
+	// ResponseCacheSpec describes the ResponseCache.
+	ResponseCacheSpec struct {
+		MaxEntries int      `yaml:"maxEntries,omitempty" jsonschema:"omitempty,minimum=1"`
+		DefaultTTL string   `yaml:"defaultTTL" jsonschema:"required,format=duration"`
+		Methods    []string `yaml:"methods" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
+		Codes      []int    `yaml:"codes" jsonschema:"omitempty,uniqueItems=true,format=httpcode-array"`
+	}
+
+	// ResponseCacheStatus is the status of ResponseCache.
+	ResponseCacheStatus struct {
+		Entries int    `yaml:"entries"`
+		Hits    uint64 `yaml:"hits"`
+		Misses  uint64 `yaml:"misses"`
+	}
+
+	// responseCacheVary records the Vary header names of the responses
+	// of a request, and how many entries are cached for the request.
+	responseCacheVary struct {
+		names []string
+		count int
+	}
+
+	responseCacheEntry struct {
+		key        string
+		primaryKey string
+		storedAt   time.Time
+		expireAt   time.Time
+		entry      *CacheEntry
+	}
+)
+
+// NewResponseCache creates a ResponseCache.
+func NewResponseCache(spec *ResponseCacheSpec) *ResponseCache {
+	rc := &ResponseCache{
+		spec:       spec,
+		maxEntries: spec.MaxEntries,
+		methods:    map[string]struct{}{},
+		codes:      map[int]struct{}{},
+		lru:        list.New(),
+		entries:    map[string]*list.Element{},
+		varies:     map[string]*responseCacheVary{},
+	}
+
+	ttl, err := time.ParseDuration(spec.DefaultTTL)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.DefaultTTL, err)
+		ttl = 10 * time.Second
+	}
+	rc.defaultTTL = ttl
+
+	if rc.maxEntries <= 0 {
+		rc.maxEntries = defaultResponseCacheMaxEntries
+	}
+
+	methods := spec.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead}
+	}
+	for _, m := range methods {
+		rc.methods[m] = struct{}{}
+	}
+
+	codes := spec.Codes
+	if len(codes) == 0 {
+		codes = []int{http.StatusOK}
+	}
+	for _, c := range codes {
+		rc.codes[c] = struct{}{}
+	}
+
+	return rc
+}
+
+// cacheControl returns the directives of the Cache-Control header.
+func cacheControl(h http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range h.Values(keyCacheControl) {
+		for _, d := range strings.Split(value, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			k, v := d, ""
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				k, v = d[:i], strings.Trim(d[i+1:], `"`)
+			}
+			directives[strings.ToLower(k)] = v
+		}
+	}
+	return directives
+}
+
+// varyNames returns the canonical header names in the Vary header,
+// ok is false if the response varies on '*'.
+func varyNames(h http.Header) (names []string, ok bool) {
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func (rc *ResponseCache) primaryKey(req *httpprot.Request) string {
+	return stringtool.Cat(req.Method(), " ", req.Scheme(), "://", req.Host(), req.Std().URL.RequestURI())
+}
+
+func (rc *ResponseCache) key(primaryKey string, names []string, req *httpprot.Request) string {
+	var sb strings.Builder
+	sb.WriteString(primaryKey)
+	for _, name := range names {
+		sb.WriteString("\n")
+		sb.WriteString(name)
+		sb.WriteString(":")
+		sb.WriteString(strings.Join(req.HTTPHeader().Values(name), ","))
+	}
+	return sb.String()
+}
+
+func (rc *ResponseCache) remove(el *list.Element) {
+	e := rc.lru.Remove(el).(*responseCacheEntry)
+	delete(rc.entries, e.key)
+	if v := rc.varies[e.primaryKey]; v != nil {
+		v.count--
+		if v.count <= 0 {
+			delete(rc.varies, e.primaryKey)
+		}
+	}
+}
+
+// Load tries to load the cached response of the request, the Age header
+// of the returned entry is set to the time since it was stored.
+func (rc *ResponseCache) Load(req *httpprot.Request) *CacheEntry {
+	if _, ok := rc.methods[req.Method()]; !ok {
+		return nil
+	}
+
+	cc := cacheControl(req.HTTPHeader())
+	if _, ok := cc["no-cache"]; ok {
+		atomic.AddUint64(&rc.misses, 1)
+		return nil
+	}
+	if _, ok := cc["no-store"]; ok {
+		atomic.AddUint64(&rc.misses, 1)
+		return nil
+	}
+
+	primaryKey := rc.primaryKey(req)
+	now := fasttime.Now()
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	v := rc.varies[primaryKey]
+	if v == nil {
+		atomic.AddUint64(&rc.misses, 1)
+		return nil
+	}
+
+	el := rc.entries[rc.key(primaryKey, v.names, req)]
+	if el == nil {
+		atomic.AddUint64(&rc.misses, 1)
+		return nil
+	}
+
+	e := el.Value.(*responseCacheEntry)
+	if !now.Before(e.expireAt) {
+		rc.remove(el)
+		atomic.AddUint64(&rc.misses, 1)
+		return nil
+	}
+
+	rc.lru.MoveToFront(el)
+	atomic.AddUint64(&rc.hits, 1)
+
+	header := e.entry.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(now.Sub(e.storedAt).Seconds())))
+	return &CacheEntry{
+		StatusCode: e.entry.StatusCode,
+		Header:     header,
+		Body:       e.entry.Body,
+	}
+}
+
+// ttl returns the time to live of the response, zero means the response
+// must not be cached.
+func (rc *ResponseCache) ttl(req *httpprot.Request, resp *httpprot.Response) time.Duration {
+	cc := cacheControl(req.HTTPHeader())
+	if _, ok := cc["no-store"]; ok {
+		return 0
+	}
+
+	cc = cacheControl(resp.HTTPHeader())
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0
+		}
+	}
+
+	// Responses to requests with credentials are only cached if they are
+	// explicitly allowed to be shared.
+	//
+	// Reference: https://www.rfc-editor.org/rfc/rfc7234#section-3.2
+	if req.HTTPHeader().Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !sMaxAge {
+			return 0
+		}
+	}
+
+	// s-maxage takes precedence over max-age for shared caches.
+	for _, d := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[d]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0
+			}
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return rc.defaultTTL
+}
+
+// Store tries to cache the response.
+func (rc *ResponseCache) Store(req *httpprot.Request, resp *httpprot.Response) {
+	if resp.IsStream() {
+		return
+	}
+	if _, ok := rc.methods[req.Method()]; !ok {
+		return
+	}
+	if _, ok := rc.codes[resp.StatusCode()]; !ok {
+		return
+	}
+
+	ttl := rc.ttl(req, resp)
+	if ttl <= 0 {
+		return
+	}
+
+	names, ok := varyNames(resp.HTTPHeader())
+	if !ok {
+		return
+	}
+
+	now := fasttime.Now()
+	primaryKey := rc.primaryKey(req)
+	e := &responseCacheEntry{
+		primaryKey: primaryKey,
+		storedAt:   now,
+		expireAt:   now.Add(ttl),
+		entry: &CacheEntry{
+			StatusCode: resp.StatusCode(),
+			Header:     resp.HTTPHeader().Clone(),
+			Body:       resp.RawPayload(),
+		},
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	// Entries stored with stale Vary header names become unreachable and
+	// will be evicted eventually.
+	v := rc.varies[primaryKey]
+	if v == nil {
+		v = &responseCacheVary{}
+		rc.varies[primaryKey] = v
+	}
+	v.names = names
+
+	e.key = rc.key(primaryKey, names, req)
+	if el := rc.entries[e.key]; el != nil {
+		el.Value = e
+		rc.lru.MoveToFront(el)
+		return
+	}
+
+	v.count++
+	rc.entries[e.key] = rc.lru.PushFront(e)
+	for rc.lru.Len() > rc.maxEntries {
+		rc.remove(rc.lru.Back())
+	}
+}
+
+// Status returns the status of the ResponseCache.
+func (rc *ResponseCache) Status() *ResponseCacheStatus {
+	rc.mutex.Lock()
+	entries := rc.lru.Len()
+	rc.mutex.Unlock()
+
+	return &ResponseCacheStatus{
+		Entries: entries,
+		Hits:    atomic.LoadUint64(&rc.hits),
+		Misses:  atomic.LoadUint64(&rc.misses),
+	}
+}
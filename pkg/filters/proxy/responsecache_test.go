@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := NewResponseCache(&ResponseCacheSpec{DefaultTTL: "1m"})
+
+	newReq := func(method, url string) *httpprot.Request {
+		stdr, _ := http.NewRequest(method, url, nil)
+		req, _ := httpprot.NewRequest(stdr)
+		return req
+	}
+	newResp := func(code int, body string) *httpprot.Response {
+		resp, _ := httpprot.NewResponse(nil)
+		resp.SetStatusCode(code)
+		resp.SetPayload([]byte(body))
+		return resp
+	}
+
+	// miss, then hit
+	req := newReq(http.MethodGet, "http://megaease.com/abc?x=1")
+	assert.Nil(rc.Load(req))
+	rc.Store(req, newResp(http.StatusOK, "abc"))
+	ce := rc.Load(req)
+	assert.NotNil(ce)
+	assert.Equal("abc", string(ce.Body))
+	assert.Equal("0", ce.Header.Get("Age"))
+
+	// different query is a different entry
+	assert.Nil(rc.Load(newReq(http.MethodGet, "http://megaease.com/abc?x=2")))
+
+	// method and status code not cacheable
+	req = newReq(http.MethodPost, "http://megaease.com/post")
+	rc.Store(req, newResp(http.StatusOK, "post"))
+	assert.Nil(rc.Load(req))
+	req = newReq(http.MethodGet, "http://megaease.com/notfound")
+	rc.Store(req, newResp(http.StatusNotFound, "notfound"))
+	assert.Nil(rc.Load(req))
+
+	// no-store and private responses are not cached
+	for _, cc := range []string{"no-store", "private", "no-cache", "max-age=0"} {
+		req = newReq(http.MethodGet, "http://megaease.com/nostore")
+		resp := newResp(http.StatusOK, "nostore")
+		resp.HTTPHeader().Set(keyCacheControl, cc)
+		rc.Store(req, resp)
+		assert.Nil(rc.Load(req), cc)
+	}
+
+	// responses to authorized requests are cached only if shared
+	for _, cc := range []string{"", "max-age=60", "public", "s-maxage=60"} {
+		req = newReq(http.MethodGet, "http://megaease.com/auth")
+		req.HTTPHeader().Set("Authorization", "Basic YWJjOmRlZg==")
+		resp := newResp(http.StatusOK, "auth")
+		resp.HTTPHeader().Set(keyCacheControl, cc)
+		rc.Store(req, resp)
+		shared := cc == "public" || cc == "s-maxage=60"
+		assert.Equal(shared, rc.Load(req) != nil, cc)
+	}
+
+	// request no-cache bypasses the cache
+	req = newReq(http.MethodGet, "http://megaease.com/abc?x=1")
+	req.HTTPHeader().Set(keyCacheControl, "no-cache")
+	assert.Nil(rc.Load(req))
+
+	// Vary
+	req = newReq(http.MethodGet, "http://megaease.com/vary")
+	req.HTTPHeader().Set("Accept-Encoding", "gzip")
+	resp := newResp(http.StatusOK, "gzip")
+	resp.HTTPHeader().Set("Vary", "accept-encoding")
+	rc.Store(req, resp)
+	assert.Equal("gzip", string(rc.Load(req).Body))
+
+	req = newReq(http.MethodGet, "http://megaease.com/vary")
+	req.HTTPHeader().Set("Accept-Encoding", "br")
+	assert.Nil(rc.Load(req))
+	resp = newResp(http.StatusOK, "br")
+	resp.HTTPHeader().Set("Vary", "Accept-Encoding")
+	rc.Store(req, resp)
+	assert.Equal("br", string(rc.Load(req).Body))
+
+	req.HTTPHeader().Set("Accept-Encoding", "gzip")
+	assert.Equal("gzip", string(rc.Load(req).Body))
+
+	// Vary: * is not cached
+	req = newReq(http.MethodGet, "http://megaease.com/varyall")
+	resp = newResp(http.StatusOK, "varyall")
+	resp.HTTPHeader().Set("Vary", "*")
+	rc.Store(req, resp)
+	assert.Nil(rc.Load(req))
+
+	status := rc.Status()
+	assert.Equal(4, status.Entries)
+	assert.Equal(uint64(6), status.Hits)
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := NewResponseCache(&ResponseCacheSpec{DefaultTTL: "100ms"})
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+	req, _ := httpprot.NewRequest(stdr)
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload([]byte("abc"))
+
+	rc.Store(req, resp)
+	assert.NotNil(rc.Load(req))
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(rc.Load(req))
+	assert.Equal(0, rc.Status().Entries)
+
+	// max-age overrides the default TTL
+	resp.HTTPHeader().Set(keyCacheControl, "public, max-age=60")
+	rc.Store(req, resp)
+	time.Sleep(200 * time.Millisecond)
+	assert.NotNil(rc.Load(req))
+}
+
+func TestResponseCacheMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	rc := NewResponseCache(&ResponseCacheSpec{DefaultTTL: "1m", MaxEntries: 2})
+
+	reqs := make([]*httpprot.Request, 3)
+	for i, path := range []string{"/a", "/b", "/c"} {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com"+path, nil)
+		reqs[i], _ = httpprot.NewRequest(stdr)
+	}
+	resp, _ := httpprot.NewResponse(nil)
+
+	rc.Store(reqs[0], resp)
+	rc.Store(reqs[1], resp)
+	assert.NotNil(rc.Load(reqs[0]))
+	rc.Store(reqs[2], resp)
+
+	// reqs[1] is the least recently used one
+	assert.NotNil(rc.Load(reqs[0]))
+	assert.Nil(rc.Load(reqs[1]))
+	assert.NotNil(rc.Load(reqs[2]))
+	assert.Equal(2, rc.Status().Entries)
+}
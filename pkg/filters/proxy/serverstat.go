@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	stdcontext "context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/easemonitor"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/sampler"
+)
+
+type (
+	// serverStat is the statistics of a server in a server pool.
+	serverStat struct {
+		url string
+
+		mutex     sync.Mutex
+		requests  uint64
+		errors    uint64
+		durations *sampler.DurationSampler
+		// idleConns are the connections put back to the idle pool of
+		// the transport and the time they were put back.
+		idleConns map[net.Conn]time.Time
+
+		activeConns int64
+		newConns    uint64
+		reusedConns uint64
+	}
+
+	// ServerStatus is the status of a server in a server pool.
+	ServerStatus struct {
+		URL         string  `yaml:"url" json:"-"`
+		Requests    uint64  `yaml:"requests" json:"requests"`
+		Errors      uint64  `yaml:"errors" json:"errors"`
+		P50         float64 `yaml:"p50" json:"p50"`
+		P95         float64 `yaml:"p95" json:"p95"`
+		ActiveConns int64   `yaml:"activeConns" json:"activeConns"`
+		IdleConns   int     `yaml:"idleConns" json:"idleConns"`
+		NewConns    uint64  `yaml:"newConns" json:"newConns"`
+		ReusedConns uint64  `yaml:"reusedConns" json:"reusedConns"`
+	}
+
+	// connTracker tracks the connection used by a request, the connection
+	// is considered active until the response body is closed.
+	connTracker struct {
+		stat *serverStat
+		conn atomic.Value
+		got  int32
+		done int32
+	}
+
+	// connTrackingBody releases the connection of a request when the
+	// response body is closed.
+	connTrackingBody struct {
+		io.ReadCloser
+		tracker *connTracker
+	}
+)
+
+func newServerStat(url string) *serverStat {
+	return &serverStat{
+		url:       url,
+		durations: sampler.NewDurationSampler(),
+		idleConns: map[net.Conn]time.Time{},
+	}
+}
+
+// record records the result of a request.
+func (ss *serverStat) record(d time.Duration, failed bool) {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	ss.requests++
+	if failed {
+		ss.errors++
+	}
+	ss.durations.Update(d)
+}
+
+// putIdleConn records that conn is put back to the idle pool.
+func (ss *serverStat) putIdleConn(conn net.Conn) {
+	ss.mutex.Lock()
+	ss.idleConns[conn] = fasttime.Now()
+	ss.mutex.Unlock()
+}
+
+// takeIdleConn records that conn is taken from the idle pool.
+func (ss *serverStat) takeIdleConn(conn net.Conn) {
+	ss.mutex.Lock()
+	delete(ss.idleConns, conn)
+	ss.mutex.Unlock()
+}
+
+// status returns the status of the server, the latency percentiles are
+// of all the requests, the idle connection count is approximate as the
+// connections closed by the idle timeout are only removed after it.
+func (ss *serverStat) status() *ServerStatus {
+	ss.mutex.Lock()
+	defer ss.mutex.Unlock()
+
+	s := &ServerStatus{
+		URL:         ss.url,
+		Requests:    ss.requests,
+		Errors:      ss.errors,
+		ActiveConns: atomic.LoadInt64(&ss.activeConns),
+		NewConns:    atomic.LoadUint64(&ss.newConns),
+		ReusedConns: atomic.LoadUint64(&ss.reusedConns),
+	}
+
+	if ss.requests > 0 {
+		percentiles := ss.durations.Percentiles()
+		s.P50, s.P95 = percentiles[1], percentiles[3]
+	}
+
+	now := fasttime.Now()
+	for conn, t := range ss.idleConns {
+		if now.Sub(t) >= defaultIdleConnTimeout {
+			delete(ss.idleConns, conn)
+		}
+	}
+	s.IdleConns = len(ss.idleConns)
+
+	return s
+}
+
+// withTrace returns a context which tracks the connection of the request.
+func (ct *connTracker) withTrace(ctx stdcontext.Context) stdcontext.Context {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if !atomic.CompareAndSwapInt32(&ct.got, 0, 1) {
+				return
+			}
+			atomic.AddInt64(&ct.stat.activeConns, 1)
+			ct.conn.Store(info.Conn)
+			if info.WasIdle {
+				ct.stat.takeIdleConn(info.Conn)
+			}
+			if info.Reused {
+				atomic.AddUint64(&ct.stat.reusedConns, 1)
+			} else {
+				atomic.AddUint64(&ct.stat.newConns, 1)
+			}
+		},
+		PutIdleConn: func(err error) {
+			if err != nil {
+				return
+			}
+			if conn, ok := ct.conn.Load().(net.Conn); ok {
+				ct.stat.putIdleConn(conn)
+			}
+		},
+	}
+	return httptrace.WithClientTrace(ctx, trace)
+}
+
+// release releases the connection, it could be called more than once.
+func (ct *connTracker) release() {
+	if atomic.LoadInt32(&ct.got) == 0 {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&ct.done, 0, 1) {
+		atomic.AddInt64(&ct.stat.activeConns, -1)
+	}
+}
+
+// Close implements io.Closer.
+func (b *connTrackingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.tracker.release()
+	return err
+}
+
+// getServerStat returns the statistics of the server, it is created if
+// not exists.
+func (sp *ServerPool) getServerStat(url string) *serverStat {
+	sp.serverStatsMutex.RLock()
+	ss := sp.serverStats[url]
+	sp.serverStatsMutex.RUnlock()
+	if ss != nil {
+		return ss
+	}
+
+	sp.serverStatsMutex.Lock()
+	defer sp.serverStatsMutex.Unlock()
+	if ss = sp.serverStats[url]; ss == nil {
+		ss = newServerStat(url)
+		sp.serverStats[url] = ss
+	}
+	return ss
+}
+
+// pruneServerStats removes the statistics of servers no longer in the pool.
+func (sp *ServerPool) pruneServerStats(servers []*Server) {
+	urls := make(map[string]struct{}, len(servers))
+	for _, s := range servers {
+		urls[s.URL] = struct{}{}
+	}
+
+	sp.serverStatsMutex.Lock()
+	defer sp.serverStatsMutex.Unlock()
+	for url := range sp.serverStats {
+		if _, ok := urls[url]; !ok {
+			delete(sp.serverStats, url)
+		}
+	}
+}
+
+func (sp *ServerPool) serverStatuses() []*ServerStatus {
+	sp.serverStatsMutex.RLock()
+	stats := make([]*serverStat, 0, len(sp.serverStats))
+	for _, ss := range sp.serverStats {
+		stats = append(stats, ss)
+	}
+	sp.serverStatsMutex.RUnlock()
+
+	if len(stats) == 0 {
+		return nil
+	}
+
+	result := make([]*ServerStatus, 0, len(stats))
+	for _, ss := range stats {
+		result = append(result, ss.status())
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].URL < result[j].URL })
+	return result
+}
+
+// sendToServer sends the request to the server and records the statistics
+// of the server, the request must be prepared with the context returned by
+// the tracker.
+func (sp *ServerPool) sendToServer(ss *serverStat, tracker *connTracker, stdReq *http.Request) (*http.Response, error) {
+	start := fasttime.Now()
+	resp, err := fnSendRequest(stdReq, sp.httpClient())
+	ss.record(fasttime.Since(start), err != nil || resp.StatusCode >= 500)
+
+	if err != nil {
+		tracker.release()
+		return nil, err
+	}
+
+	if resp.Body == nil {
+		tracker.release()
+	} else {
+		resp.Body = &connTrackingBody{ReadCloser: resp.Body, tracker: tracker}
+	}
+	return resp, nil
+}
+
+// toMetrics converts the status of a server pool to EaseMonitor metrics.
+func (s *ServerPoolStatus) toMetrics(service string) []*easemonitor.Metrics {
+	results := s.Stat.ToMetrics(service)
+
+	for _, server := range s.Servers {
+		results = append(results, &easemonitor.Metrics{
+			CommonFields: easemonitor.CommonFields{
+				Service: service,
+				Type:    "eg-http-server",
+				URL:     server.URL,
+			},
+			OtherFields: server,
+		})
+	}
+
+	if s.Resilience != nil {
+		results = append(results, &easemonitor.Metrics{
+			CommonFields: easemonitor.CommonFields{
+				Service: service,
+				Type:    "eg-http-resilience",
+			},
+			OtherFields: s.Resilience,
+		})
+	}
+
+	return results
+}
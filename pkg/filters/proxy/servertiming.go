@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const keyServerTiming = "Server-Timing"
+
+// addServerTiming appends the Server-Timing header to the response, see
+// https://www.w3.org/TR/server-timing/. The metrics are:
+//
+//   - cache: 'hit' or 'miss', only if the pool has a cache.
+//   - queue: the time from the proxy receives the request to the last
+//     attempt to the backend starts, including the waiting of the
+//     resilience policies.
+//   - backend: the duration of the last attempt to the backend, until
+//     the response headers are received and the body is buffered.
+//
+// Existing Server-Timing headers from the backend are kept.
+func (sp *ServerPool) addServerTiming(spCtx *serverPoolContext) {
+	if spCtx.resp == nil {
+		return
+	}
+
+	var metrics []string
+	if sp.memoryCache != nil || sp.cache != nil {
+		if spCtx.cacheHit {
+			metrics = append(metrics, "cache;desc=hit")
+		} else {
+			metrics = append(metrics, "cache;desc=miss")
+		}
+	}
+
+	if !spCtx.backendStart.IsZero() {
+		queue := spCtx.backendStart.Sub(spCtx.startTime)
+		metrics = append(metrics,
+			"queue;dur="+formatMilliseconds(queue),
+			"backend;dur="+formatMilliseconds(spCtx.backendDuration))
+	}
+
+	if len(metrics) > 0 {
+		spCtx.resp.HTTPHeader().Add(keyServerTiming, strings.Join(metrics, ", "))
+	}
+}
+
+// formatMilliseconds formats d in milliseconds, which is the unit of the
+// durations in Server-Timing.
+func formatMilliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerTiming(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+name: proxy
+kind: Proxy
+serverTiming: true
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  memoryCache:
+    expiration: 1m
+    maxEntryBytes: 100
+    codes: [200]
+    methods: [GET]
+`
+	proxy := newTestProxy(yamlSpec, assert)
+	defer proxy.Close()
+	sp := proxy.mainPool
+
+	newSPCtx := func() *serverPoolContext {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://megaease.com/abc", nil)
+		req, _ := httpprot.NewRequest(stdr)
+		ctx := context.New(tracing.NoopSpan)
+		ctx.SetRequest(context.DefaultNamespace, req)
+		resp, _ := httpprot.NewResponse(nil)
+		resp.HTTPHeader().Set("Server-Timing", "db;dur=5")
+		return &serverPoolContext{Context: ctx, req: req, resp: resp}
+	}
+
+	// uncached, the header of the backend is kept.
+	spCtx := newSPCtx()
+	spCtx.startTime = time.Now()
+	spCtx.backendStart = spCtx.startTime.Add(1500 * time.Microsecond)
+	spCtx.backendDuration = 20 * time.Millisecond
+	sp.addServerTiming(spCtx)
+	assert.Equal([]string{"db;dur=5", "cache;desc=miss, queue;dur=1.500, backend;dur=20.000"},
+		spCtx.resp.HTTPHeader().Values("Server-Timing"))
+
+	// cached, the request is not sent to the backend.
+	spCtx = newSPCtx()
+	sp.memoryCache.Store(spCtx.req, spCtx.resp)
+	ctx := spCtx.Context
+	assert.Equal("", proxy.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal([]string{"db;dur=5", "cache;desc=hit"}, resp.HTTPHeader().Values("Server-Timing"))
+
+	// the header in the cache is not changed.
+	assert.Equal("", proxy.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal([]string{"db;dur=5", "cache;desc=hit"}, resp.HTTPHeader().Values("Server-Timing"))
+
+	// no cache.
+	yamlSpec = `
+name: proxy
+kind: Proxy
+serverTiming: true
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+`
+	proxy2 := newTestProxy(yamlSpec, assert)
+	defer proxy2.Close()
+	spCtx = newSPCtx()
+	spCtx.startTime = time.Now()
+	spCtx.backendStart = spCtx.startTime
+	spCtx.backendDuration = 12345 * time.Microsecond
+	proxy2.mainPool.addServerTiming(spCtx)
+	assert.Equal("queue;dur=0.000, backend;dur=12.345", spCtx.resp.HTTPHeader().Values("Server-Timing")[1])
+
+	// no response.
+	spCtx.resp = nil
+	proxy2.mainPool.addServerTiming(spCtx)
+
+	// disabled.
+	yamlSpec = `
+name: proxy
+kind: Proxy
+pools:
+- servers:
+  - url: http://127.0.0.1:9095
+  memoryCache:
+    expiration: 1m
+    maxEntryBytes: 100
+    codes: [200]
+    methods: [GET]
+`
+	proxy3 := newTestProxy(yamlSpec, assert)
+	defer proxy3.Close()
+	spCtx = newSPCtx()
+	proxy3.mainPool.memoryCache.Store(spCtx.req, spCtx.resp)
+	ctx = spCtx.Context
+	assert.Equal("", proxy3.Handle(ctx))
+	resp = ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal([]string{"db;dur=5"}, resp.HTTPHeader().Values("Server-Timing"))
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+)
+
+const (
+	defaultSlowStartMinWeightPercent = 10
+
+	// slowStartMaxAttempts is the max number of servers to choose for a
+	// request, the last one is used if none of them is accepted.
+	slowStartMaxAttempts = 3
+)
+
+// fnNow is replaced in tests.
+var fnNow = time.Now
+
+type (
+	// SlowStartSpec describes the slow start of servers newly added to the
+	// pool, e.g. servers back to the service registry after recovery. The
+	// effective weight of such a server ramps from MinWeightPercent to 100
+	// percent of its weight linearly in Duration.
+	SlowStartSpec struct {
+		Duration         string `yaml:"duration" jsonschema:"required,format=duration"`
+		MinWeightPercent int    `yaml:"minWeightPercent" jsonschema:"omitempty,minimum=1,maximum=100"`
+	}
+
+	// slowStartLoadBalancer wraps a load balancer, a server chosen by it
+	// is accepted with the probability of its effective weight ratio,
+	// otherwise another server is chosen.
+	slowStartLoadBalancer struct {
+		lb        LoadBalancer
+		duration  time.Duration
+		minFactor float64
+		// joined is the time the servers were added to the pool.
+		joined map[string]time.Time
+	}
+)
+
+// Validate validates SlowStartSpec.
+func (spec *SlowStartSpec) Validate() error {
+	d, err := time.ParseDuration(spec.Duration)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %v", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	if spec.MinWeightPercent < 0 || spec.MinWeightPercent > 100 {
+		return fmt.Errorf("minWeightPercent must be in [1, 100]")
+	}
+	return nil
+}
+
+func newSlowStartLoadBalancer(spec *SlowStartSpec, lb LoadBalancer, joined map[string]time.Time) *slowStartLoadBalancer {
+	d, _ := time.ParseDuration(spec.Duration)
+	minPercent := spec.MinWeightPercent
+	if minPercent == 0 {
+		minPercent = defaultSlowStartMinWeightPercent
+	}
+	return &slowStartLoadBalancer{
+		lb:        lb,
+		duration:  d,
+		minFactor: float64(minPercent) / 100,
+		joined:    joined,
+	}
+}
+
+// factor returns the ratio of the effective weight of the server to its
+// weight.
+func (lb *slowStartLoadBalancer) factor(svr *Server) float64 {
+	joined, ok := lb.joined[svr.URL]
+	if !ok {
+		return 1
+	}
+	elapsed := fnNow().Sub(joined)
+	if elapsed >= lb.duration {
+		return 1
+	}
+	f := float64(elapsed) / float64(lb.duration)
+	if f < lb.minFactor {
+		f = lb.minFactor
+	}
+	return f
+}
+
+// ChooseServer chooses a server for the request.
+func (lb *slowStartLoadBalancer) ChooseServer(req *httpprot.Request) *Server {
+	svr := lb.lb.ChooseServer(req)
+	for i := 1; i < slowStartMaxAttempts && svr != nil; i++ {
+		if rand.Float64() < lb.factor(svr) {
+			break
+		}
+		svr = lb.lb.ChooseServer(req)
+	}
+	return svr
+}
+
+// trackServers records the time the servers were added to the pool, the
+// servers of the first call are regarded as added long ago, so that they
+// don't slow start.
+func (sp *ServerPool) trackServers(servers []*Server) map[string]time.Time {
+	now := fnNow()
+	joined := make(map[string]time.Time, len(servers))
+	for _, s := range servers {
+		if t, ok := sp.serverJoined[s.URL]; ok {
+			joined[s.URL] = t
+		} else if sp.serverJoined == nil {
+			joined[s.URL] = time.Time{}
+		} else {
+			joined[s.URL] = now
+		}
+	}
+	sp.serverJoined = joined
+	return joined
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlowStartSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&SlowStartSpec{Duration: "30s"}).Validate())
+	assert.NoError((&SlowStartSpec{Duration: "30s", MinWeightPercent: 5}).Validate())
+	assert.Error((&SlowStartSpec{}).Validate())
+	assert.Error((&SlowStartSpec{Duration: "-1s"}).Validate())
+	assert.Error((&SlowStartSpec{Duration: "30s", MinWeightPercent: 101}).Validate())
+
+	spec := &ServerPoolSpec{
+		Servers:     prepareServers(2),
+		LoadBalance: &LoadBalanceSpec{SlowStart: &SlowStartSpec{Duration: "abc"}},
+	}
+	assert.Error(spec.Validate())
+}
+
+func TestSlowStart(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	fnNow = func() time.Time { return now }
+	defer func() { fnNow = time.Now }()
+
+	sp := &ServerPool{
+		spec: &ServerPoolSpec{
+			LoadBalance: &LoadBalanceSpec{
+				SlowStart: &SlowStartSpec{Duration: "100s"},
+			},
+		},
+		serverStats: map[string]*serverStat{},
+	}
+
+	servers := prepareServers(3)
+	req, _ := httpprot.NewRequest(&http.Request{Header: http.Header{}})
+
+	// count returns how many times the new server is chosen.
+	count := func() int {
+		n := 0
+		for i := 0; i < 30000; i++ {
+			if sp.LoadBalancer().ChooseServer(req) == servers[2] {
+				n++
+			}
+		}
+		return n
+	}
+
+	// the initial servers don't slow start.
+	sp.createLoadBalancer(servers[:2])
+	lb := sp.LoadBalancer().(*slowStartLoadBalancer)
+	assert.Equal(1.0, lb.factor(servers[0]))
+
+	// the new server recovers, it slow starts.
+	sp.createLoadBalancer(servers)
+	lb = sp.LoadBalancer().(*slowStartLoadBalancer)
+	assert.Equal(1.0, lb.factor(servers[0]))
+	assert.Equal(0.1, lb.factor(servers[2]))
+
+	last := 0
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, 60 * time.Second} {
+		now = now.Add(elapsed)
+		n := count()
+		assert.Greater(n, last)
+		assert.Less(n, 10000)
+		last = n
+	}
+
+	// full traffic after the duration.
+	now = now.Add(100 * time.Second)
+	assert.Equal(1.0, lb.factor(servers[2]))
+	assert.Equal(10000, count())
+
+	// the join time is kept when the servers are updated.
+	sp.createLoadBalancer(servers)
+	lb = sp.LoadBalancer().(*slowStartLoadBalancer)
+	assert.Equal(1.0, lb.factor(servers[2]))
+
+	// the server is removed and added again.
+	sp.createLoadBalancer(servers[:2])
+	sp.createLoadBalancer(servers)
+	lb = sp.LoadBalancer().(*slowStartLoadBalancer)
+	assert.Equal(0.1, lb.factor(servers[2]))
+}
@@ -18,6 +18,7 @@
 package requestadaptor
 
 import (
+	"compress/zlib"
 	"io"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -76,9 +77,40 @@ type (
 		Body       string                `yaml:"body" jsonschema:"omitempty"`
 		Compress   string                `yaml:"compress" jsonschema:"omitempty"`
 		Decompress string                `yaml:"decompress" jsonschema:"omitempty"`
+		// MaxDecompressedSize guards against decompression bombs, the
+		// default value is httpprot.DefaultMaxPayloadSize.
+		MaxDecompressedSize int64 `yaml:"maxDecompressedSize" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// limitedReader is like io.LimitedReader, but returns an error
+	// instead of io.EOF when the limit is exceeded.
+	limitedReader struct {
+		r io.ReadCloser
+		n int64
 	}
 )
 
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.n < 0 {
+		return 0, httpprot.ErrRequestEntityTooLarge
+	}
+
+	// Read one more byte, so that we know the limit is exceeded.
+	if int64(len(p)) > lr.n+1 {
+		p = p[:lr.n+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n + int(lr.n), httpprot.ErrRequestEntityTooLarge
+	}
+	return n, err
+}
+
+func (lr *limitedReader) Close() error {
+	return lr.r.Close()
+}
+
 // Name returns the name of the RequestAdaptor filter instance.
 func (ra *RequestAdaptor) Name() string {
 	return ra.spec.Name()
@@ -96,8 +128,8 @@ func (ra *RequestAdaptor) Spec() filters.Spec {
 
 // Init initializes RequestAdaptor.
 func (ra *RequestAdaptor) Init() {
-	if ra.spec.Decompress != "" && ra.spec.Decompress != "gzip" {
-		panic("RequestAdaptor only support decompress type of gzip")
+	if ra.spec.Decompress != "" && ra.spec.Decompress != "gzip" && ra.spec.Decompress != "deflate" {
+		panic("RequestAdaptor only support decompress type of gzip and deflate")
 	}
 	if ra.spec.Compress != "" && ra.spec.Compress != "gzip" {
 		panic("RequestAdaptor only support decompress type of gzip")
@@ -208,20 +240,32 @@ func (ra *RequestAdaptor) processCompress(req *httpprot.Request) string {
 
 func (ra *RequestAdaptor) processDecompress(req *httpprot.Request) string {
 	encoding := req.HTTPHeader().Get("Content-Encoding")
-	if ra.spec.Decompress != "gzip" || encoding != "gzip" {
+	if ra.spec.Decompress != encoding {
 		return ""
 	}
 
-	zr, err := readers.NewGZipDecompressReader(req.GetPayload())
+	var zr io.ReadCloser
+	var err error
+	if encoding == "gzip" {
+		zr, err = readers.NewGZipDecompressReader(req.GetPayload())
+	} else {
+		zr, err = zlib.NewReader(req.GetPayload())
+	}
 	if err != nil {
 		return resultDecompressFailed
 	}
 
+	maxSize := ra.spec.MaxDecompressedSize
+	if maxSize == 0 {
+		maxSize = httpprot.DefaultMaxPayloadSize
+	}
+	lr := &limitedReader{r: zr, n: maxSize}
+
 	if req.IsStream() {
-		req.SetPayload(zr)
+		req.SetPayload(lr)
 	} else {
-		data, err := io.ReadAll(zr)
-		zr.Close()
+		data, err := io.ReadAll(lr)
+		lr.Close()
 		if err != nil {
 			logger.Errorf("decompress request body failed, %v", err)
 			return resultDecompressFailed
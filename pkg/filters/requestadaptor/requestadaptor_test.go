@@ -20,15 +20,18 @@ package requestadaptor
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"io"
 	"net/http"
 	"testing"
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/filters/headertojson"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/pathadaptor"
 	"github.com/stretchr/testify/assert"
 )
@@ -163,6 +166,119 @@ func TestDecompress(t *testing.T) {
 	}
 }
 
+func TestDecompressIntoHeaderToJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	h2jSpec, err := filters.NewSpec(nil, "pipeline-demo", &headertojson.Spec{
+		BaseSpec: filters.BaseSpec{MetaSpec: supervisor.MetaSpec{
+			Kind: headertojson.Kind,
+			Name: "header-to-json",
+		}},
+		HeaderMap: []*headertojson.HeaderMap{{Header: "X-User", JSON: "user"}},
+	})
+	assert.Nil(err)
+	h2j := filters.Create(h2jSpec)
+	h2j.Init()
+	defer h2j.Close()
+
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+	}
+
+	for encoding, newWriter := range encoders {
+		ra := kind.CreateInstance(defaultFilterSpec(&Spec{Decompress: encoding}))
+		ra.Init()
+
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(`{"id": 1}`))
+		w.Close()
+
+		req, err := http.NewRequest(http.MethodPost, "127.0.0.1", &buf)
+		assert.Nil(err)
+		req.Header.Set("Content-Encoding", encoding)
+		req.Header.Set("X-User", "megaease")
+
+		ctx := context.New(nil)
+		setRequest(t, ctx, req)
+
+		assert.Equal("", ra.Handle(ctx))
+		assert.Equal("", h2j.Handle(ctx))
+		assert.Equal("", ctx.GetInputRequest().Header().Get("Content-Encoding"))
+
+		body, err := io.ReadAll(ctx.GetInputRequest().GetPayload())
+		assert.Nil(err)
+		assert.JSONEq(`{"id": 1, "user": "megaease"}`, string(body), encoding)
+	}
+
+	// the request is not decompressed if the encoding is not the configured one
+	ra := kind.CreateInstance(defaultFilterSpec(&Spec{Decompress: "deflate"}))
+	ra.Init()
+	req, err := http.NewRequest(http.MethodPost, "127.0.0.1", getGzipEncoding(t, []byte("123")))
+	assert.Nil(err)
+	req.Header.Set("Content-Encoding", "gzip")
+	ctx := context.New(nil)
+	setRequest(t, ctx, req)
+	assert.Equal("", ra.Handle(ctx))
+	assert.Equal("gzip", ctx.GetInputRequest().Header().Get("Content-Encoding"))
+}
+
+func TestDecompressionBomb(t *testing.T) {
+	assert := assert.New(t)
+
+	data := bytes.Repeat([]byte("a"), 100*1024)
+
+	ra := kind.CreateInstance(defaultFilterSpec(&Spec{
+		Decompress:          "gzip",
+		MaxDecompressedSize: 1024,
+	}))
+	ra.Init()
+
+	req, err := http.NewRequest(http.MethodPost, "127.0.0.1", getGzipEncoding(t, data))
+	assert.Nil(err)
+	req.Header.Set("Content-Encoding", "gzip")
+	ctx := context.New(nil)
+	setRequest(t, ctx, req)
+	assert.Equal(resultDecompressFailed, ra.Handle(ctx))
+
+	// exactly the limit
+	ra = kind.CreateInstance(defaultFilterSpec(&Spec{
+		Decompress:          "gzip",
+		MaxDecompressedSize: int64(len(data)),
+	}))
+	ra.Init()
+
+	req, err = http.NewRequest(http.MethodPost, "127.0.0.1", getGzipEncoding(t, data))
+	assert.Nil(err)
+	req.Header.Set("Content-Encoding", "gzip")
+	ctx = context.New(nil)
+	setRequest(t, ctx, req)
+	assert.Equal("", ra.Handle(ctx))
+	body, err := io.ReadAll(ctx.GetInputRequest().GetPayload())
+	assert.Nil(err)
+	assert.Equal(data, body)
+
+	// stream
+	ra = kind.CreateInstance(defaultFilterSpec(&Spec{
+		Decompress:          "gzip",
+		MaxDecompressedSize: 1024,
+	}))
+	ra.Init()
+
+	stdr, err := http.NewRequest(http.MethodPost, "127.0.0.1", getGzipEncoding(t, data))
+	assert.Nil(err)
+	stdr.Header.Set("Content-Encoding", "gzip")
+	req2, _ := httpprot.NewRequest(stdr)
+	assert.Nil(req2.FetchPayload(-1))
+	ctx = context.New(nil)
+	ctx.SetInputRequest(req2)
+	assert.Equal("", ra.Handle(ctx))
+	body, err = io.ReadAll(ctx.GetInputRequest().GetPayload())
+	assert.Equal(httpprot.ErrRequestEntityTooLarge, err)
+	assert.Equal(1024, len(body))
+}
+
 func TestCompress(t *testing.T) {
 	assert := assert.New(t)
 
@@ -0,0 +1,161 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestbodylimiter
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+)
+
+const (
+	// Kind is the kind of RequestBodyLimiter.
+	Kind = "RequestBodyLimiter"
+
+	resultBodyTooLarge = "bodyTooLarge"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "RequestBodyLimiter rejects requests whose body is larger than a limit.",
+	Results:     []string{resultBodyTooLarge},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &RequestBodyLimiter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// RequestBodyLimiter is filter RequestBodyLimiter.
+	RequestBodyLimiter struct {
+		spec *Spec
+	}
+
+	// Spec describes the RequestBodyLimiter.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		MaxBodySize int64 `yaml:"maxBodySize" jsonschema:"required,minimum=1"`
+	}
+
+	// limitedReader is like io.LimitedReader, but returns an error
+	// instead of io.EOF when the limit is exceeded.
+	limitedReader struct {
+		r io.Reader
+		n int64
+	}
+)
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if lr.n < 0 {
+		return 0, httpprot.ErrRequestEntityTooLarge
+	}
+
+	// Read one more byte, so that we know the limit is exceeded.
+	if int64(len(p)) > lr.n+1 {
+		p = p[:lr.n+1]
+	}
+	n, err := lr.r.Read(p)
+	lr.n -= int64(n)
+	if lr.n < 0 {
+		return n + int(lr.n), httpprot.ErrRequestEntityTooLarge
+	}
+	return n, err
+}
+
+func (lr *limitedReader) Close() error {
+	if c, ok := lr.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Name returns the name of the RequestBodyLimiter filter instance.
+func (rbl *RequestBodyLimiter) Name() string {
+	return rbl.spec.Name()
+}
+
+// Kind returns the kind of RequestBodyLimiter.
+func (rbl *RequestBodyLimiter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the RequestBodyLimiter
+func (rbl *RequestBodyLimiter) Spec() filters.Spec {
+	return rbl.spec
+}
+
+// Init initializes RequestBodyLimiter.
+func (rbl *RequestBodyLimiter) Init() {
+}
+
+// Inherit inherits previous generation of RequestBodyLimiter.
+func (rbl *RequestBodyLimiter) Inherit(previousGeneration filters.Filter) {
+	rbl.Init()
+}
+
+// Handle limits the body size of the request.
+//
+// A buffered body is checked directly. A stream body is rejected at once
+// if its Content-Length exceeds the limit, otherwise, for example, a
+// chunked body, it is wrapped with a reader which fails with
+// httpprot.ErrRequestEntityTooLarge once the limit is exceeded, and the
+// filter reading the body, such as Proxy, responds 413 in this case.
+func (rbl *RequestBodyLimiter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	maxSize := rbl.spec.MaxBodySize
+
+	if !req.IsStream() {
+		if int64(len(req.RawPayload())) > maxSize {
+			return rbl.reject(ctx)
+		}
+		return ""
+	}
+
+	if req.Std().ContentLength > maxSize {
+		return rbl.reject(ctx)
+	}
+	req.SetPayload(&limitedReader{r: req.GetPayload(), n: maxSize})
+	return ""
+}
+
+func (rbl *RequestBodyLimiter) reject(ctx *context.Context) string {
+	resp, _ := httpprot.NewResponse(nil)
+	ctx.SetOutputResponse(resp)
+	ctx.AddTag("requestBodyLimiter: request entity too large")
+	resp.SetStatusCode(http.StatusRequestEntityTooLarge)
+	return resultBodyTooLarge
+}
+
+// Status returns Status.
+func (rbl *RequestBodyLimiter) Status() interface{} {
+	return nil
+}
+
+// Close closes RequestBodyLimiter.
+func (rbl *RequestBodyLimiter) Close() {
+}
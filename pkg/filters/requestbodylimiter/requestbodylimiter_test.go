@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestbodylimiter
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequestBodyLimiter(t *testing.T) *RequestBodyLimiter {
+	const yamlSpec = `
+kind: RequestBodyLimiter
+name: limiter
+maxBodySize: 10
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rbl := kind.CreateInstance(spec).(*RequestBodyLimiter)
+	rbl.Init()
+	return rbl
+}
+
+// newContext creates a context whose request has the body, the body is
+// chunked if contentLength is -1, and is a stream if stream is true.
+func newContext(body string, contentLength int64, stream bool) (*context.Context, *httpprot.Request) {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1/", io.NopCloser(strings.NewReader(body)))
+	stdr.ContentLength = contentLength
+
+	req, _ := httpprot.NewRequest(stdr)
+	if stream {
+		req.FetchPayload(-1)
+	} else {
+		req.FetchPayload(0)
+	}
+
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	return ctx, req
+}
+
+func TestSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	rawSpec := map[string]interface{}{
+		"kind":        Kind,
+		"name":        "limiter",
+		"maxBodySize": 0,
+	}
+	_, err := filters.NewSpec(nil, "", rawSpec)
+	assert.Error(err)
+
+	rbl := newRequestBodyLimiter(t)
+	assert.Equal("limiter", rbl.Name())
+	assert.Equal(kind, rbl.Kind())
+	assert.Nil(rbl.Status())
+
+	newRbl := kind.CreateInstance(rbl.Spec()).(*RequestBodyLimiter)
+	newRbl.Inherit(rbl)
+	rbl.Close()
+	newRbl.Close()
+}
+
+func TestBufferedBody(t *testing.T) {
+	assert := assert.New(t)
+	rbl := newRequestBodyLimiter(t)
+
+	for _, chunked := range []bool{false, true} {
+		cl := func(body string) int64 {
+			if chunked {
+				return -1
+			}
+			return int64(len(body))
+		}
+
+		ctx, req := newContext("0123456789", cl("0123456789"), false)
+		assert.Equal("", rbl.Handle(ctx))
+		assert.Equal("0123456789", string(req.RawPayload()))
+		assert.Nil(ctx.GetOutputResponse())
+
+		ctx, _ = newContext("0123456789a", cl("0123456789a"), false)
+		assert.Equal(resultBodyTooLarge, rbl.Handle(ctx))
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+	}
+}
+
+func TestStreamBody(t *testing.T) {
+	assert := assert.New(t)
+	rbl := newRequestBodyLimiter(t)
+
+	// the Content-Length is known.
+	ctx, req := newContext("0123456789", 10, true)
+	assert.Equal("", rbl.Handle(ctx))
+	data, err := io.ReadAll(req.GetPayload())
+	assert.NoError(err)
+	assert.Equal("0123456789", string(data))
+
+	ctx, _ = newContext("0123456789a", 11, true)
+	assert.Equal(resultBodyTooLarge, rbl.Handle(ctx))
+	resp := ctx.GetOutputResponse().(*httpprot.Response)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode())
+
+	// chunked bodies are checked while reading.
+	ctx, req = newContext("0123456789", -1, true)
+	assert.Equal("", rbl.Handle(ctx))
+	assert.True(req.IsStream())
+	data, err = io.ReadAll(req.GetPayload())
+	assert.NoError(err)
+	assert.Equal("0123456789", string(data))
+
+	ctx, req = newContext(strings.Repeat("0123456789", 100), -1, true)
+	assert.Equal("", rbl.Handle(ctx))
+	data, err = io.ReadAll(req.GetPayload())
+	assert.Equal(httpprot.ErrRequestEntityTooLarge, err)
+	assert.Equal("0123456789", string(data))
+	req.Close()
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestid
+
+import (
+	"github.com/google/uuid"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of RequestID.
+	Kind = "RequestID"
+
+	// DataKey is the key of the request ID in the data of the context.
+	DataKey = "requestID"
+
+	defaultHeaderName = "X-Request-Id"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "RequestID ensures every request has a request ID.",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{HeaderName: defaultHeaderName}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &RequestID{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// RequestID is filter RequestID.
+	RequestID struct {
+		spec *Spec
+	}
+
+	// Spec describes the RequestID.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		HeaderName string `yaml:"headerName" jsonschema:"omitempty"`
+		Overwrite  bool   `yaml:"overwrite" jsonschema:"omitempty"`
+	}
+)
+
+// Name returns the name of the RequestID filter instance.
+func (ri *RequestID) Name() string {
+	return ri.spec.Name()
+}
+
+// Kind returns the kind of RequestID.
+func (ri *RequestID) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the RequestID
+func (ri *RequestID) Spec() filters.Spec {
+	return ri.spec
+}
+
+// Init initializes RequestID.
+func (ri *RequestID) Init() {
+}
+
+// Inherit inherits previous generation of RequestID.
+func (ri *RequestID) Inherit(previousGeneration filters.Filter) {
+	ri.Init()
+}
+
+func (ri *RequestID) headerName() string {
+	if ri.spec.HeaderName == "" {
+		return defaultHeaderName
+	}
+	return ri.spec.HeaderName
+}
+
+// Handle sets the request ID to the request, and to the response if
+// there's already one, e.g. the filter is placed after a Proxy.
+//
+// The ID is taken from the request header, or generated if it is absent
+// or overwrite is enabled. It is saved in the data of the context, so
+// the ID is the same even the filter is placed more than once in a
+// pipeline.
+func (ri *RequestID) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	name := ri.headerName()
+
+	id, _ := ctx.GetData(DataKey).(string)
+	if id == "" {
+		id = req.HTTPHeader().Get(name)
+		if id == "" || ri.spec.Overwrite {
+			id = uuid.NewString()
+		}
+		ctx.SetData(DataKey, id)
+		ctx.AddTag(stringtool.Cat("requestID: ", id))
+		ctx.Span().Tag("request.id", id)
+	}
+
+	req.HTTPHeader().Set(name, id)
+	if resp, ok := ctx.GetOutputResponse().(*httpprot.Response); ok {
+		resp.HTTPHeader().Set(name, id)
+	}
+
+	return ""
+}
+
+// Status returns Status.
+func (ri *RequestID) Status() interface{} {
+	return nil
+}
+
+// Close closes RequestID.
+func (ri *RequestID) Close() {
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestid
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequestID(t *testing.T, yamlSpec string) *RequestID {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ri := kind.CreateInstance(spec).(*RequestID)
+	ri.Init()
+	return ri
+}
+
+func newContext(id string) (*context.Context, *httpprot.Request) {
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	if id != "" {
+		stdr.Header.Set("X-Request-Id", id)
+	}
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+	return ctx, req
+}
+
+func TestRequestID(t *testing.T) {
+	assert := assert.New(t)
+
+	ri := newRequestID(t, `
+kind: RequestID
+name: request-id
+`)
+	assert.Equal("request-id", ri.Name())
+	assert.Equal(kind, ri.Kind())
+	assert.Nil(ri.Status())
+
+	t.Run("generate", func(t *testing.T) {
+		ctx, req := newContext("")
+		assert.Equal("", ri.Handle(ctx))
+
+		id := req.HTTPHeader().Get("X-Request-Id")
+		_, err := uuid.Parse(id)
+		assert.NoError(err)
+		assert.Equal(id, ctx.GetData(DataKey))
+		assert.Contains(ctx.Tags(), id)
+
+		// the ID is echoed by the filter placed after the response is
+		// generated, and is not generated again.
+		resp, _ := httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+		assert.Equal("", ri.Handle(ctx))
+		assert.Equal(id, req.HTTPHeader().Get("X-Request-Id"))
+		assert.Equal(id, resp.HTTPHeader().Get("X-Request-Id"))
+
+		// different requests get different IDs.
+		ctx2, req2 := newContext("")
+		ri.Handle(ctx2)
+		assert.NotEqual(id, req2.HTTPHeader().Get("X-Request-Id"))
+	})
+
+	t.Run("pass through", func(t *testing.T) {
+		ctx, req := newContext("client-id")
+		resp, _ := httpprot.NewResponse(nil)
+		ctx.SetOutputResponse(resp)
+
+		assert.Equal("", ri.Handle(ctx))
+		assert.Equal("client-id", req.HTTPHeader().Get("X-Request-Id"))
+		assert.Equal("client-id", resp.HTTPHeader().Get("X-Request-Id"))
+		assert.Equal("client-id", ctx.GetData(DataKey))
+	})
+}
+
+func TestRequestIDOverwrite(t *testing.T) {
+	assert := assert.New(t)
+
+	ri := newRequestID(t, `
+kind: RequestID
+name: request-id
+headerName: X-Correlation-Id
+overwrite: true
+`)
+
+	stdr, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+	stdr.Header.Set("X-Correlation-Id", "client-id")
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetRequest(context.DefaultNamespace, req)
+
+	assert.Equal("", ri.Handle(ctx))
+	id := req.HTTPHeader().Get("X-Correlation-Id")
+	assert.NotEqual("client-id", id)
+	assert.Equal(id, ctx.GetData(DataKey))
+
+	// the overwritten ID is kept by the filter placed after the response
+	// is generated.
+	resp, _ := httpprot.NewResponse(nil)
+	ctx.SetOutputResponse(resp)
+	assert.Equal("", ri.Handle(ctx))
+	assert.Equal(id, req.HTTPHeader().Get("X-Correlation-Id"))
+	assert.Equal(id, resp.HTTPHeader().Get("X-Correlation-Id"))
+
+	newRi := kind.CreateInstance(ri.Spec()).(*RequestID)
+	newRi.Inherit(ri)
+	ri.Close()
+	newRi.Close()
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statuscodemapper
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+)
+
+const (
+	// Kind is the kind of StatusCodeMapper.
+	Kind = "StatusCodeMapper"
+
+	resultResponseNotFound = "responseNotFound"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "StatusCodeMapper maps the status code of responses.",
+	Results:     []string{resultResponseNotFound},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &StatusCodeMapper{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// StatusCodeMapper is filter StatusCodeMapper.
+	StatusCodeMapper struct {
+		spec *Spec
+	}
+
+	// Spec describes the StatusCodeMapper.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Mappings []*Mapping `yaml:"mappings" jsonschema:"required"`
+	}
+
+	// Mapping maps the status codes matching Codes or CodeRanges to
+	// StatusCode, and optionally adapts the header and replaces the body.
+	Mapping struct {
+		Codes      []int                 `yaml:"codes,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		CodeRanges []*CodeRange          `yaml:"codeRanges,omitempty" jsonschema:"omitempty"`
+		StatusCode int                   `yaml:"statusCode" jsonschema:"required,format=httpcode"`
+		Header     *httpheader.AdaptSpec `yaml:"header,omitempty" jsonschema:"omitempty"`
+		Body       string                `yaml:"body,omitempty" jsonschema:"omitempty"`
+	}
+
+	// CodeRange is a range of status codes, both ends are included.
+	CodeRange struct {
+		Min int `yaml:"min" jsonschema:"required,format=httpcode"`
+		Max int `yaml:"max" jsonschema:"required,format=httpcode"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for i, m := range spec.Mappings {
+		if len(m.Codes) == 0 && len(m.CodeRanges) == 0 {
+			return fmt.Errorf("mapping %d: both codes and codeRanges are empty", i)
+		}
+		for _, r := range m.CodeRanges {
+			if r.Min > r.Max {
+				return fmt.Errorf("mapping %d: min %d of code range is greater than max %d", i, r.Min, r.Max)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Mapping) match(code int) bool {
+	for _, c := range m.Codes {
+		if c == code {
+			return true
+		}
+	}
+	for _, r := range m.CodeRanges {
+		if code >= r.Min && code <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// Name returns the name of the StatusCodeMapper filter instance.
+func (scm *StatusCodeMapper) Name() string {
+	return scm.spec.Name()
+}
+
+// Kind returns the kind of StatusCodeMapper.
+func (scm *StatusCodeMapper) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the StatusCodeMapper
+func (scm *StatusCodeMapper) Spec() filters.Spec {
+	return scm.spec
+}
+
+// Init initializes StatusCodeMapper.
+func (scm *StatusCodeMapper) Init() {
+}
+
+// Inherit inherits previous generation of StatusCodeMapper.
+func (scm *StatusCodeMapper) Inherit(previousGeneration filters.Filter) {
+	scm.Init()
+}
+
+// Handle maps the status code of the response by the first matched
+// mapping, the response is left untouched if no mapping matches.
+func (scm *StatusCodeMapper) Handle(ctx *context.Context) string {
+	r := ctx.GetInputResponse()
+	if r == nil {
+		return resultResponseNotFound
+	}
+	resp := r.(*httpprot.Response)
+
+	for _, m := range scm.spec.Mappings {
+		if m.match(resp.StatusCode()) {
+			scm.apply(ctx, resp, m)
+			break
+		}
+	}
+
+	return ""
+}
+
+func (scm *StatusCodeMapper) apply(ctx *context.Context, resp *httpprot.Response, m *Mapping) {
+	ctx.AddTag(fmt.Sprintf("statusCodeMapper: %d mapped to %d", resp.StatusCode(), m.StatusCode))
+	resp.SetStatusCode(m.StatusCode)
+
+	if m.Header != nil {
+		h := resp.HTTPHeader()
+		for _, key := range m.Header.Del {
+			h.Del(key)
+		}
+		for key, value := range m.Header.Set {
+			h.Set(key, value)
+		}
+		for key, value := range m.Header.Add {
+			h.Add(key, value)
+		}
+	}
+
+	if m.Body != "" {
+		if resp.IsStream() {
+			if c, ok := resp.GetPayload().(io.Closer); ok {
+				c.Close()
+			}
+		}
+		resp.SetPayload([]byte(m.Body))
+		resp.HTTPHeader().Del("Content-Encoding")
+		resp.HTTPHeader().Set("Content-Length", strconv.Itoa(len(m.Body)))
+	}
+}
+
+// Status returns Status.
+func (scm *StatusCodeMapper) Status() interface{} {
+	return nil
+}
+
+// Close closes StatusCodeMapper.
+func (scm *StatusCodeMapper) Close() {
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package statuscodemapper
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlSpec = `
+kind: StatusCodeMapper
+name: mapper
+mappings:
+- codes: [418]
+  statusCode: 400
+- codeRanges:
+  - min: 500
+    max: 502
+  - min: 504
+    max: 599
+  statusCode: 503
+  header:
+    set:
+      Content-Type: application/json
+  body: '{"error": "service unavailable"}'
+`
+
+func newStatusCodeMapper(t *testing.T, yamlSpec string) (*StatusCodeMapper, error) {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	scm := kind.CreateInstance(spec).(*StatusCodeMapper)
+	scm.Init()
+	return scm, nil
+}
+
+func newContext(code int, stream bool) (*context.Context, *httpprot.Response) {
+	ctx := context.New(tracing.NoopSpan)
+	resp, _ := httpprot.NewResponse(&http.Response{
+		StatusCode:    code,
+		Header:        http.Header{"Content-Length": []string{"7"}, "Content-Encoding": []string{"gzip"}},
+		Body:          io.NopCloser(strings.NewReader("backend")),
+		ContentLength: 7,
+	})
+	if stream {
+		resp.FetchPayload(-1)
+	} else {
+		resp.FetchPayload(0)
+	}
+	ctx.SetInputResponse(resp)
+	return ctx, resp
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newStatusCodeMapper(t, `
+kind: StatusCodeMapper
+name: mapper
+mappings:
+- statusCode: 400
+`)
+	assert.Error(err)
+
+	_, err = newStatusCodeMapper(t, `
+kind: StatusCodeMapper
+name: mapper
+mappings:
+- codeRanges:
+  - min: 599
+    max: 500
+  statusCode: 503
+`)
+	assert.Error(err)
+
+	scm, err := newStatusCodeMapper(t, yamlSpec)
+	assert.NoError(err)
+	assert.Equal("mapper", scm.Name())
+	assert.Equal(kind, scm.Kind())
+	assert.Nil(scm.Status())
+
+	newScm := kind.CreateInstance(scm.Spec())
+	newScm.Inherit(scm)
+	scm.Close()
+	newScm.Close()
+}
+
+func TestStatusCodeMapper(t *testing.T) {
+	assert := assert.New(t)
+
+	scm, err := newStatusCodeMapper(t, yamlSpec)
+	assert.NoError(err)
+
+	t.Run("no response", func(t *testing.T) {
+		assert.Equal(resultResponseNotFound, scm.Handle(context.New(tracing.NoopSpan)))
+	})
+
+	t.Run("exact code", func(t *testing.T) {
+		ctx, resp := newContext(418, false)
+		assert.Equal("", scm.Handle(ctx))
+		assert.Equal(400, resp.StatusCode())
+		assert.Equal("backend", string(resp.RawPayload()))
+		assert.Equal("gzip", resp.HTTPHeader().Get("Content-Encoding"))
+	})
+
+	t.Run("unmatched", func(t *testing.T) {
+		for _, code := range []int{200, 404, 503} {
+			ctx, resp := newContext(code, false)
+			assert.Equal("", scm.Handle(ctx))
+			assert.Equal(code, resp.StatusCode())
+			assert.Equal("backend", string(resp.RawPayload()))
+			assert.Equal("", resp.HTTPHeader().Get("Content-Type"))
+		}
+	})
+
+	t.Run("range and body replacement", func(t *testing.T) {
+		body := `{"error": "service unavailable"}`
+		for _, code := range []int{500, 502, 504, 599} {
+			for _, stream := range []bool{false, true} {
+				ctx, resp := newContext(code, stream)
+				assert.Equal("", scm.Handle(ctx))
+				assert.Equal(503, resp.StatusCode())
+				assert.False(resp.IsStream())
+				assert.Equal(body, string(resp.RawPayload()))
+
+				h := resp.HTTPHeader()
+				assert.Equal("application/json", h.Get("Content-Type"))
+				assert.Equal("", h.Get("Content-Encoding"))
+				assert.Equal("32", h.Get("Content-Length"))
+			}
+		}
+	})
+}
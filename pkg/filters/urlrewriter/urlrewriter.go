@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package urlrewriter implements a filter to rewrite the path and query
+// of requests by regular expressions.
+package urlrewriter
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of URLRewriter.
+	Kind = "URLRewriter"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "URLRewriter rewrites the path and query of the request",
+	Results:     []string{},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &URLRewriter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// URLRewriter is the filter to rewrite the URL of requests.
+	URLRewriter struct {
+		spec  *Spec
+		rules []*rule
+	}
+
+	// Spec describes the URLRewriter.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Rules []*RuleSpec `yaml:"rules" jsonschema:"required,minItems=1"`
+	}
+
+	// RuleSpec describes a rewrite rule. Regexp is matched against the
+	// path of the request, and the first match is replaced by Replace,
+	// which could refer to the capture groups like '$1' or '${name}', an
+	// empty Replace removes the matched part and '$0' keeps it. If the
+	// result contains a '?', the part after it is merged into the query
+	// of the request.
+	RuleSpec struct {
+		Regexp  string     `yaml:"regexp" jsonschema:"required,format=regexp"`
+		Replace string     `yaml:"replace" jsonschema:"omitempty"`
+		Query   *QuerySpec `yaml:"query,omitempty" jsonschema:"omitempty"`
+	}
+
+	// QuerySpec describes the adaption of the query of the request, the
+	// values of set and add could refer to the capture groups too.
+	QuerySpec struct {
+		Del []string          `yaml:"del" jsonschema:"omitempty,uniqueItems=true"`
+		Set map[string]string `yaml:"set" jsonschema:"omitempty"`
+		Add map[string]string `yaml:"add" jsonschema:"omitempty"`
+	}
+
+	rule struct {
+		spec *RuleSpec
+		re   *regexp.Regexp
+	}
+)
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	for i, r := range spec.Rules {
+		if _, err := regexp.Compile(r.Regexp); err != nil {
+			return fmt.Errorf("rule %d: invalid regexp: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the URLRewriter filter instance.
+func (ur *URLRewriter) Name() string {
+	return ur.spec.Name()
+}
+
+// Kind returns the kind of URLRewriter.
+func (ur *URLRewriter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the URLRewriter.
+func (ur *URLRewriter) Spec() filters.Spec {
+	return ur.spec
+}
+
+// Init initializes URLRewriter.
+func (ur *URLRewriter) Init() {
+	ur.reload()
+}
+
+// Inherit inherits previous generation of URLRewriter.
+func (ur *URLRewriter) Inherit(previousGeneration filters.Filter) {
+	ur.reload()
+}
+
+func (ur *URLRewriter) reload() {
+	ur.rules = nil
+	for _, r := range ur.spec.Rules {
+		ur.rules = append(ur.rules, &rule{
+			spec: r,
+			re:   regexp.MustCompile(r.Regexp),
+		})
+	}
+}
+
+// Handle rewrites the URL of the request by the first matched rule, the
+// request is not changed if no rule matches.
+func (ur *URLRewriter) Handle(ctx *context.Context) string {
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	path := req.Path()
+
+	for _, r := range ur.rules {
+		match := r.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+
+		oldURI := req.URL().RequestURI()
+		r.rewrite(req, match)
+		newURI := req.URL().RequestURI()
+		if oldURI != newURI {
+			ctx.AddTag(stringtool.Cat("urlRewriter: ", oldURI, " rewritten to ", newURI))
+		}
+		break
+	}
+
+	return ""
+}
+
+// rewrite rewrites the URL of req, match is the indexes of the first
+// match of the rule in the path.
+func (r *rule) rewrite(req *httpprot.Request, match []int) {
+	path := req.Path()
+	expand := func(template string) string {
+		return string(r.re.ExpandString(nil, template, path, match))
+	}
+
+	u := req.URL()
+	query := u.Query()
+	queryChanged := false
+
+	// only the first match is replaced, like the query templates.
+	newPath := path[:match[0]] + expand(r.spec.Replace) + path[match[1]:]
+	if i := strings.IndexByte(newPath, '?'); i >= 0 {
+		values, _ := url.ParseQuery(newPath[i+1:])
+		for key, value := range values {
+			query[key] = value
+		}
+		queryChanged = len(values) > 0
+		newPath = newPath[:i]
+	}
+	if !strings.HasPrefix(newPath, "/") {
+		newPath = "/" + newPath
+	}
+	req.SetPath(newPath)
+
+	if qs := r.spec.Query; qs != nil {
+		for _, key := range qs.Del {
+			query.Del(key)
+		}
+		for key, value := range qs.Set {
+			query.Set(key, expand(value))
+		}
+		for key, value := range qs.Add {
+			query.Add(key, expand(value))
+		}
+		queryChanged = true
+	}
+
+	if queryChanged {
+		u.RawQuery = query.Encode()
+	}
+}
+
+// Status returns the status of URLRewriter.
+func (ur *URLRewriter) Status() interface{} {
+	return nil
+}
+
+// Close closes URLRewriter.
+func (ur *URLRewriter) Close() {
+}
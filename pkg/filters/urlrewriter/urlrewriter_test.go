@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package urlrewriter
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+func newTestURLRewriter(yamlSpec string, assert *assert.Assertions) *URLRewriter {
+	rawSpec := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(yamlSpec), &rawSpec)
+	assert.NoError(err)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+
+	ur := kind.CreateInstance(spec).(*URLRewriter)
+	ur.Init()
+	return ur
+}
+
+func rewrite(ur *URLRewriter, url string) string {
+	stdr, _ := http.NewRequest(http.MethodGet, url, nil)
+	req, _ := httpprot.NewRequest(stdr)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetInputRequest(req)
+	ur.Handle(ctx)
+	return req.URL().RequestURI()
+}
+
+func TestURLRewriter(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: URLRewriter
+name: rewriter
+rules:
+- regexp: ^/api/v(\d+)/(.*)$
+  replace: /$2?ver=$1
+- regexp: ^/api
+  replace: ""
+  query:
+    del: [debug]
+- regexp: ^/users/(?P<id>\d+)$
+  replace: /profile
+  query:
+    set:
+      uid: ${id}
+    add:
+      tag: user
+- regexp: ^/search$
+  replace: $0
+  query:
+    del: [debug]
+- regexp: ^/api/.*$
+  replace: /unreachable
+- regexp: v1
+  replace: v2
+`
+	ur := newTestURLRewriter(yamlSpec, assert)
+	defer ur.Close()
+	assert.Equal(kind, ur.Kind())
+	assert.Equal("rewriter", ur.Name())
+	assert.Nil(ur.Status())
+
+	// capture groups, the query is merged.
+	assert.Equal("/x/y?a=1&ver=1", rewrite(ur, "http://megaease.com/api/v1/x/y?a=1"))
+	assert.Equal("/x?ver=2", rewrite(ur, "http://megaease.com/api/v2/x?ver=1"))
+
+	// prefix strip.
+	assert.Equal("/abc?a=1", rewrite(ur, "http://megaease.com/api/abc?a=1&debug=true"))
+	assert.Equal("/", rewrite(ur, "http://megaease.com/api"))
+
+	// named capture groups in query values.
+	assert.Equal("/profile?tag=a&tag=user&uid=42", rewrite(ur, "http://megaease.com/users/42?uid=1&tag=a"))
+
+	// the path is kept.
+	assert.Equal("/search?q=easegress", rewrite(ur, "http://megaease.com/search?q=easegress&debug=1"))
+
+	// only the first match is replaced.
+	assert.Equal("/v2/a/v1", rewrite(ur, "http://megaease.com/v1/a/v1"))
+
+	// no match, the request is not changed.
+	assert.Equal("/other/path?b=2&a=1", rewrite(ur, "http://megaease.com/other/path?b=2&a=1"))
+}
+
+func TestSpecValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &Spec{Rules: []*RuleSpec{{Regexp: "^/a"}}}
+	assert.NoError(spec.Validate())
+
+	spec = &Spec{Rules: []*RuleSpec{{Regexp: "^/a("}}}
+	assert.Error(spec.Validate())
+}
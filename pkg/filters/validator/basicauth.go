@@ -23,6 +23,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -117,6 +118,21 @@ func (cred *etcdCredentials) Password() string {
 	return cred.Pass
 }
 
+// hashPrefixes are the password hash formats recognized in htpasswd files,
+// passwords without any of them are treated as plain text.
+var hashPrefixes = []string{"$2a$", "$2b$", "$2y$", "$apr1$", "$1$", "$5$", "$6$", "{SHA}", "{SSHA}"}
+
+// IsPasswordHashed returns whether the password in the credentials is
+// hashed in one of the formats recognized in htpasswd files.
+func IsPasswordHashed(pass string) bool {
+	for _, p := range hashPrefixes {
+		if strings.HasPrefix(pass, p) {
+			return true
+		}
+	}
+	return false
+}
+
 func parseCredentials(creds string) (string, string, error) {
 	parts := strings.Split(creds, ":")
 	if len(parts) < 2 {
@@ -156,37 +172,62 @@ func newHtpasswdUserCache(userFile string, syncInterval time.Duration) *htpasswd
 	}
 }
 
+func (huc *htpasswdUserCache) reload() {
+	err := huc.userFileObject.Reload(nil)
+	if err != nil {
+		logger.Errorf(err.Error())
+	}
+}
+
 func (huc *htpasswdUserCache) WatchChanges() {
-	if huc.userFileObject == nil || huc.watcher == nil {
+	if huc.userFileObject == nil {
 		return
 	}
+
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if huc.watcher != nil {
+		err := huc.watcher.Add(filepath.Dir(huc.userFile))
+		if err != nil {
+			logger.Errorf(err.Error())
+		} else {
+			events, errors = huc.watcher.Events, huc.watcher.Errors
+		}
+	}
+
 	go func() {
+		// Polling is the fallback in case events are missed or the
+		// watcher is not available.
+		ticker := time.NewTicker(huc.syncInterval)
+		defer ticker.Stop()
+
 		for {
 			select {
-			case _, ok := <-huc.watcher.Events:
+			case <-huc.stopCtx.Done():
+				return
+			case <-ticker.C:
+				huc.reload()
+			case event, ok := <-events:
 				if !ok {
-					return
+					events = nil
+					continue
 				}
-				err := huc.userFileObject.Reload(nil)
-				if err != nil {
-					logger.Errorf(err.Error())
+				if isFileEvent(event, huc.userFile) {
+					huc.reload()
 				}
-			case err, ok := <-huc.watcher.Errors:
+			case err, ok := <-errors:
 				if !ok {
-					return
+					errors = nil
+					continue
 				}
 				logger.Errorf(err.Error())
 			}
 		}
 	}()
-	err := huc.watcher.Add(huc.userFile)
-	if err != nil {
-		logger.Errorf(err.Error())
-	}
-	return
 }
 
 func (huc *htpasswdUserCache) Close() {
+	huc.cancel()
 	if huc.watcher != nil {
 		huc.watcher.Close()
 	}
@@ -197,12 +238,7 @@ func (huc *htpasswdUserCache) Match(username string, password string) bool {
 }
 
 func newEtcdUserCache(cluster cluster.Cluster, etcdPrefix string) *etcdUserCache {
-	prefix := customDataPrefix
-	if etcdPrefix == "" {
-		prefix += "credentials/"
-	} else {
-		prefix = customDataPrefix + strings.TrimPrefix(etcdPrefix, "/")
-	}
+	prefix := etcdCustomDataPrefix(etcdPrefix, "credentials/")
 	logger.Infof("credentials etcd prefix %s", prefix)
 	kvs, err := cluster.GetPrefix(prefix)
 	if err != nil {
@@ -259,45 +295,10 @@ func (euc *etcdUserCache) WatchChanges() {
 		logger.Errorf("missing etcd prefix, skip watching changes")
 		return
 	}
-	var (
-		syncer cluster.Syncer
-		err    error
-		ch     <-chan map[string]string
-	)
-
-	for {
-		syncer, err = euc.cluster.Syncer(euc.syncInterval)
-		if err != nil {
-			logger.Errorf("failed to create syncer: %v", err)
-		} else if ch, err = syncer.SyncPrefix(euc.prefix); err != nil {
-			logger.Errorf("failed to sync prefix: %v", err)
-			syncer.Close()
-		} else {
-			break
-		}
-
-		select {
-		case <-time.After(10 * time.Second):
-		case <-euc.stopCtx.Done():
-			return
-		}
-	}
-	// start listening in background
-	go func() {
-		defer syncer.Close()
-
-		for {
-			select {
-			case <-euc.stopCtx.Done():
-				return
-			case kvs := <-ch:
-				logger.Infof("basic auth credentials update")
-				pwReader := kvsToReader(kvs)
-				euc.userFileObject.ReloadFromReader(pwReader, nil)
-			}
-		}
-	}()
-	return
+	watchEtcdPrefix(euc.stopCtx, euc.cluster, euc.prefix, euc.syncInterval, func(kvs map[string]string) {
+		logger.Infof("basic auth credentials update")
+		euc.userFileObject.ReloadFromReader(kvsToReader(kvs), nil)
+	})
 }
 
 func (euc *etcdUserCache) Close() {
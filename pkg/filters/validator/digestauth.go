@@ -0,0 +1,516 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	digestAlgorithmMD5    = "MD5"
+	digestAlgorithmSHA256 = "SHA-256"
+
+	defaultDigestRealm    = "easegress"
+	defaultDigestNonceTTL = 5 * time.Minute
+)
+
+// nowFunc is the function to get current time, tests may replace it.
+var nowFunc = time.Now
+
+type (
+	// DigestAuthValidatorSpec defines the configuration of Digest Auth
+	// validator, it implements RFC 7616 with qop=auth.
+	DigestAuthValidatorSpec struct {
+		Realm     string `yaml:"realm" jsonschema:"omitempty"`
+		Algorithm string `yaml:"algorithm" jsonschema:"omitempty,enum=,enum=MD5,enum=SHA-256"`
+		// NonceTTL is the lifetime of a nonce, requests with an expired
+		// nonce are challenged again with stale=true.
+		NonceTTL string `yaml:"nonceTTL" jsonschema:"omitempty,format=duration"`
+		// NonceSecret is the secret to sign the nonces, instances sharing
+		// it accept the nonces issued by each other, and the nonces are
+		// still valid after reloading. A random secret is used if empty.
+		NonceSecret string `yaml:"nonceSecret" jsonschema:"omitempty"`
+
+		Mode string `yaml:"mode" jsonschema:"required,enum=FILE,enum=ETCD"`
+		// Required for 'FILE' mode.
+		// UserFile is path to file in apache2-utils/htdigest format, one
+		// user per line in the format of `user:realm:HA1`, where HA1 is the
+		// hex encoded hash of `user:realm:password` in the algorithm.
+		// Lines of other realms are ignored.
+		UserFile string `yaml:"userFile" jsonschema:"omitempty"`
+		// Required for 'ETCD' mode.
+		// The credentials are in the same format as the BasicAuth validator,
+		// but the passwords must be in plain text, users with hashed
+		// passwords are ignored:
+		// key: /custom-data/{etcdPrefix}/{$key}
+		// value:
+		//   key: "$key"
+		//   username: "$username" # optional
+		//   password: "$password"
+		EtcdPrefix string `yaml:"etcdPrefix" jsonschema:"omitempty"`
+	}
+
+	// DigestAuthValidator defines the Digest Auth validator
+	DigestAuthValidator struct {
+		spec      *DigestAuthValidatorSpec
+		realm     string
+		algorithm string
+		newHash   func() hash.Hash
+		nonceTTL  time.Duration
+		nonceKey  []byte
+		opaque    string
+		users     *digestUserCache
+
+		// nonceCounts are the nonce counts used with the nonces, to
+		// reject the replayed requests.
+		ncMutex     sync.Mutex
+		nonceCounts map[string]*nonceCount
+		ncPruned    time.Time
+	}
+
+	// nonceCount is the max nonce count used with a nonce.
+	nonceCount struct {
+		count     uint64
+		firstUsed time.Time
+	}
+
+	// DigestAuthError is the error of digest authentication, Stale
+	// indicates the request is rejected only because its nonce expired.
+	DigestAuthError struct {
+		Stale   bool
+		Message string
+	}
+
+	// digestUser is a user in digestUserCache, either password or HA1 of
+	// the user is present.
+	digestUser struct {
+		password string
+		ha1      string
+	}
+
+	// digestUserCache provides cached lookup for digest users.
+	digestUserCache struct {
+		mutex sync.RWMutex
+		users map[string]*digestUser
+		realm string
+
+		source *credentialSource
+	}
+)
+
+// Error implements the error interface.
+func (e *DigestAuthError) Error() string {
+	return e.Message
+}
+
+// Validate validates DigestAuthValidatorSpec.
+func (spec *DigestAuthValidatorSpec) Validate() error {
+	if spec.Mode == "FILE" && spec.UserFile == "" {
+		return fmt.Errorf("userFile is required in FILE mode")
+	}
+	return nil
+}
+
+// NewDigestAuthValidator creates a new Digest Auth validator
+func NewDigestAuthValidator(spec *DigestAuthValidatorSpec, supervisor *supervisor.Supervisor) *DigestAuthValidator {
+	v := &DigestAuthValidator{
+		spec:      spec,
+		realm:     defaultDigestRealm,
+		algorithm: digestAlgorithmMD5,
+		newHash:   md5.New,
+		nonceTTL:  defaultDigestNonceTTL,
+
+		nonceCounts: map[string]*nonceCount{},
+	}
+
+	if spec.Realm != "" {
+		v.realm = spec.Realm
+	}
+	if spec.Algorithm == digestAlgorithmSHA256 {
+		v.algorithm = digestAlgorithmSHA256
+		v.newHash = sha256.New
+	}
+	if spec.NonceTTL != "" {
+		v.nonceTTL, _ = time.ParseDuration(spec.NonceTTL)
+	}
+
+	if spec.NonceSecret != "" {
+		key := sha256.Sum256([]byte(spec.NonceSecret))
+		v.nonceKey = key[:]
+	} else {
+		v.nonceKey = make([]byte, 32)
+		rand.Read(v.nonceKey)
+	}
+	opaque := make([]byte, 16)
+	rand.Read(opaque)
+	v.opaque = hex.EncodeToString(opaque)
+
+	switch spec.Mode {
+	case "ETCD":
+		if supervisor == nil || supervisor.Cluster() == nil {
+			logger.Errorf("DigestAuth validator : failed to read data from etcd")
+			v.users = &digestUserCache{}
+			break
+		}
+		v.users = newEtcdDigestUserCache(supervisor.Cluster(), spec.EtcdPrefix, v.realm)
+	default:
+		v.users = newFileDigestUserCache(spec.UserFile, v.realm)
+	}
+	v.users.WatchChanges()
+
+	return v
+}
+
+func (v *DigestAuthValidator) hash(s string) string {
+	h := v.newHash()
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newNonce generates a nonce which is the issue time signed by nonceKey,
+// so it could be validated without being stored.
+func (v *DigestAuthValidator) newNonce() string {
+	buf := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(buf, uint64(nowFunc().UnixNano()))
+	mac := hmac.New(sha256.New, v.nonceKey)
+	mac.Write(buf)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(buf))
+}
+
+// checkNonce returns whether the nonce is issued by the validator and
+// whether it is expired.
+func (v *DigestAuthValidator) checkNonce(nonce string) (valid bool, stale bool) {
+	buf, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(buf) != 8+sha256.Size {
+		return false, false
+	}
+
+	mac := hmac.New(sha256.New, v.nonceKey)
+	mac.Write(buf[:8])
+	if !hmac.Equal(buf[8:], mac.Sum(nil)) {
+		return false, false
+	}
+
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(buf[:8])))
+	return true, nowFunc().Sub(issued) > v.nonceTTL
+}
+
+// useNonceCount records that nc is used with nonce, it returns false if
+// nc is not greater than the ones used with nonce before, which means
+// the request is replayed. The nonce must not be stale.
+func (v *DigestAuthValidator) useNonceCount(nonce string, nc uint64) bool {
+	now := nowFunc()
+
+	v.ncMutex.Lock()
+	defer v.ncMutex.Unlock()
+
+	// The nonces first used before the TTL are stale now, so they are
+	// rejected without checking the nonce counts.
+	if now.Sub(v.ncPruned) > v.nonceTTL {
+		for n, c := range v.nonceCounts {
+			if now.Sub(c.firstUsed) > v.nonceTTL {
+				delete(v.nonceCounts, n)
+			}
+		}
+		v.ncPruned = now
+	}
+
+	c := v.nonceCounts[nonce]
+	if c == nil {
+		v.nonceCounts[nonce] = &nonceCount{count: nc, firstUsed: now}
+		return true
+	}
+	if nc <= c.count {
+		return false
+	}
+	c.count = nc
+	return true
+}
+
+// Challenge returns the value of the WWW-Authenticate header for err.
+func (v *DigestAuthValidator) Challenge(err error) string {
+	challenge := fmt.Sprintf(`Digest realm="%s", qop="auth", algorithm=%s, nonce="%s", opaque="%s"`,
+		v.realm, v.algorithm, v.newNonce(), v.opaque)
+	if e, ok := err.(*DigestAuthError); ok && e.Stale {
+		challenge += ", stale=true"
+	}
+	return challenge
+}
+
+// parseDigestAuthorizationHeader parses the parameters of a Digest
+// Authorization header.
+func parseDigestAuthorizationHeader(value string) (map[string]string, error) {
+	const prefix = "Digest "
+
+	if !strings.HasPrefix(value, prefix) {
+		return nil, fmt.Errorf("unexpected authorization header: %s", value)
+	}
+	value = strings.TrimPrefix(value, prefix)
+
+	params := map[string]string{}
+	for {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			return params, nil
+		}
+
+		idx := strings.IndexByte(value, '=')
+		if idx <= 0 {
+			return nil, fmt.Errorf("bad format")
+		}
+		key := strings.ToLower(strings.TrimSpace(value[:idx]))
+		value = strings.TrimLeft(value[idx+1:], " \t")
+
+		if strings.HasPrefix(value, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(value) && value[i] != '"'; i++ {
+				if value[i] == '\\' && i+1 < len(value) {
+					i++
+				}
+				sb.WriteByte(value[i])
+			}
+			if i >= len(value) {
+				return nil, fmt.Errorf("bad format")
+			}
+			params[key] = sb.String()
+			value = value[i+1:]
+		} else {
+			idx = strings.IndexByte(value, ',')
+			if idx < 0 {
+				idx = len(value)
+			}
+			params[key] = strings.TrimSpace(value[:idx])
+			value = value[idx:]
+		}
+	}
+}
+
+// Validate validates the Authorization header of a http request
+func (v *DigestAuthValidator) Validate(req *httpprot.Request) error {
+	params, err := parseDigestAuthorizationHeader(req.HTTPHeader().Get("Authorization"))
+	if err != nil {
+		return &DigestAuthError{Message: err.Error()}
+	}
+
+	username, nonce, response := params["username"], params["nonce"], params["response"]
+	if username == "" || nonce == "" || response == "" {
+		return &DigestAuthError{Message: "missing digest parameters"}
+	}
+	if params["realm"] != v.realm {
+		return &DigestAuthError{Message: "realm mismatch"}
+	}
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, v.algorithm) {
+		return &DigestAuthError{Message: fmt.Sprintf("unsupported algorithm %s", algorithm)}
+	}
+	if params["qop"] != "auth" || params["nc"] == "" || params["cnonce"] == "" {
+		return &DigestAuthError{Message: "qop=auth is required"}
+	}
+	if params["uri"] != req.Std().URL.RequestURI() {
+		return &DigestAuthError{Message: "uri mismatch"}
+	}
+
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		return &DigestAuthError{Message: "invalid nonce count"}
+	}
+
+	valid, stale := v.checkNonce(nonce)
+	if !valid {
+		return &DigestAuthError{Message: "invalid nonce"}
+	}
+
+	ha1, ok := v.users.HA1(username, v.hash)
+	if !ok {
+		return &DigestAuthError{Message: "unauthorized"}
+	}
+	ha2 := v.hash(req.Method() + ":" + params["uri"])
+	expected := v.hash(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(response))) != 1 {
+		return &DigestAuthError{Message: "unauthorized"}
+	}
+
+	// The credentials are correct, let the client retry with a new nonce
+	// without prompting the user.
+	if stale {
+		return &DigestAuthError{Stale: true, Message: "stale nonce"}
+	}
+
+	// The nonce count is recorded only after the credentials are checked,
+	// so requests without them can't make the nonce unusable.
+	if !v.useNonceCount(nonce, nc) {
+		return &DigestAuthError{Message: "replayed nonce count"}
+	}
+
+	req.Header().Set("X-AUTH-USER", username)
+	return nil
+}
+
+// Close closes DigestAuthValidator.
+func (v *DigestAuthValidator) Close() {
+	v.users.Close()
+}
+
+func newFileDigestUserCache(userFile, realm string) *digestUserCache {
+	c := &digestUserCache{
+		realm:  realm,
+		source: newFileCredentialSource(userFile),
+	}
+
+	if err := c.loadFile(); err != nil {
+		logger.Errorf("load digest users from %s failed: %v", userFile, err)
+	}
+	return c
+}
+
+func newEtcdDigestUserCache(cls cluster.Cluster, etcdPrefix, realm string) *digestUserCache {
+	prefix := etcdCustomDataPrefix(etcdPrefix, "credentials/")
+	c := &digestUserCache{
+		realm:  realm,
+		source: newEtcdCredentialSource(cls, prefix),
+	}
+
+	kvs, err := cls.GetPrefix(prefix)
+	if err != nil {
+		logger.Errorf(err.Error())
+	} else {
+		c.loadKVs(kvs)
+	}
+	return c
+}
+
+func (c *digestUserCache) loadFile() error {
+	f, err := os.Open(c.source.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	users := map[string]*digestUser{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			logger.Errorf("invalid digest user line in %s", c.source.file)
+			continue
+		}
+		if parts[1] != c.realm {
+			continue
+		}
+		users[parts[0]] = &digestUser{ha1: strings.ToLower(parts[2])}
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.users = users
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *digestUserCache) loadKVs(kvs map[string]string) {
+	users := make(map[string]*digestUser, len(kvs))
+	for _, v := range kvs {
+		creds := &etcdCredentials{}
+		if err := yaml.Unmarshal([]byte(v), creds); err != nil {
+			logger.Errorf(err.Error())
+			continue
+		}
+		if creds.Username() == "" || creds.Password() == "" {
+			logger.Errorf("parsing digest user failed, make sure it contains 'key' or 'username' and 'password' entries")
+			continue
+		}
+		// The HA1 can't be computed from a hashed password, e.g. the
+		// ones hashed with bcrypt by the htpasswd import API.
+		if IsPasswordHashed(creds.Password()) {
+			logger.Errorf("digest user %s is ignored, its password must be in plain text", creds.Username())
+			continue
+		}
+		users[creds.Username()] = &digestUser{password: creds.Password()}
+	}
+
+	c.mutex.Lock()
+	c.users = users
+	c.mutex.Unlock()
+}
+
+// HA1 returns the HA1 of username, hashFn is the hash function of the
+// digest algorithm.
+func (c *digestUserCache) HA1(username string, hashFn func(string) string) (string, bool) {
+	c.mutex.RLock()
+	user := c.users[username]
+	c.mutex.RUnlock()
+
+	if user == nil {
+		return "", false
+	}
+	if user.password != "" {
+		return hashFn(username + ":" + c.realm + ":" + user.password), true
+	}
+
+	// The HA1 in the user file is only usable if it is of the algorithm.
+	if len(user.ha1) != len(hashFn("")) {
+		return "", false
+	}
+	return user.ha1, true
+}
+
+// WatchChanges watches the changes of the users.
+func (c *digestUserCache) WatchChanges() {
+	if c.source == nil {
+		return
+	}
+	c.source.watch(c.loadFile, func(kvs map[string]string) {
+		logger.Infof("digest auth users update")
+		c.loadKVs(kvs)
+	})
+}
+
+// Close stops watching the changes.
+func (c *digestUserCache) Close() {
+	if c.source != nil {
+		c.source.Close()
+	}
+}
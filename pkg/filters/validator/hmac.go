@@ -0,0 +1,305 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	defaultHMACKeyIDHeader     = "X-Hmac-Key-Id"
+	defaultHMACSignatureHeader = "X-Hmac-Signature"
+	defaultHMACTimestampHeader = "X-Hmac-Timestamp"
+	defaultHMACClockSkew       = 5 * time.Minute
+)
+
+type (
+	// HMACValidatorSpec defines the configuration of HMAC validator.
+	// The signature is the hex encoded HMAC of the string below:
+	//   method + "\n" + path + "\n" + body + "\n" + timestamp
+	// where the timestamp is in Unix seconds.
+	HMACValidatorSpec struct {
+		Algorithm       string `yaml:"algorithm" jsonschema:"omitempty,enum=,enum=sha256,enum=sha512"`
+		KeyIDHeader     string `yaml:"keyIDHeader" jsonschema:"omitempty"`
+		SignatureHeader string `yaml:"signatureHeader" jsonschema:"omitempty"`
+		TimestampHeader string `yaml:"timestampHeader" jsonschema:"omitempty"`
+		// ClockSkew is the max difference between the timestamp of a
+		// request and the local time, stale requests are rejected.
+		ClockSkew string `yaml:"clockSkew" jsonschema:"omitempty,format=duration"`
+
+		Mode string `yaml:"mode" jsonschema:"required,enum=FILE,enum=ETCD"`
+		// Required for 'FILE' mode.
+		// SecretFile is path to file containing the shared secrets, one
+		// secret per line in the format of `keyID:secret`.
+		SecretFile string `yaml:"secretFile" jsonschema:"omitempty"`
+		// Required for 'ETCD' mode.
+		// When EtcdPrefix is specified, the shared secrets are read from etcd:
+		// key: /custom-data/{etcdPrefix}/{$key}
+		// value:
+		//   key: "$key"
+		//   secret: "$secret"
+		EtcdPrefix string `yaml:"etcdPrefix" jsonschema:"omitempty"`
+	}
+
+	// HMACValidator defines the HMAC validator
+	HMACValidator struct {
+		spec            *HMACValidatorSpec
+		newHash         func() hash.Hash
+		keyIDHeader     string
+		signatureHeader string
+		timestampHeader string
+		clockSkew       time.Duration
+		secrets         *hmacSecretCache
+	}
+
+	// hmacSecretCache provides cached lookup for shared secrets.
+	hmacSecretCache struct {
+		mutex   sync.RWMutex
+		secrets map[string]string
+
+		source *credentialSource
+	}
+
+	// etcdHMACSecret defines the format for shared secrets in etcd
+	etcdHMACSecret struct {
+		Key    string `yaml:"key" jsonschema:"required"`
+		Secret string `yaml:"secret" jsonschema:"required"`
+	}
+)
+
+// Validate validates HMACValidatorSpec.
+func (spec *HMACValidatorSpec) Validate() error {
+	if spec.Mode == "FILE" && spec.SecretFile == "" {
+		return fmt.Errorf("secretFile is required in FILE mode")
+	}
+	return nil
+}
+
+// NewHMACValidator creates a new HMAC validator
+func NewHMACValidator(spec *HMACValidatorSpec, supervisor *supervisor.Supervisor) *HMACValidator {
+	v := &HMACValidator{
+		spec:            spec,
+		newHash:         sha256.New,
+		keyIDHeader:     defaultHMACKeyIDHeader,
+		signatureHeader: defaultHMACSignatureHeader,
+		timestampHeader: defaultHMACTimestampHeader,
+		clockSkew:       defaultHMACClockSkew,
+	}
+
+	if spec.Algorithm == "sha512" {
+		v.newHash = sha512.New
+	}
+	if spec.KeyIDHeader != "" {
+		v.keyIDHeader = spec.KeyIDHeader
+	}
+	if spec.SignatureHeader != "" {
+		v.signatureHeader = spec.SignatureHeader
+	}
+	if spec.TimestampHeader != "" {
+		v.timestampHeader = spec.TimestampHeader
+	}
+	if spec.ClockSkew != "" {
+		v.clockSkew, _ = time.ParseDuration(spec.ClockSkew)
+	}
+
+	switch spec.Mode {
+	case "ETCD":
+		if supervisor == nil || supervisor.Cluster() == nil {
+			logger.Errorf("HMAC validator : failed to read data from etcd")
+			v.secrets = &hmacSecretCache{}
+			break
+		}
+		v.secrets = newEtcdHMACSecretCache(supervisor.Cluster(), spec.EtcdPrefix)
+	default:
+		v.secrets = newFileHMACSecretCache(spec.SecretFile)
+	}
+	v.secrets.WatchChanges()
+
+	return v
+}
+
+// Validate validates the signature of a http request
+func (v *HMACValidator) Validate(req *httpprot.Request) error {
+	hdr := req.HTTPHeader()
+
+	keyID := hdr.Get(v.keyIDHeader)
+	if keyID == "" {
+		return fmt.Errorf("missing header %s", v.keyIDHeader)
+	}
+
+	signature, err := hex.DecodeString(hdr.Get(v.signatureHeader))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("invalid header %s", v.signatureHeader)
+	}
+
+	timestamp := hdr.Get(v.timestampHeader)
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid header %s", v.timestampHeader)
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > v.clockSkew || skew < -v.clockSkew {
+		return fmt.Errorf("timestamp is out of the clock skew")
+	}
+
+	// The body of a stream request can not be read without consuming it.
+	if req.IsStream() {
+		return fmt.Errorf("stream request is not supported")
+	}
+
+	secret, ok := v.secrets.Get(keyID)
+	if !ok {
+		return fmt.Errorf("unknown key id %s", keyID)
+	}
+
+	mac := hmac.New(v.newHash, []byte(secret))
+	io.WriteString(mac, req.Method())
+	io.WriteString(mac, "\n")
+	io.WriteString(mac, req.Path())
+	io.WriteString(mac, "\n")
+	mac.Write(req.RawPayload())
+	io.WriteString(mac, "\n")
+	io.WriteString(mac, timestamp)
+
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// Close closes HMACValidator.
+func (v *HMACValidator) Close() {
+	v.secrets.Close()
+}
+
+func newFileHMACSecretCache(secretFile string) *hmacSecretCache {
+	c := &hmacSecretCache{source: newFileCredentialSource(secretFile)}
+
+	if err := c.loadFile(); err != nil {
+		logger.Errorf("load HMAC secrets from %s failed: %v", secretFile, err)
+	}
+	return c
+}
+
+func newEtcdHMACSecretCache(cls cluster.Cluster, etcdPrefix string) *hmacSecretCache {
+	prefix := etcdCustomDataPrefix(etcdPrefix, "hmac-secrets/")
+	c := &hmacSecretCache{source: newEtcdCredentialSource(cls, prefix)}
+
+	kvs, err := cls.GetPrefix(prefix)
+	if err != nil {
+		logger.Errorf(err.Error())
+	} else {
+		c.loadKVs(kvs)
+	}
+	return c
+}
+
+func (c *hmacSecretCache) loadFile() error {
+	f, err := os.Open(c.source.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	secrets := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			logger.Errorf("invalid HMAC secret line in %s", c.source.file)
+			continue
+		}
+		secrets[parts[0]] = parts[1]
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	c.secrets = secrets
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *hmacSecretCache) loadKVs(kvs map[string]string) {
+	secrets := make(map[string]string, len(kvs))
+	for _, v := range kvs {
+		s := &etcdHMACSecret{}
+		if err := yaml.Unmarshal([]byte(v), s); err != nil {
+			logger.Errorf(err.Error())
+			continue
+		}
+		if s.Key == "" || s.Secret == "" {
+			logger.Errorf("parsing HMAC secret failed, make sure it contains 'key' and 'secret' entries")
+			continue
+		}
+		secrets[s.Key] = s.Secret
+	}
+
+	c.mutex.Lock()
+	c.secrets = secrets
+	c.mutex.Unlock()
+}
+
+// Get returns the secret of keyID.
+func (c *hmacSecretCache) Get(keyID string) (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	secret, ok := c.secrets[keyID]
+	return secret, ok
+}
+
+// WatchChanges watches the changes of the secrets.
+func (c *hmacSecretCache) WatchChanges() {
+	if c.source == nil {
+		return
+	}
+	c.source.watch(c.loadFile, func(kvs map[string]string) {
+		logger.Infof("HMAC secrets update")
+		c.loadKVs(kvs)
+	})
+}
+
+// Close stops watching the changes.
+func (c *hmacSecretCache) Close() {
+	if c.source != nil {
+		c.source.Close()
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"fmt"
+
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/util/dynamicobject"
+)
+
+// JSONSchemaErrorsDataKey is the key of the JSON schema validation errors
+// in the context data, downstream filters could use it to build the
+// response, for example: `{{.data.jsonSchemaErrors}}`.
+const JSONSchemaErrorsDataKey = "jsonSchemaErrors"
+
+type (
+	// JSONSchemaValidator validates the JSON body of a request against
+	// a JSON schema.
+	JSONSchemaValidator struct {
+		schema *gojsonschema.Schema
+	}
+
+	// JSONSchemaValidationError is the error returned when the request body
+	// does not match the schema.
+	JSONSchemaValidationError struct {
+		Errors []string
+	}
+)
+
+func (e *JSONSchemaValidationError) Error() string {
+	return fmt.Sprintf("request body does not match the schema: %v", e.Errors)
+}
+
+func compileJSONSchema(schema dynamicobject.DynamicObject) (*gojsonschema.Schema, error) {
+	sl := gojsonschema.NewSchemaLoader()
+	sl.Draft = gojsonschema.Draft7
+	sl.AutoDetect = false
+	return sl.Compile(gojsonschema.NewGoLoader(schema))
+}
+
+// NewJSONSchemaValidator creates a new JSON schema validator.
+func NewJSONSchemaValidator(schema dynamicobject.DynamicObject) (*JSONSchemaValidator, error) {
+	s, err := compileJSONSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSchemaValidator{schema: s}, nil
+}
+
+// Validate validates the body of the request, the body is not consumed
+// and could be read again by the following filters.
+func (v *JSONSchemaValidator) Validate(req *httpprot.Request) error {
+	if req.IsStream() {
+		return fmt.Errorf("stream request is not supported")
+	}
+
+	result, err := v.schema.Validate(gojsonschema.NewBytesLoader(req.RawPayload()))
+	if err != nil {
+		return &JSONSchemaValidationError{Errors: []string{err.Error()}}
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	errs := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+	return &JSONSchemaValidationError{Errors: errs}
+}
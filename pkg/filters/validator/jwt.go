@@ -18,43 +18,141 @@
 package validator
 
 import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/httpprot"
 )
 
-// JWTValidatorSpec defines the configuration of JWT validator
-type JWTValidatorSpec struct {
-	Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512"`
-	// Secret is in hex encoding
-	Secret string `yaml:"secret" jsonschema:"required,pattern=^[A-Fa-f0-9]+$"`
-	// CookieName specifies the name of a cookie, if not empty, and the cookie with
-	// this name both exists and has a non-empty value, its value is used as token
-	// string, the Authorization header is used to get the token string otherwise.
-	CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+// JWTClaimsDataKey is the key of the exposed claims in the context data.
+const JWTClaimsDataKey = "jwtClaims"
+
+const defaultJWKSRefreshInterval = 10 * time.Minute
+
+type (
+	// JWTValidatorSpec defines the configuration of JWT validator
+	JWTValidatorSpec struct {
+		Algorithm string `yaml:"algorithm" jsonschema:"enum=HS256,enum=HS384,enum=HS512,enum=RS256,enum=RS384,enum=RS512"`
+		// Secret is in hex encoding, it is required by HS256, HS384 and HS512.
+		Secret string `yaml:"secret,omitempty" jsonschema:"omitempty,pattern=^[A-Fa-f0-9]+$"`
+		// PublicKey is the PEM encoded RSA public key, one of PublicKey
+		// and JWKSURL is required by RS256, RS384 and RS512.
+		PublicKey string `yaml:"publicKey,omitempty" jsonschema:"omitempty"`
+		// JWKSURL is the URL of the JSON Web Key Set, the key set is cached
+		// and refreshed periodically, or when the key of a token is not found.
+		JWKSURL             string `yaml:"jwksURL,omitempty" jsonschema:"omitempty,format=url"`
+		JWKSRefreshInterval string `yaml:"jwksRefreshInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		Issuer              string `yaml:"issuer" jsonschema:"omitempty"`
+		Audience            string `yaml:"audience" jsonschema:"omitempty"`
+		// ExposeClaims are put into the context data with key JWTClaimsDataKey,
+		// so that they can be used by templates of the following filters.
+		ExposeClaims []string `yaml:"exposeClaims" jsonschema:"omitempty,uniqueItems=true"`
+		// CookieName specifies the name of a cookie, if not empty, and the cookie with
+		// this name both exists and has a non-empty value, its value is used as token
+		// string, the Authorization header is used to get the token string otherwise.
+		CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+	}
+
+	// JWTValidator defines the JWT validator
+	JWTValidator struct {
+		spec        *JWTValidatorSpec
+		secretBytes []byte
+		publicKey   *rsa.PublicKey
+		jwks        *jwksCache
+	}
+
+	// jwksCache provides cached lookup for the keys of a JSON Web Key Set.
+	jwksCache struct {
+		url                string
+		client             *http.Client
+		syncInterval       time.Duration
+		minRefreshInterval time.Duration
+		stopCtx            context.Context
+		cancel             context.CancelFunc
+
+		mutex       sync.RWMutex
+		keys        map[string]*rsa.PublicKey
+		lastRefresh time.Time
+	}
+
+	jsonWebKey struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	}
+)
+
+// Validate validates JWTValidatorSpec.
+func (spec *JWTValidatorSpec) Validate() error {
+	if spec.JWKSRefreshInterval != "" {
+		if d, _ := time.ParseDuration(spec.JWKSRefreshInterval); d <= 0 {
+			return fmt.Errorf("jwksRefreshInterval must be positive")
+		}
+	}
+
+	if strings.HasPrefix(spec.Algorithm, "HS") {
+		if spec.Secret == "" {
+			return fmt.Errorf("secret is required by %s", spec.Algorithm)
+		}
+		return nil
+	}
+
+	if spec.PublicKey == "" && spec.JWKSURL == "" {
+		return fmt.Errorf("publicKey or jwksURL is required by %s", spec.Algorithm)
+	}
+	if spec.PublicKey != "" {
+		if _, err := jwt.ParseRSAPublicKeyFromPEM([]byte(spec.PublicKey)); err != nil {
+			return fmt.Errorf("invalid public key: %v", err)
+		}
+	}
+
+	return nil
 }
 
 // NewJWTValidator creates a new JWT validator
 func NewJWTValidator(spec *JWTValidatorSpec) *JWTValidator {
 	secret, _ := hex.DecodeString(spec.Secret)
-	return &JWTValidator{
+	v := &JWTValidator{
 		spec:        spec,
 		secretBytes: secret,
 	}
-}
 
-// JWTValidator defines the JWT validator
-type JWTValidator struct {
-	spec        *JWTValidatorSpec
-	secretBytes []byte
+	if spec.PublicKey != "" {
+		v.publicKey, _ = jwt.ParseRSAPublicKeyFromPEM([]byte(spec.PublicKey))
+	}
+
+	if spec.JWKSURL != "" {
+		interval, _ := time.ParseDuration(spec.JWKSRefreshInterval)
+		if interval <= 0 {
+			interval = defaultJWKSRefreshInterval
+		}
+		v.jwks = newJWKSCache(spec.JWKSURL, interval)
+		v.jwks.WatchChanges()
+	}
+
+	return v
 }
 
 // Validate validates the JWT token of a http request
 func (v *JWTValidator) Validate(req *httpprot.Request) error {
+	_, err := v.validate(req)
+	return err
+}
+
+func (v *JWTValidator) validate(req *httpprot.Request) (jwt.MapClaims, error) {
 	var token string
 
 	if v.spec.CookieName != "" {
@@ -67,18 +165,197 @@ func (v *JWTValidator) Validate(req *httpprot.Request) error {
 		const prefix = "Bearer "
 		authHdr := req.HTTPHeader().Get("Authorization")
 		if !strings.HasPrefix(authHdr, prefix) {
-			return fmt.Errorf("unexpected authorization header: %s", authHdr)
+			return nil, fmt.Errorf("unexpected authorization header: %s", authHdr)
 		}
 		token = authHdr[len(prefix):]
 	}
 
-	// jwt.Parse does everything including parsing and verification
-	_, e := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if alg := token.Method.Alg(); alg != v.spec.Algorithm {
-			return nil, fmt.Errorf("unexpected signing method: %v", alg)
-		}
+	// jwt.ParseWithClaims does everything including parsing and verification,
+	// the expiration is verified if the token has one.
+	claims := jwt.MapClaims{}
+	if _, e := jwt.ParseWithClaims(token, claims, v.getKey); e != nil {
+		return nil, e
+	}
+
+	if v.spec.Issuer != "" && !claims.VerifyIssuer(v.spec.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	if v.spec.Audience != "" && !claims.VerifyAudience(v.spec.Audience, true) {
+		return nil, fmt.Errorf("unexpected audience: %v", claims["aud"])
+	}
+
+	return claims, nil
+}
+
+func (v *JWTValidator) getKey(token *jwt.Token) (interface{}, error) {
+	alg := token.Method.Alg()
+	if alg != v.spec.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", alg)
+	}
+
+	if strings.HasPrefix(alg, "HS") {
 		return v.secretBytes, nil
-	})
+	}
+
+	if v.publicKey != nil {
+		return v.publicKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	return v.jwks.getKey(kid)
+}
+
+// exposedClaims returns the claims to be exposed, nil if there's none.
+func (v *JWTValidator) exposedClaims(claims jwt.MapClaims) map[string]interface{} {
+	if len(v.spec.ExposeClaims) == 0 {
+		return nil
+	}
+
+	exposed := make(map[string]interface{}, len(v.spec.ExposeClaims))
+	for _, name := range v.spec.ExposeClaims {
+		if value, ok := claims[name]; ok {
+			exposed[name] = value
+		}
+	}
+	return exposed
+}
+
+// Close closes the JWT validator.
+func (v *JWTValidator) Close() {
+	if v.jwks != nil {
+		v.jwks.Close()
+	}
+}
+
+func newJWKSCache(url string, syncInterval time.Duration) *jwksCache {
+	stopCtx, cancel := context.WithCancel(context.Background())
+	c := &jwksCache{
+		url:                url,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		syncInterval:       syncInterval,
+		minRefreshInterval: 10 * time.Second,
+		stopCtx:            stopCtx,
+		cancel:             cancel,
+		keys:               map[string]*rsa.PublicKey{},
+	}
+
+	if err := c.refresh(); err != nil {
+		logger.Errorf("refresh JWKS from %s failed: %v", url, err)
+	}
+
+	return c
+}
+
+func (c *jwksCache) refresh() error {
+	c.mutex.Lock()
+	c.lastRefresh = time.Now()
+	c.mutex.Unlock()
+
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			logger.Errorf("parse JWK %s failed: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	c.mutex.Lock()
+	c.keys = keys
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *jwksCache) lookup(kid string) *rsa.PublicKey {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	// Use the only key if the token doesn't specify one.
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key
+		}
+	}
+	return c.keys[kid]
+}
+
+func (c *jwksCache) getKey(kid string) (*rsa.PublicKey, error) {
+	if key := c.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	// The keys could be rotated, refresh them, but not too frequently.
+	c.mutex.RLock()
+	refreshable := time.Since(c.lastRefresh) >= c.minRefreshInterval
+	c.mutex.RUnlock()
+
+	if refreshable {
+		if err := c.refresh(); err != nil {
+			logger.Errorf("refresh JWKS from %s failed: %v", c.url, err)
+		} else if key := c.lookup(kid); key != nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("key %q not found", kid)
+}
+
+// WatchChanges refreshes the keys periodically.
+func (c *jwksCache) WatchChanges() {
+	go func() {
+		ticker := time.NewTicker(c.syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stopCtx.Done():
+				return
+			case <-ticker.C:
+				if err := c.refresh(); err != nil {
+					logger.Errorf("refresh JWKS from %s failed: %v", c.url, err)
+				}
+			}
+		}
+	}()
+}
+
+func (c *jwksCache) Close() {
+	c.cancel()
+}
+
+func (k *jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
 
-	return e
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
 }
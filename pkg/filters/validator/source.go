@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+// credentialSource is the source of the credentials of a validator,
+// either a file or an etcd prefix, it notifies the changes of the source
+// to the cache of the credentials.
+type credentialSource struct {
+	file    string
+	watcher *fsnotify.Watcher
+
+	cluster      cluster.Cluster
+	prefix       string
+	syncInterval time.Duration
+
+	stopCtx context.Context
+	cancel  context.CancelFunc
+}
+
+// etcdCustomDataPrefix returns the full etcd prefix of etcdPrefix in
+// the custom data, defaultPrefix is used if etcdPrefix is empty.
+func etcdCustomDataPrefix(etcdPrefix, defaultPrefix string) string {
+	if etcdPrefix == "" {
+		return customDataPrefix + defaultPrefix
+	}
+	return customDataPrefix + strings.TrimPrefix(etcdPrefix, "/")
+}
+
+// isFileEvent reports whether the event changes file. The directory of
+// the file is watched instead of the file itself, because editors that
+// save by renaming a new file over the old one would break a watch on
+// the replaced file.
+func isFileEvent(event fsnotify.Event, file string) bool {
+	if filepath.Clean(event.Name) != filepath.Clean(file) {
+		return false
+	}
+	return event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) != 0
+}
+
+// watchEtcdPrefix creates a syncer of prefix, and calls onChange with
+// the key-values under prefix on every change until stopCtx is done.
+// It blocks until the syncer is created, which is retried every 10
+// seconds on failures.
+func watchEtcdPrefix(stopCtx context.Context, cls cluster.Cluster, prefix string,
+	syncInterval time.Duration, onChange func(kvs map[string]string)) {
+	var (
+		syncer cluster.Syncer
+		err    error
+		ch     <-chan map[string]string
+	)
+
+	for {
+		syncer, err = cls.Syncer(syncInterval)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.SyncPrefix(prefix); err != nil {
+			logger.Errorf("failed to sync prefix: %v", err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-stopCtx.Done():
+			return
+		}
+	}
+
+	go func() {
+		defer syncer.Close()
+
+		for {
+			select {
+			case <-stopCtx.Done():
+				return
+			case kvs := <-ch:
+				onChange(kvs)
+			}
+		}
+	}()
+}
+
+func newFileCredentialSource(file string) *credentialSource {
+	stopCtx, cancel := context.WithCancel(context.Background())
+	s := &credentialSource{
+		file:    file,
+		stopCtx: stopCtx,
+		cancel:  cancel,
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logger.Errorf(err.Error())
+	} else {
+		s.watcher = watcher
+	}
+	return s
+}
+
+func newEtcdCredentialSource(cls cluster.Cluster, prefix string) *credentialSource {
+	stopCtx, cancel := context.WithCancel(context.Background())
+	return &credentialSource{
+		cluster: cls,
+		prefix:  prefix,
+		stopCtx: stopCtx,
+		cancel:  cancel,
+		// cluster.Syncer updates changes immediately, syncInterval
+		// defines data consistency check interval.
+		syncInterval: 30 * time.Minute,
+	}
+}
+
+// watch calls loadFile or loadKVs on the changes of the source.
+func (s *credentialSource) watch(loadFile func() error, loadKVs func(kvs map[string]string)) {
+	if s.watcher != nil {
+		s.watchFile(loadFile)
+	} else if s.cluster != nil {
+		go watchEtcdPrefix(s.stopCtx, s.cluster, s.prefix, s.syncInterval, loadKVs)
+	}
+}
+
+func (s *credentialSource) watchFile(loadFile func() error) {
+	if err := s.watcher.Add(filepath.Dir(s.file)); err != nil {
+		logger.Errorf(err.Error())
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-s.watcher.Events:
+				if !ok {
+					return
+				}
+				if !isFileEvent(event, s.file) {
+					continue
+				}
+				if err := loadFile(); err != nil {
+					logger.Errorf("load credentials from %s failed: %v", s.file, err)
+				}
+			case err, ok := <-s.watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Errorf(err.Error())
+			}
+		}
+	}()
+}
+
+// Close stops watching the changes.
+func (s *credentialSource) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+}
@@ -24,8 +24,10 @@ import (
 
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocols/httpprot"
 	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+	"github.com/megaease/easegress/pkg/util/dynamicobject"
 	"github.com/megaease/easegress/pkg/util/signer"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
@@ -58,30 +60,44 @@ type (
 	Validator struct {
 		spec *Spec
 
-		headers   *httpheader.Validator
-		jwt       *JWTValidator
-		signer    *signer.Signer
-		oauth2    *OAuth2Validator
-		basicAuth *BasicAuthValidator
+		headers    *httpheader.Validator
+		jwt        *JWTValidator
+		signer     *signer.Signer
+		oauth2     *OAuth2Validator
+		basicAuth  *BasicAuthValidator
+		digestAuth *DigestAuthValidator
+		hmac       *HMACValidator
+		jsonSchema *JSONSchemaValidator
 	}
 
 	// Spec describes the Validator.
 	Spec struct {
 		filters.BaseSpec `yaml:",inline"`
 
-		Headers   *httpheader.ValidatorSpec `yaml:"headers,omitempty" jsonschema:"omitempty"`
-		JWT       *JWTValidatorSpec         `yaml:"jwt,omitempty" jsonschema:"omitempty"`
-		Signature *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
-		OAuth2    *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
-		BasicAuth *BasicAuthValidatorSpec   `yaml:"basicAuth,omitempty" jsonschema:"omitempty"`
+		Headers    *httpheader.ValidatorSpec `yaml:"headers,omitempty" jsonschema:"omitempty"`
+		JWT        *JWTValidatorSpec         `yaml:"jwt,omitempty" jsonschema:"omitempty"`
+		Signature  *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
+		OAuth2     *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
+		BasicAuth  *BasicAuthValidatorSpec   `yaml:"basicAuth,omitempty" jsonschema:"omitempty"`
+		DigestAuth *DigestAuthValidatorSpec  `yaml:"digestAuth,omitempty" jsonschema:"omitempty"`
+		HMAC       *HMACValidatorSpec        `yaml:"hmac,omitempty" jsonschema:"omitempty"`
+		// JSONSchema is a draft-07 JSON schema to validate the request body.
+		JSONSchema dynamicobject.DynamicObject `yaml:"jsonSchema,omitempty" jsonschema:"omitempty"`
 	}
 )
 
 // Validate verifies that at least one of the validations is defined.
 func (spec Spec) Validate() error {
-	if spec == (Spec{}) {
+	if spec.Headers == nil && spec.JWT == nil && spec.Signature == nil &&
+		spec.OAuth2 == nil && spec.BasicAuth == nil && spec.DigestAuth == nil && spec.HMAC == nil &&
+		len(spec.JSONSchema) == 0 {
 		return fmt.Errorf("none of the validations are defined")
 	}
+	if len(spec.JSONSchema) > 0 {
+		if _, err := compileJSONSchema(spec.JSONSchema); err != nil {
+			return fmt.Errorf("invalid jsonSchema: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -126,6 +142,20 @@ func (v *Validator) reload() {
 	if v.spec.BasicAuth != nil {
 		v.basicAuth = NewBasicAuthValidator(v.spec.BasicAuth, v.spec.Super())
 	}
+	if v.spec.DigestAuth != nil {
+		v.digestAuth = NewDigestAuthValidator(v.spec.DigestAuth, v.spec.Super())
+	}
+	if v.spec.HMAC != nil {
+		v.hmac = NewHMACValidator(v.spec.HMAC, v.spec.Super())
+	}
+	if len(v.spec.JSONSchema) > 0 {
+		jsv, err := NewJSONSchemaValidator(v.spec.JSONSchema)
+		if err != nil {
+			logger.Errorf("BUG: invalid jsonSchema should be rejected by Validate: %v", err)
+		} else {
+			v.jsonSchema = jsv
+		}
+	}
 }
 
 // Handle validates the request in the context.
@@ -146,10 +176,14 @@ func (v *Validator) Handle(ctx *context.Context) string {
 		}
 	}
 	if v.jwt != nil {
-		if err := v.jwt.Validate(req); err != nil {
+		claims, err := v.jwt.validate(req)
+		if err != nil {
 			prepareErrorResponse(http.StatusUnauthorized, "JWT validator: ", err)
 			return resultInvalid
 		}
+		if exposed := v.jwt.exposedClaims(claims); exposed != nil {
+			ctx.SetData(JWTClaimsDataKey, exposed)
+		}
 	}
 	if v.signer != nil {
 		if err := v.signer.Verify(req.Std()); err != nil {
@@ -169,6 +203,29 @@ func (v *Validator) Handle(ctx *context.Context) string {
 			return resultInvalid
 		}
 	}
+	if v.digestAuth != nil {
+		if err := v.digestAuth.Validate(req); err != nil {
+			prepareErrorResponse(http.StatusUnauthorized, "http digest validator: ", err)
+			resp := ctx.GetOutputResponse().(*httpprot.Response)
+			resp.HTTPHeader().Set("WWW-Authenticate", v.digestAuth.Challenge(err))
+			return resultInvalid
+		}
+	}
+	if v.hmac != nil {
+		if err := v.hmac.Validate(req); err != nil {
+			prepareErrorResponse(http.StatusUnauthorized, "hmac validator: ", err)
+			return resultInvalid
+		}
+	}
+	if v.jsonSchema != nil {
+		if err := v.jsonSchema.Validate(req); err != nil {
+			if e, ok := err.(*JSONSchemaValidationError); ok {
+				ctx.SetData(JSONSchemaErrorsDataKey, e.Errors)
+			}
+			prepareErrorResponse(http.StatusBadRequest, "json schema validator: ", err)
+			return resultInvalid
+		}
+	}
 
 	return ""
 }
@@ -178,7 +235,16 @@ func (v *Validator) Status() interface{} { return nil }
 
 // Close closes validations.
 func (v *Validator) Close() {
+	if v.jwt != nil {
+		v.jwt.Close()
+	}
+	if v.hmac != nil {
+		v.hmac.Close()
+	}
 	if v.basicAuth != nil {
 		v.basicAuth.Close()
 	}
+	if v.digestAuth != nil {
+		v.digestAuth.Close()
+	}
 }
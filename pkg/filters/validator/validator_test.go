@@ -18,16 +18,33 @@
 package validator
 
 import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"hash"
 	"io"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt"
+
 	cluster "github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/cluster/clustertest"
 	"github.com/megaease/easegress/pkg/context"
@@ -177,6 +194,194 @@ jwt:
 	}
 }
 
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return s
+}
+
+func TestJWTRS256(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	assert.Nil(err)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	yamlSpec := `
+kind: Validator
+name: validator
+jwt:
+  algorithm: RS256
+  issuer: megaease
+  audience: easegress
+  exposeClaims: [sub, role]
+  publicKey: |
+    ` + strings.ReplaceAll(strings.TrimSpace(string(pemKey)), "\n", "\n    ")
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	validate := func(token string) (string, *context.Context) {
+		ctx := context.New(nil)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		assert.Nil(err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		setRequest(t, ctx, req)
+		return v.Handle(ctx), ctx
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":  "user1",
+		"role": "admin",
+		"name": "John Doe",
+		"iss":  "megaease",
+		"aud":  "easegress",
+		"exp":  now.Add(time.Hour).Unix(),
+	}
+
+	// valid
+	result, ctx := validate(signRS256(t, key, "", claims))
+	assert.Equal("", result)
+	assert.Equal(map[string]interface{}{"sub": "user1", "role": "admin"}, ctx.GetData(JWTClaimsDataKey))
+
+	// expired
+	claims["exp"] = now.Add(-time.Hour).Unix()
+	result, _ = validate(signRS256(t, key, "", claims))
+	assert.Equal(resultInvalid, result)
+	claims["exp"] = now.Add(time.Hour).Unix()
+
+	// wrong issuer
+	claims["iss"] = "other"
+	result, _ = validate(signRS256(t, key, "", claims))
+	assert.Equal(resultInvalid, result)
+	claims["iss"] = "megaease"
+
+	// wrong audience
+	claims["aud"] = []string{"other"}
+	result, _ = validate(signRS256(t, key, "", claims))
+	assert.Equal(resultInvalid, result)
+	claims["aud"] = []string{"other", "easegress"}
+	result, _ = validate(signRS256(t, key, "", claims))
+	assert.Equal("", result)
+
+	// bad signature
+	result, _ = validate(signRS256(t, otherKey, "", claims))
+	assert.Equal(resultInvalid, result)
+
+	// unexpected algorithm
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hsToken, err := token.SignedString([]byte("secret"))
+	assert.Nil(err)
+	result, _ = validate(hsToken)
+	assert.Equal(resultInvalid, result)
+}
+
+func TestJWTJWKS(t *testing.T) {
+	assert := assert.New(t)
+
+	key1, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+
+	jwk := func(kid string, key *rsa.PrivateKey) map[string]string {
+		return map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+	}
+
+	var lock sync.Mutex
+	var fetchCount int
+	keys := []map[string]string{jwk("k1", key1)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		fetchCount++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	yamlSpec := `
+kind: Validator
+name: validator
+jwt:
+  algorithm: RS256
+  jwksURL: ` + server.URL
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	validate := func(token string) string {
+		ctx := context.New(nil)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		assert.Nil(err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		setRequest(t, ctx, req)
+		return v.Handle(ctx)
+	}
+
+	claims := jwt.MapClaims{"sub": "user1", "exp": time.Now().Add(time.Hour).Unix()}
+
+	assert.Equal("", validate(signRS256(t, key1, "k1", claims)))
+	assert.Equal("", validate(signRS256(t, key1, "", claims)))
+	assert.Equal(resultInvalid, validate(signRS256(t, key2, "k1", claims)))
+
+	// the keys are cached
+	lock.Lock()
+	assert.Equal(1, fetchCount)
+
+	// rotate the keys
+	keys = []map[string]string{jwk("k2", key2)}
+	lock.Unlock()
+
+	// not refreshed as the min refresh interval is not reached
+	assert.Equal(resultInvalid, validate(signRS256(t, key2, "k2", claims)))
+
+	v.jwt.jwks.minRefreshInterval = 0
+	assert.Equal("", validate(signRS256(t, key2, "k2", claims)))
+	assert.Equal(resultInvalid, validate(signRS256(t, key1, "k1", claims)))
+}
+
+func TestJWTValidatorSpec(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &JWTValidatorSpec{Algorithm: "HS256"}
+	assert.Error(spec.Validate())
+	spec.Secret = "313233343536"
+	assert.NoError(spec.Validate())
+
+	spec = &JWTValidatorSpec{Algorithm: "RS256"}
+	assert.Error(spec.Validate())
+	spec.PublicKey = "invalid"
+	assert.Error(spec.Validate())
+	spec.PublicKey = ""
+	spec.JWKSURL = "http://127.0.0.1/jwks"
+	assert.NoError(spec.Validate())
+
+	// non-positive refresh intervals are rejected, and fall back to the
+	// default if they are not validated.
+	for _, interval := range []string{"0s", "-1m"} {
+		spec.JWKSRefreshInterval = interval
+		assert.Error(spec.Validate())
+		v := NewJWTValidator(spec)
+		assert.Equal(defaultJWKSRefreshInterval, v.jwks.syncInterval)
+		v.Close()
+	}
+	spec.JWKSRefreshInterval = "1m"
+	assert.NoError(spec.Validate())
+}
+
 func TestOAuth2JWT(t *testing.T) {
 	assert := assert.New(t)
 
@@ -424,6 +629,47 @@ basicAuth:
 		v.Close()
 	})
 
+	t.Run("userFile watched by fsnotify", func(t *testing.T) {
+		assert := assert.New(t)
+		dir, err := os.MkdirTemp("", "apache2-htpasswd")
+		check(err)
+		defer os.RemoveAll(dir)
+
+		userFile := filepath.Join(dir, ".htpasswd")
+		write := func(name string, lines ...string) {
+			check(os.WriteFile(name, []byte(strings.Join(lines, "\n")), 0o600))
+		}
+		write(userFile, userIds[0]+":"+encryptedPasswords[0])
+
+		// A long sync interval makes sure that updates come from fsnotify.
+		huc := newHtpasswdUserCache(userFile, time.Hour)
+		huc.WatchChanges()
+		defer huc.Close()
+		assert.True(huc.Match(userIds[0], passwords[0]))
+		assert.False(huc.Match(userIds[1], passwords[1]))
+
+		// In place edit.
+		write(userFile, userIds[0]+":"+encryptedPasswords[0], userIds[1]+":"+encryptedPasswords[1])
+		assert.Eventually(func() bool {
+			return huc.Match(userIds[1], passwords[1])
+		}, time.Second, 10*time.Millisecond)
+
+		// Atomic replace by renaming a new file over the user file.
+		tmpFile := filepath.Join(dir, ".htpasswd.tmp")
+		write(tmpFile, userIds[1]+":"+encryptedPasswords[1])
+		check(os.Rename(tmpFile, userFile))
+		assert.Eventually(func() bool {
+			return !huc.Match(userIds[0], passwords[0])
+		}, time.Second, 10*time.Millisecond)
+		assert.True(huc.Match(userIds[1], passwords[1]))
+
+		// The watch survives the replacement.
+		write(userFile, userIds[0]+":"+encryptedPasswords[0])
+		assert.Eventually(func() bool {
+			return huc.Match(userIds[0], passwords[0])
+		}, time.Second, 10*time.Millisecond)
+	})
+
 	t.Run("test kvsToReader", func(t *testing.T) {
 		kvs := make(map[string]string)
 		kvs["/creds/key1"] = "key: key1\npass: pw"     // invalid
@@ -517,4 +763,443 @@ lastEntry: "byebye"
 		assert.Equal("doge", header.Get("X-AUTH-USER"))
 		v.Close()
 	})
+
+	t.Run("unknown users from etcd", func(t *testing.T) {
+		assert := assert.New(t)
+		clusterInstance, syncerChannel := createClusterAndSyncer()
+
+		// All credentials are loaded by prefix and kept in memory, lookups
+		// of unknown users must never reach etcd.
+		etcdGets := 0
+		clusterInstance.MockedGet = func(key string) (*string, error) {
+			etcdGets++
+			return nil, nil
+		}
+		clusterInstance.MockedGetPrefix = func(key string) (map[string]string, error) {
+			return map[string]string{
+				"/custom-data/credentials/1": "key: " + userIds[0] + "\npassword: " + encryptedPasswords[0],
+			}, nil
+		}
+
+		euc := newEtcdUserCache(clusterInstance, "")
+		euc.WatchChanges()
+		for i := 0; i < 100; i++ {
+			assert.False(euc.Match("nobody", "nopw"))
+		}
+		assert.True(euc.Match(userIds[0], passwords[0]))
+		assert.Equal(0, etcdGets)
+
+		// A user created later is picked up by the syncer.
+		syncerChannel <- map[string]string{
+			"/custom-data/credentials/1":      "key: " + userIds[0] + "\npassword: " + encryptedPasswords[0],
+			"/custom-data/credentials/nobody": "key: nobody\npassword: nopw",
+		}
+		time.Sleep(time.Millisecond * 100)
+		assert.True(euc.Match("nobody", "nopw"))
+		assert.Equal(0, etcdGets)
+		euc.Close()
+	})
+}
+
+func digestHash(newHash func() hash.Hash, s string) string {
+	h := newHash()
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func TestDigestAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	// authorizeNC answers the challenge as a client does with nonce
+	// count nc.
+	authorizeNC := func(newHash func() hash.Hash, challenge, user, password, uri, nc string) string {
+		params, err := parseDigestAuthorizationHeader(challenge)
+		assert.Nil(err)
+		ha1 := digestHash(newHash, user+":"+params["realm"]+":"+password)
+		ha2 := digestHash(newHash, http.MethodGet+":"+uri)
+		response := digestHash(newHash, ha1+":"+params["nonce"]+":"+nc+":0a4f113b:auth:"+ha2)
+		return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", `+
+			`algorithm=%s, qop=auth, nc=%s, cnonce="0a4f113b", response="%s", opaque="%s"`,
+			user, params["realm"], params["nonce"], uri, params["algorithm"], nc, response, params["opaque"])
+	}
+	authorize := func(newHash func() hash.Hash, challenge, user, password, uri string) string {
+		return authorizeNC(newHash, challenge, user, password, uri, "00000001")
+	}
+
+	handle := func(v *Validator, authorization string) (string, *context.Context, http.Header) {
+		ctx := context.New(nil)
+		req, err := http.NewRequest(http.MethodGet, "http://example.com/api?x=1", nil)
+		check(err)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		setRequest(t, ctx, req)
+		return v.Handle(ctx), ctx, req.Header
+	}
+
+	challengeOf := func(ctx *context.Context) string {
+		resp := ctx.GetOutputResponse().(*httpprot.Response)
+		assert.Equal(http.StatusUnauthorized, resp.StatusCode())
+		return resp.HTTPHeader().Get("WWW-Authenticate")
+	}
+
+	t.Run("users from file", func(t *testing.T) {
+		userFile, err := os.CreateTemp("", "apache2-htdigest")
+		check(err)
+		defer os.Remove(userFile.Name())
+		userFile.Write([]byte("userY:testrealm:" + digestHash(md5.New, "userY:testrealm:userpasswordY") + "\n" +
+			"userZ:otherrealm:" + digestHash(md5.New, "userZ:otherrealm:userpasswordZ") + "\n"))
+
+		yamlSpec := `
+kind: Validator
+name: validator
+digestAuth:
+  mode: FILE
+  realm: testrealm
+  nonceTTL: 1m
+  userFile: ` + userFile.Name()
+		v := createValidator(yamlSpec, nil, nil)
+		defer v.Close()
+
+		// The first request gets a challenge.
+		result, ctx, _ := handle(v, "")
+		assert.Equal(resultInvalid, result)
+		challenge := challengeOf(ctx)
+		assert.True(strings.HasPrefix(challenge, `Digest realm="testrealm", qop="auth", algorithm=MD5, nonce=`))
+		assert.NotContains(challenge, "stale")
+
+		// Full exchange.
+		authorization := authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1")
+		result, _, header := handle(v, authorization)
+		assert.Equal("", result)
+		assert.Equal("userY", header.Get("X-AUTH-USER"))
+
+		// Replayed requests are rejected, the nonce count must increase.
+		result, ctx, _ = handle(v, authorization)
+		assert.Equal(resultInvalid, result)
+		assert.NotContains(challengeOf(ctx), "stale")
+		result, _, _ = handle(v, authorizeNC(md5.New, challenge, "userY", "userpasswordY", "/api?x=1", "00000003"))
+		assert.Equal("", result)
+		result, _, _ = handle(v, authorizeNC(md5.New, challenge, "userY", "userpasswordY", "/api?x=1", "00000002"))
+		assert.Equal(resultInvalid, result)
+
+		// Wrong password, wrong uri and users of other realms.
+		result, ctx, _ = handle(v, authorize(md5.New, challenge, "userY", "wrong", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+		assert.NotContains(challengeOf(ctx), "stale")
+		result, _, _ = handle(v, authorize(md5.New, challenge, "userY", "userpasswordY", "/other"))
+		assert.Equal(resultInvalid, result)
+		result, _, _ = handle(v, authorize(md5.New, challenge, "userZ", "userpasswordZ", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+
+		// Forged nonce.
+		forged := strings.Replace(challenge, `nonce="`, `nonce="A`, 1)
+		result, ctx, _ = handle(v, authorize(md5.New, forged, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+		assert.NotContains(challengeOf(ctx), "stale")
+
+		// Stale nonce with correct credentials, the new nonce works.
+		now = now.Add(2 * time.Minute)
+		result, ctx, _ = handle(v, authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+		challenge = challengeOf(ctx)
+		assert.True(strings.HasSuffix(challenge, ", stale=true"))
+		result, _, _ = handle(v, authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal("", result)
+
+		// Stale nonce with wrong password is not stale.
+		now = now.Add(2 * time.Minute)
+		result, ctx, _ = handle(v, authorize(md5.New, challenge, "userY", "wrong", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+		assert.NotContains(challengeOf(ctx), "stale")
+	})
+
+	t.Run("nonce secret", func(t *testing.T) {
+		userFile, err := os.CreateTemp("", "apache2-htdigest")
+		check(err)
+		defer os.Remove(userFile.Name())
+		userFile.Write([]byte("userY:easegress:" + digestHash(md5.New, "userY:easegress:userpasswordY") + "\n"))
+
+		yamlSpec := `
+kind: Validator
+name: validator
+digestAuth:
+  mode: FILE
+  nonceSecret: secret
+  userFile: ` + userFile.Name()
+		v1 := createValidator(yamlSpec, nil, nil)
+		defer v1.Close()
+		v2 := createValidator(yamlSpec, nil, nil)
+		defer v2.Close()
+		v3 := createValidator(strings.Replace(yamlSpec, "nonceSecret: secret", "nonceSecret: other", 1), nil, nil)
+		defer v3.Close()
+
+		// The nonces are accepted by the validators sharing the secret.
+		_, ctx, _ := handle(v1, "")
+		challenge := challengeOf(ctx)
+		result, _, _ := handle(v2, authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal("", result)
+		result, _, _ = handle(v3, authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+	})
+
+	t.Run("users from etcd", func(t *testing.T) {
+		clusterInstance, _ := createClusterAndSyncer()
+		clusterInstance.MockedGetPrefix = func(key string) (map[string]string, error) {
+			return map[string]string{
+				"/custom-data/credentials/1": "key: userY\npassword: userpasswordY",
+				"/custom-data/credentials/2": "username: userZ\npassword: userpasswordZ",
+				"/custom-data/credentials/3": "username: userB\npassword: $2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC",
+			}, nil
+		}
+		supervisor := supervisor.NewMock(
+			nil, clusterInstance, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+
+		yamlSpec := `
+kind: Validator
+name: validator
+digestAuth:
+  mode: ETCD
+  algorithm: SHA-256
+`
+		v := createValidator(yamlSpec, nil, supervisor)
+		defer v.Close()
+
+		result, ctx, _ := handle(v, "")
+		assert.Equal(resultInvalid, result)
+		challenge := challengeOf(ctx)
+		assert.Contains(challenge, `realm="easegress"`)
+		assert.Contains(challenge, "algorithm=SHA-256")
+
+		result, _, header := handle(v, authorize(sha256.New, challenge, "userZ", "userpasswordZ", "/api?x=1"))
+		assert.Equal("", result)
+		assert.Equal("userZ", header.Get("X-AUTH-USER"))
+
+		// MD5 responses are rejected.
+		result, _, _ = handle(v, authorize(md5.New, challenge, "userY", "userpasswordY", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+		result, _, _ = handle(v, authorize(sha256.New, challenge, "userY", "wrong", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+
+		// Users with hashed passwords are ignored.
+		result, _, _ = handle(v, authorize(sha256.New, challenge, "userB", "$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC", "/api?x=1"))
+		assert.Equal(resultInvalid, result)
+	})
+}
+
+func signHMAC(secret, method, path, body, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + body + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMAC(t *testing.T) {
+	assert := assert.New(t)
+
+	secretFile, err := os.CreateTemp("", "hmac-secrets")
+	check(err)
+	defer os.Remove(secretFile.Name())
+	secretFile.Write([]byte("# comment\nkey1:secret1\n"))
+
+	yamlSpec := `
+kind: Validator
+name: validator
+hmac:
+  mode: FILE
+  clockSkew: 1m
+  secretFile: ` + secretFile.Name()
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	newCtx := func(body, keyID, signature, timestamp string) *context.Context {
+		ctx := context.New(nil)
+		stdReq, err := http.NewRequest(http.MethodPost, "http://example.com/api", strings.NewReader(body))
+		check(err)
+		stdReq.Header.Set("X-Hmac-Key-Id", keyID)
+		stdReq.Header.Set("X-Hmac-Signature", signature)
+		stdReq.Header.Set("X-Hmac-Timestamp", timestamp)
+		req, err := httpprot.NewRequest(stdReq)
+		check(err)
+		check(req.FetchPayload(0))
+		ctx.SetInputRequest(req)
+		return ctx
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := signHMAC("secret1", http.MethodPost, "/api", "hello", now)
+
+	// valid signature, and the body is still readable
+	ctx := newCtx("hello", "key1", sig, now)
+	assert.NotEqual(resultInvalid, v.Handle(ctx))
+	assert.Equal("hello", string(ctx.GetInputRequest().(*httpprot.Request).RawPayload()))
+
+	// tampered body
+	ctx = newCtx("hello!", "key1", sig, now)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+
+	// unknown key id
+	ctx = newCtx("hello", "key2", sig, now)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+
+	// missing signature
+	ctx = newCtx("hello", "key1", "", now)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+
+	// expired timestamp
+	stale := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	sig = signHMAC("secret1", http.MethodPost, "/api", "hello", stale)
+	ctx = newCtx("hello", "key1", sig, stale)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+
+	// timestamp from the future
+	future := strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10)
+	sig = signHMAC("secret1", http.MethodPost, "/api", "hello", future)
+	ctx = newCtx("hello", "key1", sig, future)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+
+	t.Run("secretFile replaced by renaming", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "hmac-secrets")
+		check(err)
+		defer os.RemoveAll(dir)
+
+		secretFile := filepath.Join(dir, "secrets")
+		check(os.WriteFile(secretFile, []byte("key1:secret1\n"), 0o600))
+
+		c := newFileHMACSecretCache(secretFile)
+		c.WatchChanges()
+		defer c.Close()
+
+		secret, _ := c.Get("key1")
+		assert.Equal("secret1", secret)
+
+		// Atomic replace by renaming a new file over the secret file.
+		tmpFile := filepath.Join(dir, "secrets.tmp")
+		check(os.WriteFile(tmpFile, []byte("key1:secret2\n"), 0o600))
+		check(os.Rename(tmpFile, secretFile))
+		assert.Eventually(func() bool {
+			secret, _ := c.Get("key1")
+			return secret == "secret2"
+		}, time.Second, 10*time.Millisecond)
+
+		// The watch survives the replacement.
+		check(os.WriteFile(secretFile, []byte("key1:secret3\n"), 0o600))
+		assert.Eventually(func() bool {
+			secret, _ := c.Get("key1")
+			return secret == "secret3"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("secrets from etcd", func(t *testing.T) {
+		clusterInstance, syncerChannel := createClusterAndSyncer()
+		clusterInstance.MockedGetPrefix = func(key string) (map[string]string, error) {
+			return map[string]string{
+				"/custom-data/hmac-secrets/key1": "key: key1\nsecret: secret1",
+				"/custom-data/hmac-secrets/bad":  "key: bad",
+			}, nil
+		}
+
+		supervisor := supervisor.NewMock(
+			nil, clusterInstance, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+
+		yamlSpec := `
+kind: Validator
+name: validator
+hmac:
+  mode: ETCD
+  algorithm: sha512
+`
+		v := createValidator(yamlSpec, nil, supervisor)
+		defer v.Close()
+
+		sign := func(secret string) string {
+			mac := hmac.New(sha512.New, []byte(secret))
+			mac.Write([]byte(http.MethodPost + "\n/api\nhello\n" + now))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+
+		ctx := newCtx("hello", "key1", sign("secret1"), now)
+		assert.NotEqual(resultInvalid, v.Handle(ctx))
+
+		// sha256 signature is rejected
+		ctx = newCtx("hello", "key1", signHMAC("secret1", http.MethodPost, "/api", "hello", now), now)
+		assert.Equal(resultInvalid, v.Handle(ctx))
+
+		syncerChannel <- map[string]string{
+			"/custom-data/hmac-secrets/key1": "key: key1\nsecret: secret2",
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		ctx = newCtx("hello", "key1", sign("secret1"), now)
+		assert.Equal(resultInvalid, v.Handle(ctx))
+		ctx = newCtx("hello", "key1", sign("secret2"), now)
+		assert.NotEqual(resultInvalid, v.Handle(ctx))
+	})
+}
+
+func TestJSONSchema(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: Validator
+name: validator
+jsonSchema:
+  type: object
+  properties:
+    name:
+      type: string
+    age:
+      type: integer
+      minimum: 0
+  required: [name]
+`
+	v := createValidator(yamlSpec, nil, nil)
+	defer v.Close()
+
+	newCtx := func(body string) *context.Context {
+		ctx := context.New(nil)
+		stdReq, err := http.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(body))
+		check(err)
+		req, err := httpprot.NewRequest(stdReq)
+		check(err)
+		check(req.FetchPayload(0))
+		ctx.SetInputRequest(req)
+		return ctx
+	}
+
+	body := `{"name": "doge", "age": 3}`
+	ctx := newCtx(body)
+	assert.NotEqual(resultInvalid, v.Handle(ctx))
+	assert.Nil(ctx.GetData(JSONSchemaErrorsDataKey))
+	data, err := io.ReadAll(ctx.GetInputRequest().GetPayload())
+	assert.NoError(err)
+	assert.Equal(body, string(data))
+
+	ctx = newCtx(`{"age": -1}`)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+	assert.Equal(http.StatusBadRequest, ctx.GetOutputResponse().(*httpprot.Response).StatusCode())
+	errs := ctx.GetData(JSONSchemaErrorsDataKey).([]string)
+	assert.Len(errs, 2)
+
+	ctx = newCtx(`not json`)
+	assert.Equal(resultInvalid, v.Handle(ctx))
+	assert.Len(ctx.GetData(JSONSchemaErrorsDataKey), 1)
+
+	// malformed schema is rejected
+	yamlSpec = `
+kind: Validator
+name: validator
+jsonSchema:
+  type: object
+  properties:
+    name:
+      type: 1
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	_, err = filters.NewSpec(nil, "", rawSpec)
+	assert.Error(err)
 }
@@ -0,0 +1,364 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmljson
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	attrPrefix = "@"
+	textKey    = "#text"
+
+	// arrayItemName is the name of the elements converted from the items
+	// of a top-level JSON array.
+	arrayItemName = "item"
+)
+
+type (
+	// orderedMap is a JSON object which keeps the order of its keys, the
+	// order of XML elements matters, e.g. in SOAP messages.
+	orderedMap struct {
+		keys   []string
+		values map[string]interface{}
+	}
+
+	// element is an XML element being decoded.
+	element struct {
+		name     string
+		obj      *orderedMap
+		text     strings.Builder
+		children bool
+	}
+)
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: map[string]interface{}{}}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// add adds value to key, values of the same key are grouped to an array.
+func (m *orderedMap) add(key string, value interface{}) {
+	old, ok := m.values[key]
+	if !ok {
+		m.set(key, value)
+		return
+	}
+	if arr, ok := old.([]interface{}); ok {
+		m.values[key] = append(arr, value)
+	} else {
+		m.values[key] = []interface{}{old, value}
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// qualifiedName returns the name with its namespace prefix, namespaces
+// are not resolved so that the prefixes are kept as they are.
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// xmlToJSON converts XML to JSON. Attributes are converted to fields
+// prefixed with '@', elements with the same name are grouped to an array,
+// the text of an element is a string if it has no attribute or child,
+// otherwise it is the '#text' field.
+func xmlToJSON(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var root *orderedMap
+	var stack []*element
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if root != nil {
+				return nil, fmt.Errorf("more than one root element")
+			}
+			e := &element{name: qualifiedName(t.Name), obj: newOrderedMap()}
+			for _, attr := range t.Attr {
+				e.obj.set(attrPrefix+qualifiedName(attr.Name), attr.Value)
+			}
+			if len(stack) > 0 {
+				stack[len(stack)-1].children = true
+			}
+			stack = append(stack, e)
+
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(t)
+			} else if len(bytes.TrimSpace(t)) > 0 {
+				return nil, fmt.Errorf("text out of the root element")
+			}
+
+		case xml.EndElement:
+			if len(stack) == 0 || stack[len(stack)-1].name != qualifiedName(t.Name) {
+				return nil, fmt.Errorf("unexpected end element %s", qualifiedName(t.Name))
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			var value interface{}
+			text := e.text.String()
+			if e.children {
+				text = strings.TrimSpace(text)
+			}
+			if len(e.obj.keys) == 0 && !e.children {
+				value = text
+			} else {
+				if text != "" {
+					e.obj.set(textKey, text)
+				}
+				value = e.obj
+			}
+
+			if len(stack) > 0 {
+				stack[len(stack)-1].obj.add(e.name, value)
+			} else {
+				root = newOrderedMap()
+				root.set(e.name, value)
+			}
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("unexpected EOF")
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return json.Marshal(root)
+}
+
+// decodeJSON decodes JSON, objects are decoded to orderedMap.
+func decodeJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			m := newOrderedMap()
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				m.set(key.(string), value)
+			}
+			_, err = decoder.Token()
+			return m, err
+		case '[':
+			arr := []interface{}{}
+			for decoder.More() {
+				value, err := decodeJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, value)
+			}
+			_, err = decoder.Token()
+			return arr, err
+		}
+		return nil, fmt.Errorf("unexpected delimiter %v", t)
+	default:
+		return t, nil
+	}
+}
+
+// jsonToXML converts JSON to XML, it is the reverse of xmlToJSON. If the
+// JSON is not an object with exactly one field whose value is not an
+// array, it is wrapped in an element named rootName, so that the XML
+// always has a single root element. The items of a top-level array are
+// converted to elements named arrayItemName.
+func jsonToXML(data []byte, rootName string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	value, err := decodeJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid data after the top-level value")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	switch v := value.(type) {
+	case []interface{}:
+		m := newOrderedMap()
+		m.set(arrayItemName, v)
+		err = writeElement(&buf, rootName, m)
+	case *orderedMap:
+		if len(v.keys) == 1 && isElementName(v.keys[0]) {
+			if _, isArray := v.values[v.keys[0]].([]interface{}); !isArray {
+				err = writeElement(&buf, v.keys[0], v.values[v.keys[0]])
+				break
+			}
+		}
+		err = writeElement(&buf, rootName, v)
+	default:
+		err = writeElement(&buf, rootName, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isElementName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, attrPrefix) && name != textKey
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n<>&\"'/=") {
+		return fmt.Errorf("invalid XML name %q", name)
+	}
+	return nil
+}
+
+func writeText(buf *bytes.Buffer, value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	case bool:
+		s = fmt.Sprint(v)
+	default:
+		return fmt.Errorf("unexpected value %v", v)
+	}
+	return xml.EscapeText(buf, []byte(s))
+}
+
+func writeElement(buf *bytes.Buffer, name string, value interface{}) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	// an array is converted to elements of the same name.
+	if arr, ok := value.([]interface{}); ok {
+		for _, v := range arr {
+			if err := writeElement(buf, name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf.WriteString("<" + name)
+
+	m, ok := value.(*orderedMap)
+	if !ok {
+		if value == nil {
+			buf.WriteString("/>")
+			return nil
+		}
+		buf.WriteByte('>')
+		if err := writeText(buf, value); err != nil {
+			return err
+		}
+		buf.WriteString("</" + name + ">")
+		return nil
+	}
+
+	for _, key := range m.keys {
+		if !strings.HasPrefix(key, attrPrefix) {
+			continue
+		}
+		attr := strings.TrimPrefix(key, attrPrefix)
+		if err := checkName(attr); err != nil {
+			return err
+		}
+		buf.WriteString(" " + attr + `="`)
+		if err := writeText(buf, m.values[key]); err != nil {
+			return err
+		}
+		buf.WriteByte('"')
+	}
+	buf.WriteByte('>')
+
+	for _, key := range m.keys {
+		switch {
+		case strings.HasPrefix(key, attrPrefix):
+		case key == textKey:
+			if err := writeText(buf, m.values[key]); err != nil {
+				return err
+			}
+		default:
+			if err := writeElement(buf, key, m.values[key]); err != nil {
+				return err
+			}
+		}
+	}
+
+	buf.WriteString("</" + name + ">")
+	return nil
+}
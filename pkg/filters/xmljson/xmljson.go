@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package xmljson implements a filter to convert bodies between XML and
+// JSON.
+package xmljson
+
+import (
+	"strconv"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols"
+)
+
+const (
+	// Kind is the kind of XMLJSONConverter.
+	Kind = "XMLJSONConverter"
+
+	conversionXMLToJSON = "xmlToJSON"
+	conversionJSONToXML = "jsonToXML"
+
+	directionRequest  = "request"
+	directionResponse = "response"
+
+	defaultRootElement = "root"
+
+	resultResponseNotFound = "responseNotFound"
+	resultParseErr         = "parseErr"
+
+	keyContentType   = "Content-Type"
+	keyContentLength = "Content-Length"
+)
+
+var kind = &filters.Kind{
+	Name:        Kind,
+	Description: "XMLJSONConverter converts the body of the request or response between XML and JSON",
+	Results:     []string{resultResponseNotFound, resultParseErr},
+	DefaultSpec: func() filters.Spec {
+		return &Spec{
+			Conversion:  conversionXMLToJSON,
+			Direction:   directionRequest,
+			RootElement: defaultRootElement,
+		}
+	},
+	CreateInstance: func(spec filters.Spec) filters.Filter {
+		return &XMLJSONConverter{spec: spec.(*Spec)}
+	},
+}
+
+func init() {
+	filters.Register(kind)
+}
+
+type (
+	// XMLJSONConverter is the filter to convert bodies between XML and JSON.
+	XMLJSONConverter struct {
+		spec *Spec
+	}
+
+	// message is the common part of requests and responses.
+	message interface {
+		Header() protocols.Header
+		IsStream() bool
+		RawPayload() []byte
+		SetPayload(payload interface{})
+	}
+
+	// Spec describes the XMLJSONConverter.
+	Spec struct {
+		filters.BaseSpec `yaml:",inline"`
+
+		Conversion  string `yaml:"conversion" jsonschema:"omitempty,enum=xmlToJSON,enum=jsonToXML"`
+		Direction   string `yaml:"direction" jsonschema:"omitempty,enum=request,enum=response"`
+		RootElement string `yaml:"rootElement" jsonschema:"omitempty"`
+		ContentType string `yaml:"contentType" jsonschema:"omitempty"`
+	}
+)
+
+// Name returns the name of the XMLJSONConverter filter instance.
+func (c *XMLJSONConverter) Name() string {
+	return c.spec.Name()
+}
+
+// Kind returns the kind of XMLJSONConverter.
+func (c *XMLJSONConverter) Kind() *filters.Kind {
+	return kind
+}
+
+// Spec returns the spec used by the XMLJSONConverter.
+func (c *XMLJSONConverter) Spec() filters.Spec {
+	return c.spec
+}
+
+// Init initializes XMLJSONConverter.
+func (c *XMLJSONConverter) Init() {
+	c.reload()
+}
+
+// Inherit inherits previous generation of XMLJSONConverter.
+func (c *XMLJSONConverter) Inherit(previousGeneration filters.Filter) {
+	c.reload()
+}
+
+func (c *XMLJSONConverter) reload() {
+	if c.spec.RootElement == "" {
+		c.spec.RootElement = defaultRootElement
+	}
+	if c.spec.ContentType == "" {
+		if c.spec.Conversion == conversionJSONToXML {
+			c.spec.ContentType = "application/xml"
+		} else {
+			c.spec.ContentType = "application/json"
+		}
+	}
+}
+
+// Handle converts the body of the request or response.
+func (c *XMLJSONConverter) Handle(ctx *context.Context) string {
+	var msg message = ctx.GetInputRequest()
+	if c.spec.Direction == directionResponse {
+		resp := ctx.GetInputResponse()
+		if resp == nil {
+			return resultResponseNotFound
+		}
+		msg = resp
+	}
+
+	if msg.IsStream() {
+		logger.Debugf("%s: can not convert a stream body", c.Name())
+		return resultParseErr
+	}
+
+	var body []byte
+	var err error
+	if c.spec.Conversion == conversionJSONToXML {
+		body, err = jsonToXML(msg.RawPayload(), c.spec.RootElement)
+	} else {
+		body, err = xmlToJSON(msg.RawPayload())
+	}
+	if err != nil {
+		logger.Debugf("%s: failed to convert body: %v", c.Name(), err)
+		return resultParseErr
+	}
+
+	msg.SetPayload(body)
+	msg.Header().Set(keyContentType, c.spec.ContentType)
+	msg.Header().Set(keyContentLength, strconv.Itoa(len(body)))
+	return ""
+}
+
+// Status returns the status of XMLJSONConverter.
+func (c *XMLJSONConverter) Status() interface{} {
+	return nil
+}
+
+// Close closes XMLJSONConverter.
+func (c *XMLJSONConverter) Close() {
+}
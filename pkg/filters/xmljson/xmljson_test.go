@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package xmljson
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/filters"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	logger.InitNop()
+}
+
+const soapXML = `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/" xmlns:m="http://megaease.com/stock">
+  <soap:Body>
+    <m:GetStockPriceResponse>
+      <m:Price currency="USD">34.5</m:Price>
+      <m:Tag>a &amp; b</m:Tag>
+      <m:Tag>c</m:Tag>
+      <m:Empty/>
+    </m:GetStockPriceResponse>
+  </soap:Body>
+</soap:Envelope>`
+
+const soapJSON = `{"soap:Envelope": {
+  "@xmlns:soap": "http://schemas.xmlsoap.org/soap/envelope/",
+  "@xmlns:m": "http://megaease.com/stock",
+  "soap:Body": {
+    "m:GetStockPriceResponse": {
+      "m:Price": {"@currency": "USD", "#text": "34.5"},
+      "m:Tag": ["a & b", "c"],
+      "m:Empty": ""
+    }
+  }
+}}`
+
+func newTestConverter(yamlSpec string, assert *assert.Assertions) *XMLJSONConverter {
+	rawSpec := make(map[string]interface{})
+	err := yaml.Unmarshal([]byte(yamlSpec), &rawSpec)
+	assert.NoError(err)
+
+	spec, err := filters.NewSpec(nil, "", rawSpec)
+	assert.NoError(err)
+
+	c := kind.CreateInstance(spec).(*XMLJSONConverter)
+	c.Init()
+	return c
+}
+
+func newContext(body string) *context.Context {
+	stdr, _ := http.NewRequest(http.MethodPost, "http://megaease.com/", strings.NewReader(body))
+	req, _ := httpprot.NewRequest(stdr)
+	req.FetchPayload(0)
+	ctx := context.New(tracing.NoopSpan)
+	ctx.SetInputRequest(req)
+	return ctx
+}
+
+func TestConvert(t *testing.T) {
+	assert := assert.New(t)
+
+	j, err := xmlToJSON([]byte(soapXML))
+	assert.NoError(err)
+	assert.JSONEq(soapJSON, string(j))
+
+	// the order of the elements is kept.
+	assert.Less(strings.Index(string(j), `"@xmlns:soap"`), strings.Index(string(j), `"@xmlns:m"`))
+
+	// XML -> JSON -> XML -> JSON
+	x, err := jsonToXML(j, "root")
+	assert.NoError(err)
+	j2, err := xmlToJSON(x)
+	assert.NoError(err)
+	assert.Equal(string(j), string(j2))
+
+	// JSON -> XML -> JSON
+	x, err = jsonToXML([]byte(`{"order": {"@id": "1", "item": [{"name": "apple", "count": 2}, {"name": "pear", "count": 1}], "paid": true, "note": null}}`), "root")
+	assert.NoError(err)
+	assert.Equal(`<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<order id="1"><item><name>apple</name><count>2</count></item><item><name>pear</name><count>1</count></item><paid>true</paid><note/></order>`, string(x))
+	j, err = xmlToJSON(x)
+	assert.NoError(err)
+	assert.JSONEq(`{"order": {"@id": "1", "item": [{"name": "apple", "count": "2"}, {"name": "pear", "count": "1"}], "paid": "true", "note": ""}}`, string(j))
+
+	// values which are not an object with a single field are wrapped.
+	x, err = jsonToXML([]byte(`{"a": "<1>", "b": "2"}`), "data")
+	assert.NoError(err)
+	assert.Contains(string(x), `<data><a>&lt;1&gt;</a><b>2</b></data>`)
+
+	// the XML always has a single root element, arrays at the top level
+	// are wrapped too.
+	x, err = jsonToXML([]byte(`{"a": [1, 2]}`), "data")
+	assert.NoError(err)
+	assert.Contains(string(x), `<data><a>1</a><a>2</a></data>`)
+	j, err = xmlToJSON(x)
+	assert.NoError(err)
+	assert.JSONEq(`{"data": {"a": ["1", "2"]}}`, string(j))
+
+	x, err = jsonToXML([]byte(`[1, 2]`), "data")
+	assert.NoError(err)
+	assert.Contains(string(x), `<data><item>1</item><item>2</item></data>`)
+	j, err = xmlToJSON(x)
+	assert.NoError(err)
+	assert.JSONEq(`{"data": {"item": ["1", "2"]}}`, string(j))
+
+	x, err = jsonToXML([]byte(`[]`), "data")
+	assert.NoError(err)
+	assert.Contains(string(x), `<data></data>`)
+	_, err = xmlToJSON(x)
+	assert.NoError(err)
+
+	// malformed input.
+	for _, s := range []string{"", "<a>", "<a></b>", "<a/><b/>", "abc", "<a>1</a>x"} {
+		_, err = xmlToJSON([]byte(s))
+		assert.Error(err, s)
+	}
+	for _, s := range []string{"", `{"a": `, `{"a": 1} 2`, `{"a b": 1}`, `{"a": {"@b c": 1}}`} {
+		_, err = jsonToXML([]byte(s), "root")
+		assert.Error(err, s)
+	}
+}
+
+func TestXMLJSONConverterRequest(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: XMLJSONConverter
+name: converter
+`
+	c := newTestConverter(yamlSpec, assert)
+	defer c.Close()
+	assert.Equal(kind, c.Kind())
+	assert.Equal("converter", c.Name())
+	assert.Nil(c.Status())
+
+	ctx := newContext(soapXML)
+	assert.Equal("", c.Handle(ctx))
+	req := ctx.GetInputRequest().(*httpprot.Request)
+	assert.JSONEq(soapJSON, string(req.RawPayload()))
+	assert.Equal("application/json", req.HTTPHeader().Get("Content-Type"))
+	assert.Equal(strconv.Itoa(len(req.RawPayload())), req.HTTPHeader().Get("Content-Length"))
+
+	// malformed input is kept as it is.
+	ctx = newContext(`<a>`)
+	assert.Equal(resultParseErr, c.Handle(ctx))
+	assert.Equal(`<a>`, string(ctx.GetInputRequest().RawPayload()))
+}
+
+func TestXMLJSONConverterResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: XMLJSONConverter
+name: converter
+conversion: jsonToXML
+direction: response
+contentType: text/xml; charset=utf-8
+`
+	c := newTestConverter(yamlSpec, assert)
+
+	ctx := newContext("")
+	assert.Equal(resultResponseNotFound, c.Handle(ctx))
+
+	resp, _ := httpprot.NewResponse(nil)
+	resp.SetPayload(soapJSON)
+	ctx.SetInputResponse(resp)
+	assert.Equal("", c.Handle(ctx))
+	assert.Equal("text/xml; charset=utf-8", resp.HTTPHeader().Get("Content-Type"))
+
+	j, err := xmlToJSON(resp.RawPayload())
+	assert.NoError(err)
+	assert.JSONEq(soapJSON, string(j))
+
+	// the request is not touched.
+	assert.Equal("", string(ctx.GetInputRequest().RawPayload()))
+
+	resp.SetPayload(`{"a": `)
+	assert.Equal(resultParseErr, c.Handle(ctx))
+}
@@ -18,7 +18,10 @@
 package graceupdate
 
 import (
+	"net"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/megaease/grace/gracenet"
 
@@ -26,13 +29,86 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 )
 
+// envListenAddrs is the environment variable passing the addresses of
+// the listeners to the child process, gracenet only passes their fds.
+const envListenAddrs = "EG_LISTEN_ADDRS"
+
 var (
 	// Global is gracenet Net struct
 	Global     = &gracenet.Net{}
 	didInherit = os.Getenv("LISTEN_FDS") != ""
 	ppid       = os.Getppid()
+
+	// inheritedAddrs are the addresses of the listeners passed by the
+	// parent process, they are removed once inherited.
+	inheritedAddrs = parseListenAddrs(os.Getenv(envListenAddrs))
+
+	// listeners are the listeners created by Listen and not closed yet.
+	listeners      = map[*listener]struct{}{}
+	listenersMutex sync.Mutex
 )
 
+type listener struct {
+	net.Listener
+	closeOnce sync.Once
+}
+
+func parseListenAddrs(s string) map[string]struct{} {
+	addrs := map[string]struct{}{}
+	for _, addr := range strings.Split(s, ",") {
+		if addr != "" {
+			addrs[addr] = struct{}{}
+		}
+	}
+	return addrs
+}
+
+// Listen announces on the local network address by Global, inherited
+// reports whether the listener is inherited from the parent process
+// rather than freshly bound.
+func Listen(network, addr string) (l net.Listener, inherited bool, err error) {
+	nl, err := Global.Listen(network, addr)
+	if err != nil {
+		return nil, false, err
+	}
+
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	key := nl.Addr().String()
+	if _, exists := inheritedAddrs[key]; exists && didInherit {
+		inherited = true
+		delete(inheritedAddrs, key)
+	}
+
+	ln := &listener{Listener: nl}
+	listeners[ln] = struct{}{}
+
+	return ln, inherited, nil
+}
+
+// Close closes the listener and stops passing it to the child process.
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		listenersMutex.Lock()
+		delete(listeners, l)
+		listenersMutex.Unlock()
+	})
+	return l.Listener.Close()
+}
+
+// listenAddrs returns the addresses of the listeners joined by comma.
+func listenAddrs() string {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	addrs := make([]string, 0, len(listeners))
+	for l := range listeners {
+		addrs = append(addrs, l.Addr().String())
+	}
+	return strings.Join(addrs, ",")
+}
+
 // IsInherit returns if I am the child process
 // on gracefully updating process.
 func IsInherit() bool {
@@ -64,6 +140,7 @@ func NotifySigUsr2(closeCls func(), restartCls func()) error {
 		sig := <-sigUsr2
 		closeCls()
 		logger.Infof("%s signal received, graceful update easegress", sig)
+		os.Setenv(envListenAddrs, listenAddrs())
 		if pid, err := Global.StartProcess(); err != nil {
 			logger.Errorf("graceful update failed: %v", err)
 			restartCls()
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graceupdate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	assert := assert.New(t)
+
+	l, inherited, err := Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	assert.False(inherited)
+	addr := l.Addr().String()
+	assert.Equal(addr, listenAddrs())
+
+	assert.NoError(l.Close())
+	assert.Equal("", listenAddrs())
+
+	// Pretend the address is passed by the parent process.
+	didInherit = true
+	inheritedAddrs = parseListenAddrs("," + addr)
+	defer func() {
+		didInherit = false
+		inheritedAddrs = map[string]struct{}{}
+	}()
+
+	l, inherited, err = Listen("tcp", addr)
+	assert.NoError(err)
+	assert.True(inherited)
+	assert.NoError(l.Close())
+
+	// The address is inherited only once.
+	l, inherited, err = Listen("tcp", addr)
+	assert.NoError(err)
+	assert.False(inherited)
+	assert.NoError(l.Close())
+}
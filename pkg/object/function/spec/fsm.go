@@ -19,6 +19,8 @@ package spec
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type (
@@ -38,6 +40,8 @@ type (
 		From  State
 		Event Event
 		To    State
+		// Guard is optional, the transition only fires if it returns true.
+		Guard func(fsm *FSM) bool
 	}
 )
 
@@ -104,29 +108,29 @@ var (
 
 func init() {
 	table := []transition{
-		{InitialState, UpdateEvent, InitialState},
-		{InitialState, DeleteEvent, DestroyedState},
-		{InitialState, ReadyEvent, ActiveState},
-		{InitialState, PendingEvent, InitialState},
-		{InitialState, ErrorEvent, FailedState},
-
-		{ActiveState, StopEvent, InactiveState},
-		{ActiveState, ErrorEvent, FailedState},
-		{ActiveState, ReadyEvent, ActiveState},
-		{ActiveState, PendingEvent, FailedState},
-
-		{InactiveState, UpdateEvent, InitialState},
-		{InactiveState, StartEvent, InactiveState},
-		{InactiveState, DeleteEvent, DestroyedState},
-		{InactiveState, ReadyEvent, ActiveState},
-		{InactiveState, PendingEvent, FailedState},
-		{InactiveState, ErrorEvent, FailedState},
-
-		{FailedState, DeleteEvent, DestroyedState},
-		{FailedState, UpdateEvent, InitialState},
-		{FailedState, ReadyEvent, InitialState},
-		{FailedState, ErrorEvent, FailedState},
-		{FailedState, PendingEvent, FailedState},
+		{From: InitialState, Event: UpdateEvent, To: InitialState},
+		{From: InitialState, Event: DeleteEvent, To: DestroyedState},
+		{From: InitialState, Event: ReadyEvent, To: ActiveState},
+		{From: InitialState, Event: PendingEvent, To: InitialState},
+		{From: InitialState, Event: ErrorEvent, To: FailedState},
+
+		{From: ActiveState, Event: StopEvent, To: InactiveState},
+		{From: ActiveState, Event: ErrorEvent, To: FailedState},
+		{From: ActiveState, Event: ReadyEvent, To: ActiveState},
+		{From: ActiveState, Event: PendingEvent, To: FailedState},
+
+		{From: InactiveState, Event: UpdateEvent, To: InitialState},
+		{From: InactiveState, Event: StartEvent, To: InactiveState},
+		{From: InactiveState, Event: DeleteEvent, To: DestroyedState},
+		{From: InactiveState, Event: ReadyEvent, To: ActiveState},
+		{From: InactiveState, Event: PendingEvent, To: FailedState},
+		{From: InactiveState, Event: ErrorEvent, To: FailedState},
+
+		{From: FailedState, Event: DeleteEvent, To: DestroyedState},
+		{From: FailedState, Event: UpdateEvent, To: InitialState},
+		{From: FailedState, Event: ReadyEvent, To: InitialState},
+		{From: FailedState, Event: ErrorEvent, To: FailedState},
+		{From: FailedState, Event: PendingEvent, To: FailedState},
 	}
 
 	// using Event as the key
@@ -156,14 +160,23 @@ func (fsm *FSM) Next(event Event) error {
 		return fmt.Errorf("unknown event: %s", event)
 	}
 
+	guarded := false
 	if t, exist := transitions[event]; exist {
 		for _, v := range t {
-			if fsm.currentState == v.From {
-				fsm.currentState = v.To
-				return nil
+			if fsm.currentState != v.From {
+				continue
 			}
+			if v.Guard != nil && !v.Guard(fsm) {
+				guarded = true
+				continue
+			}
+			fsm.currentState = v.To
+			return nil
 		}
 	}
+	if guarded {
+		return fmt.Errorf("event: %s blocked by guard, currentState: %s", event, fsm.currentState)
+	}
 	return fmt.Errorf("invalid event: %s, currentState: %s", event, fsm.currentState)
 }
 
@@ -171,3 +184,64 @@ func (fsm *FSM) Next(event Event) error {
 func (fsm *FSM) Current() State {
 	return fsm.currentState
 }
+
+// ReachableStates returns the states the FSM could reach from its current
+// state by one or more events, sorted by name.
+func (fsm *FSM) ReachableStates() []State {
+	visited := map[State]struct{}{}
+	queue := []State{fsm.currentState}
+	for len(queue) > 0 {
+		from := queue[0]
+		queue = queue[1:]
+		for _, ts := range transitions {
+			for _, t := range ts {
+				if t.From != from {
+					continue
+				}
+				if _, exist := visited[t.To]; !exist {
+					visited[t.To] = struct{}{}
+					queue = append(queue, t.To)
+				}
+			}
+		}
+	}
+
+	states := make([]State, 0, len(visited))
+	for s := range visited {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+	return states
+}
+
+// TransitionsDOT renders the transition table as a Graphviz DOT digraph,
+// one labeled edge per transition, guarded edges are marked. The output is sorted so it is stable
+// across calls.
+func TransitionsDOT() string {
+	table := []transition{}
+	for _, ts := range transitions {
+		table = append(table, ts...)
+	}
+	sort.Slice(table, func(i, j int) bool {
+		a, b := table[i], table[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.Event != b.Event {
+			return a.Event < b.Event
+		}
+		return a.To < b.To
+	})
+
+	var sb strings.Builder
+	sb.WriteString("digraph FSM {\n")
+	for _, t := range table {
+		label := string(t.Event)
+		if t.Guard != nil {
+			label += " (guarded)"
+		}
+		fmt.Fprintf(&sb, "\t%q -> %q [label=%q];\n", t.From, t.To, label)
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
@@ -19,6 +19,8 @@ package spec
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -336,3 +338,68 @@ func TestValidEventAtFailed4(t *testing.T) {
 		t.Errorf("failed's next state should be pending after updating!")
 	}
 }
+
+func TestReachableStates(t *testing.T) {
+	fsm, _ := InitFSM(DestroyedState)
+	if states := fsm.ReachableStates(); len(states) != 0 {
+		t.Errorf("destroyed state should reach nothing, got %v", states)
+	}
+
+	fsm, _ = InitFSM(InitialState)
+	expected := []State{ActiveState, DestroyedState, FailedState, InactiveState, InitialState}
+	if got := fsm.ReachableStates(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestTransitionsDOT(t *testing.T) {
+	dot := TransitionsDOT()
+	if !strings.HasPrefix(dot, "digraph FSM {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Errorf("unexpected DOT output: %s", dot)
+	}
+	for _, edge := range []string{
+		`"initial" -> "active" [label="ready"];`,
+		`"active" -> "inactive" [label="stop"];`,
+		`"failed" -> "destroyed" [label="delete"];`,
+	} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("DOT output should contain %s", edge)
+		}
+	}
+	if dot != TransitionsDOT() {
+		t.Errorf("DOT output should be stable")
+	}
+}
+
+func TestGuardedTransition(t *testing.T) {
+	origin := transitions[UpdateEvent]
+	defer func() { transitions[UpdateEvent] = origin }()
+
+	budget := 0
+	transitions[UpdateEvent] = []transition{{
+		From:  FailedState,
+		Event: UpdateEvent,
+		To:    InitialState,
+		Guard: func(fsm *FSM) bool { return budget > 0 },
+	}}
+
+	fsm, _ := InitFSM(FailedState)
+	err := fsm.Next(UpdateEvent)
+	if err == nil || !strings.Contains(err.Error(), "blocked by guard") {
+		t.Errorf("update should be blocked by guard, err: %v", err)
+	}
+	if fsm.Current() != FailedState {
+		t.Errorf("blocked transition should not change state")
+	}
+	if !strings.Contains(TransitionsDOT(), `"failed" -> "initial" [label="update (guarded)"];`) {
+		t.Errorf("guarded edge should be marked in DOT output")
+	}
+
+	budget = 1
+	if err = fsm.Next(UpdateEvent); err != nil {
+		t.Errorf("update should be permitted by guard, err: %v", err)
+	}
+	if fsm.Current() != InitialState {
+		t.Errorf("failed's next state should be initial after update event")
+	}
+}
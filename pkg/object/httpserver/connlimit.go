@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// connLimitTimeout is the deadline to reject a connection, including
+	// the TLS handshake, reading the request and writing the response.
+	connLimitTimeout = 5 * time.Second
+
+	// maxConnLimitRejecting is the max number of connections being
+	// rejected concurrently, more connections are closed directly.
+	maxConnLimitRejecting = 1024
+
+	// maxConnLimitDrainSize is the max size of the request body to drain
+	// before closing a rejected connection.
+	maxConnLimitDrainSize = 64 * 1024
+)
+
+type (
+	// ConnLimitResponse makes the server respond the connections beyond
+	// MaxConnections immediately, instead of waiting for other
+	// connections to be closed.
+	ConnLimitResponse struct {
+		StatusCode int    `yaml:"statusCode" jsonschema:"omitempty"`
+		Body       string `yaml:"body" jsonschema:"omitempty"`
+		RetryAfter string `yaml:"retryAfter" jsonschema:"omitempty,format=duration"`
+	}
+
+	// connLimitRejecter responds the connections beyond the limit.
+	connLimitRejecter struct {
+		response  []byte
+		tlsConfig *tls.Config
+		rejecting int32
+	}
+)
+
+// Validate validates ConnLimitResponse.
+func (clr *ConnLimitResponse) Validate() error {
+	switch clr.StatusCode {
+	case 0, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return fmt.Errorf("invalid statusCode %d, must be 429 or 503", clr.StatusCode)
+	}
+	if clr.RetryAfter != "" {
+		if _, err := time.ParseDuration(clr.RetryAfter); err != nil {
+			return fmt.Errorf("invalid retryAfter: %v", err)
+		}
+	}
+	return nil
+}
+
+// newConnLimitRejecter creates a connLimitRejecter, tlsConfig is nil for
+// plain HTTP servers.
+func newConnLimitRejecter(spec *ConnLimitResponse, tlsConfig *tls.Config) *connLimitRejecter {
+	code := spec.StatusCode
+	if code == 0 {
+		code = http.StatusServiceUnavailable
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&sb, "Content-Length: %d\r\n", len(spec.Body))
+	if spec.RetryAfter != "" {
+		// Retry-After is in whole seconds, round up so that a duration
+		// less than a second doesn't become zero.
+		d, _ := time.ParseDuration(spec.RetryAfter)
+		fmt.Fprintf(&sb, "Retry-After: %d\r\n", int(math.Ceil(d.Seconds())))
+	}
+	sb.WriteString("Connection: close\r\n\r\n")
+	sb.WriteString(spec.Body)
+
+	cr := &connLimitRejecter{response: []byte(sb.String())}
+	if tlsConfig != nil {
+		// the response is always HTTP/1.1.
+		cr.tlsConfig = tlsConfig.Clone()
+		cr.tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+	return cr
+}
+
+// reject responds the connection and closes it.
+func (cr *connLimitRejecter) reject(conn net.Conn) {
+	defer conn.Close()
+
+	if atomic.AddInt32(&cr.rejecting, 1) > maxConnLimitRejecting {
+		atomic.AddInt32(&cr.rejecting, -1)
+		return
+	}
+	defer atomic.AddInt32(&cr.rejecting, -1)
+
+	conn.SetDeadline(time.Now().Add(connLimitTimeout))
+
+	if cr.tlsConfig != nil {
+		tlsConn := tls.Server(conn, cr.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		conn = tlsConn
+	}
+
+	// read the request before responding, otherwise, the client may get
+	// a connection reset instead of the response.
+	if req, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+		io.CopyN(io.Discard, req.Body, maxConnLimitDrainSize)
+	}
+
+	conn.Write(cr.response)
+}
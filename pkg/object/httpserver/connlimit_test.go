@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnLimitResponseValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&ConnLimitResponse{}).Validate())
+	assert.NoError((&ConnLimitResponse{StatusCode: 429, RetryAfter: "10s"}).Validate())
+	assert.Error((&ConnLimitResponse{StatusCode: 500}).Validate())
+	assert.Error((&ConnLimitResponse{RetryAfter: "10"}).Validate())
+
+	spec := &Spec{HTTP3: true, HTTPS: true, ConnLimitResponse: &ConnLimitResponse{}}
+	assert.Error(spec.Validate())
+}
+
+func TestConnLimitRetryAfter(t *testing.T) {
+	assert := assert.New(t)
+
+	for retryAfter, expected := range map[string]string{
+		"500ms": "Retry-After: 1\r\n",
+		"1s":    "Retry-After: 1\r\n",
+		"1.5s":  "Retry-After: 2\r\n",
+		"2m":    "Retry-After: 120\r\n",
+	} {
+		cr := newConnLimitRejecter(&ConnLimitResponse{RetryAfter: retryAfter}, nil)
+		assert.Contains(string(cr.response), expected, retryAfter)
+	}
+
+	cr := newConnLimitRejecter(&ConnLimitResponse{}, nil)
+	assert.NotContains(string(cr.response), "Retry-After")
+}
+
+func TestConnLimitResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38088
+keepAlive: true
+https: false
+maxConnections: 1
+connLimitResponse:
+  body: server busy
+  retryAfter: 30s
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	// the first connection takes the only slot.
+	conn, err := net.Dial("tcp", "127.0.0.1:38088")
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	assert.Eventually(func() bool {
+		return r.Status().Connections.Active == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the second one is responded immediately.
+	client := &http.Client{
+		Timeout:   3 * time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Get("http://127.0.0.1:38088/abc")
+	if !assert.NoError(err) {
+		return
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal("server busy", string(body))
+	assert.Equal("30", resp.Header.Get("Retry-After"))
+	assert.Equal(uint64(1), r.Status().Connections.Rejected)
+}
@@ -87,6 +87,26 @@ func (hs *HTTPServer) Status() *supervisor.Status {
 	}
 }
 
+// Drain stops HTTPServer from accepting new requests.
+func (hs *HTTPServer) Drain() {
+	hs.runtime.Drain()
+}
+
+// Undrain makes HTTPServer accept new requests again after Drain.
+func (hs *HTTPServer) Undrain() {
+	hs.runtime.Undrain()
+}
+
+// Restart restarts HTTPServer gracefully with the current spec.
+func (hs *HTTPServer) Restart() {
+	hs.runtime.Restart()
+}
+
+// SetMaintenance turns on or off the maintenance mode of HTTPServer.
+func (hs *HTTPServer) SetMaintenance(on bool) {
+	hs.runtime.SetMaintenance(on)
+}
+
 // Close closes HTTPServer.
 func (hs *HTTPServer) Close() {
 	hs.runtime.Close()
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// tcpConn is the part of *net.TCPConn tuned by tcpListener.
+	tcpConn interface {
+		SetKeepAlive(keepalive bool) error
+		SetKeepAlivePeriod(d time.Duration) error
+		SetReadBuffer(bytes int) error
+		SetWriteBuffer(bytes int) error
+	}
+
+	// tcpListener applies the TCP options to the accepted connections.
+	tcpListener struct {
+		net.Listener
+
+		keepAlivePeriod time.Duration
+		readBufferSize  int
+		writeBufferSize int
+	}
+)
+
+// newTCPListener wraps l to apply the TCP options in spec, it returns l
+// if none of them is set.
+func newTCPListener(l net.Listener, spec *Spec) net.Listener {
+	if spec.TCPKeepAlivePeriod == "" && spec.TCPReadBufferSize == 0 && spec.TCPWriteBufferSize == 0 {
+		return l
+	}
+
+	return &tcpListener{
+		Listener:        l,
+		keepAlivePeriod: parseTimeout(spec.TCPKeepAlivePeriod),
+		readBufferSize:  spec.TCPReadBufferSize,
+		writeBufferSize: spec.TCPWriteBufferSize,
+	}
+}
+
+// Accept implements net.Listener.
+func (l *tcpListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := c.(tcpConn); ok {
+		if err := l.apply(tc); err != nil {
+			logger.Warnf("set tcp options of %s failed: %v", c.RemoteAddr(), err)
+		}
+	}
+
+	return c, nil
+}
+
+func (l *tcpListener) apply(c tcpConn) error {
+	if l.keepAlivePeriod > 0 {
+		if err := c.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := c.SetKeepAlivePeriod(l.keepAlivePeriod); err != nil {
+			return err
+		}
+	}
+
+	if l.readBufferSize > 0 {
+		if err := c.SetReadBuffer(l.readBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if l.writeBufferSize > 0 {
+		if err := c.SetWriteBuffer(l.writeBufferSize); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
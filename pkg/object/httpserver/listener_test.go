@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTCPConn struct {
+	keepAlive       bool
+	keepAlivePeriod time.Duration
+	readBuffer      int
+	writeBuffer     int
+	err             error
+}
+
+func (c *mockTCPConn) SetKeepAlive(keepalive bool) error {
+	c.keepAlive = keepalive
+	return c.err
+}
+
+func (c *mockTCPConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.keepAlivePeriod = d
+	return nil
+}
+
+func (c *mockTCPConn) SetReadBuffer(bytes int) error {
+	c.readBuffer = bytes
+	return nil
+}
+
+func (c *mockTCPConn) SetWriteBuffer(bytes int) error {
+	c.writeBuffer = bytes
+	return nil
+}
+
+func TestTCPListener(t *testing.T) {
+	assert := assert.New(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer ln.Close()
+
+	// no options, the listener is not wrapped.
+	assert.Equal(ln, newTCPListener(ln, &Spec{}))
+
+	spec := &Spec{
+		TCPKeepAlivePeriod: "30s",
+		TCPReadBufferSize:  65536,
+		TCPWriteBufferSize: 131072,
+	}
+	l := newTCPListener(ln, spec).(*tcpListener)
+
+	c := &mockTCPConn{}
+	assert.NoError(l.apply(c))
+	assert.True(c.keepAlive)
+	assert.Equal(30*time.Second, c.keepAlivePeriod)
+	assert.Equal(65536, c.readBuffer)
+	assert.Equal(131072, c.writeBuffer)
+
+	// the options not set are left untouched.
+	c = &mockTCPConn{}
+	(&tcpListener{readBufferSize: 4096}).apply(c)
+	assert.False(c.keepAlive)
+	assert.Equal(4096, c.readBuffer)
+	assert.Equal(0, c.writeBuffer)
+
+	c = &mockTCPConn{err: fmt.Errorf("mock error")}
+	assert.Error(l.apply(c))
+
+	// the options are applied to the real connections.
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	assert.NoError(err)
+	assert.IsType(&net.TCPConn{}, conn)
+	conn.Close()
+}
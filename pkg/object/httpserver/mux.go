@@ -18,14 +18,19 @@
 package httpserver
 
 import (
+	stdcontext "context"
 	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net"
 	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync/atomic"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/megaease/easegress/pkg/object/globalfilter"
@@ -34,6 +39,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/autocertmanager"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
 	"github.com/megaease/easegress/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
@@ -41,6 +47,7 @@ import (
 	"github.com/megaease/easegress/pkg/util/ipfilter"
 	"github.com/megaease/easegress/pkg/util/readers"
 	"github.com/megaease/easegress/pkg/util/stringtool"
+	"golang.org/x/time/rate"
 )
 
 type (
@@ -48,7 +55,9 @@ type (
 		httpStat *httpstat.HTTPStat
 		topN     *httpstat.TopN
 
-		inst atomic.Value // *muxInstance
+		inst        atomic.Value // *muxInstance
+		draining    int32
+		maintenance int32
 	}
 
 	muxInstance struct {
@@ -61,9 +70,10 @@ type (
 
 		cache *lru.ARCCache
 
-		tracer       *tracing.Tracer
-		ipFilter     *ipfilter.IPFilter
-		ipFilterChan *ipfilter.IPFilters
+		tracer         *tracing.Tracer
+		ipFilter       *ipfilter.IPFilter
+		ipFilterChan   *ipfilter.IPFilters
+		realIPResolver *realIPResolver
 
 		rules []*muxRule
 	}
@@ -75,6 +85,7 @@ type (
 		host       string
 		hostRegexp string
 		hostRE     *regexp.Regexp
+		limiter    *rate.Limiter
 		paths      []*MuxPath
 	}
 
@@ -87,27 +98,77 @@ type (
 		pathPrefix        string
 		pathRegexp        string
 		pathRE            *regexp.Regexp
+		excludePrefixes   []string
 		methods           []string
 		rewriteTarget     string
 		backend           string
+		backends          []*Backend
+		totalWeight       int
 		headers           []*Header
 		clientMaxBodySize int64
 		matchAllHeader    bool
+		limiter           *rate.Limiter
+		requestHeaders    *httpheader.AdaptSpec
+		responseHeaders   *httpheader.AdaptSpec
 	}
 
 	route struct {
 		code int
+		rule *muxRule
 		path *MuxPath
+
+		// allow is the methods allowed when code is 405.
+		allow []string
 	}
+
+	// connRequestsKey is the context key of the number of requests
+	// served by a connection.
+	connRequestsKey struct{}
 )
 
 var (
-	notFound         = &route{code: http.StatusNotFound}
-	forbidden        = &route{code: http.StatusForbidden}
-	methodNotAllowed = &route{code: http.StatusMethodNotAllowed}
-	badRequest       = &route{code: http.StatusBadRequest}
+	notFound   = &route{code: http.StatusNotFound}
+	forbidden  = &route{code: http.StatusForbidden}
+	badRequest = &route{code: http.StatusBadRequest}
 )
 
+// newRateLimiter returns nil if spec is nil.
+func newRateLimiter(spec *RateLimit) *rate.Limiter {
+	if spec == nil {
+		return nil
+	}
+
+	burst := spec.Burst
+	if burst == 0 {
+		burst = spec.RequestsPerSecond
+	}
+
+	return rate.NewLimiter(rate.Limit(spec.RequestsPerSecond), int(burst))
+}
+
+// allowRates returns whether all the limiters allow a request, the tokens
+// are consumed only if all of them allow it.
+func allowRates(limiters ...*rate.Limiter) bool {
+	now := time.Now()
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for _, limiter := range limiters {
+		if limiter == nil {
+			continue
+		}
+
+		r := limiter.ReserveN(now, 1)
+		if !r.OK() || r.DelayFrom(now) > 0 {
+			r.CancelAt(now)
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+			return false
+		}
+		reservations = append(reservations, r)
+	}
+	return true
+}
+
 // newIPFilterChain returns nil if the number of final filters is zero.
 func newIPFilterChain(parentIPFilters *ipfilter.IPFilters, childSpec *ipfilter.Spec) *ipfilter.IPFilters {
 	var ipFilters *ipfilter.IPFilters
@@ -144,9 +205,18 @@ func allowIP(ipFilter *ipfilter.IPFilter, ip string) bool {
 	return ipFilter.Allow(ip)
 }
 
+// routeHost returns the host used for routing, which is the server name
+// of TLS (SNI) for HTTPS requests, and the Host header otherwise.
+func routeHost(req *httpprot.Request) string {
+	if tls := req.Std().TLS; tls != nil && tls.ServerName != "" {
+		return tls.ServerName
+	}
+	return req.Host()
+}
+
 func (mi *muxInstance) getRouteFromCache(req *httpprot.Request) *route {
 	if mi.cache != nil {
-		key := stringtool.Cat(req.Host(), req.Method(), req.Path())
+		key := stringtool.Cat(routeHost(req), req.Method(), req.Path())
 		if value, ok := mi.cache.Get(key); ok {
 			return value.(*route)
 		}
@@ -156,7 +226,7 @@ func (mi *muxInstance) getRouteFromCache(req *httpprot.Request) *route {
 
 func (mi *muxInstance) putRouteToCache(req *httpprot.Request, r *route) {
 	if mi.cache != nil {
-		key := stringtool.Cat(req.Host(), req.Method(), req.Path())
+		key := stringtool.Cat(routeHost(req), req.Method(), req.Path())
 		mi.cache.Add(key, r)
 	}
 }
@@ -180,6 +250,7 @@ func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*MuxPat
 		host:       rule.Host,
 		hostRegexp: rule.HostRegexp,
 		hostRE:     hostRE,
+		limiter:    newRateLimiter(rule.RateLimit),
 		paths:      paths,
 	}
 }
@@ -189,7 +260,7 @@ func (mr *muxRule) match(r *httpprot.Request) bool {
 		return true
 	}
 
-	host := r.Host()
+	host := routeHost(r)
 	if h, _, err := net.SplitHostPort(host); err == nil {
 		host = h
 	}
@@ -219,6 +290,11 @@ func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *MuxPath {
 		p.initHeaderRoute()
 	}
 
+	totalWeight := 0
+	for _, b := range path.Backends {
+		totalWeight += b.Weight
+	}
+
 	return &MuxPath{
 		ipFilter:      newIPFilter(path.IPFilter),
 		ipFilterChain: newIPFilterChain(parentIPFilters, path.IPFilter),
@@ -227,21 +303,35 @@ func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *MuxPath {
 		pathPrefix:        path.PathPrefix,
 		pathRegexp:        path.PathRegexp,
 		pathRE:            pathRE,
+		excludePrefixes:   path.ExcludePrefixes,
 		rewriteTarget:     path.RewriteTarget,
 		methods:           path.Methods,
 		backend:           path.Backend,
+		backends:          path.Backends,
+		totalWeight:       totalWeight,
 		headers:           path.Headers,
 		clientMaxBodySize: path.ClientMaxBodySize,
 		matchAllHeader:    path.MatchAllHeader,
+		limiter:           newRateLimiter(path.RateLimit),
+		requestHeaders:    path.RequestHeaders,
+		responseHeaders:   path.ResponseHeaders,
 	}
 }
 
+// matchPath returns true if the path of the request matches any of path,
+// pathPrefix and pathRegexp, and has none of the excluded prefixes.
 func (mp *MuxPath) matchPath(r *httpprot.Request) bool {
+	path := r.Path()
+	for _, prefix := range mp.excludePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
 	if mp.path == "" && mp.pathPrefix == "" && mp.pathRE == nil {
 		return true
 	}
 
-	path := r.Path()
 	if mp.path != "" && mp.path == path {
 		return true
 	}
@@ -278,6 +368,25 @@ func (mp *MuxPath) rewrite(r *httpprot.Request) {
 	r.SetPath(path)
 }
 
+// chooseBackend returns a backend by weight if there are weighted backends,
+// otherwise it returns the single backend.
+func (mp *MuxPath) chooseBackend() string {
+	if mp.totalWeight <= 0 {
+		return mp.backend
+	}
+
+	n := rand.Intn(mp.totalWeight)
+	for _, b := range mp.backends {
+		if n < b.Weight {
+			return b.Backend
+		}
+		n -= b.Weight
+	}
+
+	// defensive programming
+	return mp.backends[len(mp.backends)-1].Backend
+}
+
 func (mp *MuxPath) matchMethod(r *httpprot.Request) bool {
 	if len(mp.methods) == 0 {
 		return true
@@ -286,6 +395,16 @@ func (mp *MuxPath) matchMethod(r *httpprot.Request) bool {
 	return stringtool.StrInSlice(r.Method(), mp.methods)
 }
 
+// appendMethods appends the methods not in allow to it.
+func appendMethods(allow []string, methods []string) []string {
+	for _, m := range methods {
+		if !stringtool.StrInSlice(m, allow) {
+			allow = append(allow, m)
+		}
+	}
+	return allow
+}
+
 func (mp *MuxPath) matchHeaders(r *httpprot.Request) bool {
 	if mp.matchAllHeader {
 		for _, h := range mp.headers {
@@ -354,15 +473,16 @@ func (m *mux) reload(superSpec *supervisor.Spec, muxMapper context.MuxMapper) {
 	}
 
 	inst := &muxInstance{
-		superSpec:    superSpec,
-		spec:         spec,
-		muxMapper:    muxMapper,
-		httpStat:     m.httpStat,
-		topN:         m.topN,
-		ipFilter:     newIPFilter(spec.IPFilter),
-		ipFilterChan: newIPFilterChain(nil, spec.IPFilter),
-		rules:        make([]*muxRule, len(spec.Rules)),
-		tracer:       tracer,
+		superSpec:      superSpec,
+		spec:           spec,
+		muxMapper:      muxMapper,
+		httpStat:       m.httpStat,
+		topN:           m.topN,
+		ipFilter:       newIPFilter(spec.IPFilter),
+		ipFilterChan:   newIPFilterChain(nil, spec.IPFilter),
+		realIPResolver: newRealIPResolver(spec),
+		rules:          make([]*muxRule, len(spec.Rules)),
+		tracer:         tracer,
 	}
 
 	if spec.CacheSize > 0 {
@@ -400,8 +520,114 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		return
 	}
 
+	inst := m.inst.Load().(*muxInstance)
+
+	if inst.spec.ReadinessPath != "" && stdr.URL.Path == inst.spec.ReadinessPath {
+		if m.isDraining() {
+			stdw.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			stdw.WriteHeader(http.StatusOK)
+		}
+		return
+	}
+
+	// Reject new requests if draining, the in-flight ones are not affected.
+	if m.isDraining() {
+		startAt := fasttime.Now()
+		stdw.Header().Set("Connection", "close")
+		stdw.WriteHeader(http.StatusServiceUnavailable)
+		inst.httpStat.Stat(&httpstat.Metric{
+			StatusCode: http.StatusServiceUnavailable,
+			Duration:   fasttime.Since(startAt),
+		})
+		return
+	}
+
+	// Close the connection after the response if it has served enough
+	// requests. NOTE: HTTP/2 connections are not limited as the header
+	// is ignored.
+	if max := inst.spec.MaxRequestsPerConn; max > 0 {
+		if n, ok := stdr.Context().Value(connRequestsKey{}).(*uint32); ok {
+			if atomic.AddUint32(n, 1) >= max {
+				stdw.Header().Set("Connection", "close")
+			}
+		}
+	}
+
 	// Forward to the current muxInstance to handle the request.
-	m.inst.Load().(*muxInstance).serveHTTP(stdw, stdr)
+	inst.serveHTTP(stdw, stdr, m.isMaintenance() || inst.spec.Maintenance.enabled())
+}
+
+func (m *mux) setMaintenance(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&m.maintenance, v)
+}
+
+func (m *mux) isMaintenance() bool {
+	return atomic.LoadInt32(&m.maintenance) == 1
+}
+
+// inMaintenance returns whether the server is in maintenance by either
+// the admin API or the spec.
+func (m *mux) inMaintenance() bool {
+	inst := m.inst.Load().(*muxInstance)
+	return m.isMaintenance() || inst.spec.Maintenance.enabled()
+}
+
+func (mt *Maintenance) enabled() bool {
+	return mt != nil && mt.Enabled
+}
+
+func (mt *Maintenance) allowPath(path string) bool {
+	if mt == nil {
+		return false
+	}
+	for _, p := range mt.AllowPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMaintenanceResponse builds the 503 response by the maintenance
+// spec, mt could be nil if maintenance is turned on by the admin API.
+func buildMaintenanceResponse(ctx *context.Context, mt *Maintenance) *httpprot.Response {
+	resp := buildFailureResponse(ctx, http.StatusServiceUnavailable)
+	if mt == nil {
+		return resp
+	}
+
+	if mt.RetryAfter != "" {
+		// Retry-After is in whole seconds, round up so that a duration
+		// less than a second doesn't become zero.
+		d, _ := time.ParseDuration(mt.RetryAfter)
+		resp.HTTPHeader().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+	}
+	if mt.Body != "" {
+		resp.SetPayload(mt.Body)
+	}
+	return resp
+}
+
+// connContext attaches a request counter to the context of a connection.
+func connContext(ctx stdcontext.Context, c net.Conn) stdcontext.Context {
+	return stdcontext.WithValue(ctx, connRequestsKey{}, new(uint32))
+}
+
+func (m *mux) setDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&m.draining, v)
+}
+
+func (m *mux) isDraining() bool {
+	return atomic.LoadInt32(&m.draining) == 1
 }
 
 func buildFailureResponse(ctx *context.Context, statusCode int) *httpprot.Response {
@@ -411,25 +637,55 @@ func buildFailureResponse(ctx *context.Context, statusCode int) *httpprot.Respon
 	return resp
 }
 
-func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
+// flushWriter flushes the underlying writer after each write.
+type flushWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+// copyResponseBody copies the payload of resp to stdw and returns the
+// number of bytes copied. Streamed gRPC responses are flushed after each
+// write, so that messages of server streaming calls are not delayed.
+func copyResponseBody(stdw http.ResponseWriter, resp *httpprot.Response) int64 {
+	var w io.Writer = stdw
+	if resp.IsStream() && httpprot.IsGRPC(resp.HTTPHeader()) {
+		if f, ok := stdw.(http.Flusher); ok {
+			w = &flushWriter{w: stdw, f: f}
+		}
+	}
+	n, _ := io.Copy(w, resp.GetPayload())
+	return n
+}
+
+func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request, maintenance bool) {
 	// Replace the body of the original request with a ByteCountReader, so
 	// that we can calculate the actual request size.
 	body := readers.NewByteCountReader(stdr.Body)
 	stdr.Body = body
 
 	startAt := fasttime.Now()
-	span := mi.tracer.NewSpanWithStart(mi.superSpec.Name(), startAt)
+	span := mi.tracer.NewSpanForHTTP(mi.superSpec.Name(), startAt, stdr)
 	ctx := context.New(span)
 
 	// httpprot.NewRequest never returns an error.
 	req, _ := httpprot.NewRequest(stdr)
+	if mi.realIPResolver != nil {
+		req.SetRealIP(mi.realIPResolver.resolve(stdr))
+	}
 
 	// Calculate the meta size now, as everything could be modified.
 	reqMetaSize := req.MetaSize()
 	ctx.SetRequest(context.DefaultNamespace, req)
 
 	// get topN here, as the path could be modified later.
-	topN := mi.topN.Stat(req.Path())
+	path := req.Path()
+	topN := mi.topN.Stat(path)
 
 	defer func() {
 		var resp *httpprot.Response
@@ -449,7 +705,12 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 			header[k] = v
 		}
 		stdw.WriteHeader(resp.StatusCode())
-		respBodySize, _ := io.Copy(stdw, resp.GetPayload())
+		respBodySize := copyResponseBody(stdw, resp)
+
+		// Trailers are only available after the body is fully read.
+		for k, v := range resp.Std().Trailer {
+			header[http.TrailerPrefix+k] = v
+		}
 
 		ctx.Finish()
 
@@ -464,6 +725,7 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 			RespSize:   uint64(resp.MetaSize() + respBodySize),
 		}
 		topN.Stat(&metric)
+		mi.topN.StatError(path, &metric)
 		mi.httpStat.Stat(&metric)
 
 		span.Finish()
@@ -485,16 +747,35 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		})
 	}()
 
+	if maintenance && !mi.spec.Maintenance.allowPath(req.Path()) {
+		buildMaintenanceResponse(ctx, mi.spec.Maintenance)
+		return
+	}
+
 	route := mi.search(req)
 	if route.code != 0 {
 		logger.Debugf("%s: status code of result route: %d", mi.superSpec.Name(), route.code)
-		buildFailureResponse(ctx, route.code)
+		resp := buildFailureResponse(ctx, route.code)
+		if len(route.allow) > 0 {
+			resp.HTTPHeader().Set("Allow", strings.Join(route.allow, ", "))
+		}
 		return
 	}
 
-	handler, ok := mi.muxMapper.GetHandler(route.path.backend)
+	// A request rejected by one of the limiters doesn't consume the
+	// tokens of the other.
+	if !allowRates(route.path.limiter, route.rule.limiter) {
+		logger.Debugf("%s: rate limit exceeded", mi.superSpec.Name())
+		buildFailureResponse(ctx, http.StatusTooManyRequests)
+		return
+	}
+
+	// Choose the backend only once, so that a request is always handled
+	// by the same backend.
+	backend := route.path.chooseBackend()
+	handler, ok := mi.muxMapper.GetHandler(backend)
 	if !ok {
-		logger.Debugf("%s: backend %q not found", mi.superSpec.Name(), route.path.backend)
+		logger.Debugf("%s: backend %q not found", mi.superSpec.Name(), backend)
 		buildFailureResponse(ctx, http.StatusServiceUnavailable)
 		return
 	}
@@ -503,6 +784,9 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	if mi.spec.XForwardedFor {
 		appendXForwardedFor(req)
 	}
+	if route.path.requestHeaders != nil {
+		httpheader.New(req.HTTPHeader()).Adapt(route.path.requestHeaders)
+	}
 
 	maxBodySize := route.path.clientMaxBodySize
 	if maxBodySize == 0 {
@@ -527,14 +811,21 @@ func (mi *muxInstance) serveHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	} else {
 		globalFilter.Handle(ctx, handler)
 	}
+
+	if route.path.responseHeaders != nil {
+		if resp, ok := ctx.GetResponse(context.DefaultNamespace).(*httpprot.Response); ok {
+			httpheader.New(resp.HTTPHeader()).Adapt(route.path.responseHeaders)
+		}
+	}
 }
 
 func (mi *muxInstance) search(req *httpprot.Request) *route {
-	headerMismatch, methodMismatch := false, false
+	headerMismatch := false
+	var allow []string
 
 	ip := req.RealIP()
 
-	// The key of the cache is req.Host + req.Method + req.URL.Path,
+	// The key of the cache is the route host + req.Method + req.URL.Path,
 	// and if a path is cached, we are sure it does not contain any
 	// headers.
 	r := mi.getRouteFromCache(req)
@@ -570,13 +861,13 @@ func (mi *muxInstance) search(req *httpprot.Request) *route {
 			}
 
 			if !path.matchMethod(req) {
-				methodMismatch = true
+				allow = appendMethods(allow, path.methods)
 				continue
 			}
 
 			// The path can be put into the cache if it has no headers.
 			if len(path.headers) == 0 {
-				r = &route{code: 0, path: path}
+				r = &route{code: 0, rule: host, path: path}
 				mi.putRouteToCache(req, r)
 			} else if !path.matchHeaders(req) {
 				headerMismatch = true
@@ -587,7 +878,7 @@ func (mi *muxInstance) search(req *httpprot.Request) *route {
 				return forbidden
 			}
 
-			return &route{code: 0, path: path}
+			return &route{code: 0, rule: host, path: path}
 		}
 	}
 
@@ -595,9 +886,10 @@ func (mi *muxInstance) search(req *httpprot.Request) *route {
 		return badRequest
 	}
 
-	if methodMismatch {
-		mi.putRouteToCache(req, methodNotAllowed)
-		return methodNotAllowed
+	if len(allow) > 0 {
+		r = &route{code: http.StatusMethodNotAllowed, allow: allow}
+		mi.putRouteToCache(req, r)
+		return r
 	}
 
 	mi.putRouteToCache(req, notFound)
@@ -18,10 +18,15 @@
 package httpserver
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"testing/iotest"
 
@@ -33,6 +38,7 @@ import (
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
 )
 
 func TestNewIPFilterChain(t *testing.T) {
@@ -126,6 +132,16 @@ func TestMuxPath(t *testing.T) {
 	assert.NotNil(mp)
 	assert.False(mp.matchPath(req))
 
+	// excluded prefixes take precedence over all matchers
+	mp = newMuxPath(nil, &Path{PathPrefix: "/a", ExcludePrefixes: []string{"/ab"}})
+	assert.False(mp.matchPath(req))
+	mp = newMuxPath(nil, &Path{PathRegexp: "^/a", ExcludePrefixes: []string{"/abc"}})
+	assert.False(mp.matchPath(req))
+	mp = newMuxPath(nil, &Path{ExcludePrefixes: []string{"/abc"}})
+	assert.False(mp.matchPath(req))
+	mp = newMuxPath(nil, &Path{PathPrefix: "/a", ExcludePrefixes: []string{"/x", "/abcd"}})
+	assert.True(mp.matchPath(req))
+
 	// 2. match method
 	mp = newMuxPath(nil, &Path{})
 	assert.NotNil(mp)
@@ -234,6 +250,23 @@ func TestBuildFailureResponse(t *testing.T) {
 	assert.Equal(http.StatusNotFound, resp.StatusCode())
 }
 
+func TestBuildMaintenanceResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	for retryAfter, expected := range map[string]string{
+		"":      "",
+		"500ms": "1",
+		"1s":    "1",
+		"1.5s":  "2",
+		"2m":    "120",
+	} {
+		ctx := context.New(tracing.NoopSpan)
+		resp := buildMaintenanceResponse(ctx, &Maintenance{RetryAfter: retryAfter})
+		assert.Equal(http.StatusServiceUnavailable, resp.StatusCode())
+		assert.Equal(expected, resp.HTTPHeader().Get("Retry-After"), retryAfter)
+	}
+}
+
 func TestAppendXForwardFor(t *testing.T) {
 	const xForwardedFor = "X-Forwarded-For"
 
@@ -451,7 +484,7 @@ rules:
 	stdr, _ = http.NewRequest(http.MethodGet, "http://www.megaease.com/xyz", http.NoBody)
 	stdr.Header.Set("X-Real-Ip", "192.168.1.4")
 	req, _ = httpprot.NewRequest(stdr)
-	assert.Equal(methodNotAllowed, mi.search(req))
+	assert.Equal(http.StatusMethodNotAllowed, mi.search(req).code)
 
 	// has no required header
 	stdr, _ = http.NewRequest(http.MethodGet, "http://www.megaease.com/123", http.NoBody)
@@ -487,3 +520,716 @@ rules:
 	req, _ = httpprot.NewRequest(stdr)
 	assert.Equal(400, mi.search(req).code)
 }
+
+func TestServeHTTPRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+cacheSize: 100
+rules:
+- host: www.megaease.com
+  rateLimit:
+    requestsPerSecond: 1
+    burst: 3
+  paths:
+  - path: /abc
+    backend: abc-pipeline
+    rateLimit:
+      requestsPerSecond: 1
+      burst: 2
+  - path: /xyz
+    backend: xyz-pipeline
+- host: www.megaease.cn
+  paths:
+  - path: /abc
+    backend: abc-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(url string) int {
+		stdr, _ := http.NewRequest(http.MethodGet, url, http.NoBody)
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw.Code
+	}
+
+	// the burst of the path is 2, the third request is rejected, and the
+	// rejected request doesn't consume the token of the rule.
+	assert.Equal(http.StatusOK, serve("http://www.megaease.com/abc"))
+	assert.Equal(http.StatusOK, serve("http://www.megaease.com/abc"))
+	assert.Equal(http.StatusTooManyRequests, serve("http://www.megaease.com/abc"))
+
+	// the burst of the rule is 3, one token left.
+	assert.Equal(http.StatusOK, serve("http://www.megaease.com/xyz"))
+	assert.Equal(http.StatusTooManyRequests, serve("http://www.megaease.com/xyz"))
+
+	// other rules are not limited.
+	for i := 0; i < 10; i++ {
+		assert.Equal(http.StatusOK, serve("http://www.megaease.cn/abc"))
+	}
+
+	status := m.httpStat.Status()
+	assert.Equal(uint64(15), status.Count)
+	assert.Equal(uint64(2), status.Codes[http.StatusTooManyRequests])
+
+	// reloading resets the limiters.
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("http://www.megaease.com/abc"))
+	m.close()
+}
+
+func TestAllowRates(t *testing.T) {
+	assert := assert.New(t)
+
+	path := rate.NewLimiter(1, 2)
+	rule := rate.NewLimiter(1, 1)
+
+	assert.True(allowRates(path, nil, rule))
+
+	// the rule rejects the request, the token of the path is not consumed.
+	assert.False(allowRates(path, rule))
+	assert.True(allowRates(path))
+	assert.False(allowRates(path))
+	assert.True(allowRates())
+}
+
+func TestServeHTTPMaxRequestsPerConn(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+maxRequestsPerConn: 3
+rules:
+- paths:
+  - pathPrefix: /
+    backend: abc-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	var conns int32
+	ts := httptest.NewUnstartedServer(m)
+	ts.Config.ConnContext = connContext
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	get := func() bool {
+		resp, err := ts.Client().Get(ts.URL + "/abc")
+		assert.NoError(err)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		return resp.Close
+	}
+
+	// every connection serves 3 requests.
+	for i := 1; i <= 7; i++ {
+		assert.Equal(i%3 == 0, get(), "request %d", i)
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&conns))
+
+	// the limit is removed without restarting.
+	superSpec, err = supervisor.NewSpec(strings.Replace(yamlSpec, "maxRequestsPerConn: 3", "", 1))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	for i := 0; i < 5; i++ {
+		assert.False(get())
+	}
+	assert.Equal(int32(3), atomic.LoadInt32(&conns))
+}
+
+func TestServeHTTPMaintenance(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+maintenance:
+  enabled: true
+  body: under maintenance
+  retryAfter: 2m
+  allowPaths: [/healthz]
+rules:
+- paths:
+  - pathPrefix: /
+    backend: abc-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com"+path, http.NoBody)
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw
+	}
+
+	stdw := serve("/abc")
+	assert.Equal(http.StatusServiceUnavailable, stdw.Code)
+	assert.Equal("under maintenance", stdw.Body.String())
+	assert.Equal("120", stdw.Header().Get("Retry-After"))
+	assert.True(m.inMaintenance())
+
+	// the allowlisted path is handled by the pipeline.
+	assert.Equal(http.StatusOK, serve("/healthz").Code)
+
+	// the responses in maintenance are counted.
+	status := m.httpStat.Status()
+	assert.Equal(uint64(2), status.Count)
+	assert.Equal(uint64(1), status.Codes[http.StatusServiceUnavailable])
+
+	// disable it by reloading.
+	superSpec, err = supervisor.NewSpec(strings.Replace(yamlSpec, "enabled: true", "enabled: false", 1))
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusOK, serve("/abc").Code)
+	assert.False(m.inMaintenance())
+
+	// turn it on by the admin API, the body and allowlist of the spec
+	// are still used.
+	m.setMaintenance(true)
+	stdw = serve("/abc")
+	assert.Equal(http.StatusServiceUnavailable, stdw.Code)
+	assert.Equal("under maintenance", stdw.Body.String())
+	assert.Equal(http.StatusOK, serve("/healthz").Code)
+
+	// the maintenance turned on by the admin API survives reloading.
+	m.reload(superSpec, mm)
+	assert.Equal(http.StatusServiceUnavailable, serve("/abc").Code)
+
+	m.setMaintenance(false)
+	assert.Equal(http.StatusOK, serve("/abc").Code)
+}
+
+func TestServeHTTPGRPCBodySize(t *testing.T) {
+	assert := assert.New(t)
+
+	var stream bool
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				stream = ctx.GetInputRequest().IsStream()
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+clientMaxBodySize: 4
+rules:
+- paths:
+  - pathPrefix: /stream
+    clientMaxBodySize: -1
+    backend: grpc-pipeline
+  - pathPrefix: /
+    backend: grpc-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		stdr, _ := http.NewRequest(http.MethodPost, "http://www.megaease.com"+path, strings.NewReader("0123456789"))
+		stdr.Header.Set("Content-Type", "application/grpc")
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw
+	}
+
+	// the content type of the client doesn't lift the body size limit.
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("/pkg.Service/Call").Code)
+
+	// streaming is enabled by the path explicitly.
+	assert.Equal(http.StatusOK, serve("/stream/pkg.Service/Call").Code)
+	assert.True(stream)
+}
+
+func TestServeHTTPAdaptHeaders(t *testing.T) {
+	assert := assert.New(t)
+
+	var reqHeader http.Header
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				reqHeader = ctx.GetRequest(context.DefaultNamespace).(*httpprot.Request).HTTPHeader().Clone()
+				resp, _ := httpprot.NewResponse(nil)
+				resp.HTTPHeader().Set("X-Internal", "secret")
+				resp.HTTPHeader().Set("X-Backend", name)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+rules:
+- paths:
+  - path: /v2
+    backend: v2-pipeline
+    requestHeaders:
+      set: {X-Route: v2}
+      add: {X-Tag: b}
+      del: [X-Debug]
+    responseHeaders:
+      set: {X-Backend: hidden}
+      del: [X-Internal]
+  - path: /v1
+    backend: v1-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com"+path, http.NoBody)
+		stdr.Header.Set("X-Debug", "true")
+		stdr.Header.Set("X-Tag", "a")
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw
+	}
+
+	stdw := serve("/v2")
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal("v2", reqHeader.Get("X-Route"))
+	assert.Equal([]string{"a", "b"}, reqHeader.Values("X-Tag"))
+	assert.Equal("", reqHeader.Get("X-Debug"))
+	assert.Equal("hidden", stdw.Header().Get("X-Backend"))
+	assert.Equal("", stdw.Header().Get("X-Internal"))
+
+	// other paths are not affected.
+	stdw = serve("/v1")
+	assert.Equal(http.StatusOK, stdw.Code)
+	assert.Equal("", reqHeader.Get("X-Route"))
+	assert.Equal("true", reqHeader.Get("X-Debug"))
+	assert.Equal("v1-pipeline", stdw.Header().Get("X-Backend"))
+	assert.Equal("secret", stdw.Header().Get("X-Internal"))
+}
+
+func TestServeHTTPMethods(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				resp, _ := httpprot.NewResponse(nil)
+				resp.HTTPHeader().Set("X-Backend", name)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+cacheSize: 100
+rules:
+- paths:
+  - path: /orders
+    methods: [GET, HEAD]
+    backend: query-pipeline
+  - path: /orders
+    methods: [POST, PUT, GET]
+    backend: command-pipeline
+  - path: /items
+    backend: items-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		stdr, _ := http.NewRequest(method, "http://www.megaease.com"+path, http.NoBody)
+		stdw := httptest.NewRecorder()
+		m.ServeHTTP(stdw, stdr)
+		return stdw
+	}
+
+	// twice for the cached routes
+	for i := 0; i < 2; i++ {
+		assert.Equal("query-pipeline", serve(http.MethodGet, "/orders").Header().Get("X-Backend"))
+		assert.Equal("command-pipeline", serve(http.MethodPost, "/orders").Header().Get("X-Backend"))
+		assert.Equal("command-pipeline", serve(http.MethodPut, "/orders").Header().Get("X-Backend"))
+
+		stdw := serve(http.MethodDelete, "/orders")
+		assert.Equal(http.StatusMethodNotAllowed, stdw.Code)
+		assert.Equal("GET, HEAD, POST, PUT", stdw.Header().Get("Allow"))
+
+		// paths without methods allow all methods.
+		assert.Equal(http.StatusOK, serve(http.MethodDelete, "/items").Code)
+	}
+}
+
+func TestServeHTTPWeightedBackends(t *testing.T) {
+	assert := assert.New(t)
+
+	counts := map[string]int{}
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		counts[name]++
+		return &contexttest.MockedHandler{}, true
+	}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+cacheSize: 100
+rules:
+- paths:
+  - path: /abc
+    backends:
+    - backend: stable-pipeline
+      weight: 95
+    - backend: canary-pipeline
+      weight: 5
+  - path: /xyz
+    backend: xyz-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	serve := func(url string) {
+		stdr, _ := http.NewRequest(http.MethodGet, url, http.NoBody)
+		m.ServeHTTP(httptest.NewRecorder(), stdr)
+	}
+
+	rand.Seed(0)
+	const total = 10000
+	for i := 0; i < total; i++ {
+		serve("http://www.megaease.com/abc")
+	}
+	assert.Equal(total, counts["stable-pipeline"]+counts["canary-pipeline"])
+	assert.InDelta(0.95, float64(counts["stable-pipeline"])/total, 0.02)
+	assert.InDelta(0.05, float64(counts["canary-pipeline"])/total, 0.02)
+
+	serve("http://www.megaease.com/xyz")
+	assert.Equal(1, counts["xyz-pipeline"])
+
+	// reload with new weights.
+	yamlSpec = strings.Replace(yamlSpec, "weight: 5\n", "weight: 50\n", 1)
+	yamlSpec = strings.Replace(yamlSpec, "weight: 95", "weight: 50", 1)
+	superSpec, err = supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	counts = map[string]int{}
+	for i := 0; i < total; i++ {
+		serve("http://www.megaease.com/abc")
+	}
+	assert.InDelta(0.5, float64(counts["canary-pipeline"])/total, 0.02)
+	m.close()
+}
+
+func TestMuxInstanceSearchExcludePrefixes(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), nil)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+cacheSize: 100
+rules:
+- paths:
+  - pathPrefix: /api
+    excludePrefixes: [/api/health, /api/v2]
+    backend: api-pipeline
+  - pathRegexp: ^/api/v[0-9]+/
+    backend: versioned-pipeline
+  - pathPrefix: /
+    backend: default-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, nil)
+	mi := m.inst.Load().(*muxInstance)
+
+	search := func(path string) string {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com"+path, http.NoBody)
+		req, _ := httpprot.NewRequest(stdr)
+		r := mi.search(req)
+		assert.Equal(0, r.code)
+		return r.path.backend
+	}
+
+	// twice for the cached routes
+	for i := 0; i < 2; i++ {
+		assert.Equal("api-pipeline", search("/api/users"))
+		assert.Equal("api-pipeline", search("/api/v1/users"))
+
+		// the excluded requests fall through to the following paths.
+		assert.Equal("versioned-pipeline", search("/api/v2/users"))
+		assert.Equal("default-pipeline", search("/api/health"))
+		assert.Equal("default-pipeline", search("/api/v2"))
+		assert.Equal("default-pipeline", search("/index.html"))
+	}
+
+	superSpec, err = supervisor.NewSpec(strings.Replace(yamlSpec, "[/api/health, /api/v2]", "[api]", 1))
+	assert.Error(err)
+	assert.Nil(superSpec)
+}
+
+func TestMuxInstanceSearchHost(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), nil)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+cacheSize: 100
+rules:
+- host: a.example.com
+  paths:
+  - pathPrefix: /
+    backend: a-pipeline
+- hostRegexp: ^[^.]+\.b\.example\.com$
+  paths:
+  - pathPrefix: /
+    backend: b-pipeline
+- paths:
+  - pathPrefix: /
+    backend: default-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, nil)
+	mi := m.inst.Load().(*muxInstance)
+
+	search := func(url, serverName string) string {
+		stdr, _ := http.NewRequest(http.MethodGet, url, http.NoBody)
+		if serverName != "" {
+			stdr.TLS = &tls.ConnectionState{ServerName: serverName}
+		}
+		req, _ := httpprot.NewRequest(stdr)
+		r := mi.search(req)
+		assert.Equal(0, r.code)
+		return r.path.backend
+	}
+
+	// exact host
+	assert.Equal("a-pipeline", search("http://a.example.com/abc", ""))
+	assert.Equal("a-pipeline", search("http://a.example.com:8080/abc", ""))
+
+	// regexp host
+	assert.Equal("b-pipeline", search("http://x.b.example.com/abc", ""))
+
+	// default fallback
+	assert.Equal("default-pipeline", search("http://c.example.com/abc", ""))
+	assert.Equal("default-pipeline", search("http://b.example.com/abc", ""))
+
+	// SNI takes precedence over the Host header, and the cached route of
+	// the Host header is not used.
+	assert.Equal("b-pipeline", search("https://a.example.com/abc", "y.b.example.com"))
+	assert.Equal("a-pipeline", search("https://c.example.com/abc", "a.example.com"))
+	assert.Equal("a-pipeline", search("https://a.example.com/abc", "a.example.com"))
+	m.close()
+}
+
+func TestServeHTTPTraceContext(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+	defer m.close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+tracing:
+  serviceName: test
+  %s
+  zipkin:
+    serverURL: http://test.megaease.com/zipkin
+    sampleRate: 1
+rules:
+- paths:
+  - pathPrefix: /
+    backend: pipeline
+`
+	reload := func(b3 string) {
+		superSpec, err := supervisor.NewSpec(fmt.Sprintf(yamlSpec, b3))
+		assert.NoError(err)
+		m.reload(superSpec, mm)
+	}
+
+	// the headers of the request sent to the backend
+	var upstream http.Header
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				stdr, _ := http.NewRequest(http.MethodGet, "http://backend/", nil)
+				ctx.Span().InjectHTTP(stdr)
+				upstream = stdr.Header
+				return ""
+			},
+		}, true
+	}
+	serve := func(header http.Header) {
+		upstream = nil
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/abc", http.NoBody)
+		for k, v := range header {
+			stdr.Header[k] = v
+		}
+		m.ServeHTTP(httptest.NewRecorder(), stdr)
+	}
+	parseTraceParent := func(value string) (traceID, spanID, flags string) {
+		fields := strings.Split(value, "-")
+		assert.Len(fields, 4)
+		assert.Equal("00", fields[0])
+		return fields[1], fields[2], fields[3]
+	}
+
+	reload("b3Propagation: false")
+
+	// an incoming trace is continued
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	serve(http.Header{
+		"Traceparent": {"00-" + traceID + "-" + parentID + "-01"},
+		"Tracestate":  {"congo=t61rcWkgMzE"},
+	})
+	tid, sid, flags := parseTraceParent(upstream.Get("traceparent"))
+	assert.Equal(traceID, tid)
+	assert.NotEqual(parentID, sid)
+	assert.Equal("01", flags)
+	assert.Equal("congo=t61rcWkgMzE", upstream.Get("tracestate"))
+	assert.Empty(upstream.Get("b3"))
+
+	// a new trace is started if there is no trace context
+	serve(nil)
+	tid, sid, flags = parseTraceParent(upstream.Get("traceparent"))
+	assert.NotEqual(traceID, tid)
+	assert.Len(tid, 32)
+	assert.Len(sid, 16)
+	assert.Equal("01", flags)
+	assert.Empty(upstream.Get("tracestate"))
+
+	// invalid trace context is ignored
+	serve(http.Header{"Traceparent": {"00-" + strings.Repeat("0", 32) + "-" + parentID + "-01"}})
+	tid, _, _ = parseTraceParent(upstream.Get("traceparent"))
+	assert.NotEqual(strings.Repeat("0", 32), tid)
+
+	// B3 headers are ignored unless B3 propagation is enabled
+	const b3 = "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1"
+	serve(http.Header{"B3": {b3}})
+	tid, _, _ = parseTraceParent(upstream.Get("traceparent"))
+	assert.NotEqual("80f198ee56343ba864fe8b2a57d3eff7", tid)
+
+	reload("b3Propagation: true")
+	serve(http.Header{"B3": {b3}})
+	tid, _, _ = parseTraceParent(upstream.Get("traceparent"))
+	assert.Equal("80f198ee56343ba864fe8b2a57d3eff7", tid)
+	assert.True(strings.HasPrefix(upstream.Get("b3"), "80f198ee56343ba864fe8b2a57d3eff7-"))
+
+	// B3 propagation is enabled if not specified
+	reload("")
+	serve(http.Header{"B3": {b3}})
+	tid, _, _ = parseTraceParent(upstream.Get("traceparent"))
+	assert.Equal("80f198ee56343ba864fe8b2a57d3eff7", tid)
+	assert.True(strings.HasPrefix(upstream.Get("b3"), "80f198ee56343ba864fe8b2a57d3eff7-"))
+
+	// W3C trace context takes precedence over B3 headers
+	serve(http.Header{
+		"B3":          {b3},
+		"Traceparent": {"00-" + traceID + "-" + parentID + "-01"},
+	})
+	tid, _, _ = parseTraceParent(upstream.Get("traceparent"))
+	assert.Equal(traceID, tid)
+}
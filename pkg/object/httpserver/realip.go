@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+// realIPResolver derives the real IP of the client from the X-Forwarded-For
+// header, only the entries appended by trusted proxies are honored.
+type realIPResolver struct {
+	trustedHops int
+	trusted     *ipfilter.IPFilter
+}
+
+// newRealIPResolver returns nil if neither trusted CIDRs nor trusted hops
+// are configured, the default rules are used in this case.
+func newRealIPResolver(spec *Spec) *realIPResolver {
+	if len(spec.TrustedCIDRs) == 0 && spec.XForwardedForTrustedHops <= 0 {
+		return nil
+	}
+
+	rr := &realIPResolver{trustedHops: spec.XForwardedForTrustedHops}
+	if len(spec.TrustedCIDRs) > 0 {
+		rr.trusted = ipfilter.New(&ipfilter.Spec{
+			BlockByDefault: true,
+			AllowIPs:       spec.TrustedCIDRs,
+		})
+	}
+
+	return rr
+}
+
+func (rr *realIPResolver) isTrusted(ip string) bool {
+	return rr.trusted != nil && rr.trusted.Allow(ip)
+}
+
+// resolve walks back the address chain, which is the entries of the
+// X-Forwarded-For header followed by the remote address, from the nearest
+// hop. The first address which is neither within the trusted hops nor in
+// the trusted CIDRs is the client IP. If all addresses are trusted, the
+// leftmost one is the client IP.
+func (rr *realIPResolver) resolve(stdr *http.Request) string {
+	remoteIP := stdr.RemoteAddr
+	if h, _, err := net.SplitHostPort(remoteIP); err == nil {
+		remoteIP = h
+	}
+
+	var chain []string
+	for _, v := range stdr.Header.Values("X-Forwarded-For") {
+		for _, ip := range strings.Split(v, ",") {
+			if ip = strings.TrimSpace(ip); ip != "" {
+				chain = append(chain, ip)
+			}
+		}
+	}
+	chain = append(chain, remoteIP)
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		// An invalid entry can only be forged, so the address next to it,
+		// which is appended by a trusted proxy, is the client IP.
+		if net.ParseIP(chain[i]) == nil {
+			if i == len(chain)-1 {
+				return chain[i]
+			}
+			return chain[i+1]
+		}
+
+		hop := len(chain) - i
+		if i == 0 || (hop > rr.trustedHops && !rr.isTrusted(chain[i])) {
+			return chain[i]
+		}
+	}
+
+	// never reach here
+	return remoteIP
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpstat"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealIPResolver(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newRealIPResolver(&Spec{}))
+
+	resolve := func(rr *realIPResolver, remoteAddr string, xff ...string) string {
+		stdr, _ := http.NewRequest(http.MethodGet, "http://www.megaease.com/", http.NoBody)
+		stdr.RemoteAddr = remoteAddr
+		for _, v := range xff {
+			stdr.Header.Add("X-Forwarded-For", v)
+		}
+		return rr.resolve(stdr)
+	}
+
+	// trusted CIDRs
+	rr := newRealIPResolver(&Spec{TrustedCIDRs: []string{"10.0.0.0/8", "192.168.1.1"}})
+	assert.NotNil(rr)
+
+	// spoofed by a client which is not a trusted proxy
+	assert.Equal("1.2.3.4", resolve(rr, "1.2.3.4:1234", "5.6.7.8"))
+	// no X-Forwarded-For
+	assert.Equal("1.2.3.4", resolve(rr, "1.2.3.4:1234"))
+	// legitimate chain
+	assert.Equal("5.6.7.8", resolve(rr, "10.0.0.1:1234", "5.6.7.8"))
+	assert.Equal("5.6.7.8", resolve(rr, "192.168.1.1:1234", "5.6.7.8, 10.0.0.2"))
+	assert.Equal("5.6.7.8", resolve(rr, "10.0.0.1:1234", "5.6.7.8", "10.0.0.2"))
+	// spoofed entries before the client are ignored
+	assert.Equal("5.6.7.8", resolve(rr, "10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 10.0.0.2"))
+	// all are trusted
+	assert.Equal("10.0.0.3", resolve(rr, "10.0.0.1:1234", "10.0.0.3"))
+	// invalid entry
+	assert.Equal("10.0.0.2", resolve(rr, "10.0.0.1:1234", "not-an-ip, 10.0.0.2"))
+
+	// trusted hops
+	rr = newRealIPResolver(&Spec{XForwardedForTrustedHops: 2})
+	assert.NotNil(rr)
+	assert.Equal("5.6.7.8", resolve(rr, "1.1.1.1:1234", "9.9.9.9, 5.6.7.8, 2.2.2.2"))
+	assert.Equal("5.6.7.8", resolve(rr, "1.1.1.1:1234", "5.6.7.8"))
+	assert.Equal("1.1.1.1", resolve(rr, "1.1.1.1:1234"))
+
+	// trusted hops and CIDRs
+	rr = newRealIPResolver(&Spec{XForwardedForTrustedHops: 1, TrustedCIDRs: []string{"10.0.0.0/8"}})
+	assert.Equal("5.6.7.8", resolve(rr, "1.1.1.1:1234", "9.9.9.9, 5.6.7.8, 10.0.0.2"))
+	assert.Equal("10.0.0.2", resolve(rr, "1.1.1.1:1234", "10.0.0.2"))
+}
+
+func TestServeHTTPTrustedCIDRs(t *testing.T) {
+	assert := assert.New(t)
+
+	mm := &contexttest.MockedMuxMapper{}
+	m := newMux(httpstat.New(), httpstat.NewTopN(10), mm)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 8080
+keepAlive: true
+https: false
+trustedCIDRs: [192.0.2.0/24]
+ipFilter:
+  blockIPs: [9.9.9.9]
+rules:
+- paths:
+  - pathPrefix: /
+    backend: abc-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	m.reload(superSpec, mm)
+
+	// the client 9.9.9.9 tries to spoof its IP, the RemoteAddr of the
+	// request created by httptest is 192.0.2.1, which is trusted.
+	stdr := httptest.NewRequest(http.MethodGet, "http://www.megaease.com/", http.NoBody)
+	stdr.Header.Set("X-Forwarded-For", "1.1.1.1, 9.9.9.9")
+	stdw := httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusForbidden, stdw.Code)
+
+	stdr = httptest.NewRequest(http.MethodGet, "http://www.megaease.com/", http.NoBody)
+	stdr.Header.Set("X-Forwarded-For", "1.1.1.1")
+	stdw = httptest.NewRecorder()
+	m.ServeHTTP(stdw, stdr)
+	assert.Equal(http.StatusServiceUnavailable, stdw.Code)
+	m.close()
+}
@@ -22,6 +22,8 @@ import (
 	stdcontext "context"
 	"fmt"
 	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"reflect"
@@ -38,24 +40,33 @@ import (
 	"github.com/megaease/easegress/pkg/util/easemonitor"
 	"github.com/megaease/easegress/pkg/util/filterwriter"
 	"github.com/megaease/easegress/pkg/util/limitlistener"
+	"github.com/megaease/easegress/pkg/util/reuseport"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 const (
 	defaultKeepAliveTimeout = 60 * time.Second
 
-	checkFailedTimeout = 10 * time.Second
+	defaultFailedRetryInterval    = 10 * time.Second
+	defaultFailedRetryMaxInterval = 5 * time.Minute
 
 	topNum = 10
 
-	stateNil     stateType = "nil"
-	stateFailed  stateType = "failed"
-	stateRunning stateType = "running"
-	stateClosed  stateType = "closed"
+	stateNil      stateType = "nil"
+	stateFailed   stateType = "failed"
+	stateRunning  stateType = "running"
+	stateDraining stateType = "draining"
+	stateClosed   stateType = "closed"
 )
 
 var (
 	errNil = fmt.Errorf("")
-	gnet   = graceupdate.Global
+
+	// fnAfterFunc, fnListen and fnListenReusePort are replaced in tests.
+	fnAfterFunc       = time.AfterFunc
+	fnListen          = graceupdate.Listen
+	fnListenReusePort = reuseport.Listen
 )
 
 type (
@@ -70,7 +81,10 @@ type (
 		nextSuperSpec *supervisor.Spec
 		muxMapper     context.MuxMapper
 	}
-	eventClose struct{ done chan struct{} }
+	eventClose   struct{ done chan struct{} }
+	eventDrain   struct{ done chan struct{} }
+	eventUndrain struct{ done chan struct{} }
+	eventRestart struct{ done chan struct{} }
 
 	runtime struct {
 		superSpec *supervisor.Spec
@@ -82,12 +96,24 @@ type (
 		eventChan chan interface{}
 
 		// status
-		state atomic.Value // stateType
-		err   atomic.Value // error
-
-		httpStat      *httpstat.HTTPStat
-		topN          *httpstat.TopN
-		limitListener *limitlistener.LimitListener
+		state     atomic.Value // stateType
+		err       atomic.Value // error
+		inherited atomic.Value // bool
+
+		httpStat *httpstat.HTTPStat
+		topN     *httpstat.TopN
+		// limitListener is accessed by Status concurrently.
+		limitListener atomic.Value // *limitlistener.LimitListener
+
+		// failedRetries is the number of consecutive retries to
+		// restart the failed server, retryTimer is the timer of the
+		// pending retry.
+		failedRetries int
+		retryTimer    *time.Timer
+
+		// healthErrorRateThreshold is accessed by Status concurrently,
+		// so it is not read from spec.
+		healthErrorRateThreshold atomic.Value // float64
 	}
 
 	// Status contains all status generated by runtime, for displaying to users.
@@ -98,8 +124,22 @@ type (
 		State stateType `yaml:"state"`
 		Error string    `yaml:"error,omitempty"`
 
+		// Inherited is true if the listener is inherited from the
+		// parent process by graceful update.
+		Inherited bool `yaml:"inherited"`
+
+		Maintenance bool `yaml:"maintenance"`
+
+		// Connections is the connection statistics of the listener, it
+		// is nil for HTTP/3.
+		Connections *limitlistener.Status `yaml:"connections,omitempty"`
+
 		*httpstat.Status
 		TopN []*httpstat.Item `yaml:"topN"`
+
+		// ErrorTopN is the TopN of error responses ranked by error count.
+		ErrorTopN     []*httpstat.Item             `yaml:"errorTopN"`
+		TopErrorCodes []*httpstat.StatusCodeMetric `yaml:"topErrorCodes"`
 	}
 )
 
@@ -112,11 +152,12 @@ func newRuntime(superSpec *supervisor.Spec, muxMapper context.MuxMapper) *runtim
 	}
 
 	r.mux = newMux(r.httpStat, r.topN, muxMapper)
+	r.healthErrorRateThreshold.Store(float64(0))
 	r.setState(stateNil)
 	r.setError(errNil)
+	r.inherited.Store(false)
 
 	go r.fsm()
-	go r.checkFailed(checkFailedTimeout)
 
 	return r
 }
@@ -128,18 +169,91 @@ func (r *runtime) Close() {
 	<-done
 }
 
+// Drain stops the server from accepting new requests, the readiness path
+// returns 503 and keep-alives are disabled, while the in-flight requests
+// are not affected.
+func (r *runtime) Drain() {
+	done := make(chan struct{})
+	r.eventChan <- &eventDrain{done: done}
+	<-done
+}
+
+// Undrain reverts Drain, the server accepts new requests again.
+func (r *runtime) Undrain() {
+	done := make(chan struct{})
+	r.eventChan <- &eventUndrain{done: done}
+	<-done
+}
+
+// Restart restarts the server with the current spec, the in-flight
+// requests are drained like closing the server. It returns after the
+// server is restarted.
+func (r *runtime) Restart() {
+	done := make(chan struct{})
+	r.eventChan <- &eventRestart{done: done}
+	<-done
+}
+
+// SetMaintenance turns on or off the maintenance mode, it overrides the
+// maintenance in the spec only if it is on.
+func (r *runtime) SetMaintenance(on bool) {
+	r.mux.setMaintenance(on)
+}
+
 // Status returns HTTPServer Status.
 func (r *runtime) Status() *Status {
-	health := r.getError().Error()
+	stat := r.httpStat.Status()
 
 	return &Status{
 		Name:   r.superSpec.Name(),
-		Health: health,
+		Health: r.health(stat),
 		State:  r.getState(),
 		Error:  r.getError().Error(),
-		Status: r.httpStat.Status(),
+
+		Inherited:   r.inherited.Load().(bool),
+		Maintenance: r.mux.inMaintenance(),
+		Connections: r.connections(),
+
+		Status: stat,
 		TopN:   r.topN.Status(),
+
+		ErrorTopN:     r.topN.ErrorStatus(),
+		TopErrorCodes: stat.TopErrorCodes(topNum),
+	}
+}
+
+// connections returns the connection statistics of the listener, it is
+// nil if there's no listener.
+func (r *runtime) connections() *limitlistener.Status {
+	if l := r.getLimitListener(); l != nil {
+		return l.Status()
 	}
+	return nil
+}
+
+func (r *runtime) getLimitListener() *limitlistener.LimitListener {
+	l, _ := r.limitListener.Load().(*limitlistener.LimitListener)
+	return l
+}
+
+// health returns the aggregate health of the server, it is empty if the
+// server is healthy. It is the error of the server if there is one,
+// otherwise, the server is degraded if the error rate of the last minute
+// exceeds the threshold.
+func (r *runtime) health(stat *httpstat.Status) string {
+	if err := r.getError().Error(); err != "" {
+		return err
+	}
+
+	threshold := r.healthErrorRateThreshold.Load().(float64)
+	if threshold <= 0 || stat.M1 <= 0 {
+		return ""
+	}
+
+	if errRate := stat.M1Err / stat.M1; errRate > threshold {
+		return fmt.Sprintf("degraded: error rate %.2f exceeds %.2f", errRate, threshold)
+	}
+	return ""
 }
 
 // FSM is the finite-state-machine for the runtime.
@@ -152,6 +266,12 @@ func (r *runtime) fsm() {
 			r.handleEventServeFailed(e)
 		case *eventReload:
 			r.handleEventReload(e)
+		case *eventDrain:
+			r.handleEventDrain(e)
+		case *eventUndrain:
+			r.handleEventUndrain(e)
+		case *eventRestart:
+			r.handleEventRestart(e)
 		case *eventClose:
 			r.handleEventClose(e)
 			// NOTE: We don't close hs.eventChan,
@@ -169,10 +289,24 @@ func (r *runtime) reload(nextSuperSpec *supervisor.Spec, muxMapper context.MuxMa
 	r.mux.reload(nextSuperSpec, muxMapper)
 
 	nextSpec := nextSuperSpec.ObjectSpec().(*Spec)
+	if nextSpec != nil {
+		r.healthErrorRateThreshold.Store(nextSpec.HealthErrorRateThreshold)
+		r.httpStat.SetSLO(nextSpec.slo())
+	}
 
 	// r.limitListener is not created just after the process started and the config load for the first time.
-	if nextSpec != nil && r.limitListener != nil {
-		r.limitListener.SetMaxConnection(nextSpec.MaxConnections)
+	if l := r.getLimitListener(); nextSpec != nil && l != nil {
+		l.SetMaxConnection(nextSpec.MaxConnections)
+		// replacing the accept rate limiter resets its bucket, so only
+		// replace it when the rate is changed.
+		perSecond, burst := nextSpec.acceptRate()
+		var prevPerSecond, prevBurst uint32
+		if r.spec != nil {
+			prevPerSecond, prevBurst = r.spec.acceptRate()
+		}
+		if perSecond != prevPerSecond || burst != prevBurst {
+			l.SetAcceptRate(perSecond, burst)
+		}
 	}
 
 	// NOTE: Due to the mechanism of supervisor,
@@ -232,14 +366,38 @@ func (r *runtime) needRestartServer(nextSpec *Spec) bool {
 	x.MaxConnections, y.MaxConnections = 0, 0
 	x.CacheSize, y.CacheSize = 0, 0
 	x.XForwardedFor, y.XForwardedFor = false, false
+	x.TrustedCIDRs, y.TrustedCIDRs = nil, nil
+	x.XForwardedForTrustedHops, y.XForwardedForTrustedHops = 0, 0
 	x.Tracing, y.Tracing = nil, nil
 	x.IPFilter, y.IPFilter = nil, nil
 	x.Rules, y.Rules = nil, nil
-
-	// The update of rules need not to shutdown server.
+	x.ReadinessPath, y.ReadinessPath = "", ""
+	x.FailedRetryInterval, y.FailedRetryInterval = "", ""
+	x.FailedRetryMaxInterval, y.FailedRetryMaxInterval = "", ""
+	x.HealthErrorRateThreshold, y.HealthErrorRateThreshold = 0, 0
+	x.MaxRequestsPerConn, y.MaxRequestsPerConn = 0, 0
+	x.Maintenance, y.Maintenance = nil, nil
+	x.AcceptRate, y.AcceptRate = nil, nil
+	x.SLO, y.SLO = nil, nil
+
+	// The update of rules need not to shutdown server, but the timeouts
+	// (readTimeout, writeTimeout, etc.) are only applied when the server
+	// starts, so they are compared below.
 	return !reflect.DeepEqual(x, y)
 }
 
+// listen creates the listener of the server. With reusePort, the listener
+// is not managed by graceupdate, it is never passed to the child process
+// on graceful update, the child binds the port by itself instead.
+func (r *runtime) listen() (net.Listener, bool, error) {
+	addr := fmt.Sprintf(":%d", r.spec.Port)
+	if r.spec.ReusePort {
+		l, err := fnListenReusePort("tcp", addr)
+		return l, false, err
+	}
+	return fnListen("tcp", addr)
+}
+
 func (r *runtime) startServer() {
 	keepAliveTimeout := defaultKeepAliveTimeout
 	if r.spec.KeepAliveTimeout != "" {
@@ -247,14 +405,30 @@ func (r *runtime) startServer() {
 		keepAliveTimeout = t
 	}
 
+	// Zero means no timeout.
+	readTimeout := parseTimeout(r.spec.ReadTimeout)
+	readHeaderTimeout := parseTimeout(r.spec.ReadHeaderTimeout)
+	writeTimeout := parseTimeout(r.spec.WriteTimeout)
+
 	fw := filterwriter.New(os.Stderr, func(p []byte) bool {
 		return !bytes.Contains(p, []byte("TLS handshake error"))
 	})
+	// HTTP/2 without TLS (h2c) is only accepted when it is enabled
+	// explicitly.
+	var handler http.Handler = r.mux
+	if r.spec.H2C {
+		handler = h2c.NewHandler(r.mux, &http2.Server{})
+	}
+
 	srv := &http.Server{
-		Addr:        fmt.Sprintf(":%d", r.spec.Port),
-		Handler:     r.mux,
-		IdleTimeout: keepAliveTimeout,
-		ErrorLog:    log.New(fw, "", log.LstdFlags),
+		Addr:              fmt.Sprintf(":%d", r.spec.Port),
+		Handler:           handler,
+		ConnContext:       connContext,
+		IdleTimeout:       keepAliveTimeout,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		ErrorLog:          log.New(fw, "", log.LstdFlags),
 	}
 	srv.SetKeepAlivesEnabled(r.spec.KeepAlive)
 
@@ -268,28 +442,54 @@ func (r *runtime) startServer() {
 	r.setState(stateRunning)
 	r.setError(nil)
 
+	// The server could be restarted by reloading when draining.
+	if r.mux.isDraining() {
+		srv.SetKeepAlivesEnabled(false)
+		r.setState(stateDraining)
+	}
+
 	if r.spec.HTTP3 {
 		r.server3 = &http3.Server{
 			Server: r.server,
 		}
-		go r.runHTTP3Server(r.startNum)
+		r.limitListener.Store((*limitlistener.LimitListener)(nil))
+		go r.runHTTP3Server(r.server3, r.startNum)
 	} else {
-		listener, err := gnet.Listen("tcp", fmt.Sprintf(":%d", r.spec.Port))
+		listener, inherited, err := r.listen()
 		if err != nil {
 			r.setState(stateFailed)
 			r.setError(err)
+			r.scheduleRetry()
 
 			return
 		}
+		r.failedRetries = 0
+		r.inherited.Store(inherited)
 
+		listener = newTCPListener(listener, r.spec)
 		limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
-		r.limitListener = limitListener
-		go r.runHTTP1And2Server(limitListener, r.spec.HTTPS, r.startNum)
+		if r.spec.ConnLimitResponse != nil {
+			limitListener.SetRejecter(newConnLimitRejecter(r.spec.ConnLimitResponse, srv.TLSConfig).reject)
+		}
+		limitListener.SetAcceptRate(r.spec.acceptRate())
+		r.limitListener.Store(limitListener)
+		go r.runHTTP1And2Server(srv, limitListener, r.spec.HTTPS, r.startNum)
+	}
+}
+
+// parseTimeout returns 0 if s is empty, s is validated by the spec.
+func parseTimeout(s string) time.Duration {
+	if s == "" {
+		return 0
 	}
+	t, _ := time.ParseDuration(s)
+	return t
 }
 
-func (r *runtime) runHTTP3Server(startNum uint64) {
-	err := r.server3.ListenAndServe()
+// runHTTP3Server runs srv, which is passed in instead of read from r,
+// because r.server3 is replaced when the server is restarted.
+func (r *runtime) runHTTP3Server(srv *http3.Server, startNum uint64) {
+	err := srv.ListenAndServe()
 	if err != http.ErrServerClosed {
 		r.eventChan <- &eventServeFailed{
 			err:      err,
@@ -298,12 +498,14 @@ func (r *runtime) runHTTP3Server(startNum uint64) {
 	}
 }
 
-func (r *runtime) runHTTP1And2Server(limitListener *limitlistener.LimitListener, https bool, startNum uint64) {
+// runHTTP1And2Server runs srv, which is passed in instead of read from
+// r, because r.server is replaced when the server is restarted.
+func (r *runtime) runHTTP1And2Server(srv *http.Server, limitListener *limitlistener.LimitListener, https bool, startNum uint64) {
 	var err error
 	if https {
-		err = r.server.ServeTLS(limitListener, "", "")
+		err = srv.ServeTLS(limitListener, "", "")
 	} else {
-		err = r.server.Serve(limitListener)
+		err = srv.Serve(limitListener)
 	}
 	if err != http.ErrServerClosed {
 		r.eventChan <- &eventServeFailed{
@@ -334,20 +536,55 @@ func (r *runtime) closeServer() {
 	}
 }
 
-func (r *runtime) checkFailed(timeout time.Duration) {
-	ticker := time.NewTicker(timeout)
-	for range ticker.C {
-		state := r.getState()
-		if state == stateFailed {
-			r.eventChan <- &eventCheckFailed{}
-		} else if state == stateClosed {
-			ticker.Stop()
-			return
+// retryInterval returns the interval before the next retry to restart
+// the failed server. The interval grows exponentially up to the max
+// interval, and a random jitter is applied to it.
+func (r *runtime) retryInterval() time.Duration {
+	interval, maxInterval := defaultFailedRetryInterval, defaultFailedRetryMaxInterval
+	if r.spec != nil {
+		if d := parseTimeout(r.spec.FailedRetryInterval); d > 0 {
+			interval = d
 		}
+		if d := parseTimeout(r.spec.FailedRetryMaxInterval); d > 0 {
+			maxInterval = d
+		}
+	}
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+
+	for i := 0; i < r.failedRetries && interval < maxInterval; i++ {
+		interval *= 2
 	}
+	if interval > maxInterval {
+		interval = maxInterval
+	}
+
+	// equal jitter: the result is in [interval/2, interval].
+	half := interval / 2
+	return half + time.Duration(rand.Int63n(int64(interval-half)+1))
+}
+
+// scheduleRetry schedules a retry to restart the failed server, it does
+// nothing if there's already a pending retry.
+func (r *runtime) scheduleRetry() {
+	if r.retryTimer != nil {
+		return
+	}
+
+	interval := r.retryInterval()
+	r.failedRetries++
+	logger.Infof("http server %s failed, retry in %v", r.superSpec.Name(), interval)
+
+	r.retryTimer = fnAfterFunc(interval, func() {
+		if r.getState() != stateClosed {
+			r.eventChan <- &eventCheckFailed{}
+		}
+	})
 }
 
 func (r *runtime) handleEventCheckFailed(e *eventCheckFailed) {
+	r.retryTimer = nil
 	if r.getState() == stateFailed {
 		r.startServer()
 	}
@@ -359,13 +596,66 @@ func (r *runtime) handleEventServeFailed(e *eventServeFailed) {
 	}
 	r.setState(stateFailed)
 	r.setError(e.err)
+	r.scheduleRetry()
 }
 
 func (r *runtime) handleEventReload(e *eventReload) {
 	r.reload(e.nextSuperSpec, e.muxMapper)
 }
 
+func (r *runtime) handleEventDrain(e *eventDrain) {
+	defer close(e.done)
+
+	if r.getState() != stateRunning {
+		return
+	}
+
+	r.mux.setDraining(true)
+	if r.server != nil {
+		r.server.SetKeepAlivesEnabled(false)
+	}
+	r.setState(stateDraining)
+}
+
+func (r *runtime) handleEventUndrain(e *eventUndrain) {
+	defer close(e.done)
+
+	// Clear the flag even if the server failed when draining, so that
+	// it is not draining after started again.
+	r.mux.setDraining(false)
+	if r.getState() != stateDraining {
+		return
+	}
+
+	if r.server != nil {
+		r.server.SetKeepAlivesEnabled(r.spec.KeepAlive)
+	}
+	r.setState(stateRunning)
+}
+
+func (r *runtime) handleEventRestart(e *eventRestart) {
+	defer close(e.done)
+
+	if r.spec == nil || r.getState() == stateClosed {
+		return
+	}
+
+	// the restart replaces the pending retry of the failed server.
+	if r.retryTimer != nil {
+		r.retryTimer.Stop()
+		r.retryTimer = nil
+	}
+	r.failedRetries = 0
+
+	r.closeServer()
+	r.startServer()
+}
+
 func (r *runtime) handleEventClose(e *eventClose) {
+	r.setState(stateClosed)
+	if r.retryTimer != nil {
+		r.retryTimer.Stop()
+	}
 	r.closeServer()
 	r.mux.close()
 	close(e.done)
@@ -391,5 +681,36 @@ func (s *Status) ToMetrics(service string) []*easemonitor.Metrics {
 		results = append(results, metrics...)
 	}
 
+	for _, item := range s.ErrorTopN {
+		metrics := item.ToMetrics(service)
+		for _, m := range metrics {
+			m.Resource = "SERVER_ERROR_TOPN"
+			m.URL = item.Path
+		}
+		results = append(results, metrics...)
+	}
+
+	if s.Connections != nil {
+		results = append(results, &easemonitor.Metrics{
+			CommonFields: easemonitor.CommonFields{
+				Service:  service,
+				Type:     "eg-http-connection",
+				Resource: "SERVER_CONNECTION",
+			},
+			OtherFields: s.Connections,
+		})
+	}
+
+	for _, code := range s.TopErrorCodes {
+		results = append(results, &easemonitor.Metrics{
+			CommonFields: easemonitor.CommonFields{
+				Service:  service,
+				Type:     "eg-http-status-code",
+				Resource: "SERVER_TOP_ERROR_CODE",
+			},
+			OtherFields: code,
+		})
+	}
+
 	return results
 }
@@ -18,12 +18,24 @@
 package httpserver
 
 import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/protocols/httpprot"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/reuseport"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
 )
 
 func TestNewRuntim(t *testing.T) {
@@ -92,3 +104,688 @@ https: false
 
 	//
 }
+
+func TestReadHeaderTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38083
+keepAlive: true
+https: false
+readHeaderTimeout: 100ms
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.reload(superSpec, mm)
+
+	var conn net.Conn
+	for i := 0; i < 10; i++ {
+		conn, err = net.Dial("tcp", "127.0.0.1:38083")
+		if err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+
+	// a slow client which never finishes sending the header.
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: www.megaease.com\r\n"))
+	assert.NoError(err)
+
+	// the server closes the connection when the timeout fires.
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Read(make([]byte, 1024))
+	assert.Equal(io.EOF, err)
+
+	// timeouts changes require restarting the server.
+	yamlSpec = `
+kind: HTTPServer
+name: test
+port: 38083
+keepAlive: true
+https: false
+readHeaderTimeout: 200ms
+writeTimeout: 10s
+`
+	superSpec, err = supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+	assert.True(r.needRestartServer(superSpec.ObjectSpec().(*Spec)))
+}
+
+func TestDrain(t *testing.T) {
+	assert := assert.New(t)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				close(entered)
+				<-release
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38084
+keepAlive: true
+https: false
+readinessPath: /healthz
+rules:
+- paths:
+  - pathPrefix: /api
+    backend: api-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.reload(superSpec, mm)
+
+	get := func(path string) (int, error) {
+		resp, err := http.Get("http://127.0.0.1:38084" + path)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	var code int
+	for i := 0; i < 10; i++ {
+		if code, err = get("/healthz"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(http.StatusOK, code)
+
+	// start an in-flight request
+	inflight := make(chan int)
+	go func() {
+		code, _ := get("/api")
+		inflight <- code
+	}()
+	<-entered
+
+	r.Drain()
+	assert.Equal(stateDraining, r.getState())
+
+	code, err = get("/healthz")
+	assert.NoError(err)
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	code, err = get("/api")
+	assert.NoError(err)
+	assert.Equal(http.StatusServiceUnavailable, code)
+
+	// the in-flight request completes
+	close(release)
+	assert.Equal(http.StatusOK, <-inflight)
+
+	// the rejected request is recorded
+	assert.Equal(uint64(1), r.httpStat.Status().Codes[http.StatusServiceUnavailable])
+
+	r.Undrain()
+	assert.Equal(stateRunning, r.getState())
+
+	code, err = get("/healthz")
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, code)
+}
+
+func TestFailedRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	// take the port to make the server fail to start.
+	l, err := net.Listen("tcp", ":38085")
+	if !assert.NoError(err) {
+		return
+	}
+
+	var (
+		mutex     sync.Mutex
+		intervals []time.Duration
+	)
+	fnAfterFunc = func(d time.Duration, f func()) *time.Timer {
+		mutex.Lock()
+		intervals = append(intervals, d)
+		mutex.Unlock()
+		return time.AfterFunc(time.Millisecond, f)
+	}
+	defer func() { fnAfterFunc = time.AfterFunc }()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38085
+keepAlive: true
+https: false
+failedRetryInterval: 1s
+failedRetryMaxInterval: 8s
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+
+	count := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return len(intervals)
+	}
+	for i := 0; i < 100 && count() < 6; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// release the port, the server starts on the next retry.
+	l.Close()
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !assert.GreaterOrEqual(len(intervals), 6) {
+		return
+	}
+
+	// the interval grows exponentially with jitter, up to the cap.
+	upper := time.Second
+	for i, d := range intervals[:6] {
+		assert.GreaterOrEqual(d, upper/2, "retry %d", i)
+		assert.LessOrEqual(d, upper, "retry %d", i)
+		if upper < 8*time.Second {
+			upper *= 2
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38086
+keepAlive: true
+https: false
+healthErrorRateThreshold: 0.5
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	// the error rate is below the threshold.
+	assert.Equal("", r.health(&httpstat.Status{RequestMetric: httpstat.RequestMetric{M1: 10, M1Err: 5}}))
+
+	// most of the requests failed, the server is degraded while the
+	// listener is fine. The rates are updated after a tick interval.
+	now := time.Now()
+	r.httpStat.SetClock(func() time.Time { return now })
+	for i := 0; i < 10; i++ {
+		code := http.StatusBadGateway
+		if i < 2 {
+			code = http.StatusOK
+		}
+		r.httpStat.Stat(&httpstat.Metric{StatusCode: code, Duration: time.Millisecond})
+	}
+	now = now.Add(5 * time.Second)
+	status := r.Status()
+	assert.Equal(stateRunning, status.State)
+	assert.Equal("", status.Error)
+	assert.False(status.Inherited)
+	assert.Equal("degraded: error rate 0.80 exceeds 0.50", status.Health)
+
+	// the error of the server takes precedence.
+	r.setError(fmt.Errorf("listen failed"))
+	assert.Equal("listen failed", r.health(status.Status))
+	r.setError(nil)
+
+	// the threshold is disabled.
+	r.healthErrorRateThreshold.Store(float64(0))
+	assert.Equal("", r.health(status.Status))
+}
+
+func TestSLO(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38090
+keepAlive: true
+https: false
+slo:
+  availability: 0.9
+  latencyThreshold: 100ms
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+
+	now := time.Now()
+	r.httpStat.SetClock(func() time.Time { return now })
+	r.reload(superSpec, mm)
+
+	// 2 failed and 2 slow requests out of 10, the bad ratio is 0.4, which
+	// is 4 times the error budget.
+	for i := 0; i < 10; i++ {
+		m := &httpstat.Metric{StatusCode: http.StatusOK, Duration: time.Millisecond}
+		if i < 2 {
+			m.StatusCode = http.StatusBadGateway
+		} else if i < 4 {
+			m.Duration = time.Second
+		}
+		r.httpStat.Stat(m)
+	}
+	now = now.Add(5 * time.Second)
+	slo := r.Status().Status.SLO
+	if assert.NotNil(slo) {
+		assert.InDelta(4, slo.M1BurnRate, 0.01)
+	}
+
+	// the burn rates are not reported once the SLO is removed.
+	superSpec, err = supervisor.NewSpec(strings.Split(yamlSpec, "slo:")[0])
+	assert.NoError(err)
+	assert.False(r.needRestartServer(superSpec.ObjectSpec().(*Spec)))
+	r.reload(superSpec, mm)
+	assert.Nil(r.Status().Status.SLO)
+}
+
+func TestReusePort(t *testing.T) {
+	if !reuseport.Supported {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+
+	assert := assert.New(t)
+
+	// another process (simulated by a listener) binds the same port.
+	l, err := reuseport.Listen("tcp", ":38086")
+	if !assert.NoError(err) {
+		return
+	}
+	defer l.Close()
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38086
+keepAlive: true
+https: false
+reusePort: true
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+	assert.False(r.inherited.Load().(bool))
+}
+
+func TestConnections(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38087
+keepAlive: true
+https: false
+maxConnections: 1
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+
+	// there's no listener before the server starts.
+	assert.Nil(r.Status().Connections)
+
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	conn1, err := net.Dial("tcp", "127.0.0.1:38087")
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn1.Close()
+
+	assert.Eventually(func() bool {
+		c := r.Status().Connections
+		return c.Active == 1 && c.Accepted == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the second connection waits for the first one to be closed.
+	conn2, err := net.Dial("tcp", "127.0.0.1:38087")
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn2.Close()
+
+	assert.Eventually(func() bool {
+		return r.Status().Connections.LimitReached >= 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(uint64(1), r.Status().Connections.Accepted)
+
+	conn1.Close()
+	assert.Eventually(func() bool {
+		return r.Status().Connections.Accepted == 2
+	}, time.Second, 10*time.Millisecond)
+
+	found := false
+	for _, m := range r.Status().ToMetrics("mock") {
+		if m.Type == "eg-http-connection" {
+			found = true
+			assert.Equal("SERVER_CONNECTION", m.Resource)
+		}
+	}
+	assert.True(found)
+}
+
+func TestAcceptRate(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38089
+keepAlive: true
+https: false
+acceptRate:
+  connectionsPerSecond: 10
+  burst: 1
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	// the connections are accepted at 10 connections per second.
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:38089")
+		if !assert.NoError(err) {
+			return
+		}
+		defer conn.Close()
+	}
+	assert.Eventually(func() bool {
+		return r.Status().Connections.Accepted == 4
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(time.Since(start), 250*time.Millisecond)
+	assert.GreaterOrEqual(r.Status().Connections.RateLimited, uint64(3))
+
+	// changing the limit doesn't restart the server.
+	superSpec, err = supervisor.NewSpec(strings.Split(yamlSpec, "acceptRate")[0])
+	assert.NoError(err)
+	assert.False(r.needRestartServer(superSpec.ObjectSpec().(*Spec)))
+
+	spec := superSpec.ObjectSpec().(*Spec)
+	spec.HTTP3 = true
+	spec.AcceptRate = &AcceptRate{ConnectionsPerSecond: 10}
+	assert.Error(spec.Validate())
+}
+
+func TestAcceptRateReload(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38091
+keepAlive: true
+https: false
+acceptRate:
+  connectionsPerSecond: 1
+  burst: 3
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+	for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(stateRunning, r.getState())
+
+	dial := func() {
+		conn, err := net.Dial("tcp", "127.0.0.1:38091")
+		if assert.NoError(err) {
+			defer conn.Close()
+		}
+	}
+
+	// the listener takes a token before each accept, so the burst is
+	// used up after 2 connections.
+	dial()
+	dial()
+	assert.Eventually(func() bool {
+		return r.Status().Connections.Accepted == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(uint64(0), r.Status().Connections.RateLimited)
+
+	// reloading with the same rate keeps the bucket, so the accept after
+	// the next connection waits for a token.
+	r.reload(superSpec, mm)
+	dial()
+	assert.Eventually(func() bool {
+		status := r.Status().Connections
+		return status.Accepted == 3 && status.RateLimited == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestH2C(t *testing.T) {
+	assert := assert.New(t)
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38092
+keepAlive: true
+https: false
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	mm := &contexttest.MockedMuxMapper{}
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+
+	waitRunning := func() {
+		for i := 0; i < 100 && r.getState() != stateRunning; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(stateRunning, r.getState())
+	}
+
+	// the client sends HTTP/2 requests without TLS with prior knowledge.
+	h2cGet := func() (*http.Response, error) {
+		client := &http.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+					return net.Dial(network, addr)
+				},
+			},
+			Timeout: time.Second,
+		}
+		return client.Get("http://127.0.0.1:38092/")
+	}
+
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+	waitRunning()
+	_, err = h2cGet()
+	assert.Error(err)
+
+	// enabling h2c restarts the server.
+	superSpec, err = supervisor.NewSpec(yamlSpec + "h2c: true\n")
+	assert.NoError(err)
+	assert.True(r.needRestartServer(superSpec.ObjectSpec().(*Spec)))
+	r.eventChan <- &eventReload{nextSuperSpec: superSpec, muxMapper: mm}
+	assert.Eventually(func() bool {
+		resp, err := h2cGet()
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.ProtoMajor == 2
+	}, 2*time.Second, 50*time.Millisecond)
+
+	spec := superSpec.ObjectSpec().(*Spec)
+	spec.HTTPS = true
+	spec.AutoCert = true
+	assert.Error(spec.Validate())
+}
+
+func TestRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	mm := &contexttest.MockedMuxMapper{}
+	mm.MockedGetHandler = func(name string) (context.Handler, bool) {
+		return &contexttest.MockedHandler{
+			MockedHandle: func(ctx *context.Context) string {
+				if ctx.GetInputRequest().(*httpprot.Request).Path() == "/slow" {
+					close(entered)
+					<-release
+				}
+				resp, _ := httpprot.NewResponse(nil)
+				ctx.SetResponse(context.DefaultNamespace, resp)
+				return ""
+			},
+		}, true
+	}
+
+	yamlSpec := `
+kind: HTTPServer
+name: test
+port: 38090
+keepAlive: true
+https: false
+rules:
+- paths:
+  - pathPrefix: /
+    backend: api-pipeline
+`
+	superSpec, err := supervisor.NewSpec(yamlSpec)
+	assert.NoError(err)
+
+	r := newRuntime(superSpec, mm)
+	defer r.Close()
+	r.reload(superSpec, mm)
+
+	get := func(path string) (int, error) {
+		resp, err := http.Get("http://127.0.0.1:38090" + path)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	var code int
+	for i := 0; i < 10; i++ {
+		if code, err = get("/api"); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(http.StatusOK, code)
+	spec, startNum := r.spec, r.startNum
+
+	// start an in-flight request
+	inflight := make(chan int)
+	go func() {
+		code, _ := get("/slow")
+		inflight <- code
+	}()
+	<-entered
+
+	// the restart waits for the in-flight request.
+	restarted := make(chan struct{})
+	go func() {
+		r.Restart()
+		close(restarted)
+	}()
+	select {
+	case <-restarted:
+		assert.Fail("restarted before the in-flight request completes")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	assert.Equal(http.StatusOK, <-inflight)
+	<-restarted
+
+	// the spec is kept, and the runtime state is reset.
+	assert.Same(spec, r.spec)
+	assert.Equal(startNum+1, r.startNum)
+	assert.Equal(stateRunning, r.getState())
+	assert.Equal(uint64(0), r.Status().Connections.Accepted)
+
+	code, err = get("/api")
+	assert.NoError(err)
+	assert.Equal(http.StatusOK, code)
+}
@@ -23,10 +23,15 @@ import (
 	"encoding/base64"
 	"fmt"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/megaease/easegress/pkg/object/autocertmanager"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpheader"
+	"github.com/megaease/easegress/pkg/protocols/httpprot/httpstat"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/reuseport"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
@@ -39,8 +44,12 @@ type (
 		AutoCert          bool          `yaml:"autoCert" jsonschema:"omitempty"`
 		XForwardedFor     bool          `yaml:"xForwardedFor" jsonschema:"omitempty"`
 		Port              uint16        `yaml:"port" jsonschema:"required,minimum=1"`
+		ReusePort         bool          `yaml:"reusePort" jsonschema:"omitempty"`
 		ClientMaxBodySize int64         `yaml:"clientMaxBodySize" jsonschema:"omitempty"`
 		KeepAliveTimeout  string        `yaml:"keepAliveTimeout" jsonschema:"omitempty,format=duration"`
+		ReadTimeout       string        `yaml:"readTimeout" jsonschema:"omitempty,format=duration"`
+		ReadHeaderTimeout string        `yaml:"readHeaderTimeout" jsonschema:"omitempty,format=duration"`
+		WriteTimeout      string        `yaml:"writeTimeout" jsonschema:"omitempty,format=duration"`
 		MaxConnections    uint32        `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
 		CacheSize         uint32        `yaml:"cacheSize" jsonschema:"omitempty"`
 		Tracing           *tracing.Spec `yaml:"tracing" jsonschema:"omitempty"`
@@ -56,10 +65,84 @@ type (
 		// Keys saved as map, key is domain name, value is secret
 		Keys map[string]string `yaml:"keys" jsonschema:"omitempty"`
 
+		// TrustedCIDRs and XForwardedForTrustedHops make the server only
+		// honor X-Forwarded-For entries appended by trusted proxies when
+		// deriving the real IP of the client.
+		TrustedCIDRs             []string `yaml:"trustedCIDRs" jsonschema:"omitempty,uniqueItems=true,format=ipcidr-array"`
+		XForwardedForTrustedHops int      `yaml:"xForwardedForTrustedHops" jsonschema:"omitempty,minimum=0"`
+
 		IPFilter *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
 		Rules    []*Rule        `yaml:"rules" jsonschema:"omitempty"`
 
 		GlobalFilter string `yaml:"globalFilter,omitempty" jsonschema:"omitempty"`
+
+		// ReadinessPath returns 200 if the server is running, and 503 if
+		// it is draining.
+		ReadinessPath string `yaml:"readinessPath,omitempty" jsonschema:"omitempty,pattern=^/"`
+
+		// FailedRetryInterval and FailedRetryMaxInterval define the
+		// exponential backoff to restart a failed server.
+		FailedRetryInterval    string `yaml:"failedRetryInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		FailedRetryMaxInterval string `yaml:"failedRetryMaxInterval,omitempty" jsonschema:"omitempty,format=duration"`
+
+		// HealthErrorRateThreshold is the threshold of the error rate of
+		// the last minute, the server is reported as degraded when the
+		// error rate exceeds it. Zero means disabled.
+		HealthErrorRateThreshold float64 `yaml:"healthErrorRateThreshold,omitempty" jsonschema:"omitempty,minimum=0,maximum=1"`
+
+		// MaxRequestsPerConn is the max number of requests served by a
+		// keep-alive connection before it is closed. Zero means no limit.
+		MaxRequestsPerConn uint32 `yaml:"maxRequestsPerConn,omitempty" jsonschema:"omitempty"`
+
+		// TCPKeepAlivePeriod is the TCP keepalive period, TCPReadBufferSize
+		// and TCPWriteBufferSize are the SO_RCVBUF and SO_SNDBUF of the
+		// accepted connections. The system defaults are used if not set.
+		TCPKeepAlivePeriod string `yaml:"tcpKeepAlivePeriod,omitempty" jsonschema:"omitempty,format=duration"`
+		TCPReadBufferSize  int    `yaml:"tcpReadBufferSize,omitempty" jsonschema:"omitempty,minimum=4096,maximum=67108864"`
+		TCPWriteBufferSize int    `yaml:"tcpWriteBufferSize,omitempty" jsonschema:"omitempty,minimum=4096,maximum=67108864"`
+
+		Maintenance *Maintenance `yaml:"maintenance,omitempty" jsonschema:"omitempty"`
+
+		// ConnLimitResponse makes the server respond the connections
+		// beyond MaxConnections instead of waiting.
+		ConnLimitResponse *ConnLimitResponse `yaml:"connLimitResponse,omitempty" jsonschema:"omitempty"`
+
+		// AcceptRate limits the rate of accepting new connections.
+		AcceptRate *AcceptRate `yaml:"acceptRate,omitempty" jsonschema:"omitempty"`
+
+		// SLO enables the error budget burn rates in the status.
+		SLO *SLO `yaml:"slo,omitempty" jsonschema:"omitempty"`
+
+		// H2C makes the plain HTTP server accept HTTP/2 without TLS,
+		// which is required by gRPC clients without TLS.
+		H2C bool `yaml:"h2c,omitempty" jsonschema:"omitempty"`
+	}
+
+	// SLO is the service level objective of the server. A request is bad
+	// if it failed or took longer than LatencyThreshold, and the ratio of
+	// good requests is expected to be at least Availability.
+	SLO struct {
+		Availability     float64 `yaml:"availability" jsonschema:"required"`
+		LatencyThreshold string  `yaml:"latencyThreshold" jsonschema:"omitempty,format=duration"`
+	}
+
+	// AcceptRate limits the rate of accepting connections with a token
+	// bucket, connections beyond the rate wait in the backlog of the
+	// listener.
+	AcceptRate struct {
+		ConnectionsPerSecond uint32 `yaml:"connectionsPerSecond" jsonschema:"required,minimum=1"`
+		// Burst defaults to ConnectionsPerSecond if not set.
+		Burst uint32 `yaml:"burst" jsonschema:"omitempty"`
+	}
+
+	// Maintenance makes the server respond 503 to all requests except
+	// the ones to AllowPaths, the pipelines are not affected. It could
+	// also be enabled by the admin API in a member.
+	Maintenance struct {
+		Enabled    bool     `yaml:"enabled" jsonschema:"omitempty"`
+		Body       string   `yaml:"body" jsonschema:"omitempty"`
+		RetryAfter string   `yaml:"retryAfter" jsonschema:"omitempty,format=duration"`
+		AllowPaths []string `yaml:"allowPaths" jsonschema:"omitempty,uniqueItems=true"`
 	}
 
 	// Rule is first level entry of router.
@@ -74,6 +157,7 @@ type (
 		IPFilter   *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
 		Host       string         `yaml:"host" jsonschema:"omitempty"`
 		HostRegexp string         `yaml:"hostRegexp" jsonschema:"omitempty,format=regexp"`
+		RateLimit  *RateLimit     `yaml:"rateLimit,omitempty" jsonschema:"omitempty"`
 		Paths      []*Path        `yaml:"paths" jsonschema:"omitempty"`
 	}
 
@@ -85,10 +169,38 @@ type (
 		PathRegexp        string         `yaml:"pathRegexp,omitempty" jsonschema:"omitempty,format=regexp"`
 		RewriteTarget     string         `yaml:"rewriteTarget" jsonschema:"omitempty"`
 		Methods           []string       `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
-		Backend           string         `yaml:"backend" jsonschema:"required"`
+		Backend           string         `yaml:"backend" jsonschema:"omitempty"`
+		Backends          []*Backend     `yaml:"backends,omitempty" jsonschema:"omitempty"`
 		Headers           []*Header      `yaml:"headers" jsonschema:"omitempty"`
 		ClientMaxBodySize int64          `yaml:"clientMaxBodySize" jsonschema:"omitempty"`
 		MatchAllHeader    bool           `yaml:"matchAllHeader" jsonschema:"omitempty"`
+		RateLimit         *RateLimit     `yaml:"rateLimit,omitempty" jsonschema:"omitempty"`
+
+		// RequestHeaders and ResponseHeaders adapt the headers of the
+		// request before it is dispatched to the backend, and the headers
+		// of the response returned by the backend.
+		RequestHeaders  *httpheader.AdaptSpec `yaml:"requestHeaders,omitempty" jsonschema:"omitempty"`
+		ResponseHeaders *httpheader.AdaptSpec `yaml:"responseHeaders,omitempty" jsonschema:"omitempty"`
+
+		// ExcludePrefixes excludes the requests whose path has any of the
+		// prefixes even if they match path, pathPrefix or pathRegexp, so
+		// that they can be matched by the following paths.
+		ExcludePrefixes []string `yaml:"excludePrefixes,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Backend is a backend with weight, requests matching a path are split
+	// between its backends by weight.
+	Backend struct {
+		Backend string `yaml:"backend" jsonschema:"required"`
+		Weight  int    `yaml:"weight" jsonschema:"required,minimum=1"`
+	}
+
+	// RateLimit limits the request rate of a rule or a path with a token
+	// bucket, requests exceeding the limit are rejected with 429.
+	RateLimit struct {
+		RequestsPerSecond uint32 `yaml:"requestsPerSecond" jsonschema:"required,minimum=1"`
+		// Burst defaults to RequestsPerSecond if not set.
+		Burst uint32 `yaml:"burst" jsonschema:"omitempty"`
 	}
 
 	// Header is the third level entry of router. A header entry is always under a specific path entry, that is to mean
@@ -105,6 +217,43 @@ type (
 
 // Validate validates HTTPServerSpec.
 func (spec *Spec) Validate() error {
+	if spec.TCPKeepAlivePeriod != "" {
+		d, _ := time.ParseDuration(spec.TCPKeepAlivePeriod)
+		if d < time.Second || d > 2*time.Hour {
+			return fmt.Errorf("tcpKeepAlivePeriod %s is out of range [1s, 2h]", spec.TCPKeepAlivePeriod)
+		}
+	}
+
+	if spec.ConnLimitResponse != nil {
+		if spec.HTTP3 {
+			return fmt.Errorf("connLimitResponse is not supported when http3 enabled")
+		}
+		if err := spec.ConnLimitResponse.Validate(); err != nil {
+			return fmt.Errorf("connLimitResponse: %v", err)
+		}
+	}
+
+	if spec.SLO != nil && (spec.SLO.Availability <= 0 || spec.SLO.Availability >= 1) {
+		return fmt.Errorf("slo: availability must be in (0, 1)")
+	}
+
+	if spec.H2C && spec.HTTPS {
+		return fmt.Errorf("h2c is not supported when https enabled")
+	}
+
+	if spec.AcceptRate != nil && spec.HTTP3 {
+		return fmt.Errorf("acceptRate is not supported when http3 enabled")
+	}
+
+	if spec.ReusePort {
+		if !reuseport.Supported {
+			return fmt.Errorf("reusePort is only supported on Linux")
+		}
+		if spec.HTTP3 {
+			return fmt.Errorf("reusePort is not supported when http3 enabled")
+		}
+	}
+
 	if !spec.HTTPS {
 		if spec.HTTP3 {
 			return fmt.Errorf("https is disabled when http3 enabled")
@@ -119,6 +268,27 @@ func (spec *Spec) Validate() error {
 	return err
 }
 
+// acceptRate returns the arguments of LimitListener.SetAcceptRate, zeros
+// mean no limit.
+func (spec *Spec) acceptRate() (uint32, uint32) {
+	if spec.AcceptRate == nil {
+		return 0, 0
+	}
+	return spec.AcceptRate.ConnectionsPerSecond, spec.AcceptRate.Burst
+}
+
+// slo returns the SLO of the HTTPStat, nil means disabled.
+func (spec *Spec) slo() *httpstat.SLO {
+	if spec.SLO == nil {
+		return nil
+	}
+	d, _ := time.ParseDuration(spec.SLO.LatencyThreshold)
+	return &httpstat.SLO{
+		Availability:     spec.SLO.Availability,
+		LatencyThreshold: d,
+	}
+}
+
 func tryDecodeBase64Pem(pem string) []byte {
 	// The pem could in base64 encoding or plain text. It starts with '-' if it is
 	// in plain text, and '-' is not a valid character in standard base64 encoding.
@@ -209,5 +379,15 @@ func (p *Path) Validate() error {
 		return fmt.Errorf("rewriteTarget is specified but path is empty")
 	}
 
+	if p.Backend == "" && len(p.Backends) == 0 {
+		return fmt.Errorf("both of backend and backends are empty")
+	}
+
+	for _, prefix := range p.ExcludePrefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("exclude prefix %q doesn't start with /", prefix)
+		}
+	}
+
 	return nil
 }
@@ -43,6 +43,7 @@ cacheSize: 200
 rules:
   - paths:
     - pathPrefix: /api
+      backend: api-pipeline
 `
 
 	superSpec, err := supervisor.NewSpec(superSpecYaml)
@@ -67,6 +68,18 @@ rules:
 name: http-server-test
 kind: HTTPServer
 port: 10080
+rules:
+  - paths:
+    - pathPrefix: /api
+`
+	superSpec, err = supervisor.NewSpec(superSpecYaml)
+	assert.True(strings.Contains(err.Error(), "both of backend and backends are empty"))
+	assert.Nil(superSpec)
+
+	superSpecYaml = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
 keepAliveTimeout: not-really-a-duration
 cacheSize: 200
 rules:
@@ -75,6 +88,46 @@ rules:
 	superSpec, err = supervisor.NewSpec(superSpecYaml)
 	assert.True(strings.Contains(err.Error(), "keepAliveTimeout: invalid duration"))
 	assert.Nil(superSpec)
+
+	superSpecYaml = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+tcpKeepAlivePeriod: 100ms
+tcpReadBufferSize: 65536`
+	superSpec, err = supervisor.NewSpec(superSpecYaml)
+	assert.True(strings.Contains(err.Error(), "tcpKeepAlivePeriod 100ms is out of range"))
+	assert.Nil(superSpec)
+
+	superSpecYaml = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+tcpReadBufferSize: 1024`
+	superSpec, err = supervisor.NewSpec(superSpecYaml)
+	assert.Error(err)
+	assert.Nil(superSpec)
+
+	superSpecYaml = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+tcpKeepAlivePeriod: 1m
+tcpReadBufferSize: 65536
+tcpWriteBufferSize: 65536`
+	superSpec, err = supervisor.NewSpec(superSpecYaml)
+	assert.NoError(err)
+	assert.NotNil(superSpec)
+
+	superSpecYaml = `
+name: http-server-test
+kind: HTTPServer
+port: 10080
+slo:
+  availability: 1`
+	superSpec, err = supervisor.NewSpec(superSpecYaml)
+	assert.True(strings.Contains(err.Error(), "slo: availability must be in (0, 1)"))
+	assert.Nil(superSpec)
 }
 
 func TestTlsConfig(t *testing.T) {
@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,6 +33,7 @@ import (
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/go-chi/chi/v5"
 	"github.com/megaease/easegress/pkg/api"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
@@ -39,6 +41,7 @@ import (
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/openzipkin/zipkin-go/model"
 	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -55,6 +58,10 @@ type (
 		pipelines map[PacketType]string
 		muxMapper context.MuxMapper
 
+		// offlineSessions are the non-clean sessions owned by the broker
+		// whose clients are offline, messages to them are queued.
+		offlineSessions map[string]*Session
+
 		sessMgr           *SessionManager
 		topicMgr          *TopicManager
 		connectionLimiter *Limiter
@@ -84,6 +91,18 @@ type (
 		SessionID string `json:"sessionID"`
 		Topic     string `json:"topic"`
 	}
+
+	// HTTPClients is json data used to list clients connected to the broker
+	HTTPClients struct {
+		Clients []*HTTPClient `json:"clients"`
+	}
+
+	// HTTPClient is json data of a client connected to the broker
+	HTTPClient struct {
+		ClientID     string   `json:"clientID"`
+		Topics       []string `json:"topics"`
+		CleanSession bool     `json:"cleanSession"`
+	}
 )
 
 func getPipelineMap(spec *Spec) (map[PacketType]string, error) {
@@ -118,6 +137,8 @@ func newBroker(spec *Spec, store storage, muxMapper context.MuxMapper, memberURL
 		memberURL: memberURL,
 		done:      make(chan struct{}),
 		muxMapper: muxMapper,
+
+		offlineSessions: make(map[string]*Session),
 	}
 	pipelines, err := getPipelineMap(spec)
 	if err != nil {
@@ -137,6 +158,7 @@ func newBroker(spec *Spec, store storage, muxMapper context.MuxMapper, memberURL
 	broker.topicMgr = newTopicManager(spec.TopicCacheSize)
 	broker.sessMgr = newSessionManager(broker, store)
 	broker.connectionLimiter = newLimiter(spec.ConnectionLimit)
+	broker.loadOfflineSessions()
 	go broker.run()
 	ch, closeFunc, err := broker.sessMgr.store.watchDelete(sessionStoreKey(""))
 	if err != nil {
@@ -231,6 +253,8 @@ func (b *Broker) watchDelete(ch <-chan map[string]*string, closeFunc func()) {
 }
 
 func (b *Broker) deleteSession(clientID string) {
+	b.delOfflineSession(clientID)
+
 	b.Lock()
 	defer b.Unlock()
 	if c, ok := b.clients[clientID]; ok {
@@ -367,6 +391,8 @@ func (b *Broker) handleConn(conn net.Conn) {
 	}
 	b.clients[client.info.cid] = client
 	b.setSession(client, connect)
+	offlineSess := b.offlineSessions[cid]
+	delete(b.offlineSessions, cid)
 	b.Unlock()
 
 	err = connack.Write(conn)
@@ -376,6 +402,10 @@ func (b *Broker) handleConn(conn net.Conn) {
 	}
 
 	client.session.updateEGName(b.egName, b.name)
+	if offlineSess != nil {
+		topics, _, _ := offlineSess.allSubscribes()
+		b.topicMgr.unsubscribe(topics, cid)
+	}
 	topics, qoss, _ := client.session.allSubscribes()
 	if len(topics) > 0 {
 		err = b.topicMgr.subscribe(topics, qoss, client.info.cid)
@@ -384,6 +414,7 @@ func (b *Broker) handleConn(conn net.Conn) {
 		}
 	}
 	go client.writeLoop()
+	b.replayOfflineMessages(client)
 	client.readLoop()
 }
 
@@ -437,16 +468,150 @@ func (b *Broker) sendMsgToClient(span *model.SpanContext, topic string, payload
 		return
 	}
 
-	for clientID, subQoS := range subscribers {
-		if subQoS < qos {
-			return
-		}
+	for clientID := range subscribers {
 		client := b.getClient(clientID)
 		if client == nil {
+			if sess := b.getOfflineSession(clientID); sess != nil {
+				b.queueOfflineMessage(span, sess, topic, payload, qos)
+				continue
+			}
 			logger.SpanDebugf(span, "client %v not on broker %v in eg %v", clientID, b.name, b.egName)
-		} else {
-			client.session.publish(span, topic, payload, qos)
+			continue
+		}
+
+		// a client may have several subscriptions matching the topic, the
+		// message is delivered once with the maximum QoS granted by them,
+		// and never with a QoS higher than the one it was published with.
+		subQoS, ok := client.session.matchQoS(topic)
+		if !ok {
+			logger.SpanDebugf(span, "client %v has no subscription matching topic %v", clientID, topic)
+			continue
+		}
+		if subQoS > qos {
+			subQoS = qos
+		}
+		client.session.publish(span, topic, payload, subQoS)
+	}
+}
+
+func (b *Broker) offlineEnabled() bool {
+	return b.spec.MaxOfflineMessages > 0
+}
+
+// loadOfflineSessions loads the offline sessions owned by the broker from
+// the storage, so that messages to them are queued after a restart.
+func (b *Broker) loadOfflineSessions() {
+	if !b.offlineEnabled() {
+		return
+	}
+
+	sessions, err := b.sessMgr.store.getPrefix(sessionStoreKey(""), false)
+	if err != nil {
+		logger.SpanErrorf(nil, "get all sessions failed, %v", err)
+		return
+	}
+	for _, v := range sessions {
+		info := &SessionInfo{}
+		if err := yaml.Unmarshal([]byte(v), info); err != nil {
+			continue
+		}
+		if info.CleanFlag || info.EGName != b.egName || info.Name != b.name {
+			continue
+		}
+
+		sess := &Session{info: info}
+		topics, qoss, _ := sess.allSubscribes()
+		if len(topics) == 0 {
+			continue
+		}
+		b.offlineSessions[info.ClientID] = sess
+		b.topicMgr.subscribe(topics, qoss, info.ClientID)
+	}
+}
+
+// setOfflineSession keeps the subscriptions of the session of a client
+// which went offline, it returns false if the session is not kept.
+func (b *Broker) setOfflineSession(c *Client) bool {
+	if !b.offlineEnabled() || c.session.cleanSession() || b.closed() {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	// the client has been taken over by a new client with the same ID.
+	if cur, ok := b.clients[c.info.cid]; ok && cur != c && !cur.disconnected() {
+		return true
+	}
+	b.offlineSessions[c.info.cid] = c.session
+	return true
+}
+
+func (b *Broker) getOfflineSession(clientID string) *Session {
+	b.RLock()
+	defer b.RUnlock()
+	return b.offlineSessions[clientID]
+}
+
+func (b *Broker) delOfflineSession(clientID string) {
+	b.Lock()
+	sess, ok := b.offlineSessions[clientID]
+	delete(b.offlineSessions, clientID)
+	b.Unlock()
+
+	if ok {
+		topics, _, _ := sess.allSubscribes()
+		b.topicMgr.unsubscribe(topics, clientID)
+	}
+}
+
+func (b *Broker) queueOfflineMessage(span *model.SpanContext, sess *Session, topic string, payload []byte, qos byte) {
+	subQoS, ok := sess.matchQoS(topic)
+	if !ok {
+		return
+	}
+	if subQoS > qos {
+		subQoS = qos
+	}
+	if subQoS == QoS0 {
+		return
+	}
+
+	clientID := sess.info.ClientID
+	owned, err := b.sessMgr.queueOffline(clientID, newMsg(topic, payload, subQoS))
+	if err != nil {
+		logger.SpanErrorf(span, "queue message of topic %v for offline client %v failed: %v", topic, clientID, err)
+		return
+	}
+	if !owned {
+		logger.SpanDebugf(span, "session %v is not owned by broker %v in eg %v any more", clientID, b.name, b.egName)
+		b.delOfflineSession(clientID)
+	}
+}
+
+// replayOfflineMessages sends the messages queued while the client was
+// offline to it in order.
+func (b *Broker) replayOfflineMessages(c *Client) {
+	if !b.offlineEnabled() {
+		return
+	}
+
+	if c.session.cleanSession() {
+		b.sessMgr.delOfflineQueue(c.info.cid)
+		return
+	}
+
+	msgs, err := b.sessMgr.takeOverOffline(c.session)
+	if err != nil {
+		logger.SpanErrorf(nil, "take offline messages of client %v failed: %v", c.info.cid, err)
+		return
+	}
+	for _, m := range msgs {
+		payload, err := base64.StdEncoding.DecodeString(m.B64Payload)
+		if err != nil {
+			logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
+			continue
 		}
+		c.session.publish(nil, m.Topic, payload, byte(m.QoS))
 	}
 }
 
@@ -623,9 +788,91 @@ func (b *Broker) httpDeleteSessionHandler(w http.ResponseWriter, r *http.Request
 		if err != nil {
 			logger.SpanErrorf(span, "delete session %v failed, %v", s, err)
 		}
+		b.sessMgr.delOfflineQueue(s.SessionID)
+	}
+}
+
+func (b *Broker) httpListClientsHandler(w http.ResponseWriter, r *http.Request) {
+	span, _ := b3.ExtractHTTP(r)()
+	logger.SpanDebugf(span, "http endpoint receive request to list clients")
+
+	b.RLock()
+	clients := make([]*Client, 0, len(b.clients))
+	for _, c := range b.clients {
+		clients = append(clients, c)
+	}
+	b.RUnlock()
+
+	res := &HTTPClients{Clients: []*HTTPClient{}}
+	for _, c := range clients {
+		if c.disconnected() {
+			continue
+		}
+		topics, _, _ := c.session.allSubscribes()
+		sort.Strings(topics)
+		res.Clients = append(res.Clients, &HTTPClient{
+			ClientID:     c.info.cid,
+			Topics:       topics,
+			CleanSession: c.session.cleanSession(),
+		})
+	}
+	sort.Slice(res.Clients, func(i, j int) bool {
+		return res.Clients[i].ClientID < res.Clients[j].ClientID
+	})
+
+	jsonData, err := json.Marshal(res)
+	if err != nil {
+		logger.SpanErrorf(span, "clients json marshal failed, %v", err)
+		api.HandleAPIError(w, r, http.StatusInternalServerError, fmt.Errorf("clients json marshal failed, %v", err))
+		return
+	}
+	_, err = w.Write(jsonData)
+	if err != nil {
+		logger.SpanErrorf(span, "write json data to http response writer failed, %v", err)
 	}
 }
 
+func (b *Broker) httpDisconnectClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := chi.URLParam(r, "clientID")
+	purge := false
+	if purgeStr := r.URL.Query().Get("purge"); purgeStr != "" {
+		var err error
+		purge, err = strconv.ParseBool(purgeStr)
+		if err != nil {
+			api.HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("purge in query should be true or false"))
+			return
+		}
+	}
+
+	span, _ := b3.ExtractHTTP(r)()
+	logger.SpanDebugf(span, "http endpoint receive request to disconnect client %v, purge %v", clientID, purge)
+
+	if !b.disconnectClient(clientID, purge) {
+		api.HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("client %s not found", clientID))
+	}
+}
+
+// disconnectClient closes the connection of the client, the session of the
+// client is kept unless it is a clean session or purge is true. It returns
+// false if the client is not connected to the broker.
+func (b *Broker) disconnectClient(clientID string, purge bool) bool {
+	client := b.getClient(clientID)
+	if client == nil || client.disconnected() {
+		return false
+	}
+
+	logger.SpanDebugf(nil, "broker %v disconnect client %v, purge session %v", b.name, clientID, purge)
+	client.closeAndDelSession()
+	if client.conn != nil {
+		client.conn.Close()
+	}
+	if purge {
+		b.sessMgr.delDB(clientID)
+	}
+	b.removeClient(clientID)
+	return true
+}
+
 func (b *Broker) currentClients() map[string]struct{} {
 	ans := make(map[string]struct{})
 	b.Lock()
@@ -636,6 +883,15 @@ func (b *Broker) currentClients() map[string]struct{} {
 	return ans
 }
 
+func (b *Broker) status() *Status {
+	b.Lock()
+	defer b.Unlock()
+	return &Status{
+		Connections:          len(b.clients),
+		MaxAllowedConnection: b.spec.MaxAllowedConnection,
+	}
+}
+
 func (b *Broker) registerAPIs() {
 	group := &api.Group{
 		Group: b.name,
@@ -643,6 +899,8 @@ func (b *Broker) registerAPIs() {
 			{Path: b.mqttAPIPrefix(mqttAPITopicPublishPrefix), Method: http.MethodPost, Handler: b.httpTopicsPublishHandler},
 			{Path: b.mqttAPIPrefix(mqttAPISessionQueryPrefix), Method: http.MethodGet, Handler: b.httpGetAllSessionHandler},
 			{Path: b.mqttAPIPrefix(mqttAPISessionDeletePrefix), Method: http.MethodDelete, Handler: b.httpDeleteSessionHandler},
+			{Path: b.mqttAPIPrefix(mqttAPIClientsPrefix), Method: http.MethodGet, Handler: b.httpListClientsHandler},
+			{Path: b.mqttAPIPrefix(mqttAPIClientPrefix), Method: http.MethodDelete, Handler: b.httpDisconnectClientHandler},
 		},
 	}
 
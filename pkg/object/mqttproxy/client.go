@@ -295,8 +295,11 @@ func (c *Client) closeAndDelSession() {
 		c.broker.sessMgr.delDB(c.info.cid)
 	}
 
-	topics, _, _ := c.session.allSubscribes()
-	c.broker.topicMgr.unsubscribe(topics, c.info.cid)
+	// subscriptions of offline sessions are kept to queue messages.
+	if !c.broker.setOfflineSession(c) {
+		topics, _, _ := c.session.allSubscribes()
+		c.broker.topicMgr.unsubscribe(topics, c.info.cid)
+	}
 
 	c.close()
 }
@@ -18,6 +18,7 @@
 package mqttproxy
 
 import (
+	stdcontext "context"
 	"fmt"
 	"reflect"
 	"strings"
@@ -60,6 +61,9 @@ func (m *mockCluster) CloseServer(wg *sync.WaitGroup)
 func (m *mockCluster) StartServer() (chan struct{}, chan struct{}, error)        { return nil, nil, nil }
 func (m *mockCluster) Close(wg *sync.WaitGroup)                                  {}
 func (m *mockCluster) PurgeMember(member string) error                           { return nil }
+func (m *mockCluster) STMWithContext(ctx stdcontext.Context, apply func(concurrency.STM) error) error {
+	return nil
+}
 
 func (m *mockCluster) Watcher() (cluster.Watcher, error) {
 	m.Lock()
@@ -148,6 +152,28 @@ func (m *mockCluster) Put(key, value string) error {
 	return nil
 }
 
+func (m *mockCluster) GetInt(key string) (int, bool, error) {
+	return cluster.GetInt(m.Get, key)
+}
+
+func (m *mockCluster) GetJSON(key string, out interface{}) (bool, error) {
+	return cluster.GetJSON(m.Get, key, out)
+}
+
+func (m *mockCluster) GetYAML(key string, out interface{}) (bool, error) {
+	return cluster.GetYAML(m.Get, key, out)
+}
+
+func (m *mockCluster) PutIfAbsent(key, value string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.kv[key]; ok {
+		return false, nil
+	}
+	m.kv[key] = value
+	return true, nil
+}
+
 func newMockCluster() cluster.Cluster {
 	return &mockCluster{kv: make(map[string]string)}
 }
@@ -27,6 +27,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
 	"sync"
@@ -35,6 +36,7 @@ import (
 
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/go-chi/chi/v5"
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/filters"
 	_ "github.com/megaease/easegress/pkg/filters/mqttclientauth"
@@ -1140,6 +1142,104 @@ func TestWildCard(t *testing.T) {
 
 // this certPem and keyPem come from golang crypto/tls/testdata
 // with original name: example-key.pem and example-key.pem
+func TestTopicMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"sensors/temp", "sensors/temp", true},
+		{"sensors/temp", "sensors/humidity", false},
+
+		// single-level wildcard
+		{"sensors/+/temp", "sensors/room1/temp", true},
+		{"sensors/+/temp", "sensors/room1/humidity", false},
+		{"sensors/+/temp", "sensors/room1/a/temp", false},
+		{"sensors/+", "sensors/room1", true},
+		{"sensors/+", "sensors", false},
+		{"+/+", "/finance", true},
+		{"+", "/finance", false},
+
+		// multi-level wildcard
+		{"sensors/#", "sensors", true},
+		{"sensors/#", "sensors/room1", true},
+		{"sensors/#", "sensors/room1/temp", true},
+		{"sensors/#", "devices/room1", false},
+		{"sensors/+/#", "sensors/room1/temp/c", true},
+		{"#", "a/b/c", true},
+
+		// invalid filters never match
+		{"sensors/#/temp", "sensors/a/temp", false},
+		{"sensors/a+", "sensors/a+", false},
+	}
+
+	for _, tt := range tests {
+		if got := topicMatch(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("topicMatch(%q, %q) got %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestSessionMatchQoS(t *testing.T) {
+	s := &Session{info: &SessionInfo{Topics: map[string]int{}}}
+	s.info.Topics["sensors/#"] = 0
+	s.info.Topics["sensors/+/temp"] = 1
+
+	qos, ok := s.matchQoS("sensors/room1/temp")
+	if !ok || qos != QoS1 {
+		t.Errorf("want matched with qos 1, got %v %v", ok, qos)
+	}
+	qos, ok = s.matchQoS("sensors/room1/humidity")
+	if !ok || qos != QoS0 {
+		t.Errorf("want matched with qos 0, got %v %v", ok, qos)
+	}
+	if _, ok = s.matchQoS("devices/room1/temp"); ok {
+		t.Errorf("want not matched")
+	}
+}
+
+func TestSendMsgToWildcardSubscriber(t *testing.T) {
+	broker := getDefaultBroker(nil)
+	defer broker.close()
+
+	subscribeCh := make(chan CheckMsg, 100)
+	handler := getMQTTSubscribeHandler(subscribeCh)
+
+	c := getDefaultMQTTClient(t, "wildcardClient", true)
+	defer c.Disconnect(200)
+	if token := c.Subscribe("sensors/+/temp", 0, handler); token.Wait() && token.Error() != nil {
+		t.Fatalf("subscribe error %s", token.Error())
+	}
+	if token := c.Subscribe("devices/#", 1, handler); token.Wait() && token.Error() != nil {
+		t.Fatalf("subscribe error %s", token.Error())
+	}
+
+	broker.sendMsgToClient(nil, "sensors/room1/humidity", []byte("ignored"), QoS1)
+	// published with qos 1, delivered with the granted qos 0
+	broker.sendMsgToClient(nil, "sensors/room1/temp", []byte("temp"), QoS1)
+	broker.sendMsgToClient(nil, "devices/a/b", []byte("device"), QoS1)
+
+	want := []CheckMsg{
+		{topic: "sensors/room1/temp", payload: "temp", qos: 0},
+		{topic: "devices/a/b", payload: "device", qos: 1},
+	}
+	for _, w := range want {
+		select {
+		case msg := <-subscribeCh:
+			if msg != w {
+				t.Errorf("want %v, got %v", w, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", w)
+		}
+	}
+	select {
+	case msg := <-subscribeCh:
+		t.Errorf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 const certPem = `
 -----BEGIN CERTIFICATE-----
 MIIBhTCCASugAwIBAgIQIRi6zePL6mKjOipn+dNuaTAKBggqhkjOPQQDAjASMRAw
@@ -1364,11 +1464,11 @@ func TestBrokerHandleConn(t *testing.T) {
 func TestMQTTProxy(t *testing.T) {
 	assert := assert.New(t)
 	mp := MQTTProxy{}
-	mp.Status()
 
 	broker := getDefaultBroker(nil)
 
 	mp.broker = broker
+	assert.Equal(&Status{}, mp.Status().ObjectStatus)
 	broker.reconnectWatcher()
 	mp.Close()
 
@@ -1585,10 +1685,37 @@ func TestMaxAllowedConnection(t *testing.T) {
 	}
 	for i := clientNum; i < 2*clientNum; i++ {
 		client := getUnConnectClient(strconv.Itoa(i), "test", "test", true)
-		if token := client.Connect(); token.Wait() && token.Error() == nil {
+		token := client.Connect()
+		if token.Wait() && token.Error() == nil {
 			t.Errorf("client %v connect should fail but got nil error, %v", i, token.Error())
 		}
+		if code := token.(*paho.ConnectToken).ReturnCode(); code != packets.ErrRefusedServerUnavailable {
+			t.Errorf("client %v got connack return code %v, expected %v", i, code, packets.ErrRefusedServerUnavailable)
+		}
+	}
+
+	status := broker.status()
+	if status.Connections != clientNum || status.MaxAllowedConnection != clientNum {
+		t.Errorf("wrong status %+v", status)
 	}
+
+	// new clients are accepted again after others disconnect.
+	clients[0].Disconnect(200)
+	for i := 0; i < 10; i++ {
+		num = broker.status().Connections
+		if num == clientNum-1 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if num != clientNum-1 {
+		t.Fatalf("wrong client connection number, got %v, expected %v", num, clientNum-1)
+	}
+	clients[0] = getMQTTClient(t, strconv.Itoa(2*clientNum), "test", "test", true)
+	if !clients[0].IsConnected() {
+		t.Errorf("client %v should connect after others disconnect", 2*clientNum)
+	}
+
 	for _, c := range clients {
 		c.Disconnect(200)
 	}
@@ -1637,6 +1764,188 @@ func TestClientPublishLimit(t *testing.T) {
 	}
 }
 
+func TestClientPublishLimitFlood(t *testing.T) {
+	pipe, backend := getPublishPipeline(t)
+	defer pipe.Close()
+
+	mapper := &mockMuxMapper{
+		MockFunc: func(name string) (context.Handler, bool) {
+			return pipe, true
+		},
+	}
+	spec := getDefaultSpec()
+	spec.ClientPublishLimit = &RateLimit{
+		RequestRate: 5,
+		TimePeriod:  1000,
+	}
+	broker := getBrokerFromSpec(spec, mapper)
+	defer broker.close()
+
+	client := getMQTTClient(t, "test", "test", "test", true)
+	defer client.Disconnect(200)
+
+	for i := 0; i < 20; i++ {
+		token := client.Publish("flood", 0, false, fmt.Sprintf("%d", i))
+		token.Wait()
+	}
+
+	received := 0
+	timeout := time.After(500 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case <-backend.ch:
+			received++
+		case <-timeout:
+			done = true
+		}
+	}
+	if received != 5 {
+		t.Errorf("flooding client should be limited to 5 messages, got %d", received)
+	}
+}
+
+func TestSessionInflightWindow(t *testing.T) {
+	newSession := func(policy string) (*Session, *Client) {
+		spec := getDefaultSpec()
+		spec.MaxInflight = 3
+		spec.InflightPolicy = policy
+		broker := &Broker{spec: spec, clients: map[string]*Client{}}
+		client := &Client{
+			info:    ClientInfo{cid: "inflight"},
+			writeCh: make(chan packets.ControlPacket, 100),
+		}
+		broker.clients["inflight"] = client
+		s := &Session{
+			broker:       broker,
+			info:         &SessionInfo{ClientID: "inflight"},
+			pending:      map[uint16]*Message{},
+			pendingQueue: []uint16{},
+		}
+		return s, client
+	}
+	pendingTopics := func(s *Session) []string {
+		topics := []string{}
+		for _, id := range s.pendingQueue {
+			if msg, ok := s.pending[id]; ok {
+				topics = append(topics, msg.Topic)
+			}
+		}
+		return topics
+	}
+
+	s, client := newSession(InflightPolicyDropOldest)
+	for i := 0; i < 5; i++ {
+		s.publish(nil, fmt.Sprintf("t%d", i), []byte("data"), QoS1)
+	}
+	if len(s.pending) != 3 {
+		t.Errorf("inflight window should be 3, got %d", len(s.pending))
+	}
+	if got := pendingTopics(s); !reflect.DeepEqual(got, []string{"t2", "t3", "t4"}) {
+		t.Errorf("oldest messages should be dropped, got %v", got)
+	}
+	if len(client.writeCh) != 5 {
+		t.Errorf("all messages should be sent, got %d", len(client.writeCh))
+	}
+
+	// acknowledged messages free the window
+	s.puback(&packets.PubackPacket{MessageID: s.pendingQueue[0]})
+	s.publish(nil, "t5", []byte("data"), QoS1)
+	if got := pendingTopics(s); !reflect.DeepEqual(got, []string{"t3", "t4", "t5"}) {
+		t.Errorf("want pending t3, t4, t5, got %v", got)
+	}
+
+	s, client = newSession(InflightPolicyPause)
+	for i := 0; i < 5; i++ {
+		s.publish(nil, fmt.Sprintf("t%d", i), []byte("data"), QoS1)
+	}
+	if got := pendingTopics(s); !reflect.DeepEqual(got, []string{"t0", "t1", "t2"}) {
+		t.Errorf("new messages should be dropped when paused, got %v", got)
+	}
+	if len(client.writeCh) != 3 {
+		t.Errorf("delivery should be paused, got %d messages sent", len(client.writeCh))
+	}
+	s.puback(&packets.PubackPacket{MessageID: s.pendingQueue[0]})
+	s.publish(nil, "t5", []byte("data"), QoS1)
+	if got := pendingTopics(s); !reflect.DeepEqual(got, []string{"t1", "t2", "t5"}) {
+		t.Errorf("delivery should resume after ack, got %v", got)
+	}
+
+	// qos0 messages are not limited by the inflight window
+	s.publish(nil, "qos0", []byte("data"), QoS0)
+	if len(client.writeCh) != 5 {
+		t.Errorf("qos0 message should be sent, got %d messages sent", len(client.writeCh))
+	}
+}
+
+func TestSessionResend(t *testing.T) {
+	spec := getDefaultSpec()
+	spec.ResendInterval = "50ms"
+	spec.MaxResend = 3
+	broker := &Broker{spec: spec, clients: map[string]*Client{}}
+	client := &Client{
+		info:    ClientInfo{cid: "resend"},
+		writeCh: make(chan packets.ControlPacket, 100),
+	}
+	broker.clients["resend"] = client
+	s := &Session{
+		broker:       broker,
+		info:         &SessionInfo{ClientID: "resend"},
+		done:         make(chan struct{}),
+		pending:      map[uint16]*Message{},
+		pendingQueue: []uint16{},
+	}
+
+	s.publish(nil, "t0", []byte("data"), QoS1)
+	s.publish(nil, "t1", []byte("data"), QoS1)
+	for i := 0; i < 2; i++ {
+		<-client.writeCh
+	}
+
+	start := time.Now()
+	go s.backgroundResendPending()
+	defer s.close()
+
+	// the oldest message is resent until max resend, then the next one
+	wantTopics := []string{"t0", "t0", "t0", "t1", "t1", "t1"}
+	for i, topic := range wantTopics {
+		select {
+		case p := <-client.writeCh:
+			publish := p.(*packets.PublishPacket)
+			if publish.TopicName != topic {
+				t.Errorf("resend %d want topic %v, got %v", i, topic, publish.TopicName)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("resend %d timeout", i)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("resend interval is not respected, 6 resends in %v", elapsed)
+	}
+
+	// all messages are dropped after max resend
+	for i := 0; i < 20; i++ {
+		s.Lock()
+		n := len(s.pending)
+		s.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.Lock()
+	assert.Empty(t, s.pending)
+	assert.Empty(t, s.pendingQueue)
+	s.Unlock()
+
+	select {
+	case p := <-client.writeCh:
+		t.Errorf("dropped message should not be resent, got %v", p)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	assert.Equal(t, defaultResendInterval, (&Spec{}).resendInterval())
+}
+
 func TestHTTPGetAllSession(t *testing.T) {
 	broker := getDefaultBroker(nil)
 	defer broker.close()
@@ -1778,6 +2087,85 @@ func TestHTTPDeleteSession(t *testing.T) {
 	}
 }
 
+func TestHTTPClients(t *testing.T) {
+	broker := getDefaultBroker(nil)
+	defer broker.close()
+
+	connect := func(clientID string, cleanSession bool) paho.Client {
+		opts := paho.NewClientOptions().AddBroker("tcp://0.0.0.0:1883").SetClientID(clientID).
+			SetUsername("test").SetPassword("test").SetCleanSession(cleanSession).SetAutoReconnect(false)
+		c := paho.NewClient(opts)
+		if token := c.Connect(); token.Wait() && token.Error() != nil {
+			t.Fatalf("connect client %s failed: %v", clientID, token.Error())
+		}
+		return c
+	}
+	c1 := connect("client1", true)
+	defer c1.Disconnect(200)
+	c2 := connect("client2", false)
+	defer c2.Disconnect(200)
+	if token := c2.Subscribe("b/#", 1, nil); token.Wait() && token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+	if token := c2.Subscribe("a/+", 0, nil); token.Wait() && token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+	if err := checkSessionStore(broker, "client2", "a/+"); err != nil {
+		t.Fatal(err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/clients", broker.httpListClientsHandler)
+	router.Delete("/clients/{clientID}", broker.httpDisconnectClientHandler)
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+
+	listClients := func() *HTTPClients {
+		resp, err := http.Get(srv.URL + "/clients")
+		require.Nil(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		res := &HTTPClients{}
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(res))
+		return res
+	}
+	disconnect := func(url string) int {
+		req, _ := http.NewRequest(http.MethodDelete, srv.URL+url, nil)
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	want := &HTTPClients{Clients: []*HTTPClient{
+		{ClientID: "client1", Topics: nil, CleanSession: true},
+		{ClientID: "client2", Topics: []string{"a/+", "b/#"}, CleanSession: false},
+	}}
+	assert.Equal(t, want, listClients())
+
+	assert.Equal(t, http.StatusNotFound, disconnect("/clients/client3"))
+	assert.Equal(t, http.StatusBadRequest, disconnect("/clients/client1?purge=abc"))
+
+	// the session of client2 is kept without purge
+	assert.Equal(t, http.StatusOK, disconnect("/clients/client2"))
+	assert.Nil(t, broker.getClient("client2"))
+	assert.Nil(t, checkSessionStore(broker, "client2", "a/+"))
+	want.Clients = want.Clients[:1]
+	assert.Equal(t, want, listClients())
+
+	// the session of client1 is purged
+	assert.Equal(t, http.StatusOK, disconnect("/clients/client1?purge=true"))
+	assert.Nil(t, broker.getClient("client1"))
+	assert.Nil(t, broker.sessMgr.get("client1"))
+	assert.Empty(t, listClients().Clients)
+
+	// the TCP connection is closed
+	for i := 0; i < 20 && c1.IsConnectionOpen(); i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.False(t, c1.IsConnectionOpen())
+}
+
 func TestHTTPTransferHeaderCopy(t *testing.T) {
 	done := make(chan bool, 2)
 
@@ -1827,3 +2215,158 @@ func TestHTTPTransferHeaderCopy(t *testing.T) {
 	srv0.shutdown()
 	srv1.shutdown()
 }
+
+func newOfflineTestBroker(spec *Spec, store storage) *Broker {
+	return newBroker(spec, store, nil, func(s, ss string) ([]string, error) {
+		return nil, nil
+	})
+}
+
+// connectOfflineTestClient connects a non-clean session client which sends
+// all received messages to ch.
+func connectOfflineTestClient(t *testing.T, clientID string, ch chan CheckMsg) paho.Client {
+	opts := paho.NewClientOptions().AddBroker("tcp://0.0.0.0:1883").SetClientID(clientID).
+		SetUsername("test").SetPassword("test").SetCleanSession(false).
+		SetDefaultPublishHandler(getMQTTSubscribeHandler(ch))
+	c := paho.NewClient(opts)
+	if token := c.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("connect client %s failed: %v", clientID, token.Error())
+	}
+	return c
+}
+
+func waitOffline(t *testing.T, broker *Broker, clientID string) {
+	for i := 0; i < 20; i++ {
+		if broker.getOfflineSession(clientID) != nil && broker.getClient(clientID) == nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("client %s is not offline", clientID)
+}
+
+func TestOfflineMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := getDefaultSpec()
+	spec.MaxOfflineMessages = 2
+	store := newStorage(nil)
+	broker := newOfflineTestBroker(spec, store)
+	defer broker.close()
+
+	ch := make(chan CheckMsg, 10)
+	client := connectOfflineTestClient(t, "offline", ch)
+	token := client.Subscribe("a/+", 1, nil)
+	assert.True(token.WaitTimeout(time.Second))
+	assert.NoError(token.Error())
+	client.Disconnect(200)
+	waitOffline(t, broker, "offline")
+
+	// QoS 0 messages are not queued, and the oldest message is dropped
+	// when the queue is full.
+	broker.sendMsgToClient(nil, "a/0", []byte("qos0"), QoS0)
+	for i := 1; i <= 3; i++ {
+		broker.sendMsgToClient(nil, "a/"+strconv.Itoa(i), []byte(strconv.Itoa(i)), QoS1)
+	}
+	msgs := decodeOfflineQueue(func() *string { s, _ := store.get(offlineQueueKey("offline")); return s }())
+	assert.Len(msgs, 2)
+
+	// queued messages are replayed in order after reconnect.
+	client = connectOfflineTestClient(t, "offline", ch)
+	defer client.Disconnect(200)
+	for _, want := range []string{"2", "3"} {
+		select {
+		case msg := <-ch:
+			assert.Equal(CheckMsg{topic: "a/" + want, payload: want, qos: 1}, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %s not received", want)
+		}
+	}
+	_, err := store.get(offlineQueueKey("offline"))
+	assert.Error(err)
+	assert.Nil(broker.getOfflineSession("offline"))
+
+	// messages are delivered directly while online.
+	broker.sendMsgToClient(nil, "a/4", []byte("4"), QoS1)
+	select {
+	case msg := <-ch:
+		assert.Equal(CheckMsg{topic: "a/4", payload: "4", qos: 1}, msg)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("message 4 not received")
+	}
+}
+
+func TestOfflineMessagesDropNewest(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := getDefaultSpec()
+	spec.MaxOfflineMessages = 2
+	spec.OfflineQueuePolicy = OfflineQueuePolicyDropNewest
+	store := newStorage(nil)
+	broker := newOfflineTestBroker(spec, store)
+	defer broker.close()
+
+	ch := make(chan CheckMsg, 10)
+	client := connectOfflineTestClient(t, "offline", ch)
+	token := client.Subscribe("a", 1, nil)
+	assert.True(token.WaitTimeout(time.Second))
+	client.Disconnect(200)
+	waitOffline(t, broker, "offline")
+
+	for i := 1; i <= 3; i++ {
+		broker.sendMsgToClient(nil, "a", []byte(strconv.Itoa(i)), QoS1)
+	}
+	s, _ := store.get(offlineQueueKey("offline"))
+	msgs := decodeOfflineQueue(s)
+	assert.Len(msgs, 2)
+	assert.Equal(base64.StdEncoding.EncodeToString([]byte("2")), msgs[1].B64Payload)
+
+	// the session is taken over by another broker.
+	sessStr, _ := store.get(sessionStoreKey("offline"))
+	info := &SessionInfo{}
+	assert.NoError(yaml.Unmarshal([]byte(*sessStr), info))
+	info.EGName = "other"
+	b, _ := yaml.Marshal(info)
+	store.put(sessionStoreKey("offline"), string(b))
+
+	broker.sendMsgToClient(nil, "a", []byte("4"), QoS1)
+	assert.Nil(broker.getOfflineSession("offline"))
+	subscribers, _ := broker.topicMgr.findSubscribers("a")
+	assert.NotContains(subscribers, "offline")
+}
+
+func TestOfflineMessagesBrokerRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := getDefaultSpec()
+	spec.MaxOfflineMessages = 10
+	store := newStorage(nil)
+	broker := newOfflineTestBroker(spec, store)
+
+	ch := make(chan CheckMsg, 10)
+	client := connectOfflineTestClient(t, "offline", ch)
+	token := client.Subscribe("a", 1, nil)
+	assert.True(token.WaitTimeout(time.Second))
+	client.Disconnect(200)
+	waitOffline(t, broker, "offline")
+
+	broker.sendMsgToClient(nil, "a", []byte("before restart"), QoS1)
+	broker.close()
+
+	// the new broker queues messages to the offline session as well.
+	broker = newOfflineTestBroker(spec, store)
+	defer broker.close()
+	assert.NotNil(broker.getOfflineSession("offline"))
+	broker.sendMsgToClient(nil, "a", []byte("after restart"), QoS1)
+
+	client = connectOfflineTestClient(t, "offline", ch)
+	defer client.Disconnect(200)
+	for _, want := range []string{"before restart", "after restart"} {
+		select {
+		case msg := <-ch:
+			assert.Equal(CheckMsg{topic: "a", payload: want, qos: 1}, msg)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("message %s not received", want)
+		}
+	}
+}
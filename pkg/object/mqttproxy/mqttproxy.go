@@ -50,6 +50,15 @@ type (
 		spec      *Spec
 		broker    *Broker
 	}
+
+	// Status is the status of MQTTProxy.
+	Status struct {
+		// Connections is the number of connected clients.
+		Connections int `yaml:"connections"`
+		// MaxAllowedConnection is the max number of connected clients,
+		// 0 means unlimited.
+		MaxAllowedConnection int `yaml:"maxAllowedConnection"`
+	}
 )
 
 // Category returns the category of MQTTProxy.
@@ -69,7 +78,9 @@ func (mp *MQTTProxy) DefaultSpec() interface{} {
 
 // Status returns the Status of MQTTProxy.
 func (mp *MQTTProxy) Status() *supervisor.Status {
-	return &supervisor.Status{}
+	return &supervisor.Status{
+		ObjectStatus: mp.broker.status(),
+	}
 }
 
 func updatePort(urlStr string, hostWithPort string) (string, error) {
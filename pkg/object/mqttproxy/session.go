@@ -58,6 +58,9 @@ type (
 		Topic      string `yaml:"topic"`
 		B64Payload string `yaml:"b64Payload"`
 		QoS        int    `yaml:"qos"`
+
+		// resend is the number of times the message has been resent
+		resend int
 	}
 )
 
@@ -157,6 +160,26 @@ func (s *Session) allSubscribes() ([]string, []byte, error) {
 	return sub, qos, nil
 }
 
+// matchQoS returns the maximum QoS granted by the subscriptions of the
+// session whose topic filters match topic, and whether any of them match.
+func (s *Session) matchQoS(topic string) (byte, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	matched := false
+	var qos byte
+	for filter, q := range s.info.Topics {
+		if !topicMatch(filter, topic) {
+			continue
+		}
+		if !matched || byte(q) > qos {
+			qos = byte(q)
+		}
+		matched = true
+	}
+	return qos, matched
+}
+
 func (s *Session) getPacketFromMsg(topic string, payload []byte, qos byte) *packets.PublishPacket {
 	p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
 	p.Qos = qos
@@ -187,6 +210,10 @@ func (s *Session) publish(span *model.SpanContext, topic string, payload []byte,
 		default:
 		}
 	} else if qos == QoS1 {
+		if !s.reserveInflight() {
+			logger.SpanDebugf(span, "session %v inflight window is full, drop message of topic %v", s.info.ClientID, topic)
+			return
+		}
 		msg := newMsg(topic, payload, qos)
 		s.pending[p.MessageID] = msg
 		s.pendingQueue = append(s.pendingQueue, p.MessageID)
@@ -196,6 +223,28 @@ func (s *Session) publish(span *model.SpanContext, topic string, payload []byte,
 	}
 }
 
+// reserveInflight makes sure there is room in the inflight window for a
+// new QoS 1 message, it returns false if the message should be dropped.
+// The caller must hold the lock of the session.
+func (s *Session) reserveInflight() bool {
+	spec := s.broker.spec
+	if spec.MaxInflight <= 0 || len(s.pending) < spec.MaxInflight {
+		return true
+	}
+	if spec.InflightPolicy == InflightPolicyPause {
+		return false
+	}
+
+	// drop the oldest messages, acknowledged messages may still be in
+	// pendingQueue, so skip them.
+	for len(s.pending) >= spec.MaxInflight && len(s.pendingQueue) > 0 {
+		id := s.pendingQueue[0]
+		s.pendingQueue = s.pendingQueue[1:]
+		delete(s.pending, id)
+	}
+	return true
+}
+
 func (s *Session) puback(p *packets.PubackPacket) {
 	s.Lock()
 	delete(s.pending, p.MessageID)
@@ -219,33 +268,45 @@ func (s *Session) doResend() {
 		s.pendingQueue = []uint16{}
 		return
 	}
+	maxResend := s.broker.spec.MaxResend
 	for i, idx := range s.pendingQueue {
-		if val, ok := s.pending[idx]; ok {
-			// find first msg need to resend
-			s.pendingQueue = s.pendingQueue[i:]
-			p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
-			p.Qos = byte(val.QoS)
-			p.TopicName = val.Topic
-			payload, err := base64.StdEncoding.DecodeString(val.B64Payload)
-			if err != nil {
-				logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
-				return
-			}
-			p.Payload = payload
-			p.MessageID = idx
-			if client != nil {
-				client.writePacket(p)
-			} else {
-				logger.SpanDebugf(nil, "session %v do resend but client is nil", s.info.ClientID)
-			}
+		val, ok := s.pending[idx]
+		if !ok {
+			continue
+		}
+		if maxResend > 0 && val.resend >= maxResend {
+			logger.SpanErrorf(nil, "session %v drop message %v of topic %v after %v resends", s.info.ClientID, idx, val.Topic, val.resend)
+			delete(s.pending, idx)
+			continue
+		}
+
+		// find first msg need to resend
+		s.pendingQueue = s.pendingQueue[i:]
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.Qos = byte(val.QoS)
+		p.TopicName = val.Topic
+		payload, err := base64.StdEncoding.DecodeString(val.B64Payload)
+		if err != nil {
+			logger.SpanErrorf(nil, "base64 decode error for Message B64Payload %s", err)
 			return
 		}
+		p.Payload = payload
+		p.MessageID = idx
+		if client != nil {
+			client.writePacket(p)
+			val.resend++
+		} else {
+			logger.SpanDebugf(nil, "session %v do resend but client is nil", s.info.ClientID)
+		}
+		return
 	}
+	// all pending messages are dropped
+	s.pendingQueue = []uint16{}
 }
 
 func (s *Session) backgroundResendPending() {
 	debugLogTime := time.Now().Add(time.Minute)
-	ticker := time.NewTicker(200 * time.Millisecond)
+	ticker := time.NewTicker(s.broker.spec.resendInterval())
 	defer ticker.Stop()
 
 	for {
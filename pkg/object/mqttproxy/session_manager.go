@@ -22,6 +22,7 @@ import (
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/megaease/easegress/pkg/logger"
+	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -125,4 +126,92 @@ func (sm *SessionManager) delDB(clientID string) {
 	if err != nil {
 		logger.SpanErrorf(nil, "delete session %v failed, %v", err)
 	}
+	sm.delOfflineQueue(clientID)
+}
+
+func (sm *SessionManager) delOfflineQueue(clientID string) {
+	err := sm.store.delete(offlineQueueKey(clientID))
+	if err != nil {
+		logger.SpanErrorf(nil, "delete offline queue of session %v failed, %v", clientID, err)
+	}
+}
+
+func decodeOfflineQueue(str *string) []*Message {
+	if str == nil {
+		return nil
+	}
+	var msgs []*Message
+	if err := yaml.Unmarshal([]byte(*str), &msgs); err != nil {
+		logger.SpanErrorf(nil, "decode offline queue failed, %v", err)
+		return nil
+	}
+	return msgs
+}
+
+// queueOffline appends msg to the offline queue of the session, if the
+// session is still owned by the broker, that is, the client has not
+// connected to another broker since it went offline. It returns whether
+// the session is owned by the broker.
+func (sm *SessionManager) queueOffline(clientID string, msg *Message) (bool, error) {
+	spec := sm.broker.spec
+	sessKey, queueKey := sessionStoreKey(clientID), offlineQueueKey(clientID)
+
+	owned := false
+	err := sm.store.update([]string{sessKey, queueKey}, func(values []*string) map[string]*string {
+		owned = false
+		if values[0] == nil {
+			return nil
+		}
+		info := &SessionInfo{}
+		if err := yaml.Unmarshal([]byte(*values[0]), info); err != nil {
+			return nil
+		}
+		if info.EGName != sm.broker.egName || info.Name != sm.broker.name {
+			return nil
+		}
+		owned = true
+
+		msgs := decodeOfflineQueue(values[1])
+		if len(msgs) >= spec.MaxOfflineMessages {
+			if spec.OfflineQueuePolicy == OfflineQueuePolicyDropNewest {
+				logger.SpanDebugf(nil, "offline queue of session %v is full, drop message of topic %v", clientID, msg.Topic)
+				return nil
+			}
+			msgs = msgs[len(msgs)-spec.MaxOfflineMessages+1:]
+		}
+		msgs = append(msgs, msg)
+
+		b, err := yaml.Marshal(msgs)
+		if err != nil {
+			return nil
+		}
+		str := string(b)
+		return map[string]*string{queueKey: &str}
+	})
+	return owned, err
+}
+
+// takeOverOffline stores the session, which makes the broker the owner
+// of it, and takes the messages queued while it was offline atomically.
+func (sm *SessionManager) takeOverOffline(sess *Session) ([]*Message, error) {
+	sess.Lock()
+	clientID := sess.info.ClientID
+	str, err := sess.encode()
+	sess.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*Message
+	err = sm.store.update([]string{offlineQueueKey(clientID)}, func(values []*string) map[string]*string {
+		msgs = decodeOfflineQueue(values[0])
+		return map[string]*string{
+			sessionStoreKey(clientID): &str,
+			offlineQueueKey(clientID): nil,
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
 }
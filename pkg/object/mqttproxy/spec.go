@@ -20,14 +20,44 @@ package mqttproxy
 import (
 	"crypto/tls"
 	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
 )
 
 const (
 	sessionPrefix              = "/mqtt/sessionMgr/clientID/%s"
+	offlineQueuePrefix         = "/mqtt/offlineQueue/clientID/%s"
 	topicPrefix                = "/mqtt/topicMgr/topic/%s"
 	mqttAPITopicPublishPrefix  = "/mqttproxy/%s/topics/publish"
 	mqttAPISessionQueryPrefix  = "/mqttproxy/%s/session/query"
 	mqttAPISessionDeletePrefix = "/mqttproxy/%s/sessions"
+	mqttAPIClientsPrefix       = "/mqttproxy/%s/clients"
+	mqttAPIClientPrefix        = "/mqttproxy/%s/clients/{clientID}"
+
+	defaultResendInterval = 200 * time.Millisecond
+)
+
+const (
+	// InflightPolicyDropOldest drops the oldest unacknowledged QoS 1
+	// message of a session to make room for a new one when the inflight
+	// window is full.
+	InflightPolicyDropOldest = "dropOldest"
+
+	// InflightPolicyPause pauses the delivery of QoS 1 messages to a
+	// session while its inflight window is full, messages published in
+	// the meantime are dropped.
+	InflightPolicyPause = "pause"
+)
+
+const (
+	// OfflineQueuePolicyDropOldest drops the oldest queued message of an
+	// offline session to make room for a new one when the queue is full.
+	OfflineQueuePolicyDropOldest = "dropOldest"
+
+	// OfflineQueuePolicyDropNewest drops new messages to an offline
+	// session while its queue is full.
+	OfflineQueuePolicyDropNewest = "dropNewest"
 )
 
 // PacketType is mqtt packet type
@@ -70,6 +100,12 @@ type (
 		MaxAllowedConnection int           `yaml:"maxAllowedConnection" jsonschema:"omitempty"`
 		ConnectionLimit      *RateLimit    `yaml:"connectionLimit" jsonschema:"omitempty"`
 		ClientPublishLimit   *RateLimit    `yaml:"clientPublishLimit" jsonschema:"omitempty"`
+		MaxInflight          int           `yaml:"maxInflight" jsonschema:"omitempty"`
+		InflightPolicy       string        `yaml:"inflightPolicy,omitempty" jsonschema:"omitempty,enum=dropOldest,enum=pause"`
+		ResendInterval       string        `yaml:"resendInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		MaxResend            int           `yaml:"maxResend" jsonschema:"omitempty"`
+		MaxOfflineMessages   int           `yaml:"maxOfflineMessages" jsonschema:"omitempty"`
+		OfflineQueuePolicy   string        `yaml:"offlineQueuePolicy,omitempty" jsonschema:"omitempty,enum=dropOldest,enum=dropNewest"`
 		Rules                []*Rule       `yaml:"rules" jsonschema:"omitempty"`
 	}
 
@@ -119,6 +155,22 @@ func (spec *Spec) tlsConfig() (*tls.Config, error) {
 	return &tls.Config{Certificates: certificates}, nil
 }
 
+func (spec *Spec) resendInterval() time.Duration {
+	if spec.ResendInterval == "" {
+		return defaultResendInterval
+	}
+	d, err := time.ParseDuration(spec.ResendInterval)
+	if err != nil || d <= 0 {
+		logger.Errorf("BUG: invalid resend interval %s, use default %v", spec.ResendInterval, defaultResendInterval)
+		return defaultResendInterval
+	}
+	return d
+}
+
 func sessionStoreKey(clientID string) string {
 	return fmt.Sprintf(sessionPrefix, clientID)
 }
+
+func offlineQueueKey(clientID string) string {
+	return fmt.Sprintf(offlineQueuePrefix, clientID)
+}
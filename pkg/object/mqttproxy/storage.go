@@ -24,6 +24,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/cluster"
 	etcderror "go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"go.etcd.io/etcd/client/v3/concurrency"
 )
 
 type (
@@ -33,6 +34,12 @@ type (
 		put(key, value string) error
 		delete(key string) error
 		watchDelete(prefix string) (<-chan map[string]*string, func(), error)
+
+		// update calls fn with the values of keys, nil for keys not
+		// exist, and applies the changes returned by fn atomically, a nil
+		// value in the changes deletes the key. fn may be called more
+		// than once.
+		update(keys []string, fn func(values []*string) map[string]*string) error
 	}
 
 	mockStorage struct {
@@ -108,6 +115,26 @@ func (m *mockStorage) delete(key string) error {
 	return nil
 }
 
+func (m *mockStorage) update(keys []string, fn func(values []*string) map[string]*string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	values := make([]*string, len(keys))
+	for i, k := range keys {
+		if v, ok := m.store[k]; ok {
+			values[i] = &v
+		}
+	}
+	for k, v := range fn(values) {
+		if v == nil {
+			delete(m.store, k)
+		} else {
+			m.store[k] = *v
+		}
+	}
+	return nil
+}
+
 func (m *mockStorage) watched() bool {
 	flag := atomic.LoadInt32(&m.watchFlag)
 	return flag == 1
@@ -137,6 +164,25 @@ func (cs *clusterStorage) delete(key string) error {
 	return cs.cls.Delete(key)
 }
 
+func (cs *clusterStorage) update(keys []string, fn func(values []*string) map[string]*string) error {
+	return cs.cls.STM(func(s concurrency.STM) error {
+		values := make([]*string, len(keys))
+		for i, k := range keys {
+			if v := s.Get(k); v != "" {
+				values[i] = &v
+			}
+		}
+		for k, v := range fn(values) {
+			if v == nil {
+				s.Del(k)
+			} else {
+				s.Put(k, *v)
+			}
+		}
+		return nil
+	})
+}
+
 func (cs *clusterStorage) watchDelete(prefix string) (<-chan map[string]*string, func(), error) {
 	watcher, err := cs.cls.Watcher()
 	if err != nil {
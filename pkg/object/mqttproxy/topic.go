@@ -208,6 +208,37 @@ func splitTopic(topic string) ([]string, bool) {
 	return levels, true
 }
 
+// topicMatch reports whether topic matches the topic filter, where
+// filter may contain the single-level wildcard '+' and the multi-level
+// wildcard '#'. Like findSubscribers, "a/#" also matches "a".
+func topicMatch(filter, topic string) bool {
+	filterLevels, ok := splitTopic(filter)
+	if !ok {
+		return false
+	}
+	topicLevels, ok := splitTopic(topic)
+	if !ok {
+		return false
+	}
+
+	for i, fl := range filterLevels {
+		if fl == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if fl != "+" && fl != topicLevels[i] {
+			return false
+		}
+	}
+	if len(filterLevels) == len(topicLevels) {
+		return true
+	}
+	// "a/#" matches "a" as well
+	return len(filterLevels) == len(topicLevels)+1 && filterLevels[len(filterLevels)-1] == "#"
+}
+
 func (t *topicLevelManager) get(topic string) ([]string, error) {
 	if val, ok := t.data.Get(topic); ok {
 		return val.([]string), nil
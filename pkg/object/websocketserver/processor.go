@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	// ProcessorAddPrefix adds a prefix to text messages.
+	ProcessorAddPrefix = "addPrefix"
+	// ProcessorTrimPrefix removes a prefix from text messages.
+	ProcessorTrimPrefix = "trimPrefix"
+	// ProcessorJSONSchema rejects text messages not satisfying a JSON schema.
+	ProcessorJSONSchema = "jsonSchema"
+	// ProcessorDropBinary drops binary messages.
+	ProcessorDropBinary = "dropBinary"
+	// ProcessorMaxSize rejects messages larger than a size.
+	ProcessorMaxSize = "maxSize"
+)
+
+type (
+	// MessageProcessorSpec describes a message processor, which modifies,
+	// drops or rejects messages passing through the WebSocketServer.
+	MessageProcessorSpec struct {
+		Kind    string `yaml:"kind" jsonschema:"required,enum=addPrefix,enum=trimPrefix,enum=jsonSchema,enum=dropBinary,enum=maxSize"`
+		Prefix  string `yaml:"prefix,omitempty" jsonschema:"omitempty"`
+		Schema  string `yaml:"schema,omitempty" jsonschema:"omitempty"`
+		MaxSize int    `yaml:"maxSize,omitempty" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// messageProcessor processes a message, it returns the processed
+	// message, or nil if the message should be dropped, or a
+	// *rejectError if the connection should be closed.
+	messageProcessor func(msgType int, msg []byte) ([]byte, error)
+
+	// rejectError is the error returned by a processor which rejects
+	// a message, code is the close code sent to both peers.
+	rejectError struct {
+		code   int
+		reason string
+	}
+)
+
+// Error implements error.
+func (e *rejectError) Error() string {
+	return fmt.Sprintf("message rejected: %s", e.reason)
+}
+
+func newMessageProcessor(spec *MessageProcessorSpec) (messageProcessor, error) {
+	switch spec.Kind {
+	case ProcessorAddPrefix:
+		prefix := []byte(spec.Prefix)
+		return func(msgType int, msg []byte) ([]byte, error) {
+			if msgType != websocket.TextMessage {
+				return msg, nil
+			}
+			return append(append([]byte{}, prefix...), msg...), nil
+		}, nil
+
+	case ProcessorTrimPrefix:
+		prefix := []byte(spec.Prefix)
+		return func(msgType int, msg []byte) ([]byte, error) {
+			if msgType != websocket.TextMessage {
+				return msg, nil
+			}
+			return bytes.TrimPrefix(msg, prefix), nil
+		}, nil
+
+	case ProcessorJSONSchema:
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(spec.Schema))
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON schema: %v", err)
+		}
+		return func(msgType int, msg []byte) ([]byte, error) {
+			if msgType != websocket.TextMessage {
+				return msg, nil
+			}
+			result, err := schema.Validate(gojsonschema.NewBytesLoader(msg))
+			if err != nil {
+				return nil, &rejectError{websocket.CloseInvalidFramePayloadData, "invalid JSON"}
+			}
+			if !result.Valid() {
+				return nil, &rejectError{websocket.ClosePolicyViolation, "JSON schema not satisfied"}
+			}
+			return msg, nil
+		}, nil
+
+	case ProcessorDropBinary:
+		return func(msgType int, msg []byte) ([]byte, error) {
+			if msgType == websocket.BinaryMessage {
+				return nil, nil
+			}
+			return msg, nil
+		}, nil
+
+	case ProcessorMaxSize:
+		if spec.MaxSize <= 0 {
+			return nil, fmt.Errorf("maxSize must be greater than 0")
+		}
+		maxSize := spec.MaxSize
+		return func(msgType int, msg []byte) ([]byte, error) {
+			if len(msg) > maxSize {
+				return nil, &rejectError{websocket.CloseMessageTooBig, "message too big"}
+			}
+			return msg, nil
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown message processor kind: %s", spec.Kind)
+	}
+}
+
+func newMessageProcessors(specs []*MessageProcessorSpec) ([]messageProcessor, error) {
+	processors := make([]messageProcessor, 0, len(specs))
+	for _, spec := range specs {
+		p, err := newMessageProcessor(spec)
+		if err != nil {
+			return nil, err
+		}
+		processors = append(processors, p)
+	}
+	return processors, nil
+}
+
+// processMessage applies the processors to a message in order, it
+// returns nil if the message is dropped.
+func processMessage(processors []messageProcessor, msgType int, msg []byte) ([]byte, error) {
+	for _, p := range processors {
+		var err error
+		if msg, err = p(msgType, msg); err != nil || msg == nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageProcessors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newMessageProcessors([]*MessageProcessorSpec{{Kind: "unknown"}})
+	assert.Error(err)
+	_, err = newMessageProcessors([]*MessageProcessorSpec{{Kind: ProcessorMaxSize}})
+	assert.Error(err)
+	_, err = newMessageProcessors([]*MessageProcessorSpec{{Kind: ProcessorJSONSchema, Schema: "{"}})
+	assert.Error(err)
+
+	processors, err := newMessageProcessors([]*MessageProcessorSpec{
+		{Kind: ProcessorTrimPrefix, Prefix: "v1:"},
+		{Kind: ProcessorJSONSchema, Schema: `{"type": "object", "required": ["id"]}`},
+		{Kind: ProcessorAddPrefix, Prefix: "v2:"},
+		{Kind: ProcessorDropBinary},
+	})
+	require.Nil(t, err)
+
+	msg, err := processMessage(processors, websocket.TextMessage, []byte(`v1:{"id": 1}`))
+	assert.Nil(err)
+	assert.Equal(`v2:{"id": 1}`, string(msg))
+
+	_, err = processMessage(processors, websocket.TextMessage, []byte(`v1:{"name": 1}`))
+	assert.Equal(websocket.ClosePolicyViolation, err.(*rejectError).code)
+
+	_, err = processMessage(processors, websocket.TextMessage, []byte(`v1:{`))
+	assert.Equal(websocket.CloseInvalidFramePayloadData, err.(*rejectError).code)
+
+	msg, err = processMessage(processors, websocket.BinaryMessage, []byte("binary"))
+	assert.Nil(err)
+	assert.Nil(msg)
+
+	processors, err = newMessageProcessors([]*MessageProcessorSpec{{Kind: ProcessorMaxSize, MaxSize: 4}})
+	require.Nil(t, err)
+	msg, err = processMessage(processors, websocket.BinaryMessage, []byte("1234"))
+	assert.Nil(err)
+	assert.Equal("1234", string(msg))
+	_, err = processMessage(processors, websocket.BinaryMessage, []byte("12345"))
+	assert.Equal(websocket.CloseMessageTooBig, err.(*rejectError).code)
+}
@@ -84,6 +84,11 @@ type Proxy struct {
 	//  dialer contains options for connecting to the backend WebSocket server.
 	dialer *websocket.Dialer
 
+	// clientProcessors process messages from client to backend, and
+	// backendProcessors process messages from backend to client.
+	clientProcessors  []messageProcessor
+	backendProcessors []messageProcessor
+
 	// done is the channel for shutdowning this proxy.
 	done chan struct{}
 }
@@ -107,8 +112,10 @@ func (p *Proxy) buildRequestURL(r *http.Request) *url.URL {
 	return &u
 }
 
-// passMsg passes websocket message from src to dst.
-func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan struct{}) {
+// passMsg passes websocket message from src to dst, the message is
+// processed by processors before sending to dst, and both src and dst
+// are closed if the message is rejected.
+func (p *Proxy) passMsg(src, dst *websocket.Conn, processors []messageProcessor, errc chan error, stop chan struct{}) {
 	handle := func() bool {
 		msgType, msg, err := src.ReadMessage()
 		if err != nil {
@@ -119,9 +126,30 @@ func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan str
 				}
 			}
 			dst.WriteMessage(websocket.CloseMessage, m)
+			// the read deadline is only set by keepalive, so a timeout
+			// means a pong is missing, tell src the connection is closing.
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				m := websocket.FormatCloseMessage(websocket.CloseGoingAway, "pong timeout")
+				src.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+			}
 			errc <- err
 			return false
 		}
+
+		msg, err = processMessage(processors, msgType, msg)
+		if e, ok := err.(*rejectError); ok {
+			m := websocket.FormatCloseMessage(e.code, e.reason)
+			// src is written by the other passMsg goroutine, WriteControl
+			// is safe to be called concurrently.
+			src.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+			dst.WriteMessage(websocket.CloseMessage, m)
+			errc <- err
+			return false
+		}
+		if msg == nil {
+			return true
+		}
+
 		err = dst.WriteMessage(msgType, msg)
 		if err != nil {
 			errc <- err
@@ -143,6 +171,36 @@ func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan str
 	}
 }
 
+// keepalive extends the read deadline of conn when a pong is received, and
+// starts sending pings to conn every interval until stop is closed. A missing
+// pong makes the read of conn fail, which closes the connection. It must be
+// called before reading conn.
+func (p *Proxy) keepalive(conn *websocket.Conn, interval, timeout time.Duration, stop chan struct{}) {
+	conn.SetReadDeadline(time.Now().Add(interval + timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(interval + timeout))
+	})
+	go p.ping(conn, interval, timeout, stop)
+}
+
+func (p *Proxy) ping(conn *websocket.Conn, interval, timeout time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// WriteControl is safe to be called concurrently.
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout))
+			if err != nil {
+				logger.Debugf("%s sends ping to %s failed: %v", p.superSpec.Name(), conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
+
 // run runs the websocket proxy.
 func (p *Proxy) run() {
 	spec := p.superSpec.ObjectSpec().(*Spec)
@@ -167,6 +225,15 @@ func (p *Proxy) run() {
 	p.dialer = dialer
 	p.upgrader = defaultUpgrader
 
+	if p.clientProcessors, err = newMessageProcessors(spec.ClientMessageProcessors); err != nil {
+		logger.Errorf("BUG: %s get invalid client message processors: %v", p.superSpec.Name(), err)
+		return
+	}
+	if p.backendProcessors, err = newMessageProcessors(spec.BackendMessageProcessors); err != nil {
+		logger.Errorf("BUG: %s get invalid backend message processors: %v", p.superSpec.Name(), err)
+		return
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", p.handle)
 	addr := fmt.Sprintf(":%d", spec.Port)
@@ -249,9 +316,45 @@ func (p *Proxy) upgradeRspHeader(resp *http.Response) http.Header {
 	return upgradeHeader
 }
 
+// subprotocols returns the subprotocols requested by the client which are
+// allowed by the spec, it returns false if the client requests subprotocols
+// but none of them is allowed.
+func (p *Proxy) subprotocols(req *http.Request) ([]string, bool) {
+	requested := websocket.Subprotocols(req)
+	allowlist := p.superSpec.ObjectSpec().(*Spec).Subprotocols
+	if len(requested) == 0 || len(allowlist) == 0 {
+		return requested, true
+	}
+
+	allowed := make([]string, 0, len(requested))
+	for _, proto := range requested {
+		for _, a := range allowlist {
+			if proto == a {
+				allowed = append(allowed, proto)
+				break
+			}
+		}
+	}
+	return allowed, len(allowed) > 0
+}
+
 // handle implements the http.Handler that proxies WebSocket connections.
 func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
-	connBackend, resp, err := p.dialer.Dial(p.buildRequestURL(req).String(), p.copyHeader(req))
+	protocols, ok := p.subprotocols(req)
+	if !ok {
+		logger.Debugf("%s rejects subprotocols: %v", p.superSpec.Name(), websocket.Subprotocols(req))
+		http.Error(rw, "subprotocol not allowed", http.StatusBadRequest)
+		return
+	}
+
+	// the backend chooses one of the subprotocols, which is passed to
+	// the client in upgradeRspHeader.
+	header := p.copyHeader(req)
+	if len(protocols) > 0 {
+		header.Set("Sec-Websocket-Protocol", strings.Join(protocols, ", "))
+	}
+
+	connBackend, resp, err := p.dialer.Dial(p.buildRequestURL(req).String(), header)
 	if err != nil {
 		logger.Errorf("%s dials %s failed: %v", p.superSpec.Name(), p.backendURL.String(), err)
 		if resp != nil {
@@ -284,10 +387,15 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 
 	defer close(stop)
 
+	if interval, timeout := p.superSpec.ObjectSpec().(*Spec).keepalive(); interval > 0 {
+		p.keepalive(connClient, interval, timeout, stop)
+		p.keepalive(connBackend, interval, timeout, stop)
+	}
+
 	// pass msg from backend to client via WebSocket protocol.
-	go p.passMsg(connBackend, connClient, errBackend, stop)
+	go p.passMsg(connBackend, connClient, p.backendProcessors, errBackend, stop)
 	// pass msg from client to backend via WebSocket protocol.
-	go p.passMsg(connClient, connBackend, errClient, stop)
+	go p.passMsg(connClient, connBackend, p.clientProcessors, errClient, stop)
 
 	var errMsg string
 	select {
@@ -300,6 +408,10 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if _, ok := err.(*rejectError); ok {
+		logger.Debugf(errMsg, p.superSpec.Name(), p.backendURL.String(), err)
+		return
+	}
 	if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
 		logger.Errorf(errMsg, p.superSpec.Name(), p.backendURL.String(), err)
 	}
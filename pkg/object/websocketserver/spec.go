@@ -23,8 +23,12 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 )
 
+// defaultPongTimeout is the default timeout to wait for a pong.
+const defaultPongTimeout = 10 * time.Second
+
 type (
 	// Spec describes the WebSocketServer.
 	Spec struct {
@@ -37,6 +41,22 @@ type (
 
 		WssCertBase64 string `yaml:"wssCertBase64" jsonschema:"omitempty,format=base64"`
 		WssKeyBase64  string `yaml:"wssKeyBase64" jsonschema:"omitempty,format=base64"`
+
+		// Subprotocols is the allowlist of subprotocols which are passed
+		// to the backend, all subprotocols are allowed if it is empty.
+		Subprotocols []string `yaml:"subprotocols" jsonschema:"omitempty,uniqueItems=true"`
+
+		// PingInterval is the interval to send pings to both the client
+		// and the backend, the connection is closed if a pong isn't
+		// received within PongTimeout after the ping.
+		PingInterval string `yaml:"pingInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		PongTimeout  string `yaml:"pongTimeout,omitempty" jsonschema:"omitempty,format=duration"`
+
+		// ClientMessageProcessors are applied in order to messages from
+		// client to backend, BackendMessageProcessors are applied to
+		// messages from backend to client.
+		ClientMessageProcessors  []*MessageProcessorSpec `yaml:"clientMessageProcessors" jsonschema:"omitempty"`
+		BackendMessageProcessors []*MessageProcessorSpec `yaml:"backendMessageProcessors" jsonschema:"omitempty"`
 	}
 )
 
@@ -61,9 +81,30 @@ func (spec *Spec) Validate() error {
 			return fmt.Errorf("invalid wssCertbase64 or wssKeybase64 with wss enable, spec: %#v", spec)
 		}
 	}
+
+	if _, err := newMessageProcessors(spec.ClientMessageProcessors); err != nil {
+		return fmt.Errorf("invalid client message processors: %v", err)
+	}
+	if _, err := newMessageProcessors(spec.BackendMessageProcessors); err != nil {
+		return fmt.Errorf("invalid backend message processors: %v", err)
+	}
 	return nil
 }
 
+// keepalive returns the ping interval and pong timeout, the ping
+// interval is 0 if keepalive is disabled.
+func (spec *Spec) keepalive() (time.Duration, time.Duration) {
+	if spec.PingInterval == "" {
+		return 0, 0
+	}
+	interval, _ := time.ParseDuration(spec.PingInterval)
+	timeout := defaultPongTimeout
+	if spec.PongTimeout != "" {
+		timeout, _ = time.ParseDuration(spec.PongTimeout)
+	}
+	return interval, timeout
+}
+
 func validateTLS(certBas64, keyBase64 string) (*tls.Config, error) {
 	var certificates []tls.Certificate
 	if len(certBas64) != 0 && len(keyBase64) != 0 {
@@ -21,8 +21,11 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -155,6 +158,178 @@ backend: ws://127.0.0.1:8000`
 	newWs.Close()
 }
 
+func TestWebSocketMessageProcessors(t *testing.T) {
+	testSrv := getTestServer(t, "127.0.0.1:8000")
+	defer testSrv.Close()
+
+	wsYaml := `
+kind: WebSocketServer
+name: websocket-demo
+port: 10081
+https: false
+backend: ws://127.0.0.1:8000
+clientMessageProcessors:
+- kind: addPrefix
+  prefix: "client:"
+- kind: maxSize
+  maxSize: 16
+backendMessageProcessors:
+- kind: addPrefix
+  prefix: "backend:"
+`
+	ws := getWebSocket(t, wsYaml, "ws://127.0.0.1:10081")
+	defer ws.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:10081", nil)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	// text messages are rewritten in both directions.
+	err = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	require.Nil(t, err)
+	mt, p, err := conn.ReadMessage()
+	require.Nil(t, err)
+	assert.Equal(t, websocket.TextMessage, mt)
+	assert.Equal(t, "backend:client:hello", string(p))
+
+	// binary messages are not rewritten.
+	err = conn.WriteMessage(websocket.BinaryMessage, []byte("small"))
+	require.Nil(t, err)
+	mt, p, err = conn.ReadMessage()
+	require.Nil(t, err)
+	assert.Equal(t, websocket.BinaryMessage, mt)
+	assert.Equal(t, "small", string(p))
+
+	// oversized messages are rejected and the connection is closed.
+	err = conn.WriteMessage(websocket.BinaryMessage, make([]byte, 17))
+	require.Nil(t, err)
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "%v", err)
+}
+
+func TestWebSocketSubprotocols(t *testing.T) {
+	backendUpgrader := &websocket.Upgrader{Subprotocols: []string{"chat.v1", "chat.v2", "chat.v3"}}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := backendUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, msg)
+		}
+	}))
+	defer backend.Close()
+
+	wsYaml := `
+kind: WebSocketServer
+name: websocket-demo
+port: 10081
+https: false
+backend: ` + strings.Replace(backend.URL, "http", "ws", 1) + `
+subprotocols: ["chat.v1", "chat.v2"]
+`
+	ws := getWebSocket(t, wsYaml, "ws://127.0.0.1:10081")
+	defer ws.Close()
+
+	// the allowed subprotocol is negotiated end-to-end.
+	dialer := &websocket.Dialer{Subprotocols: []string{"chat.v3", "chat.v2"}}
+	conn, resp, err := dialer.Dial("ws://127.0.0.1:10081", nil)
+	require.Nil(t, err)
+	assert.Equal(t, "chat.v2", conn.Subprotocol())
+	assert.Equal(t, "chat.v2", resp.Header.Get("Sec-Websocket-Protocol"))
+	err = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	require.Nil(t, err)
+	_, p, err := conn.ReadMessage()
+	require.Nil(t, err)
+	assert.Equal(t, "hello", string(p))
+	conn.Close()
+
+	// disallowed subprotocols are rejected.
+	dialer = &websocket.Dialer{Subprotocols: []string{"chat.v3"}}
+	_, resp, err = dialer.Dial("ws://127.0.0.1:10081", nil)
+	assert.NotNil(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// no subprotocol requested.
+	conn, _, err = websocket.DefaultDialer.Dial("ws://127.0.0.1:10081", nil)
+	require.Nil(t, err)
+	assert.Equal(t, "", conn.Subprotocol())
+	conn.Close()
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	var backendPings int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := testUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetPingHandler(func(data string) error {
+			atomic.AddInt32(&backendPings, 1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	wsYaml := `
+kind: WebSocketServer
+name: websocket-demo
+port: 10081
+https: false
+backend: ` + strings.Replace(backend.URL, "http", "ws", 1) + `
+pingInterval: 100ms
+pongTimeout: 300ms
+`
+	ws := getWebSocket(t, wsYaml, "ws://127.0.0.1:10081")
+	defer ws.Close()
+
+	// a client replying pongs keeps the connection alive.
+	conn, _, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:10081", nil)
+	require.Nil(t, err)
+	var clientPings int32
+	conn.SetPingHandler(func(data string) error {
+		atomic.AddInt32(&clientPings, 1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	errc := make(chan error, 1)
+	go func() {
+		_, _, err := conn.ReadMessage()
+		errc <- err
+	}()
+
+	time.Sleep(650 * time.Millisecond)
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&clientPings), int32(5))
+	assert.GreaterOrEqual(t, atomic.LoadInt32(&backendPings), int32(5))
+	select {
+	case err := <-errc:
+		t.Fatalf("connection closed unexpectedly: %v", err)
+	default:
+	}
+	conn.Close()
+	<-errc
+
+	// a client not replying pongs is closed.
+	conn, _, err = websocket.DefaultDialer.Dial("ws://127.0.0.1:10081", nil)
+	require.Nil(t, err)
+	defer conn.Close()
+	conn.SetPingHandler(func(string) error { return nil })
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "%v", err)
+}
+
 func TestWebSocketTLS(t *testing.T) {
 	testSrv := getTestServer(t, "127.0.0.1:8000")
 	defer testSrv.Close()
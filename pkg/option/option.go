@@ -47,6 +47,24 @@ type ClusterOptions struct {
 	// Secondary members define URLs to connect to cluster formed by primary members.
 	PrimaryListenPeerURLs []string `yaml:"primary-listen-peer-urls"`
 	MaxCallSendMsgSize    int      `yaml:"max-call-send-msg-size"`
+
+	// Client TLS and authentication for connecting to the cluster, they are
+	// required when connecting to a hardened standalone etcd.
+	ClientTLS ClientTLSOptions `yaml:"client-tls"`
+	Username  string           `yaml:"username"`
+	Password  string           `yaml:"password"`
+}
+
+// ClientTLSOptions defines the TLS files of the cluster client.
+type ClientTLSOptions struct {
+	CAFile   string `yaml:"ca-file"`
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+}
+
+// Enabled returns whether the client TLS is enabled.
+func (o *ClientTLSOptions) Enabled() bool {
+	return o.CAFile != "" || o.CertFile != "" || o.KeyFile != ""
 }
 
 // Options is the start-up options.
@@ -117,6 +135,11 @@ func addClusterVars(opt *Options) {
 	opt.flags.StringVar(&opt.Cluster.StateFlag, "state-flag", "new", "Cluster state (new, existing)")
 	opt.flags.StringSliceVar(&opt.Cluster.PrimaryListenPeerURLs, "primary-listen-peer-urls", []string{"http://localhost:2380"}, "List of peer URLs of primary members. Define this only, when cluster-role is secondary.")
 	opt.flags.IntVar(&opt.Cluster.MaxCallSendMsgSize, "max-call-send-msg-size", 10*1024*1024, "Maximum size in bytes for cluster synchronization messages.")
+	opt.flags.StringVar(&opt.Cluster.ClientTLS.CAFile, "client-tls-ca-file", "", "Path to the CA file to verify the cluster server certificates.")
+	opt.flags.StringVar(&opt.Cluster.ClientTLS.CertFile, "client-tls-cert-file", "", "Path to the client certificate file to connect to the cluster.")
+	opt.flags.StringVar(&opt.Cluster.ClientTLS.KeyFile, "client-tls-key-file", "", "Path to the client key file to connect to the cluster.")
+	opt.flags.StringVar(&opt.Cluster.Username, "cluster-username", "", "Username to authenticate to the cluster.")
+	opt.flags.StringVar(&opt.Cluster.Password, "cluster-password", "", "Password to authenticate to the cluster.")
 }
 
 // New creates a default Options.
@@ -315,6 +338,13 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid cluster-role: supported roles are primary/secondary")
 	}
 
+	if tls := opt.Cluster.ClientTLS; (tls.CertFile == "") != (tls.KeyFile == "") {
+		return fmt.Errorf("cluster.client-tls.cert-file and cluster.client-tls.key-file must be specified together")
+	}
+	if opt.Cluster.Password != "" && opt.Cluster.Username == "" {
+		return fmt.Errorf("cluster.password is specified without cluster.username")
+	}
+
 	_, err := time.ParseDuration(opt.ClusterRequestTimeout)
 	if err != nil {
 		return fmt.Errorf("invalid cluster-request-timeout: %v", err)
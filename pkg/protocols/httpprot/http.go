@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/megaease/easegress/pkg/protocols"
 	"gopkg.in/yaml.v3"
@@ -107,6 +108,18 @@ func (p *Protocol) CreateResponse(resp interface{}) (protocols.Response, error)
 	return NewResponse(r)
 }
 
+// IsGRPC returns whether h is the header of a gRPC request or response,
+// that is, its content type is application/grpc or application/grpc+xxx.
+// gRPC-Web is not included.
+func IsGRPC(h http.Header) bool {
+	ct := h.Get("Content-Type")
+	if !strings.HasPrefix(ct, "application/grpc") {
+		return false
+	}
+	ct = ct[len("application/grpc"):]
+	return ct == "" || ct[0] == '+' || ct[0] == ';'
+}
+
 func parseJSONBody(body []byte) (interface{}, error) {
 	var v interface{}
 	d := json.NewDecoder(bytes.NewReader(body))
@@ -199,3 +199,21 @@ name: 123
 		assert.Nil(err)
 	}
 }
+
+func TestIsGRPC(t *testing.T) {
+	assert := assert.New(t)
+
+	for ct, expected := range map[string]bool{
+		"":                              false,
+		"application/json":              false,
+		"application/grpc":              true,
+		"application/grpc+proto":        true,
+		"application/grpc;charset=utf8": true,
+		"application/grpc-web":          false,
+		"application/grpcfoo":           false,
+	} {
+		h := http.Header{}
+		h.Set("Content-Type", ct)
+		assert.Equal(expected, IsGRPC(h), ct)
+	}
+}
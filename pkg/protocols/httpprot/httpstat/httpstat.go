@@ -19,6 +19,7 @@ package httpstat
 
 import (
 	"math"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,11 +31,19 @@ import (
 	"github.com/megaease/easegress/pkg/util/sampler"
 )
 
+// tickInterval is the interval the EWMAs expect to be ticked.
+// https://github.com/rcrowley/go-metrics/blob/3113b8401b8a98917cde58f8bbd42a1b1c03b1fd/ewma.go#L98-L99
+const tickInterval = 5 * time.Second
+
 type (
 	// HTTPStat is the statistics tool for HTTP traffic.
 	HTTPStat struct {
 		mutex sync.RWMutex
 
+		// lastTick is the unix nano of the last tick of the EWMAs.
+		lastTick int64
+		now      func() time.Time
+
 		count  uint64
 		rate1  metrics.EWMA
 		rate5  metrics.EWMA
@@ -53,12 +62,29 @@ type (
 		min   uint64
 		max   uint64
 
-		durationSampler *sampler.DurationSampler
+		durationSampler sampler.Sampler
 
 		reqSize  uint64
 		respSize uint64
 
 		cc *codecounter.HTTPStatusCodeCounter
+
+		// slo is optional, badRate1 to badRate15 are only created along
+		// with it.
+		slo       *SLO
+		badRate1  metrics.EWMA
+		badRate5  metrics.EWMA
+		badRate15 metrics.EWMA
+	}
+
+	// SLO is the service level objective of the HTTP traffic. A request is
+	// bad if it failed or took longer than LatencyThreshold, and the
+	// ratio of good requests is expected to be at least Availability.
+	SLO struct {
+		// LatencyThreshold is ignored if it is zero.
+		LatencyThreshold time.Duration
+		// Availability is the target in (0, 1), e.g. 0.999.
+		Availability float64
 	}
 
 	// Metric is the package of statistics at once.
@@ -107,10 +133,37 @@ type (
 		Count uint64 `json:"cnt"`
 	}
 
+	// StatusCodeClassMetric is the metrics of http status code classes.
+	StatusCodeClassMetric struct {
+		Code1xx uint64 `json:"code1xx"`
+		Code2xx uint64 `json:"code2xx"`
+		Code3xx uint64 `json:"code3xx"`
+		Code4xx uint64 `json:"code4xx"`
+		Code5xx uint64 `json:"code5xx"`
+	}
+
+	// SLOMetric is the error budget burn rates of the SLO, a burn rate of
+	// 1 consumes the error budget exactly in the SLO period.
+	SLOMetric struct {
+		M1BurnRate  float64 `yaml:"m1BurnRate" json:"m1BurnRate"`
+		M5BurnRate  float64 `yaml:"m5BurnRate" json:"m5BurnRate"`
+		M15BurnRate float64 `yaml:"m15BurnRate" json:"m15BurnRate"`
+	}
+
 	// Status contains all status generated by HTTPStat.
 	Status struct {
 		RequestMetric
 		Codes map[int]uint64 `yaml:"codes"`
+
+		// Code1xx to Code5xx are the rollups of Codes by class.
+		Code1xx uint64 `yaml:"code1xx"`
+		Code2xx uint64 `yaml:"code2xx"`
+		Code3xx uint64 `yaml:"code3xx"`
+		Code4xx uint64 `yaml:"code4xx"`
+		Code5xx uint64 `yaml:"code5xx"`
+
+		// SLO is only present if the SLO of HTTPStat is set.
+		SLO *SLOMetric `yaml:"slo,omitempty"`
 	}
 )
 
@@ -118,8 +171,28 @@ func (m *Metric) isErr() bool {
 	return m.StatusCode >= 400
 }
 
+func (slo *SLO) isBad(m *Metric) bool {
+	if m.isErr() {
+		return true
+	}
+	return slo.LatencyThreshold > 0 && m.Duration > slo.LatencyThreshold
+}
+
+func (slo *SLO) burnRate(bad, all float64) float64 {
+	if all <= 0 || slo.Availability <= 0 || slo.Availability >= 1 {
+		return 0
+	}
+	return bad / all / (1 - slo.Availability)
+}
+
 // New creates an HTTPStat.
 func New() *HTTPStat {
+	return NewWithSampler(sampler.NewDurationSampler())
+}
+
+// NewWithSampler creates an HTTPStat which samples durations by ds, e.g.
+// sampler.NewHDRDurationSampler() for percentiles with bounded error.
+func NewWithSampler(ds sampler.Sampler) *HTTPStat {
 	hs := &HTTPStat{
 		rate1:  metrics.NewEWMA1(),
 		rate5:  metrics.NewEWMA5(),
@@ -130,14 +203,74 @@ func New() *HTTPStat {
 		errRate15: metrics.NewEWMA15(),
 
 		min:             math.MaxUint64,
-		durationSampler: sampler.NewDurationSampler(),
+		durationSampler: ds,
 
 		cc: codecounter.New(),
 	}
+	hs.SetClock(time.Now)
 
 	return hs
 }
 
+// SetClock sets the clock used to tick the EWMAs, it is time.Now by
+// default. It is for tests and must be called before hs is used.
+func (hs *HTTPStat) SetClock(now func() time.Time) {
+	hs.now = now
+	hs.lastTick = now().UnixNano()
+}
+
+// SetSLO sets the SLO to calculate the error budget burn rates, nil
+// disables it.
+func (hs *HTTPStat) SetSLO(slo *SLO) {
+	hs.mutex.Lock()
+	defer hs.mutex.Unlock()
+
+	hs.slo = slo
+	if slo == nil {
+		hs.badRate1, hs.badRate5, hs.badRate15 = nil, nil, nil
+		return
+	}
+	if hs.badRate1 == nil {
+		hs.badRate1 = metrics.NewEWMA1()
+		hs.badRate5 = metrics.NewEWMA5()
+		hs.badRate15 = metrics.NewEWMA15()
+	}
+}
+
+// tick ticks the EWMAs once for every tick interval elapsed since the
+// last tick, so the rates don't depend on how often Status is called.
+// It is called before updating the EWMAs too, to count the requests in
+// the interval they arrived.
+func (hs *HTTPStat) tick() {
+	now := hs.now().UnixNano()
+	for {
+		last := atomic.LoadInt64(&hs.lastTick)
+		n := (now - last) / int64(tickInterval)
+		if n <= 0 {
+			return
+		}
+
+		if !atomic.CompareAndSwapInt64(&hs.lastTick, last, last+n*int64(tickInterval)) {
+			continue
+		}
+
+		for i := int64(0); i < n; i++ {
+			hs.rate1.Tick()
+			hs.rate5.Tick()
+			hs.rate15.Tick()
+			hs.errRate1.Tick()
+			hs.errRate5.Tick()
+			hs.errRate15.Tick()
+			if hs.slo != nil {
+				hs.badRate1.Tick()
+				hs.badRate5.Tick()
+				hs.badRate15.Tick()
+			}
+		}
+		return
+	}
+}
+
 // Stat stats the ctx.
 func (hs *HTTPStat) Stat(m *Metric) {
 	// Note: although this is a data update operation, we are using the RLock here,
@@ -148,6 +281,8 @@ func (hs *HTTPStat) Stat(m *Metric) {
 	hs.mutex.RLock()
 	defer hs.mutex.RUnlock()
 
+	hs.tick()
+
 	atomic.AddUint64(&hs.count, 1)
 	hs.rate1.Update(1)
 	hs.rate5.Update(1)
@@ -160,6 +295,12 @@ func (hs *HTTPStat) Stat(m *Metric) {
 		hs.errRate15.Update(1)
 	}
 
+	if hs.slo != nil && hs.slo.isBad(m) {
+		hs.badRate1.Update(1)
+		hs.badRate5.Update(1)
+		hs.badRate15.Update(1)
+	}
+
 	duration := uint64(m.Duration.Milliseconds())
 	atomic.AddUint64(&hs.total, duration)
 	for {
@@ -189,18 +330,12 @@ func (hs *HTTPStat) Stat(m *Metric) {
 	hs.cc.Count(m.StatusCode)
 }
 
-// Status returns HTTPStat Status, It assumes it is called every five seconds.
-// https://github.com/rcrowley/go-metrics/blob/3113b8401b8a98917cde58f8bbd42a1b1c03b1fd/ewma.go#L98-L99
+// Status returns HTTPStat Status, it can be called at any frequency.
 func (hs *HTTPStat) Status() *Status {
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
 
-	hs.rate1.Tick()
-	hs.rate5.Tick()
-	hs.rate15.Tick()
-	hs.errRate1.Tick()
-	hs.errRate5.Tick()
-	hs.errRate15.Tick()
+	hs.tick()
 
 	m1, m5, m15 := hs.rate1.Rate(), hs.rate5.Rate(), hs.rate15.Rate()
 	m1Err, m5Err, m15Err := hs.errRate1.Rate(), hs.errRate5.Rate(), hs.errRate15.Rate()
@@ -261,9 +396,53 @@ func (hs *HTTPStat) Status() *Status {
 		Codes: codes,
 	}
 
+	if hs.slo != nil {
+		status.SLO = &SLOMetric{
+			M1BurnRate:  hs.slo.burnRate(hs.badRate1.Rate(), m1),
+			M5BurnRate:  hs.slo.burnRate(hs.badRate5.Rate(), m5),
+			M15BurnRate: hs.slo.burnRate(hs.badRate15.Rate(), m15),
+		}
+	}
+
+	for code, count := range codes {
+		switch code / 100 {
+		case 1:
+			status.Code1xx += count
+		case 2:
+			status.Code2xx += count
+		case 3:
+			status.Code3xx += count
+		case 4:
+			status.Code4xx += count
+		case 5:
+			status.Code5xx += count
+		}
+	}
+
 	return status
 }
 
+// TopErrorCodes returns at most n error status codes ranked by count.
+func (s *Status) TopErrorCodes(n int) []*StatusCodeMetric {
+	codes := make([]*StatusCodeMetric, 0)
+	for code, count := range s.Codes {
+		if code >= 400 {
+			codes = append(codes, &StatusCodeMetric{Code: code, Count: count})
+		}
+	}
+
+	sort.Slice(codes, func(i, j int) bool {
+		if codes[i].Count != codes[j].Count {
+			return codes[i].Count > codes[j].Count
+		}
+		return codes[i].Code < codes[j].Code
+	})
+	if len(codes) > n {
+		codes = codes[:n]
+	}
+	return codes
+}
+
 // ToMetrics implements easemonitor.Metricer.
 func (s *Status) ToMetrics(service string) []*easemonitor.Metrics {
 	results := make([]*easemonitor.Metrics, 0, 32)
@@ -276,6 +455,30 @@ func (s *Status) ToMetrics(service string) []*easemonitor.Metrics {
 		OtherFields: &s.RequestMetric,
 	})
 
+	results = append(results, &easemonitor.Metrics{
+		CommonFields: easemonitor.CommonFields{
+			Service: service,
+			Type:    "eg-http-status-code-class",
+		},
+		OtherFields: &StatusCodeClassMetric{
+			Code1xx: s.Code1xx,
+			Code2xx: s.Code2xx,
+			Code3xx: s.Code3xx,
+			Code4xx: s.Code4xx,
+			Code5xx: s.Code5xx,
+		},
+	})
+
+	if s.SLO != nil {
+		results = append(results, &easemonitor.Metrics{
+			CommonFields: easemonitor.CommonFields{
+				Service: service,
+				Type:    "eg-http-slo",
+			},
+			OtherFields: s.SLO,
+		})
+	}
+
 	for code, count := range s.Codes {
 		results = append(results, &easemonitor.Metrics{
 			CommonFields: easemonitor.CommonFields{
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpstat
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusPollingFrequency(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(0, 0)
+	clock := func() time.Time { return now }
+
+	fast, slow, never := New(), New(), New()
+	for _, hs := range []*HTTPStat{fast, slow, never} {
+		hs.SetClock(clock)
+	}
+
+	// 10 requests per second for 10 minutes, a quarter of them failed.
+	for i := 1; i <= 6000; i++ {
+		now = now.Add(100 * time.Millisecond)
+
+		m := &Metric{StatusCode: 200}
+		if i%4 == 0 {
+			m.StatusCode = 500
+		}
+		fast.Stat(m)
+		slow.Stat(m)
+		never.Stat(m)
+
+		if i%10 == 0 {
+			fast.Status()
+		}
+		if i%300 == 0 {
+			slow.Status()
+		}
+	}
+
+	fs, ss, ns := fast.Status(), slow.Status(), never.Status()
+
+	assert.InDelta(10, fs.M1, 0.5)
+	assert.InDelta(2.5, fs.M1Err, 0.2)
+	for _, s := range []*Status{ss, ns} {
+		assert.InDelta(fs.M1, s.M1, 1e-9)
+		assert.InDelta(fs.M5, s.M5, 1e-9)
+		assert.InDelta(fs.M15, s.M15, 1e-9)
+		assert.InDelta(fs.M1Err, s.M1Err, 1e-9)
+	}
+
+	// Rates decay while idle even if nobody polls.
+	now = now.Add(10 * time.Minute)
+	assert.Less(slow.Status().M1, 0.1)
+}
+
+func TestStatusCodeClasses(t *testing.T) {
+	assert := assert.New(t)
+
+	hs := New()
+	codes := map[int]int{100: 1, 101: 1, 200: 5, 204: 2, 301: 1, 304: 3, 404: 4, 429: 1, 500: 2, 503: 1, 600: 1}
+	for code, n := range codes {
+		for i := 0; i < n; i++ {
+			hs.Stat(&Metric{StatusCode: code})
+		}
+	}
+
+	s := hs.Status()
+	assert.Equal(uint64(2), s.Code1xx)
+	assert.Equal(uint64(7), s.Code2xx)
+	assert.Equal(uint64(4), s.Code3xx)
+	assert.Equal(uint64(5), s.Code4xx)
+	assert.Equal(uint64(3), s.Code5xx)
+	assert.Len(s.Codes, len(codes))
+	assert.Equal(uint64(4), s.Codes[404])
+
+	metrics := s.ToMetrics("test")
+	assert.Len(metrics, 2+len(codes))
+	assert.Equal("eg-http-status-code-class", metrics[1].Type)
+	assert.Equal(&StatusCodeClassMetric{
+		Code1xx: 2, Code2xx: 7, Code3xx: 4, Code4xx: 5, Code5xx: 3,
+	}, metrics[1].OtherFields)
+
+	// The rollups are reset with the per-code counters.
+	s = hs.Status()
+	assert.Equal(uint64(0), s.Code2xx)
+}
+
+func TestSLOBurnRate(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(0, 0)
+	hs := New()
+	hs.SetClock(func() time.Time { return now })
+	assert.Nil(hs.Status().SLO)
+	assert.Len(hs.Status().ToMetrics("test"), 2)
+
+	hs.SetSLO(&SLO{LatencyThreshold: 100 * time.Millisecond, Availability: 0.99})
+
+	// run sends 10 requests per second for 5 minutes, slowEvery-th and
+	// errEvery-th requests are slow and failed.
+	run := func(slowEvery, errEvery int) *Status {
+		for i := 1; i <= 3000; i++ {
+			now = now.Add(100 * time.Millisecond)
+			m := &Metric{StatusCode: 200, Duration: 50 * time.Millisecond}
+			if slowEvery > 0 && i%slowEvery == 0 {
+				m.Duration = 200 * time.Millisecond
+			}
+			if errEvery > 0 && i%errEvery == 0 {
+				m.StatusCode = 503
+			}
+			hs.Stat(m)
+		}
+		return hs.Status()
+	}
+
+	s := run(0, 0)
+	assert.InDelta(0, s.SLO.M1BurnRate, 1e-9)
+	assert.InDelta(0, s.SLO.M15BurnRate, 1e-9)
+
+	// 1% bad requests burn the budget at exactly the expected pace.
+	s = run(100, 0)
+	assert.InDelta(1, s.SLO.M1BurnRate, 0.1)
+
+	// Requests both slow and failed are counted once.
+	s = run(20, 20)
+	assert.InDelta(5, s.SLO.M1BurnRate, 0.5)
+
+	// 10% bad requests burn it 10 times faster.
+	s = run(20, 10)
+	assert.InDelta(10, s.SLO.M1BurnRate, 1)
+	assert.Greater(s.SLO.M1BurnRate, s.SLO.M5BurnRate)
+	assert.Greater(s.SLO.M5BurnRate, s.SLO.M15BurnRate)
+
+	metrics := s.ToMetrics("test")
+	assert.Equal("eg-http-slo", metrics[2].Type)
+	assert.Equal(s.SLO, metrics[2].OtherFields)
+
+	hs.SetSLO(nil)
+	assert.Nil(hs.Status().SLO)
+}
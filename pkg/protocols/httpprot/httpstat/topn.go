@@ -30,6 +30,9 @@ type (
 		m   sync.Map
 		n   int
 		uca *urlclusteranalyzer.URLClusterAnalyzer
+
+		// errM is the same as m, but only for error responses.
+		errM sync.Map
 	}
 
 	// Item is the item of status.
@@ -48,28 +51,41 @@ func NewTopN(n int) *TopN {
 	}
 }
 
+func loadOrStoreHTTPStat(m *sync.Map, pattern string) *HTTPStat {
+	if v, loaded := m.Load(pattern); loaded {
+		return v.(*HTTPStat)
+	}
+	v, _ := m.LoadOrStore(pattern, New())
+	return v.(*HTTPStat)
+}
+
 // Stat stats the ctx.
 func (t *TopN) Stat(path string) *HTTPStat {
-	pattern := t.uca.GetPattern(path)
+	return loadOrStoreHTTPStat(&t.m, t.uca.GetPattern(path))
+}
 
-	var httpStat *HTTPStat
-	if v, loaded := t.m.Load(pattern); loaded {
-		httpStat = v.(*HTTPStat)
-	} else {
-		httpStat = New()
-		v, loaded = t.m.LoadOrStore(pattern, httpStat)
-		if loaded {
-			httpStat = v.(*HTTPStat)
-		}
+// StatError stats m under path if it is an error response.
+func (t *TopN) StatError(path string, m *Metric) {
+	if !m.isErr() {
+		return
 	}
-
-	return httpStat
+	loadOrStoreHTTPStat(&t.errM, t.uca.GetPattern(path)).Stat(m)
 }
 
 // Status returns TopN Status, and resets all metrics.
 func (t *TopN) Status() []*Item {
+	return t.status(&t.m)
+}
+
+// ErrorStatus returns the TopN Status of error responses, ranked by the
+// error count, and resets all metrics.
+func (t *TopN) ErrorStatus() []*Item {
+	return t.status(&t.errM)
+}
+
+func (t *TopN) status(m *sync.Map) []*Item {
 	status := make([]*Item, 0)
-	t.m.Range(func(key, value interface{}) bool {
+	m.Range(func(key, value interface{}) bool {
 		status = append(status, &Item{
 			Path:   key.(string),
 			Status: value.(*HTTPStat).Status(),
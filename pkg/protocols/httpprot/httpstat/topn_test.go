@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpstat
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopNErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	topN := NewTopN(2)
+	hs := New()
+	feed := func(path string, code, n int) {
+		for i := 0; i < n; i++ {
+			m := &Metric{StatusCode: code}
+			topN.Stat(path).Stat(m)
+			topN.StatError(path, m)
+			hs.Stat(m)
+		}
+	}
+
+	// busy has the most requests but few errors.
+	feed("/busy", 200, 100)
+	feed("/busy", 404, 2)
+	feed("/broken", 200, 10)
+	feed("/broken", 500, 20)
+	feed("/broken", 502, 5)
+	feed("/flaky", 200, 30)
+	feed("/flaky", 503, 10)
+	feed("/ok", 200, 50)
+
+	items := topN.Status()
+	assert.Len(items, 2)
+	assert.Equal("/busy", items[0].Path)
+	assert.Equal("/ok", items[1].Path)
+
+	items = topN.ErrorStatus()
+	assert.Len(items, 2)
+	assert.Equal("/broken", items[0].Path)
+	assert.Equal(uint64(25), items[0].ErrCount)
+	assert.Equal(uint64(25), items[0].Count)
+	assert.Equal("/flaky", items[1].Path)
+	assert.Equal(uint64(10), items[1].ErrCount)
+
+	codes := hs.Status().TopErrorCodes(3)
+	assert.Equal([]*StatusCodeMetric{
+		{Code: 500, Count: 20},
+		{Code: 503, Count: 10},
+		{Code: 502, Count: 5},
+	}, codes)
+}
@@ -294,6 +294,12 @@ func (r *Request) RealIP() string {
 	return r.realIP
 }
 
+// SetRealIP sets the real IP of the request, it is used when the real IP is
+// derived by rules other than the default ones.
+func (r *Request) SetRealIP(ip string) {
+	r.realIP = ip
+}
+
 // Std returns the underlying http.Request.
 func (r *Request) Std() *http.Request {
 	return r.Request
@@ -24,21 +24,30 @@ import (
 	_ "github.com/megaease/easegress/pkg/filters/connectcontrol"
 	_ "github.com/megaease/easegress/pkg/filters/corsadaptor"
 	_ "github.com/megaease/easegress/pkg/filters/fallback"
+	_ "github.com/megaease/easegress/pkg/filters/grpcproxy"
 	_ "github.com/megaease/easegress/pkg/filters/headerlookup"
 	_ "github.com/megaease/easegress/pkg/filters/headertojson"
+	_ "github.com/megaease/easegress/pkg/filters/jqtransformer"
 	_ "github.com/megaease/easegress/pkg/filters/kafka"
 	_ "github.com/megaease/easegress/pkg/filters/kafkabackend"
+	_ "github.com/megaease/easegress/pkg/filters/keyedratelimiter"
 	_ "github.com/megaease/easegress/pkg/filters/meshadaptor"
+	_ "github.com/megaease/easegress/pkg/filters/methodoverride"
 	_ "github.com/megaease/easegress/pkg/filters/mock"
 	_ "github.com/megaease/easegress/pkg/filters/mqttclientauth"
 	_ "github.com/megaease/easegress/pkg/filters/proxy"
 	_ "github.com/megaease/easegress/pkg/filters/ratelimiter"
 	_ "github.com/megaease/easegress/pkg/filters/remotefilter"
 	_ "github.com/megaease/easegress/pkg/filters/requestadaptor"
+	_ "github.com/megaease/easegress/pkg/filters/requestbodylimiter"
+	_ "github.com/megaease/easegress/pkg/filters/requestid"
 	_ "github.com/megaease/easegress/pkg/filters/responseadaptor"
+	_ "github.com/megaease/easegress/pkg/filters/statuscodemapper"
 	_ "github.com/megaease/easegress/pkg/filters/topicmapper"
+	_ "github.com/megaease/easegress/pkg/filters/urlrewriter"
 	_ "github.com/megaease/easegress/pkg/filters/validator"
 	_ "github.com/megaease/easegress/pkg/filters/wasmhost"
+	_ "github.com/megaease/easegress/pkg/filters/xmljson"
 
 	// Objects
 	_ "github.com/megaease/easegress/pkg/object/autocertmanager"
@@ -21,6 +21,7 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
@@ -65,6 +66,13 @@ type CircuitBreakerPolicy struct {
 	WaitDurationInOpen               string `yaml:"waitDurationInOpenState" jsonschema:"omitempty,format=duration"`
 }
 
+// CircuitBreakerStatus is the status of a circuit breaker.
+type CircuitBreakerStatus struct {
+	State         string `yaml:"state" json:"state"`
+	ShortCircuits uint64 `yaml:"shortCircuits" json:"shortCircuits"`
+	Failures      uint64 `yaml:"failures" json:"failures"`
+}
+
 // Validate validates the CircuitBreakPolicy.
 func (p *CircuitBreakerPolicy) Validate() error {
 	// TODO
@@ -102,20 +110,33 @@ func (p *CircuitBreakerPolicy) CreateWrapper() Wrapper {
 		policy.WaitDurationInOpen = time.Minute
 	}
 
-	return circuitBreakerWrapper{CircuitBreaker: libcb.New(policy)}
+	return &circuitBreakerWrapper{CircuitBreaker: libcb.New(policy)}
 }
 
 type circuitBreakerWrapper struct {
 	*libcb.CircuitBreaker
+	shortCircuits uint64
+	failures      uint64
+}
+
+// Status returns the status of the circuit breaker, the counters are
+// accumulated since the wrapper was created.
+func (w *circuitBreakerWrapper) Status() *CircuitBreakerStatus {
+	return &CircuitBreakerStatus{
+		State:         w.State().String(),
+		ShortCircuits: atomic.LoadUint64(&w.shortCircuits),
+		Failures:      atomic.LoadUint64(&w.failures),
+	}
 }
 
 // Wrap wraps the handler function.
-func (w circuitBreakerWrapper) Wrap(handler HandlerFunc) HandlerFunc {
+func (w *circuitBreakerWrapper) Wrap(handler HandlerFunc) HandlerFunc {
 	return func(ctx context.Context) error {
 		var err error
 
 		permitted, stateID := w.AcquirePermission()
 		if !permitted {
+			atomic.AddUint64(&w.shortCircuits, 1)
 			return ErrShortCircuited
 		}
 
@@ -124,11 +145,15 @@ func (w circuitBreakerWrapper) Wrap(handler HandlerFunc) HandlerFunc {
 		panicked := true
 		defer func() {
 			if panicked {
+				atomic.AddUint64(&w.failures, 1)
 				w.RecordResult(stateID, true, time.Since(start))
 			}
 		}()
 
 		err = handler(ctx)
+		if err != nil {
+			atomic.AddUint64(&w.failures, 1)
+		}
 		w.RecordResult(stateID, err != nil, time.Since(start))
 
 		panicked = false
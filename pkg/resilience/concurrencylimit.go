@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/fasttime"
+)
+
+// ConcurrencyLimitKind is the kind of ConcurrencyLimit.
+var ConcurrencyLimitKind = &Kind{
+	Name: "ConcurrencyLimit",
+
+	DefaultPolicy: func() Policy {
+		return &ConcurrencyLimitPolicy{
+			InitialLimit: 20,
+			MinLimit:     1,
+			MaxLimit:     1000,
+			Smoothing:    0.2,
+			RTTTolerance: 1.5,
+			LongWindow:   600,
+		}
+	},
+}
+
+var _ Policy = (*ConcurrencyLimitPolicy)(nil)
+
+// ConcurrencyLimitPolicy defines the adaptive concurrency limit policy.
+//
+// The limit is adjusted by the gradient of the long-term average latency
+// to the short-term one, that's, it decreases when the latency increases
+// and increases when the latency is stable, requests exceeding the limit
+// are short circuited.
+type ConcurrencyLimitPolicy struct {
+	BaseSpec     `yaml:",inline"`
+	InitialLimit int     `yaml:"initialLimit" jsonschema:"omitempty,minimum=1"`
+	MinLimit     int     `yaml:"minLimit" jsonschema:"omitempty,minimum=1"`
+	MaxLimit     int     `yaml:"maxLimit" jsonschema:"omitempty,minimum=1"`
+	Smoothing    float64 `yaml:"smoothing" jsonschema:"omitempty,minimum=0,maximum=1"`
+	RTTTolerance float64 `yaml:"rttTolerance" jsonschema:"omitempty,minimum=1"`
+	LongWindow   int     `yaml:"longWindow" jsonschema:"omitempty,minimum=1"`
+}
+
+// ConcurrencyLimitStatus is the status of a concurrency limiter.
+type ConcurrencyLimitStatus struct {
+	Limit    int `yaml:"limit" json:"limit"`
+	Inflight int `yaml:"inflight" json:"inflight"`
+}
+
+// Validate validates the ConcurrencyLimitPolicy.
+func (p *ConcurrencyLimitPolicy) Validate() error {
+	// TODO
+	return nil
+}
+
+// CreateWrapper creates a Wrapper.
+func (p *ConcurrencyLimitPolicy) CreateWrapper() Wrapper {
+	def := ConcurrencyLimitKind.DefaultPolicy().(*ConcurrencyLimitPolicy)
+
+	l := &ConcurrencyLimiter{
+		minLimit:   float64(p.MinLimit),
+		maxLimit:   float64(p.MaxLimit),
+		smoothing:  p.Smoothing,
+		tolerance:  p.RTTTolerance,
+		longWindow: float64(p.LongWindow),
+	}
+	if l.minLimit <= 0 {
+		l.minLimit = float64(def.MinLimit)
+	}
+	if l.maxLimit < l.minLimit {
+		l.maxLimit = math.Max(float64(def.MaxLimit), l.minLimit)
+	}
+	if l.smoothing <= 0 {
+		l.smoothing = def.Smoothing
+	}
+	if l.tolerance < 1 {
+		l.tolerance = def.RTTTolerance
+	}
+	if l.longWindow <= 0 {
+		l.longWindow = float64(def.LongWindow)
+	}
+
+	l.limit = float64(p.InitialLimit)
+	if l.limit <= 0 {
+		l.limit = float64(def.InitialLimit)
+	}
+	l.limit = math.Min(math.Max(l.limit, l.minLimit), l.maxLimit)
+	return l
+}
+
+// ConcurrencyLimiter limits the number of concurrent calls with a limit
+// adjusted by the observed latency.
+type ConcurrencyLimiter struct {
+	lock     sync.Mutex
+	inflight int
+	limit    float64
+
+	minLimit   float64
+	maxLimit   float64
+	smoothing  float64
+	tolerance  float64
+	longWindow float64
+
+	// shortRTT is the latency of the latest call, longRTT is the
+	// exponential moving average of the latency of the calls in the
+	// long window.
+	shortRTT float64
+	longRTT  float64
+	samples  float64
+}
+
+// Wrap wraps the handler function.
+func (l *ConcurrencyLimiter) Wrap(handler HandlerFunc) HandlerFunc {
+	return func(ctx context.Context) error {
+		if !l.acquire() {
+			return ErrShortCircuited
+		}
+
+		start := fasttime.Now()
+		defer func() {
+			l.release(fasttime.Since(start))
+		}()
+
+		return handler(ctx)
+	}
+}
+
+// Status returns the status of the concurrency limiter.
+func (l *ConcurrencyLimiter) Status() *ConcurrencyLimitStatus {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return &ConcurrencyLimitStatus{Limit: int(l.limit), Inflight: l.inflight}
+}
+
+func (l *ConcurrencyLimiter) acquire() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(rtt time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	inflight := l.inflight
+	l.inflight--
+	l.observe(float64(rtt), inflight)
+}
+
+// observe updates the limit with the latency of a call, inflight is the
+// number of in-flight calls when the call completes, including itself.
+// The caller must hold the lock.
+func (l *ConcurrencyLimiter) observe(rtt float64, inflight int) {
+	if rtt <= 0 {
+		rtt = 1
+	}
+
+	l.shortRTT = rtt
+	if l.samples < l.longWindow {
+		// use the simple average until the window is full, so that
+		// the first samples don't get too much weight.
+		l.samples++
+		l.longRTT += (rtt - l.longRTT) / l.samples
+	} else {
+		l.longRTT += (rtt - l.longRTT) * 2 / (l.longWindow + 1)
+	}
+
+	// the long-term latency may drift up if the latency keeps
+	// increasing, pull it back faster to recover from the overload.
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	// don't increase the limit if it is not fully used, as the latency
+	// tells nothing about a higher concurrency.
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.tolerance*l.longRTT/l.shortRTT))
+	queueSize := math.Sqrt(l.limit)
+	newLimit := l.limit*gradient + queueSize
+	newLimit = l.limit*(1-l.smoothing) + newLimit*l.smoothing
+	l.limit = math.Min(math.Max(newLimit, l.minLimit), l.maxLimit)
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyLimitPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy, err := NewPolicy(map[string]interface{}{
+		"name":     "limit",
+		"kind":     "ConcurrencyLimit",
+		"maxLimit": 50,
+	})
+	assert.NoError(err)
+	l := policy.CreateWrapper().(*ConcurrencyLimiter)
+	assert.Equal(20, l.Status().Limit)
+	assert.Equal(float64(50), l.maxLimit)
+	assert.Equal(0.2, l.smoothing)
+
+	// invalid values fall back to defaults
+	l = (&ConcurrencyLimitPolicy{InitialLimit: 100, MaxLimit: 10}).CreateWrapper().(*ConcurrencyLimiter)
+	assert.Equal(float64(1), l.minLimit)
+	assert.Equal(float64(10), l.maxLimit)
+	assert.Equal(10, l.Status().Limit)
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	newLimiter := func() *ConcurrencyLimiter {
+		p := ConcurrencyLimitKind.DefaultPolicy().(*ConcurrencyLimitPolicy)
+		p.InitialLimit = 20
+		p.MaxLimit = 100
+		p.LongWindow = 50
+		return p.CreateWrapper().(*ConcurrencyLimiter)
+	}
+
+	// the limit grows while the latency is stable and the limit is used.
+	l := newLimiter()
+	for i := 0; i < 100; i++ {
+		l.observe(float64(10*time.Millisecond), int(l.limit))
+	}
+	assert.Greater(l.Status().Limit, 20)
+	grown := l.Status().Limit
+
+	// the limit does not grow if it is not used.
+	l2 := newLimiter()
+	for i := 0; i < 100; i++ {
+		l2.observe(float64(10*time.Millisecond), 1)
+	}
+	assert.Equal(20, l2.Status().Limit)
+
+	// the limit drops when the latency increases.
+	for i := 0; i < 20; i++ {
+		l.observe(float64(100*time.Millisecond), int(l.limit))
+	}
+	dropped := l.Status().Limit
+	assert.Less(dropped, grown/2)
+	assert.GreaterOrEqual(dropped, 1)
+
+	// and recovers once the latency is back to normal.
+	for i := 0; i < 200; i++ {
+		l.observe(float64(10*time.Millisecond), int(l.limit))
+	}
+	assert.Greater(l.Status().Limit, dropped)
+}
+
+func TestConcurrencyLimiterWrap(t *testing.T) {
+	assert := assert.New(t)
+
+	l := (&ConcurrencyLimitPolicy{InitialLimit: 2, MinLimit: 1, MaxLimit: 2}).CreateWrapper().(*ConcurrencyLimiter)
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 2)
+	handler := l.Wrap(func(ctx context.Context) error {
+		started <- struct{}{}
+		<-block
+		return nil
+	})
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- handler(context.Background()) }()
+	}
+	<-started
+	<-started
+	assert.Equal(2, l.Status().Inflight)
+
+	// requests exceeding the limit are short circuited.
+	assert.Equal(ErrShortCircuited, handler(context.Background()))
+
+	close(block)
+	assert.NoError(<-done)
+	assert.NoError(<-done)
+	assert.Equal(0, l.Status().Inflight)
+	assert.NoError(handler(context.Background()))
+}
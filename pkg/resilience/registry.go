@@ -19,8 +19,9 @@ package resilience
 
 // kinds is the resilience kind registry.
 var kinds = map[string]*Kind{
-	CircuitBreakerKind.Name: CircuitBreakerKind,
-	RetryKind.Name:          RetryKind,
+	CircuitBreakerKind.Name:   CircuitBreakerKind,
+	ConcurrencyLimitKind.Name: ConcurrencyLimitKind,
+	RetryKind.Name:            RetryKind,
 }
 
 // WalkKind walks the registry, calling fn for each filter kind, and stops
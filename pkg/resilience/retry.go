@@ -20,6 +20,7 @@ package resilience
 import (
 	"context"
 	"math/rand"
+	"sync/atomic"
 	"time"
 )
 
@@ -48,13 +49,43 @@ type RetryPolicy struct {
 	RandomizationFactor float64 `yaml:"randomizationFactor" jsonschema:"omitempty,minimum=0,maximum=1"`
 }
 
+// RetryStatus is the status of a retryer.
+type RetryStatus struct {
+	// Retries is the number of retried attempts.
+	Retries uint64 `yaml:"retries" json:"retries"`
+	// Exhausted is the number of calls still failed after all attempts.
+	Exhausted uint64 `yaml:"exhausted" json:"exhausted"`
+}
+
+// NonRetriableError wraps an error to stop the retry wrapper from
+// retrying the call, the wrapper returns the wrapped error.
+type NonRetriableError struct {
+	Err error
+}
+
+// Error implements error.
+func (e *NonRetriableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *NonRetriableError) Unwrap() error {
+	return e.Err
+}
+
+type retryWrapper struct {
+	*RetryPolicy
+	retries   uint64
+	exhausted uint64
+}
+
 // Validate validates the retry policy.
 func (p *RetryPolicy) Validate() error {
 	// TODO
 	return nil
 }
 
-// CreateWrapper creates a Wrapper. For the RetryPolicy, just reuse itself.
+// CreateWrapper creates a Wrapper.
 func (p *RetryPolicy) CreateWrapper() Wrapper {
 	if d := p.WaitDuration; d != "" {
 		p.waitDuration, _ = time.ParseDuration(d)
@@ -62,20 +93,36 @@ func (p *RetryPolicy) CreateWrapper() Wrapper {
 	if p.waitDuration <= 0 {
 		p.waitDuration = time.Millisecond * 500
 	}
-	return p
+	return &retryWrapper{RetryPolicy: p}
+}
+
+// Status returns the status of the retryer, the counters are accumulated
+// since the wrapper was created.
+func (w *retryWrapper) Status() *RetryStatus {
+	return &RetryStatus{
+		Retries:   atomic.LoadUint64(&w.retries),
+		Exhausted: atomic.LoadUint64(&w.exhausted),
+	}
 }
 
 // Wrap wraps the handler function.
-func (p *RetryPolicy) Wrap(handler HandlerFunc) HandlerFunc {
+func (w *retryWrapper) Wrap(handler HandlerFunc) HandlerFunc {
+	p := w.RetryPolicy
 	return func(ctx context.Context) error {
 		var err error
 		base := float64(p.waitDuration)
 
 		for attempt := 0; attempt < p.MaxAttempts; attempt++ {
+			if attempt > 0 {
+				atomic.AddUint64(&w.retries, 1)
+			}
 			err = handler(ctx)
 			if err == nil {
 				return nil
 			}
+			if nre, ok := err.(*NonRetriableError); ok {
+				return nre.Err
+			}
 
 			delta := base * p.RandomizationFactor
 			d := base - delta + float64(rand.Intn(int(delta*2+1)))
@@ -90,6 +137,7 @@ func (p *RetryPolicy) Wrap(handler HandlerFunc) HandlerFunc {
 			}
 		}
 
+		atomic.AddUint64(&w.exhausted, 1)
 		return err
 	}
 }
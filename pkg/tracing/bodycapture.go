@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultCaptureMaxBytes = 1024
+	redactedValue          = "[REDACTED]"
+)
+
+type (
+	// BodyCaptureSpec describes the capture of HTTP headers and bodies
+	// into spans.
+	BodyCaptureSpec struct {
+		MaxBytes      int      `yaml:"maxBytes,omitempty" jsonschema:"omitempty,minimum=1"`
+		RedactHeaders []string `yaml:"redactHeaders" jsonschema:"omitempty"`
+		RedactFields  []string `yaml:"redactFields" jsonschema:"omitempty"`
+	}
+
+	bodyCapturer struct {
+		maxBytes int
+		headers  map[string]struct{}
+		fields   map[string]struct{}
+	}
+)
+
+// defaultRedactHeaders are always redacted.
+var defaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+func newBodyCapturer(spec *BodyCaptureSpec) *bodyCapturer {
+	if spec == nil {
+		return nil
+	}
+
+	c := &bodyCapturer{
+		maxBytes: spec.MaxBytes,
+		headers:  map[string]struct{}{},
+		fields:   map[string]struct{}{},
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultCaptureMaxBytes
+	}
+	for _, h := range defaultRedactHeaders {
+		c.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, h := range spec.RedactHeaders {
+		c.headers[http.CanonicalHeaderKey(h)] = struct{}{}
+	}
+	for _, f := range spec.RedactFields {
+		c.fields[strings.ToLower(f)] = struct{}{}
+	}
+	return c
+}
+
+// capture records the headers and body in the span with tags prefixed
+// by prefix, the body is nil if it is a stream.
+func (c *bodyCapturer) capture(s *span, prefix string, header http.Header, body []byte) {
+	s.Tag(prefix+".headers", c.captureHeader(header))
+
+	if body == nil {
+		s.Tag(prefix+".body", "[stream]")
+		return
+	}
+
+	body, truncated := c.truncate(c.redactBody(header.Get("Content-Type"), body))
+	s.Tag(prefix+".body", string(body))
+	if truncated {
+		s.Tag(prefix+".body.truncated", "true")
+	}
+}
+
+func (c *bodyCapturer) captureHeader(header http.Header) string {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		redacted := false
+		if _, ok := c.headers[http.CanonicalHeaderKey(k)]; ok {
+			redacted = true
+		}
+		for _, v := range header[k] {
+			if redacted {
+				v = redactedValue
+			}
+			sb.WriteString(k)
+			sb.WriteString(": ")
+			sb.WriteString(v)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// redactBody redacts the fields of JSON and form bodies, other bodies
+// are returned as is.
+func (c *bodyCapturer) redactBody(contentType string, body []byte) []byte {
+	if len(c.fields) == 0 || len(body) == 0 {
+		return body
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/x-www-form-urlencoded" {
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return []byte(redactedValue)
+		}
+		for k, vs := range values {
+			if _, ok := c.fields[strings.ToLower(k)]; ok {
+				for i := range vs {
+					vs[i] = redactedValue
+				}
+			}
+		}
+		return []byte(values.Encode())
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		// the body is not JSON, but if it claims to be, it may still
+		// contain the fields, so don't take the risk.
+		if strings.HasPrefix(mediaType, "application/json") || strings.HasSuffix(mediaType, "+json") {
+			return []byte(redactedValue)
+		}
+		return body
+	}
+
+	v = c.redactJSON(v)
+	result, err := json.Marshal(v)
+	if err != nil {
+		return []byte(redactedValue)
+	}
+	return result
+}
+
+func (c *bodyCapturer) redactJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, fv := range x {
+			if _, ok := c.fields[strings.ToLower(k)]; ok {
+				x[k] = redactedValue
+			} else {
+				x[k] = c.redactJSON(fv)
+			}
+		}
+	case []interface{}:
+		for i, e := range x {
+			x[i] = c.redactJSON(e)
+		}
+	}
+	return v
+}
+
+// truncate truncates the body to at most maxBytes bytes without breaking
+// a UTF-8 character.
+func (c *bodyCapturer) truncate(body []byte) ([]byte, bool) {
+	if len(body) <= c.maxBytes {
+		return body, false
+	}
+	n := c.maxBytes
+	for n > 0 && !utf8.RuneStart(body[n]) {
+		n--
+	}
+	return body[:n], true
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyCapturer(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newBodyCapturer(nil))
+
+	c := newBodyCapturer(&BodyCaptureSpec{})
+	assert.Equal(defaultCaptureMaxBytes, c.maxBytes)
+
+	c = newBodyCapturer(&BodyCaptureSpec{
+		MaxBytes:      10,
+		RedactHeaders: []string{"x-api-key"},
+		RedactFields:  []string{"password", "Token"},
+	})
+
+	// headers
+	header := http.Header{}
+	header.Set("X-Api-Key", "secret")
+	header.Set("Authorization", "Bearer secret")
+	header.Set("Content-Type", "application/json")
+	assert.Equal("Authorization: [REDACTED]\nContent-Type: application/json\nX-Api-Key: [REDACTED]\n", c.captureHeader(header))
+
+	// JSON bodies
+	body := `{"user":"megaease","password":"secret","nested":[{"token":"secret","id":12345678901234567890}]}`
+	redacted := string(c.redactBody("application/json", []byte(body)))
+	assert.NotContains(redacted, "secret")
+	assert.Equal(`{"nested":[{"id":12345678901234567890,"token":"[REDACTED]"}],"password":"[REDACTED]","user":"megaease"}`, redacted)
+
+	// invalid JSON body claimed to be JSON
+	assert.Equal(redactedValue, string(c.redactBody("application/json; charset=utf-8", []byte(`{"password":"secret"`))))
+
+	// form bodies
+	redacted = string(c.redactBody("application/x-www-form-urlencoded", []byte("user=megaease&password=secret")))
+	assert.Equal("password=%5BREDACTED%5D&user=megaease", redacted)
+
+	// other bodies are not changed
+	assert.Equal("password=secret", string(c.redactBody("text/plain", []byte("password=secret"))))
+
+	// no redaction rules
+	c2 := newBodyCapturer(&BodyCaptureSpec{})
+	assert.Equal(body, string(c2.redactBody("application/json", []byte(body))))
+
+	// truncation
+	b, truncated := c.truncate([]byte("0123456789"))
+	assert.Equal("0123456789", string(b))
+	assert.False(truncated)
+	b, truncated = c.truncate([]byte("0123456789abc"))
+	assert.Equal("0123456789", string(b))
+	assert.True(truncated)
+	// never break a UTF-8 character
+	b, truncated = c.truncate([]byte("012345678" + "世界"))
+	assert.Equal("012345678", string(b))
+	assert.True(truncated)
+	assert.LessOrEqual(len(b), 10)
+
+	// redaction is applied before truncation
+	c.maxBytes = 1000
+	b, _ = c.truncate(c.redactBody("application/json", []byte(`{"password":"`+strings.Repeat("s", 2000)+`"}`)))
+	assert.Equal(`{"password":"[REDACTED]"}`, string(b))
+}
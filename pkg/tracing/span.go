@@ -43,11 +43,24 @@ type (
 
 		// InjectHTTP injects span context into an HTTP request.
 		InjectHTTP(r *http.Request)
+
+		// CaptureHTTPRequest records the headers and body of an HTTP
+		// request in the span if body capture is enabled, body should be
+		// nil if it is a stream.
+		CaptureHTTPRequest(header http.Header, body []byte)
+
+		// CaptureHTTPResponse is the same as CaptureHTTPRequest, but for
+		// an HTTP response.
+		CaptureHTTPResponse(header http.Header, body []byte)
 	}
 
 	span struct {
 		zipkingo.Span
 		tracer *Tracer
+
+		// traceState is the vendor specific trace data received in the
+		// tracestate header, which is propagated without modification.
+		traceState string
 	}
 )
 
@@ -86,13 +99,43 @@ func (s *span) newChildWithStart(name string, startAt time.Time) Span {
 		zipkingo.StartTime(startAt))
 
 	return &span{
-		tracer: s.tracer,
-		Span:   child,
+		tracer:     s.tracer,
+		Span:       child,
+		traceState: s.traceState,
 	}
 }
 
-// InjectHTTP injects span context into an HTTP request.
+// InjectHTTP injects span context into an HTTP request, as W3C trace
+// context headers, and B3 headers if B3 propagation is enabled.
 func (s *span) InjectHTTP(r *http.Request) {
-	inject := b3.InjectHTTP(r, b3.WithSingleHeaderOnly())
-	inject(s.Context())
+	if s.IsNoop() {
+		return
+	}
+
+	sc := s.Context()
+	r.Header.Set(TraceParentHeader, formatTraceParent(sc))
+	if s.traceState != "" {
+		r.Header.Set(TraceStateHeader, s.traceState)
+	} else {
+		r.Header.Del(TraceStateHeader)
+	}
+
+	if s.tracer.b3 {
+		inject := b3.InjectHTTP(r, b3.WithSingleHeaderOnly())
+		inject(sc)
+	}
+}
+
+// CaptureHTTPRequest records the headers and body of an HTTP request.
+func (s *span) CaptureHTTPRequest(header http.Header, body []byte) {
+	if !s.IsNoop() && s.tracer.capturer != nil {
+		s.tracer.capturer.capture(s, "http.request", header, body)
+	}
+}
+
+// CaptureHTTPResponse records the headers and body of an HTTP response.
+func (s *span) CaptureHTTPResponse(header http.Header, body []byte) {
+	if !s.IsNoop() && s.tracer.capturer != nil {
+		s.tracer.capturer.capture(s, "http.response", header, body)
+	}
 }
@@ -19,6 +19,7 @@ package tracing
 
 import (
 	"io"
+	"net/http"
 	"time"
 
 	"github.com/megaease/easegress/pkg/util/fasttime"
@@ -32,6 +33,15 @@ type (
 		ServiceName string            `yaml:"serviceName" jsonschema:"required"`
 		Tags        map[string]string `yaml:"tags" jsonschema:"omitempty"`
 		Zipkin      *ZipkinSpec       `yaml:"zipkin" jsonschema:"required"`
+
+		// B3Propagation enables the propagation of the legacy B3 headers
+		// in addition to the W3C trace context, it is enabled if not
+		// specified to keep the backends relying on B3 working.
+		B3Propagation *bool `yaml:"b3Propagation,omitempty" jsonschema:"omitempty"`
+
+		// BodyCapture enables the capture of HTTP headers and bodies
+		// sent to and received from backends in spans.
+		BodyCapture *BodyCaptureSpec `yaml:"bodyCapture,omitempty" jsonschema:"omitempty"`
 	}
 
 	// ZipkinSpec describes Zipkin.
@@ -45,9 +55,11 @@ type (
 
 	// Tracer is the tracer.
 	Tracer struct {
-		tracer *zipkingo.Tracer
-		tags   map[string]string
-		closer io.Closer
+		tracer   *zipkingo.Tracer
+		tags     map[string]string
+		closer   io.Closer
+		b3       bool
+		capturer *bodyCapturer
 	}
 
 	noopCloser struct{}
@@ -105,8 +117,10 @@ func New(spec *Spec) (*Tracer, error) {
 	}
 
 	return &Tracer{
-		tracer: tracer,
-		closer: reporter,
+		tracer:   tracer,
+		closer:   reporter,
+		b3:       spec.B3Propagation == nil || *spec.B3Propagation,
+		capturer: newBodyCapturer(spec.BodyCapture),
 	}, nil
 }
 
@@ -140,6 +154,24 @@ func (t *Tracer) NewSpanWithStart(name string, startAt time.Time) Span {
 	return t.newSpanWithStart(name, startAt)
 }
 
+// NewSpanForHTTP creates a span for an inbound HTTP request with specify
+// start time. The span continues the trace carried by the W3C trace
+// context headers of the request, or the B3 headers if B3 propagation is
+// enabled, and starts a new trace if there is none.
+func (t *Tracer) NewSpanForHTTP(name string, startAt time.Time, r *http.Request) Span {
+	if t.IsNoopTracer() {
+		return NoopSpan
+	}
+
+	parent, traceState := extractHTTP(r, t.b3)
+	if parent == nil {
+		return t.newSpanWithStart(name, startAt)
+	}
+
+	s := t.tracer.StartSpan(name, zipkingo.Parent(*parent), zipkingo.StartTime(startAt))
+	return &span{Span: s, tracer: t, traceState: traceState}
+}
+
 func (t *Tracer) newSpanWithStart(name string, startAt time.Time) Span {
 	s := t.tracer.StartSpan(name, zipkingo.StartTime(startAt))
 	return &span{Span: s, tracer: t}
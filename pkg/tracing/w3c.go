@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+)
+
+// Header names of the W3C trace context, see https://www.w3.org/TR/trace-context/.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+)
+
+// parseTraceParent parses the value of a traceparent header.
+func parseTraceParent(value string) (model.SpanContext, bool) {
+	var sc model.SpanContext
+
+	// version-traceid-parentid-flags, future versions may append more
+	// fields after the flags.
+	fields := strings.Split(strings.TrimSpace(value), "-")
+	if len(fields) < 4 {
+		return sc, false
+	}
+	version, traceID, spanID, flags := fields[0], fields[1], fields[2], fields[3]
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) {
+		return sc, false
+	}
+	if version == "00" && len(fields) != 4 {
+		return sc, false
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) || strings.Count(traceID, "0") == 32 {
+		return sc, false
+	}
+	if len(spanID) != 16 || !isLowerHex(spanID) || strings.Count(spanID, "0") == 16 {
+		return sc, false
+	}
+	if len(flags) != 2 || !isLowerHex(flags) {
+		return sc, false
+	}
+
+	tid, err := model.TraceIDFromHex(traceID)
+	if err != nil {
+		return sc, false
+	}
+	sid, err := strconv.ParseUint(spanID, 16, 64)
+	if err != nil {
+		return sc, false
+	}
+	f, _ := strconv.ParseUint(flags, 16, 8)
+	sampled := f&0x01 == 0x01
+
+	sc.TraceID = tid
+	sc.ID = model.ID(sid)
+	sc.Sampled = &sampled
+	return sc, true
+}
+
+// formatTraceParent formats the span context as the value of a
+// traceparent header.
+func formatTraceParent(sc model.SpanContext) string {
+	flags := "00"
+	if sc.Sampled != nil && *sc.Sampled || sc.Debug {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%016x%016x-%016x-%s", sc.TraceID.High, sc.TraceID.Low, uint64(sc.ID), flags)
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// extractHTTP extracts the span context and trace state from the W3C
+// trace context headers of the request, and from the B3 headers if useB3
+// is true and there's no W3C trace context.
+func extractHTTP(r *http.Request, useB3 bool) (*model.SpanContext, string) {
+	if sc, ok := parseTraceParent(r.Header.Get(TraceParentHeader)); ok {
+		return &sc, r.Header.Get(TraceStateHeader)
+	}
+	if useB3 {
+		if sc, err := b3.ExtractHTTP(r)(); err == nil && sc != nil {
+			return sc, ""
+		}
+	}
+	return nil, ""
+}
@@ -306,6 +306,11 @@ var stateStrings = []string{
 	"ForceOpen",
 }
 
+// String returns the name of the state
+func (s State) String() string {
+	return stateStrings[s]
+}
+
 // NewPolicy create and initialize a policy
 func NewPolicy(failureRateThreshold, slowCallRateThreshold, slidingWindowType uint8,
 	slidingWindowSize, permittedNumberOfCallsInHalfOpen, minimumNumberOfCalls uint32,
@@ -388,6 +393,8 @@ func (cb *CircuitBreaker) transitTo(state State, reason string) {
 
 // State returns the state of the circuit breaker
 func (cb *CircuitBreaker) State() State {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
 	return cb.state
 }
 
@@ -21,8 +21,11 @@ import (
 	"context"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	sem2 "github.com/megaease/easegress/pkg/util/sem"
+	"golang.org/x/time/rate"
 )
 
 // NewLimitListener returns a Listener that accepts at most n simultaneous
@@ -40,40 +43,168 @@ func NewLimitListener(l net.Listener, n uint32) *LimitListener {
 
 // LimitListener is the Listener to limit connections.
 type LimitListener struct {
+	// the counters are accessed atomically, keep them at the beginning
+	// of the struct for the 64-bit alignment on 32-bit platforms.
+	active       int64
+	accepted     uint64
+	limitReached uint64
+	rejected     uint64
+	rateLimited  uint64
+
 	net.Listener
+	rejecter  atomic.Value // rejecter
+	limiter   atomic.Value // acceptLimiter
 	sem       *sem2.Semaphore
 	ctx       context.Context
 	cancel    context.CancelFunc
 	closeOnce sync.Once // ensures the done chan is only closed once
 }
 
-// acquire acquires the limiting semaphore. Returns true if successfully
-// accquired, false if the listener is closed and the semaphore is not
-// acquired.
-func (l *LimitListener) acquire() bool {
-	return l.sem.AcquireWithContext(l.ctx) == nil
+// Status is the connection statistics of LimitListener.
+type Status struct {
+	// Active is the number of connections currently open.
+	Active int64 `yaml:"active" json:"active"`
+	// Accepted is the total number of accepted connections.
+	Accepted uint64 `yaml:"accepted" json:"accepted"`
+	// LimitReached is the number of times the connection limit was
+	// reached when accepting a connection, Accept waits for a connection
+	// to be closed, or the new connection is rejected if there's a
+	// rejecter.
+	LimitReached uint64 `yaml:"limitReached" json:"limitReached"`
+	// Rejected is the number of connections passed to the rejecter.
+	Rejected uint64 `yaml:"rejected" json:"rejected"`
+	// RateLimited is the number of accepts delayed by the accept rate
+	// limit.
+	RateLimited uint64 `yaml:"rateLimited" json:"rateLimited"`
+}
+
+// rejecter wraps the reject function, so that it could be stored in an
+// atomic.Value even if it is nil.
+type rejecter struct {
+	fn func(net.Conn)
+}
+
+// acceptLimiter wraps the rate limiter of accepts, so that it could be
+// stored in an atomic.Value even if it is nil.
+type acceptLimiter struct {
+	limiter *rate.Limiter
 }
 
 func (l *LimitListener) release() {
 	l.sem.Release()
 }
 
+func (l *LimitListener) closeConn() {
+	atomic.AddInt64(&l.active, -1)
+	l.release()
+}
+
+// SetRejecter sets the function to handle the connections beyond the
+// limit, it must close the connection. If it is nil, which is the
+// default, Accept waits for a connection to be closed when the limit is
+// reached.
+func (l *LimitListener) SetRejecter(fn func(net.Conn)) {
+	l.rejecter.Store(rejecter{fn: fn})
+}
+
+func (l *LimitListener) getRejecter() func(net.Conn) {
+	r, _ := l.rejecter.Load().(rejecter)
+	return r.fn
+}
+
+// SetAcceptRate limits the rate of accepting connections with a token
+// bucket, perSecond is the number of connections accepted per second and
+// burst defaults to perSecond if it is zero. Accepts beyond the rate are
+// delayed, the pending connections stay in the backlog of the kernel. A
+// zero perSecond removes the limit, which is the default.
+func (l *LimitListener) SetAcceptRate(perSecond, burst uint32) {
+	if perSecond == 0 {
+		l.limiter.Store(acceptLimiter{})
+		return
+	}
+
+	if burst == 0 {
+		burst = perSecond
+	}
+	l.limiter.Store(acceptLimiter{
+		limiter: rate.NewLimiter(rate.Limit(perSecond), int(burst)),
+	})
+}
+
+func (l *LimitListener) getAcceptLimiter() *rate.Limiter {
+	al, _ := l.limiter.Load().(acceptLimiter)
+	return al.limiter
+}
+
+// waitAcceptRate waits until the next accept is allowed by the accept
+// rate limit, or the listener is closed.
+func (l *LimitListener) waitAcceptRate() error {
+	limiter := l.getAcceptLimiter()
+	if limiter == nil {
+		return nil
+	}
+
+	r := limiter.Reserve()
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	atomic.AddUint64(&l.rateLimited, 1)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-l.ctx.Done():
+		r.Cancel()
+		return l.ctx.Err()
+	}
+}
+
 // Accept accepts one connection.
 func (l *LimitListener) Accept() (net.Conn, error) {
-	acquired := l.acquire()
-	if err := l.ctx.Err(); err != nil {
-		if acquired {
-			l.release()
+	for {
+		acquired := l.sem.TryAcquire()
+		reject := l.getRejecter()
+		if !acquired {
+			atomic.AddUint64(&l.limitReached, 1)
+			if reject == nil {
+				acquired = l.sem.AcquireWithContext(l.ctx) == nil
+			}
+		}
+
+		err := l.ctx.Err()
+		if err == nil {
+			err = l.waitAcceptRate()
+		}
+		if err != nil {
+			if acquired {
+				l.release()
+			}
+			return nil, err
 		}
-		return nil, err
-	}
 
-	c, err := l.Listener.Accept()
-	if err != nil {
-		l.release()
-		return nil, err
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if acquired {
+				l.release()
+			}
+			return nil, err
+		}
+
+		// a connection may have been closed while waiting for the new one.
+		if !acquired && !l.sem.TryAcquire() {
+			atomic.AddUint64(&l.rejected, 1)
+			go reject(c)
+			continue
+		}
+
+		atomic.AddUint64(&l.accepted, 1)
+		atomic.AddInt64(&l.active, 1)
+		return &limitListenerConn{Conn: c, release: l.closeConn}, nil
 	}
-	return &limitListenerConn{Conn: c, release: l.release}, nil
 }
 
 // SetMaxConnection sets max connection.
@@ -81,6 +212,17 @@ func (l *LimitListener) SetMaxConnection(n uint32) {
 	l.sem.SetMaxCount(int64(n))
 }
 
+// Status returns the connection statistics of LimitListener.
+func (l *LimitListener) Status() *Status {
+	return &Status{
+		Active:       atomic.LoadInt64(&l.active),
+		Accepted:     atomic.LoadUint64(&l.accepted),
+		LimitReached: atomic.LoadUint64(&l.limitReached),
+		Rejected:     atomic.LoadUint64(&l.rejected),
+		RateLimited:  atomic.LoadUint64(&l.rateLimited),
+	}
+}
+
 // Close closes LimitListener.
 func (l *LimitListener) Close() error {
 	err := l.Listener.Close()
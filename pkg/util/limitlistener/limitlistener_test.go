@@ -0,0 +1,239 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package limitlistener
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pipeListener is a net.Listener whose connections are created by net.Pipe.
+type pipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (pl *pipeListener) dial() {
+	c, _ := net.Pipe()
+	pl.conns <- c
+}
+
+func (pl *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-pl.conns:
+		return c, nil
+	case <-pl.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (pl *pipeListener) Close() error {
+	close(pl.done)
+	return nil
+}
+
+func (pl *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func TestLimitListener(t *testing.T) {
+	assert := assert.New(t)
+
+	pl := newPipeListener()
+	l := NewLimitListener(pl, 2)
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	pl.dial()
+	pl.dial()
+	c1, c2 := <-conns, <-conns
+	status := l.Status()
+	assert.Equal(int64(2), status.Active)
+	assert.Equal(uint64(2), status.Accepted)
+
+	// the limit is reached, Accept waits for a connection to be closed.
+	assert.Eventually(func() bool {
+		return l.Status().LimitReached == 1
+	}, time.Second, 10*time.Millisecond)
+
+	go pl.dial()
+	select {
+	case <-conns:
+		assert.Fail("connection accepted beyond the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the third connection is accepted after the first one is closed.
+	c1.Close()
+	c1.Close()
+	c3 := <-conns
+	status = l.Status()
+	assert.Equal(int64(2), status.Active)
+	assert.Equal(uint64(3), status.Accepted)
+	assert.Eventually(func() bool {
+		return l.Status().LimitReached == 2
+	}, time.Second, 10*time.Millisecond)
+
+	c2.Close()
+	c3.Close()
+	assert.Equal(int64(0), l.Status().Active)
+
+	l.Close()
+	_, ok := <-conns
+	assert.False(ok)
+}
+
+func TestLimitListenerRejecter(t *testing.T) {
+	assert := assert.New(t)
+
+	pl := newPipeListener()
+	l := NewLimitListener(pl, 1)
+	defer l.Close()
+
+	rejected := make(chan net.Conn, 10)
+	l.SetRejecter(func(c net.Conn) {
+		rejected <- c
+		c.Close()
+	})
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	pl.dial()
+	c1 := <-conns
+
+	// connections beyond the limit are rejected instead of waiting.
+	pl.dial()
+	pl.dial()
+	<-rejected
+	<-rejected
+	status := l.Status()
+	assert.Equal(int64(1), status.Active)
+	assert.Equal(uint64(1), status.Accepted)
+	assert.Equal(uint64(2), status.Rejected)
+
+	// new connections are accepted after the first one is closed.
+	c1.Close()
+	pl.dial()
+	c2 := <-conns
+	defer c2.Close()
+	assert.Equal(uint64(2), l.Status().Accepted)
+	assert.Equal(uint64(2), l.Status().Rejected)
+}
+
+func TestLimitListenerAcceptRate(t *testing.T) {
+	assert := assert.New(t)
+
+	pl := newPipeListener()
+	l := NewLimitListener(pl, 100)
+	l.SetAcceptRate(20, 2)
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	// the burst is accepted immediately, and the others are accepted
+	// at 20 connections per second.
+	start := time.Now()
+	go func() {
+		for i := 0; i < 6; i++ {
+			pl.dial()
+		}
+	}()
+	for i := 0; i < 6; i++ {
+		c := <-conns
+		defer c.Close()
+	}
+	assert.GreaterOrEqual(time.Since(start), 150*time.Millisecond)
+	assert.GreaterOrEqual(l.Status().RateLimited, uint64(4))
+	assert.Equal(uint64(6), l.Status().Accepted)
+
+	l.Close()
+	_, ok := <-conns
+	assert.False(ok)
+}
+
+func TestLimitListenerAcceptRateClose(t *testing.T) {
+	assert := assert.New(t)
+
+	pl := newPipeListener()
+	l := NewLimitListener(pl, 100)
+	l.SetAcceptRate(1, 1)
+
+	conns := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				close(conns)
+				return
+			}
+			conns <- c
+		}
+	}()
+
+	pl.dial()
+	c := <-conns
+	defer c.Close()
+
+	// Accept is waiting for the rate limit, it returns when the listener
+	// is closed.
+	assert.Eventually(func() bool {
+		return l.Status().RateLimited == 1
+	}, time.Second, 10*time.Millisecond)
+	l.Close()
+	select {
+	case _, ok := <-conns:
+		assert.False(ok)
+	case <-time.After(500 * time.Millisecond):
+		assert.Fail("Accept is not interrupted by Close")
+	}
+}
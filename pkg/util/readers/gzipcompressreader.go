@@ -26,27 +26,39 @@ import (
 
 var bodyFlushSize = 8 * int64(os.Getpagesize())
 
-// GZipCompressReader wraps an io.Reader to a new io.Reader, whose data
-// is the gzip compression result of the original io.Reader.
-type GZipCompressReader struct {
+// CompressReader wraps an io.Reader to a new io.Reader, whose data
+// is the compression result of the original io.Reader.
+type CompressReader struct {
 	r    io.Reader
 	buff *bytes.Buffer
-	gw   *gzip.Writer
+	cw   io.WriteCloser
 	err  error
 }
 
-// NewGZipCompressReader creates a new GZipCompressReader from r.
-func NewGZipCompressReader(r io.Reader) *GZipCompressReader {
+// GZipCompressReader wraps an io.Reader to a new io.Reader, whose data
+// is the gzip compression result of the original io.Reader.
+type GZipCompressReader = CompressReader
+
+// NewCompressReader creates a new CompressReader from r, newWriter is
+// used to create the compression writer.
+func NewCompressReader(r io.Reader, newWriter func(w io.Writer) io.WriteCloser) *CompressReader {
 	buff := bytes.NewBuffer(nil)
-	return &GZipCompressReader{
+	return &CompressReader{
 		r:    r,
 		buff: buff,
-		gw:   gzip.NewWriter(buff),
+		cw:   newWriter(buff),
 	}
 }
 
+// NewGZipCompressReader creates a new GZipCompressReader from r.
+func NewGZipCompressReader(r io.Reader) *GZipCompressReader {
+	return NewCompressReader(r, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+}
+
 // Read implements io.Reader.
-func (r *GZipCompressReader) Read(p []byte) (n int, err error) {
+func (r *CompressReader) Read(p []byte) (n int, err error) {
 	for {
 		// The error could only be io.EOF, which need to be ignored.
 		m, _ := r.buff.Read(p)
@@ -66,13 +78,13 @@ func (r *GZipCompressReader) Read(p []byte) (n int, err error) {
 	return
 }
 
-func (r *GZipCompressReader) pull() {
+func (r *CompressReader) pull() {
 	// reset the buffer to avoid it becomes too large.
 	r.buff.Reset()
 
-	_, r.err = io.CopyN(r.gw, r.r, bodyFlushSize)
+	_, r.err = io.CopyN(r.cw, r.r, bodyFlushSize)
 	if r.err == io.EOF {
-		if err := r.gw.Close(); err != nil {
+		if err := r.cw.Close(); err != nil {
 			r.err = err
 		}
 	}
@@ -80,7 +92,7 @@ func (r *GZipCompressReader) pull() {
 
 // Close implements io.Closer and closes the underlying io.Reader if
 // it is an io.Closer.
-func (r *GZipCompressReader) Close() error {
+func (r *CompressReader) Close() error {
 	if c, ok := r.r.(io.Closer); ok {
 		return c.Close()
 	}
@@ -0,0 +1,39 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package reuseport creates listeners with the SO_REUSEPORT socket option,
+// so that more than one process could bind the same port, and the kernel
+// distributes the connections among them.
+package reuseport
+
+import (
+	"context"
+	"net"
+)
+
+// Listen announces on the local network address with SO_REUSEPORT set.
+func Listen(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: control}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// ListenPacket announces on the local network address with SO_REUSEPORT
+// set.
+func ListenPacket(network, addr string) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: control}
+	return lc.ListenPacket(context.Background(), network, addr)
+}
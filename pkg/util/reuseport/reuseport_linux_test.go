@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reuseport
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListen(t *testing.T) {
+	assert := assert.New(t)
+
+	l1, err := Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer l1.Close()
+
+	// the same port could be bound again.
+	addr := l1.Addr().String()
+	l2, err := Listen("tcp", addr)
+	assert.NoError(err)
+	defer l2.Close()
+
+	// but not by a listener without the option.
+	_, err = net.Listen("tcp", addr)
+	assert.Error(err)
+}
+
+func TestListenPacket(t *testing.T) {
+	assert := assert.New(t)
+
+	c1, err := ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer c1.Close()
+
+	c2, err := ListenPacket("udp", c1.LocalAddr().String())
+	assert.NoError(err)
+	defer c2.Close()
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sampler
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// hdrSubBucketBits decides the precision of HDRDurationSampler, every
+	// power-of-two range of values is split into 2^hdrSubBucketBits
+	// sub-buckets, so the relative error of percentiles is bounded by
+	// hdrRelativeError.
+	hdrSubBucketBits = 8
+	hdrSubBucketHalf = 1 << hdrSubBucketBits
+	hdrSubBucketMask = 2*hdrSubBucketHalf - 1
+	hdrRelativeError = 1.0 / (2 * hdrSubBucketHalf)
+
+	// hdrMaxValue is the max duration in microseconds, larger durations
+	// are recorded as it.
+	hdrMaxValue = uint64(time.Hour / time.Microsecond)
+)
+
+// HDRDurationSampler is the sampler backed by an HDR histogram, it keeps
+// the relative error of percentiles bounded for durations from 1
+// microsecond to 1 hour, at the cost of more memory than DurationSampler.
+type HDRDurationSampler struct {
+	count  uint64
+	counts []uint64
+}
+
+var _ Sampler = (*HDRDurationSampler)(nil)
+
+// NewHDRDurationSampler creates an HDRDurationSampler.
+func NewHDRDurationSampler() *HDRDurationSampler {
+	return &HDRDurationSampler{
+		counts: make([]uint64, hdrIndex(hdrMaxValue)+1),
+	}
+}
+
+// hdrIndex returns the index of the counter of value v.
+func hdrIndex(v uint64) int {
+	bucket := bits.Len64(v|hdrSubBucketMask) - (hdrSubBucketBits + 1)
+	sub := int(v >> uint(bucket))
+	return bucket*hdrSubBucketHalf + sub
+}
+
+// hdrValue returns the median of the values counted by the counter at
+// index idx.
+func hdrValue(idx int) float64 {
+	if idx < 2*hdrSubBucketHalf {
+		return float64(idx)
+	}
+
+	bucket := (idx-2*hdrSubBucketHalf)/hdrSubBucketHalf + 1
+	sub := idx - bucket*hdrSubBucketHalf
+	lowest := uint64(sub) << uint(bucket)
+	return float64(lowest) + float64(uint64(1)<<uint(bucket))/2
+}
+
+// Update updates the sample. This function could be called concurrently,
+// but should not be called concurrently with Percentiles.
+func (hs *HDRDurationSampler) Update(d time.Duration) {
+	v := uint64(0)
+	if d > 0 {
+		v = uint64(d / time.Microsecond)
+	}
+	if v > hdrMaxValue {
+		v = hdrMaxValue
+	}
+
+	atomic.AddUint64(&hs.count, 1)
+	atomic.AddUint64(&hs.counts[hdrIndex(v)], 1)
+}
+
+// Reset reset the HDRDurationSampler to initial state
+func (hs *HDRDurationSampler) Reset() {
+	for i := 0; i < len(hs.counts); i++ {
+		hs.counts[i] = 0
+	}
+	hs.count = 0
+}
+
+// Percentiles returns 7 metrics in milliseconds by order:
+// P25, P50, P75, P95, P98, P99, P999
+func (hs *HDRDurationSampler) Percentiles() []float64 {
+	result := make([]float64, len(percentiles))
+	if hs.count == 0 {
+		return result
+	}
+
+	count, pi := uint64(0), 0
+	for idx, c := range hs.counts {
+		count += c
+		for pi < len(percentiles) && count >= uint64(math.Ceil(percentiles[pi]*float64(hs.count))) {
+			result[pi] = hdrValue(idx) / float64(time.Millisecond/time.Microsecond)
+			pi++
+		}
+		if pi == len(percentiles) {
+			break
+		}
+	}
+
+	return result
+}
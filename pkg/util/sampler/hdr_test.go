@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sampler
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHDRDurationSamplerIndex(t *testing.T) {
+	for _, v := range []uint64{0, 1, 511, 512, 513, 1023, 1024, 123456, hdrMaxValue} {
+		got := hdrValue(hdrIndex(v))
+		if math.Abs(got-float64(v)) > float64(v)*hdrRelativeError {
+			t.Errorf("value of %d: want within %v, got %v", v, hdrRelativeError, got)
+		}
+	}
+}
+
+func TestHDRDurationSamplerPercentiles(t *testing.T) {
+	hs := NewHDRDurationSampler()
+
+	// Percentiles of an empty sampler are zero.
+	for _, p := range hs.Percentiles() {
+		if p != 0 {
+			t.Errorf("want 0, got %v", p)
+		}
+	}
+
+	// Uniform distribution from 10µs to 100s.
+	const n = 10000000
+	for i := 1; i <= n; i += 1000 {
+		hs.Update(time.Duration(i) * 10 * time.Microsecond)
+	}
+
+	want := []float64{25000, 50000, 75000, 95000, 98000, 99000, 99900}
+	for i, got := range hs.Percentiles() {
+		bound := want[i]*hdrRelativeError + 10
+		if math.Abs(got-want[i]) > bound {
+			t.Errorf("percentile %v: want %v±%v, got %v", percentiles[i], want[i], bound, got)
+		}
+	}
+
+	// Skewed distribution: 99% at 1ms, 1% at 3s.
+	hs.Reset()
+	for i := 0; i < 10000; i++ {
+		d := time.Millisecond
+		if i%100 == 0 {
+			d = 3 * time.Second
+		}
+		hs.Update(d)
+	}
+
+	want = []float64{1, 1, 1, 1, 1, 1, 3000}
+	for i, got := range hs.Percentiles() {
+		if math.Abs(got-want[i]) > want[i]*hdrRelativeError {
+			t.Errorf("percentile %v: want %v, got %v", percentiles[i], want[i], got)
+		}
+	}
+
+	// Durations out of range are recorded as the max.
+	hs.Reset()
+	hs.Update(2 * time.Hour)
+	hs.Update(-time.Second)
+	p := hs.Percentiles()
+	if p[0] != 0 {
+		t.Errorf("want 0, got %v", p[0])
+	}
+	if math.Abs(p[6]-float64(time.Hour/time.Millisecond)) > float64(time.Hour/time.Millisecond)*hdrRelativeError {
+		t.Errorf("want about 1h, got %v", p[6])
+	}
+}
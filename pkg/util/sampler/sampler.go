@@ -23,6 +23,13 @@ import (
 )
 
 type (
+	// Sampler is the sampler for sampling duration.
+	Sampler interface {
+		Update(d time.Duration)
+		Reset()
+		Percentiles() []float64
+	}
+
 	// DurationSampler is the sampler for sampling duration.
 	DurationSampler struct {
 		count     uint64
@@ -36,6 +43,11 @@ type (
 	}
 )
 
+// percentiles are the percentiles returned by samplers.
+var percentiles = []float64{0.25, 0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
+
+var _ Sampler = (*DurationSampler)(nil)
+
 var segments = []DurationSegment{
 	{time.Millisecond, 500},        // < 500ms
 	{time.Millisecond * 2, 250},    // < 1s
@@ -89,8 +101,6 @@ func (ds *DurationSampler) Reset() {
 // Percentiles returns 7 metrics by order:
 // P25, P50, P75, P95, P98, P99, P999
 func (ds *DurationSampler) Percentiles() []float64 {
-	percentiles := []float64{0.25, 0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
-
 	result := make([]float64, len(percentiles))
 	count, total := uint64(0), float64(ds.count)
 	di, pi := 0, 0